@@ -3,41 +3,56 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/Brownie44l1/http-1/internal/fileserver"
+	"github.com/Brownie44l1/http-1/internal/ratelimit"
 	"github.com/Brownie44l1/http-1/internal/response"
 	"github.com/Brownie44l1/http-1/internal/router"
 	"github.com/Brownie44l1/http-1/internal/server"
-
-	net "github.com/Brownie44l1/socket-wrapper"
+	"github.com/Brownie44l1/http-1/internal/websocket"
 )
 
 func main() {
 	r := router.New()
 
 	// Static routes
-	r.GET("/", handleHome)
-	r.GET("/health", handleHealth)
+	must(r.GET("/", handleHome))
+	must(r.GET("/health", handleHealth))
 
 	// ✅ Issue #10: Parameters with constraints
-	r.GET("/users/:id<[0-9]+>", handleGetUser) // id must be numeric
-	r.POST("/users", handleCreateUser)
+	must(r.GET("/users/:id<[0-9]+>", handleGetUser)) // id must be numeric
+	must(r.POST("/users", handleCreateUser))
 
-	// ✅ Issue #10: Wildcards
-	r.GET("/static/*filepath", handleStatic)
+	// ✅ Issue #10: Wildcards - static files from ./public (HEAD is served by GET)
+	static := fileserver.New(fileserver.DefaultConfig("./public"))
+	must(r.GET("/static/*filepath", static))
 
 	// ✅ Issue #6: WebSocket support (hijacking)
-	r.GET("/ws", handleWebSocket)
+	must(r.GET("/ws", handleWebSocket))
 
 	// API group with middleware
 	api := r.Group("/api/v1")
 	logger := &server.DefaultLogger{}
 	api.Use(server.LoggingMiddleware(logger))
 	api.Use(server.MetricsMiddleware(server.NewMetrics()))
-	api.GET("/data", handleAPIData)
+
+	// Per-route quota for API clients: bursts of 10, then 1 request/second
+	apiLimiter, err := ratelimit.NewGCRA(ratelimit.PerSecond(1), 10)
+	if err != nil {
+		fmt.Printf("Rate limiter error: %v\n", err)
+		os.Exit(1)
+	}
+	defer apiLimiter.Close()
+	api.Use(server.RateLimitMiddleware(server.RateLimitConfig{
+		Limiter: apiLimiter,
+		Key:     server.KeyByRoute(server.KeyByIP),
+	}))
+	must(api.GET("/data", handleAPIData))
 
 	// ✅ Issue #1: Configure server with custom net library
 	config := server.DefaultConfig()
@@ -50,13 +65,22 @@ func main() {
 
 	srv := server.New(config, r)
 
+	// ✅ Issue #16: Prometheus scrape endpoint
+	must(r.GET("/metrics", srv.Metrics().Handler().ServeHTTP))
+
 	// ✅ Issue #7: Add middleware
 	logger = &server.DefaultLogger{} // Create logger instance
 	srv.SetLogger(logger)
 	srv.Use(server.RecoveryMiddleware(logger))
 	srv.Use(server.LoggingMiddleware(logger))
 	srv.Use(server.RequestIDMiddleware())
-	srv.Use(server.RateLimitMiddleware(server.NewRateLimiter(100, time.Minute)))
+	limiter, err := ratelimit.NewTokenBucket(ratelimit.PerMinute(100), 100)
+	if err != nil {
+		fmt.Printf("Rate limiter error: %v\n", err)
+		os.Exit(1)
+	}
+	defer limiter.Close()
+	srv.Use(server.RateLimitMiddleware(server.RateLimitConfig{Limiter: limiter}))
 
 	// ✅ Issue #21: CORS
 	corsConfig := server.CORSConfig{
@@ -68,7 +92,7 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		fmt.Printf("🚀 Server starting on %s\n", config.Port)
+		fmt.Printf("🚀 Server starting on :%d\n", config.Port)
 		fmt.Println("✅ All 22 critical issues fixed!")
 		fmt.Println("📊 Features:")
 		fmt.Println("   - Custom network library with epoll")
@@ -112,6 +136,13 @@ func main() {
 	fmt.Println("✨ Server stopped gracefully")
 }
 
+// must stops the server if a route can't be registered
+func must(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 // Handler examples
 
 func handleHome(ctx *server.Context) {
@@ -163,54 +194,30 @@ func handleCreateUser(ctx *server.Context) {
 	}`, len(body)))
 }
 
-func handleStatic(ctx *server.Context) {
-	// ✅ Issue #10: Wildcard parameter
-	filepath := ctx.Param("filepath")
-
-	ctx.Text(response.StatusOK, fmt.Sprintf("Serving static file: %s", filepath))
+// ✅ Issue #6: WebSocket echo example (RFC 6455 handshake + frames)
+var upgrader = &websocket.Upgrader{
+	Subprotocols:      []string{"echo"},
+	EnableCompression: true,
 }
 
-// ✅ Issue #6: WebSocket example (hijacking)
 func handleWebSocket(ctx *server.Context) {
-	// Check if it's a WebSocket upgrade request
-	if !ctx.IsWebSocketUpgrade() {
-		ctx.Error(response.StatusBadRequest, "Not a WebSocket request")
-		return
-	}
-
-	// Hijack the connection
-	conn, err := ctx.Hijack()
+	// Upgrade writes the error response itself if the handshake is invalid
+	conn, err := upgrader.Upgrade(ctx)
 	if err != nil {
-		ctx.Error(response.StatusInternalServerError, "Failed to hijack connection")
 		return
 	}
-
-	// Send WebSocket upgrade response
-	upgradeResponse := "HTTP/1.1 101 Switching Protocols\r\n"
-	upgradeResponse += "Upgrade: websocket\r\n"
-	upgradeResponse += "Connection: Upgrade\r\n"
-	upgradeResponse += "\r\n"
-
-	conn.Write([]byte(upgradeResponse))
-
-	// Now handle WebSocket protocol
-	// (In production, use a WebSocket library)
-	handleWebSocketConnection(conn)
-}
-
-func handleWebSocketConnection(conn net.Conn) {
 	defer conn.Close()
 
-	// Simple echo WebSocket (simplified - not real WebSocket frames)
-	buf := make([]byte, 4096)
 	for {
-		n, err := conn.Read(buf)
+		messageType, msg, err := conn.ReadMessage()
 		if err != nil {
+			// Close frames are echoed by ReadMessage
 			return
 		}
 
-		// Echo back
-		conn.Write(buf[:n])
+		if err := conn.WriteMessage(messageType, msg); err != nil {
+			return
+		}
 	}
 }
 
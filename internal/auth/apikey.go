@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/sha256"
+
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// APIKeyConfig configures API key authentication
+type APIKeyConfig struct {
+	Header string // Header carrying the key; "" disables
+	Query  string // Query parameter carrying the key; "" disables
+
+	// Keys maps each key to the name of its owner
+	Keys map[string]string
+
+	// Validate, if set, is used instead of Keys and returns the owner
+	Validate func(key string) (string, bool)
+}
+
+// DefaultAPIKeyConfig reads keys from the X-API-Key header only. Query
+// parameters end up in logs and browser history, so enable them with care.
+func DefaultAPIKeyConfig() APIKeyConfig {
+	return APIKeyConfig{Header: "X-API-Key"}
+}
+
+// APIKeyAuth requires a known API key in the configured header or query
+// parameter; the header wins if both are present. The Principal's Name is
+// the key's owner.
+func APIKeyAuth(config APIKeyConfig) server.Middleware {
+	validate := config.Validate
+	if validate == nil {
+		// Look keys up by hash so the comparison doesn't leak key prefixes
+		owners := make(map[[sha256.Size]byte]string, len(config.Keys))
+		for key, owner := range config.Keys {
+			owners[sha256.Sum256([]byte(key))] = owner
+		}
+		validate = func(key string) (string, bool) {
+			owner, ok := owners[sha256.Sum256([]byte(key))]
+			return owner, ok
+		}
+	}
+
+	return func(next server.Handler) server.Handler {
+		return server.HandlerFunc(func(ctx *server.Context) {
+			key := ""
+			if config.Header != "" {
+				key = ctx.Header(config.Header)
+			}
+			if key == "" && config.Query != "" {
+				key = ctx.Query(config.Query)
+			}
+
+			owner, ok := "", false
+			if key != "" {
+				owner, ok = validate(key)
+			}
+			if !ok {
+				unauthorized(ctx, "")
+				return
+			}
+
+			setPrincipal(ctx, &Principal{Name: owner, Scheme: "APIKey"})
+			next.ServeHTTP(ctx)
+		})
+	}
+}
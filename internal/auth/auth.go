@@ -0,0 +1,84 @@
+// Package auth provides authentication middlewares: HTTP Basic, Bearer
+// JWT and API keys. Each one places the authenticated Principal on the
+// request's server.Context, where handlers read it with FromContext.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// contextKey is where the middlewares store the Principal
+const contextKey = "auth.principal"
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string // User name, JWT subject or API key owner
+	Scheme string // "Basic", "Bearer" or "APIKey"
+	Claims Claims // Verified JWT claims; nil for other schemes
+}
+
+// FromContext returns the request's Principal, or nil if the request was
+// not authenticated
+func FromContext(ctx *server.Context) *Principal {
+	v, _ := ctx.Get(contextKey)
+	p, _ := v.(*Principal)
+	return p
+}
+
+// KeyByPrincipal keys rate limits by authenticated user, so a user's quota
+// follows them across addresses. Unauthenticated requests use fallback,
+// or are exempt if it is nil. Put the auth middleware before the limiter.
+func KeyByPrincipal(fallback server.KeyFunc) server.KeyFunc {
+	return func(ctx *server.Context) string {
+		if p := FromContext(ctx); p != nil {
+			return "principal:" + p.Scheme + ":" + p.Name
+		}
+		if fallback == nil {
+			return ""
+		}
+		return fallback(ctx)
+	}
+}
+
+// setPrincipal records p on ctx
+func setPrincipal(ctx *server.Context, p *Principal) {
+	ctx.Set(contextKey, p)
+}
+
+// authorization splits the Authorization header into its scheme and
+// credentials, matching the scheme case-insensitively
+func authorization(ctx *server.Context, scheme string) (string, bool) {
+	value := ctx.Header("authorization")
+	s, credentials, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(s, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(credentials), true
+}
+
+// unauthorized sends a 401, with a challenge if one is given
+func unauthorized(ctx *server.Context, challenge string) {
+	if challenge != "" {
+		ctx.Response.Headers().Set("WWW-Authenticate", challenge)
+	}
+	ctx.Error(response.StatusUnauthorized, "Unauthorized")
+}
+
+// secureCompare compares two secrets in constant time. Hashing first
+// keeps the time independent of their lengths too.
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// quote makes s a quoted-string for an auth-param
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
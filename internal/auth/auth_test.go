@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/ratelimit"
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// serve runs a request through mw and returns the response and the
+// Principal the handler saw
+func serve(mw server.Middleware, target string, hdrs ...string) (string, *Principal) {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(hdrs); i += 2 {
+		h.Set(hdrs[i], hdrs[i+1])
+	}
+	var buf bytes.Buffer
+	req := &request.Request{Method: "GET", Path: target, Headers: h}
+	ctx := server.NewContext(req, response.NewWriter(&buf), nil)
+
+	var principal *Principal
+	mw(server.HandlerFunc(func(ctx *server.Context) {
+		principal = FromContext(ctx)
+		ctx.Text(response.StatusOK, "ok")
+	})).ServeHTTP(ctx)
+	return buf.String(), principal
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestBasicAuth(t *testing.T) {
+	mw := BasicAuth(BasicConfig{Realm: `Admin "area"`, Users: map[string]string{"alice": "s3cret:with:colons"}})
+
+	resp, p := serve(mw, "/", "Authorization", "basic "+base64.StdEncoding.EncodeToString([]byte("alice:s3cret:with:colons")))
+	assert.Contains(t, resp, "200 OK")
+	require.NotNil(t, p)
+	assert.Equal(t, Principal{Name: "alice", Scheme: "Basic"}, *p)
+
+	for _, creds := range []string{"alice:wrong", "bob:s3cret:with:colons", "alice", ""} {
+		resp, p = serve(mw, "/", "Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds)))
+		assert.Contains(t, resp, "401 Unauthorized", creds)
+		assert.Contains(t, resp, `www-authenticate: Basic realm="Admin \"area\"", charset="UTF-8"`)
+		assert.Nil(t, p)
+	}
+
+	resp, _ = serve(mw, "/")
+	assert.Contains(t, resp, "401 Unauthorized")
+	resp, _ = serve(mw, "/", "Authorization", "Basic !!!")
+	assert.Contains(t, resp, "401 Unauthorized")
+}
+
+// testKeys builds one key of each supported type and the JWKS that
+// publishes them
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	jwks   []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	k := &testKeys{secret: bytes.Repeat([]byte("k"), 32)}
+
+	var err error
+	k.rsa, err = rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pad := func(n *big.Int) string {
+		b := make([]byte, 32)
+		return b64(n.FillBytes(b))
+	}
+	k.jwks, err = json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "k": b64(k.secret)},
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": pad(k.ec.X), "y": pad(k.ec.Y)},
+		{"kty": "RSA", "use": "enc", "n": "ignored"},
+	}})
+	require.NoError(t, err)
+	return k
+}
+
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks, 0o600))
+	set, err := LoadJWKS(path)
+	require.NoError(t, err)
+
+	config := DefaultJWTConfig()
+	config.Keys = set
+	config.Issuer = "https://issuer.example"
+	config.Audience = "orders"
+	verifier, err := NewJWTVerifier(config)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	verifier.now = func() time.Time { return now }
+
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "user-1",
+			"iss": "https://issuer.example",
+			"aud": []string{"billing", "orders"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	for _, alg := range []string{"HS256", "RS256", "ES256"} {
+		got, err := verifier.Verify(keys.sign(t, alg, "", claims(nil)))
+		require.NoError(t, err, alg)
+		assert.Equal(t, "user-1", got.Subject())
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"expired", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), ErrTokenExpired},
+		{"expired within skew", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), nil},
+		{"not yet valid", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()})), ErrTokenNotYetValid},
+		{"nbf within skew", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()})), nil},
+		{"no exp", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"exp": nil})), ErrMissingExpiry},
+		{"wrong issuer", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"iss": "evil"})), ErrInvalidIssuer},
+		{"wrong audience", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"aud": "billing"})), ErrInvalidAudience},
+		{"string audience", keys.sign(t, "HS256", "hmac", claims(map[string]interface{}{"aud": "orders"})), nil},
+		{"unknown kid", keys.sign(t, "RS256", "other", claims(nil)), ErrInvalidSignature},
+		{"alg none", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"x"}`)) + ".", ErrUnsupportedAlg},
+		{"garbage", "not.a.jwt", ErrMalformedToken},
+		{"two parts", "a.b", ErrMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(tt.token)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+
+	// A token signed with the RSA key but labelled HS256 must not verify
+	// with the public key as an HMAC secret
+	token := keys.sign(t, "RS256", "rsa", claims(nil))
+	header := b64([]byte(`{"alg":"HS256","kid":"rsa"}`))
+	_, err = verifier.Verify(header + token[len(b64([]byte(`{"alg":"RS256","kid":"rsa","typ":"JWT"}`))):])
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// Tampering with the payload breaks the signature
+	other := keys.sign(t, "ES256", "ec", claims(map[string]interface{}{"sub": "admin"}))
+	parts := bytes.Split([]byte(keys.sign(t, "ES256", "ec", claims(nil))), []byte("."))
+	otherParts := bytes.Split([]byte(other), []byte("."))
+	_, err = verifier.Verify(string(parts[0]) + "." + string(otherParts[1]) + "." + string(parts[2]))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseJWKS(keys.jwks)
+	require.NoError(t, err)
+	verifier, err := NewJWTVerifier(JWTConfig{Keys: set, Realm: "orders"})
+	require.NoError(t, err)
+	mw := JWTAuth(verifier)
+
+	token := keys.sign(t, "ES256", "ec", map[string]interface{}{
+		"sub":   "user-7",
+		"scope": "read",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	resp, p := serve(mw, "/", "Authorization", "Bearer "+token)
+	assert.Contains(t, resp, "200 OK")
+	require.NotNil(t, p)
+	assert.Equal(t, "user-7", p.Name)
+	assert.Equal(t, "Bearer", p.Scheme)
+	assert.Equal(t, "read", p.Claims.String("scope"))
+
+	resp, _ = serve(mw, "/")
+	assert.Contains(t, resp, "401 Unauthorized")
+	assert.Contains(t, resp, "www-authenticate: Bearer realm=\"orders\"\r\n")
+
+	resp, _ = serve(mw, "/", "Authorization", "Bearer "+token+"x")
+	assert.Contains(t, resp, `www-authenticate: Bearer realm="orders", error="invalid_token", error_description="invalid token signature"`)
+}
+
+func TestParseJWKSRejectsBadKeys(t *testing.T) {
+	for _, doc := range []string{
+		`{"keys":[]}`,
+		`{"keys":[{"kty":"oct","k":"c2hvcnQ"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-384","x":"AA","y":"AA"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`,
+		`{"keys":[{"kty":"OKP"}]}`,
+		`not json`,
+	} {
+		_, err := ParseJWKS([]byte(doc))
+		assert.ErrorIs(t, err, ErrInvalidJWKS, doc)
+	}
+
+	_, err := ParseJWKS([]byte(`{"keys":[{"kty":"oct","alg":"RS256","k":"` + b64(bytes.Repeat([]byte("k"), 32)) + `"}]}`))
+	assert.ErrorIs(t, err, ErrInvalidJWKS)
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	config := DefaultAPIKeyConfig()
+	config.Query = "api_key"
+	config.Keys = map[string]string{"k-123": "billing-service"}
+	mw := APIKeyAuth(config)
+
+	resp, p := serve(mw, "/", "X-API-Key", "k-123")
+	assert.Contains(t, resp, "200 OK")
+	require.NotNil(t, p)
+	assert.Equal(t, Principal{Name: "billing-service", Scheme: "APIKey"}, *p)
+
+	_, p = serve(mw, "/orders?api_key=k-123")
+	require.NotNil(t, p)
+	assert.Equal(t, "billing-service", p.Name)
+
+	// The header is checked first
+	resp, _ = serve(mw, "/orders?api_key=k-123", "X-API-Key", "wrong")
+	assert.Contains(t, resp, "401 Unauthorized")
+
+	resp, _ = serve(mw, "/")
+	assert.Contains(t, resp, "401 Unauthorized")
+
+	// Query keys are off by default
+	resp, _ = serve(APIKeyAuth(APIKeyConfig{Header: "X-API-Key", Keys: config.Keys}), "/?api_key=k-123")
+	assert.Contains(t, resp, "401 Unauthorized")
+}
+
+func TestKeyByPrincipal(t *testing.T) {
+	limiter, err := ratelimit.NewSlidingWindowLog(ratelimit.PerMinute(1))
+	require.NoError(t, err)
+	defer limiter.Close()
+
+	config := DefaultAPIKeyConfig()
+	config.Keys = map[string]string{"k-1": "alice", "k-2": "bob"}
+	authenticate := APIKeyAuth(config)
+	limit := server.RateLimitMiddleware(server.RateLimitConfig{
+		Limiter: limiter,
+		Key:     KeyByPrincipal(nil),
+	})
+	mw := func(next server.Handler) server.Handler { return authenticate(limit(next)) }
+
+	resp, _ := serve(mw, "/", "X-API-Key", "k-1")
+	assert.Contains(t, resp, "200 OK")
+	assert.Contains(t, resp, "ratelimit-remaining: 0")
+	resp, _ = serve(mw, "/", "X-API-Key", "k-1")
+	assert.Contains(t, resp, "429 Too Many Requests")
+	resp, _ = serve(mw, "/", "X-API-Key", "k-2")
+	assert.Contains(t, resp, "200 OK")
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// BasicConfig configures HTTP Basic authentication (RFC 7617)
+type BasicConfig struct {
+	Realm string
+
+	// Users maps user names to passwords. Passwords are compared in
+	// constant time.
+	Users map[string]string
+
+	// Validate, if set, is used instead of Users, e.g. to check a password
+	// hash. It must do its own constant-time comparison.
+	Validate func(user, password string) bool
+}
+
+// DefaultBasicConfig returns a config with a generic realm and no users
+func DefaultBasicConfig() BasicConfig {
+	return BasicConfig{Realm: "Restricted"}
+}
+
+// BasicAuth requires valid Basic credentials. Other requests get a 401
+// with a WWW-Authenticate challenge naming the realm.
+func BasicAuth(config BasicConfig) server.Middleware {
+	if config.Realm == "" {
+		config.Realm = DefaultBasicConfig().Realm
+	}
+	challenge := "Basic realm=" + quote(config.Realm) + `, charset="UTF-8"`
+
+	validate := config.Validate
+	if validate == nil {
+		validate = func(user, password string) bool {
+			want, ok := config.Users[user]
+			// Compare even for unknown users so timing doesn't reveal them
+			return secureCompare(password, want) && ok
+		}
+	}
+
+	return func(next server.Handler) server.Handler {
+		return server.HandlerFunc(func(ctx *server.Context) {
+			user, password, ok := basicCredentials(ctx)
+			if !ok || !validate(user, password) {
+				unauthorized(ctx, challenge)
+				return
+			}
+
+			setPrincipal(ctx, &Principal{Name: user, Scheme: "Basic"})
+			next.ServeHTTP(ctx)
+		})
+	}
+}
+
+// basicCredentials decodes a Basic Authorization header
+func basicCredentials(ctx *server.Context) (string, string, bool) {
+	encoded, ok := authorization(ctx, "Basic")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+var ErrInvalidJWKS = errors.New("auth: invalid JWKS")
+
+// KeySet holds the keys JWTs are verified with, loaded from a JSON Web Key
+// Set (RFC 7517). Supported keys are "oct" for HS256, "RSA" for RS256 and
+// "EC" on P-256 for ES256.
+type KeySet struct {
+	keys []jwk
+}
+
+// jwk is a parsed verification key
+type jwk struct {
+	kid string
+	alg string      // Algorithm the key is for
+	key interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// rawJWK is a key as it appears in the JWKS document
+type rawJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads a key set from a local JWKS file
+func LoadJWKS(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses a JWKS document. Keys marked for encryption ("use":
+// "enc") are ignored; any other unsupported key is an error, so a typo
+// doesn't silently disable a key.
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []rawJWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWKS, err)
+	}
+
+	set := &KeySet{}
+	for i, raw := range doc.Keys {
+		if raw.Use == "enc" {
+			continue
+		}
+		key, err := parseJWK(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d (kid %q): %v", ErrInvalidJWKS, i, raw.Kid, err)
+		}
+		set.keys = append(set.keys, key)
+	}
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("%w: no signing keys", ErrInvalidJWKS)
+	}
+	return set, nil
+}
+
+func parseJWK(raw rawJWK) (jwk, error) {
+	key := jwk{kid: raw.Kid}
+
+	switch raw.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(raw.K)
+		if err != nil || len(secret) < 32 {
+			return key, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		key.alg, key.key = "HS256", secret
+
+	case "RSA":
+		n, err1 := decodeBigInt(raw.N)
+		e, err2 := decodeBigInt(raw.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() {
+			return key, errors.New("bad RSA modulus or exponent")
+		}
+		if n.BitLen() < 2048 {
+			return key, errors.New("RSA key shorter than 2048 bits")
+		}
+		key.alg, key.key = "RS256", &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		if raw.Crv != "P-256" {
+			return key, fmt.Errorf("unsupported curve %q", raw.Crv)
+		}
+		x, err1 := decodeBigInt(raw.X)
+		y, err2 := decodeBigInt(raw.Y)
+		if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return key, errors.New("bad P-256 point")
+		}
+		key.alg, key.key = "ES256", &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+	default:
+		return key, fmt.Errorf("unsupported key type %q", raw.Kty)
+	}
+
+	if raw.Alg != "" && raw.Alg != key.alg {
+		return key, fmt.Errorf("alg %q does not match key type %s", raw.Alg, raw.Kty)
+	}
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("bad base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// candidates returns the keys that may have signed a token with this
+// header. A key is only ever used with its own algorithm, which rules out
+// algorithm confusion attacks.
+func (s *KeySet) candidates(alg, kid string) []jwk {
+	var out []jwk
+	for _, k := range s.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+var (
+	ErrMalformedToken   = errors.New("auth: malformed token")
+	ErrUnsupportedAlg   = errors.New("auth: unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("auth: invalid token signature")
+	ErrTokenExpired     = errors.New("auth: token expired")
+	ErrTokenNotYetValid = errors.New("auth: token not yet valid")
+	ErrMissingExpiry    = errors.New("auth: token has no expiry")
+	ErrInvalidIssuer    = errors.New("auth: invalid token issuer")
+	ErrInvalidAudience  = errors.New("auth: invalid token audience")
+)
+
+// Claims is a verified JWT payload
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" if it is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject returns the "sub" claim
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// Audience returns the "aud" claim, which may be a string or a list
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		out := make([]string, 0, len(aud))
+		for _, v := range aud {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Time returns a NumericDate claim such as "exp"
+func (c Claims) Time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	f, ok := v.(float64)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, true, fmt.Errorf("%w: %s is not a number", ErrMalformedToken, name)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true, nil
+}
+
+// JWTConfig configures Bearer JWT validation
+type JWTConfig struct {
+	Keys *KeySet // Verification keys, e.g. from LoadJWKS
+
+	Issuer   string // If set, "iss" must equal it
+	Audience string // If set, "aud" must contain it
+
+	// ClockSkew is the leeway for exp and nbf, to tolerate clocks that
+	// disagree slightly with the token issuer's
+	ClockSkew time.Duration
+
+	AllowNoExpiry bool     // Accept tokens without "exp"
+	Algorithms    []string // Accepted "alg" values; defaults to HS256, RS256 and ES256
+
+	Realm string // Realm in the WWW-Authenticate challenge
+}
+
+// DefaultJWTConfig returns a config with a one minute clock skew; Keys must
+// still be set
+func DefaultJWTConfig() JWTConfig {
+	return JWTConfig{
+		ClockSkew:  time.Minute,
+		Algorithms: []string{"HS256", "RS256", "ES256"},
+		Realm:      "api",
+	}
+}
+
+// JWTVerifier checks signed JWTs (RFC 7519) against a KeySet
+type JWTVerifier struct {
+	config JWTConfig
+	now    func() time.Time
+}
+
+// NewJWTVerifier creates a verifier
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	if config.Keys == nil {
+		return nil, fmt.Errorf("%w: no keys configured", ErrInvalidJWKS)
+	}
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = DefaultJWTConfig().Algorithms
+	}
+	for _, alg := range config.Algorithms {
+		switch alg {
+		case "HS256", "RS256", "ES256":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+		}
+	}
+	if config.Realm == "" {
+		config.Realm = DefaultJWTConfig().Realm
+	}
+	return &JWTVerifier{config: config, now: time.Now}, nil
+}
+
+// Verify checks a compact-serialised JWT's signature and its exp, nbf,
+// iss and aud claims, returning the claims if it is valid
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if !v.allowed(header.Alg) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlg, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	verified := false
+	for _, key := range v.config.Keys.candidates(header.Alg, header.Kid) {
+		if verifySignature(key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidSignature
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) allowed(alg string) bool {
+	for _, a := range v.config.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the registered claims
+func (v *JWTVerifier) validate(claims Claims) error {
+	now := v.now()
+	skew := v.config.ClockSkew
+
+	exp, ok, err := claims.Time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok && !v.config.AllowNoExpiry {
+		return ErrMissingExpiry
+	}
+	if ok && !now.Before(exp.Add(skew)) {
+		return ErrTokenExpired
+	}
+
+	nbf, ok, err := claims.Time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(skew).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+
+	if v.config.Issuer != "" && claims.String("iss") != v.config.Issuer {
+		return ErrInvalidIssuer
+	}
+	if v.config.Audience != "" {
+		found := false
+		for _, aud := range claims.Audience() {
+			if aud == v.config.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrInvalidAudience
+		}
+	}
+	return nil
+}
+
+func verifySignature(key jwk, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch k := key.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+
+	case *ecdsa.PublicKey:
+		// JWS uses the fixed-width r||s form, not ASN.1
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	return nil
+}
+
+// JWTAuth requires a valid Bearer token. Failures get a 401 with an RFC
+// 6750 challenge; the Principal's Name is the token subject.
+func JWTAuth(verifier *JWTVerifier) server.Middleware {
+	realm := "Bearer realm=" + quote(verifier.config.Realm)
+
+	return func(next server.Handler) server.Handler {
+		return server.HandlerFunc(func(ctx *server.Context) {
+			token, ok := authorization(ctx, "Bearer")
+			if !ok || token == "" {
+				unauthorized(ctx, realm)
+				return
+			}
+
+			claims, err := verifier.Verify(token)
+			if err != nil {
+				description := strings.TrimPrefix(err.Error(), "auth: ")
+				unauthorized(ctx, realm+`, error="invalid_token", error_description=`+quote(description))
+				return
+			}
+
+			setPrincipal(ctx, &Principal{Name: claims.Subject(), Scheme: "Bearer", Claims: claims})
+			next.ServeHTTP(ctx)
+		})
+	}
+}
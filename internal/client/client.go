@@ -0,0 +1,462 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/request"
+)
+
+var (
+	ErrTooManyRedirects = errors.New("client: stopped after too many redirects")
+	errBodyNotSent      = errors.New("client: request body not sent")
+)
+
+// maxDrainBytes is how much of an unread response body Close discards to
+// keep the connection alive
+const maxDrainBytes = 256 << 10
+
+// Config configures a Client
+type Config struct {
+	Timeout                time.Duration // Whole exchange including the response body (0 = none)
+	DialTimeout            time.Duration
+	ResponseHeaderTimeout  time.Duration // Waiting for the response head after sending the request
+	ExpectContinueTimeout  time.Duration // Wait for 100 Continue before sending the body anyway
+	IdleConnTimeout        time.Duration
+	MaxIdleConnsPerHost    int
+	MaxRedirects           int // 0 returns redirect responses to the caller
+	MaxResponseHeaderBytes int
+	MaxResponseBodySize    int64
+	UserAgent              string
+}
+
+// DefaultConfig returns sensible client defaults
+func DefaultConfig() Config {
+	return Config{
+		DialTimeout:            10 * time.Second,
+		ResponseHeaderTimeout:  30 * time.Second,
+		ExpectContinueTimeout:  time.Second,
+		IdleConnTimeout:        90 * time.Second,
+		MaxIdleConnsPerHost:    8,
+		MaxRedirects:           10,
+		MaxResponseHeaderBytes: 1 << 20,
+		MaxResponseBodySize:    1 << 30,
+		UserAgent:              "http-1-client",
+	}
+}
+
+// Response is a response received by the client. Its Body must be read
+// to EOF or closed so the connection can be reused.
+type Response struct {
+	*request.Response
+	Request *Request // The request that produced it (the last after redirects)
+}
+
+// Client is an HTTP/1.1 client speaking the same wire implementation as
+// the server: requests are written by request.Request.Write and responses
+// read by request.ReadResponse. It is safe for concurrent use.
+type Client struct {
+	config Config
+	dialer net.Dialer
+
+	mu    sync.Mutex
+	pools map[string]*hostPool
+}
+
+// New creates a client
+func New(config Config) *Client {
+	return &Client{
+		config: config,
+		dialer: net.Dialer{Timeout: config.DialTimeout, KeepAlive: 30 * time.Second},
+		pools:  make(map[string]*hostPool),
+	}
+}
+
+// Get issues a GET request
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post issues a POST request with the given content type
+func (c *Client) Post(rawURL, contentType string, body io.Reader) (*Response, error) {
+	req, err := NewRequest("POST", rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Do sends a request and returns the response, following redirects up to
+// MaxRedirects. Errors caused by Timeout or the request's context wrap
+// context.DeadlineExceeded or context.Canceled.
+func (c *Client) Do(req *Request) (*Response, error) {
+	if err := validateURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context()
+	cancel := context.CancelFunc(func() {})
+	if c.config.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+	}
+
+	redirects := 0
+	for {
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		next, err := c.redirectRequest(req, resp)
+		if err != nil {
+			resp.Body.Close()
+			cancel()
+			return nil, err
+		}
+		if next == nil {
+			// The timeout covers reading the body
+			if body, ok := resp.Body.(*bodyReader); ok {
+				body.cancel = cancel
+			} else {
+				cancel()
+			}
+			return resp, nil
+		}
+
+		redirects++
+		if redirects > c.config.MaxRedirects {
+			resp.Body.Close()
+			cancel()
+			return nil, ErrTooManyRedirects
+		}
+
+		resp.Body.Close()
+		req = next
+	}
+}
+
+// CloseIdleConnections closes pooled connections
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pools {
+		p.closeIdle()
+	}
+}
+
+// pool returns the connection pool for addr
+func (c *Client) pool(addr string) *hostPool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.pools[addr]
+	if !ok {
+		p = &hostPool{
+			addr:        addr,
+			maxIdle:     c.config.MaxIdleConnsPerHost,
+			idleTimeout: c.config.IdleConnTimeout,
+		}
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// send performs a single exchange. A pooled connection the server has
+// already closed is retried when the body can be replayed.
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
+	p := c.pool(hostPort(req))
+
+	for {
+		out, err := c.outgoingRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		pc, err := p.get(ctx, &c.dialer)
+		if err != nil {
+			return nil, wrapContextErr(ctx, err)
+		}
+
+		resp, err := c.exchange(ctx, pc, out)
+		if err == nil {
+			return &Response{Response: resp, Request: req}, nil
+		}
+		pc.Close()
+
+		replayable := req.Body == nil || req.GetBody != nil
+		if pc.reused && replayable && ctx.Err() == nil {
+			continue
+		}
+		return nil, wrapContextErr(ctx, err)
+	}
+}
+
+// outgoingRequest converts req to the wire representation
+func (c *Client) outgoingRequest(req *Request) (*request.Request, error) {
+	out := request.NewRequest()
+	out.Method = req.Method
+	out.Path = req.URL.RequestURI()
+	out.Version = "HTTP/1.1"
+	out.Headers = req.Headers.Clone()
+
+	if _, ok := out.Headers.Get("host"); !ok {
+		out.Headers.Set("Host", req.URL.Host)
+	}
+	if _, ok := out.Headers.Get("user-agent"); !ok && c.config.UserAgent != "" {
+		out.Headers.Set("User-Agent", c.config.UserAgent)
+	}
+
+	out.Headers.Del("Content-Length")
+	out.Headers.Del("Transfer-Encoding")
+
+	body := req.Body
+	if body != nil && req.GetBody != nil {
+		// Always start from the beginning; an earlier attempt may have
+		// consumed part of it
+		var err error
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case body == nil || req.ContentLength == 0:
+		if req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+			out.Headers.Set("Content-Length", "0")
+		}
+	case req.ContentLength > 0:
+		out.Headers.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+		out.Body = io.NopCloser(body)
+	default:
+		out.Headers.Set("Transfer-Encoding", "chunked")
+		out.Body = io.NopCloser(body)
+	}
+
+	return out, nil
+}
+
+// exchange writes the request and reads the response head on pc
+func (c *Client) exchange(ctx context.Context, pc *persistConn, out *request.Request) (*request.Response, error) {
+	// Cancelling the context unblocks any read or write
+	stop := context.AfterFunc(ctx, func() { pc.Close() })
+
+	var interim func(*request.Response)
+	var bodySent chan error // Result of an asynchronous body write (Expect only)
+
+	expect, _ := out.Headers.Get("expect")
+	if strings.EqualFold(expect, "100-continue") && out.Body != request.NoBody {
+		if err := out.WriteHead(pc); err != nil {
+			stop()
+			return nil, err
+		}
+
+		// The body goes out on 100 Continue, or after a grace period for
+		// servers that ignore Expect. A final response first means it is
+		// not wanted at all.
+		bodySent = make(chan error, 1)
+		proceed := make(chan struct{})
+		final := make(chan struct{})
+		var once sync.Once
+
+		interim = func(r *request.Response) {
+			if r.StatusCode == 100 {
+				once.Do(func() { close(proceed) })
+			}
+		}
+
+		go func() {
+			timer := time.NewTimer(c.config.ExpectContinueTimeout)
+			defer timer.Stop()
+
+			select {
+			case <-proceed:
+			case <-timer.C:
+			case <-final:
+				bodySent <- errBodyNotSent
+				return
+			}
+			bodySent <- out.WriteBody(pc)
+		}()
+		defer close(final)
+	} else if err := out.Write(pc); err != nil {
+		stop()
+		return nil, err
+	}
+
+	if c.config.ResponseHeaderTimeout > 0 {
+		pc.SetReadDeadline(time.Now().Add(c.config.ResponseHeaderTimeout))
+	}
+	resp, err := request.ReadResponseWithInterim(pc, out.Method, c.config.MaxResponseHeaderBytes, c.config.MaxResponseBodySize, interim)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	pc.SetReadDeadline(time.Time{})
+
+	reusable := func() bool {
+		if resp.WantsClose() || out.WantsClose() {
+			return false
+		}
+		if bodySent == nil {
+			return true
+		}
+		// An unsent or half-sent body leaves the stream out of sync
+		select {
+		case err := <-bodySent:
+			return err == nil
+		default:
+			return false
+		}
+	}
+
+	release := func(ok bool) {
+		if stop() && ok && reusable() {
+			pc.pool.put(pc)
+		} else {
+			pc.Close()
+		}
+	}
+
+	if resp.Body == request.NoBody {
+		release(true)
+		return resp, nil
+	}
+
+	resp.Body = &bodyReader{ReadCloser: resp.Body, ctx: ctx, release: release}
+	return resp, nil
+}
+
+// redirectRequest returns the request to follow a redirect with, or nil
+// if resp should be returned to the caller
+func (c *Client) redirectRequest(req *Request, resp *Response) (*Request, error) {
+	if c.config.MaxRedirects <= 0 {
+		return nil, nil
+	}
+
+	method := req.Method
+	keepBody := false
+	switch resp.StatusCode {
+	case 301, 302, 303:
+		// Browsers turn these into GET; 303 requires it
+		if method != "GET" && method != "HEAD" {
+			method = "GET"
+		}
+	case 307, 308:
+		keepBody = true
+		if req.Body != nil && req.GetBody == nil {
+			return nil, nil // Can't replay the body
+		}
+	default:
+		return nil, nil
+	}
+
+	location, ok := resp.Headers.Get("location")
+	if !ok || location == "" {
+		return nil, nil
+	}
+	u, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateURL(u); err != nil {
+		return nil, err
+	}
+
+	next := &Request{
+		Method:  method,
+		URL:     u,
+		Headers: req.Headers.Clone(),
+		ctx:     req.ctx,
+	}
+	next.Headers.Del("Host")
+
+	if keepBody {
+		next.Body = req.Body
+		next.ContentLength = req.ContentLength
+		next.GetBody = req.GetBody
+	} else {
+		next.Headers.Del("Content-Type")
+		next.Headers.Del("Expect")
+	}
+
+	// Credentials are not sent to a different host
+	if u.Host != req.URL.Host {
+		next.Headers.Del("Authorization")
+		next.Headers.Del("Cookie")
+	}
+
+	return next, nil
+}
+
+// hostPort returns the dial address for a request URL
+func hostPort(req *Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// wrapContextErr reports a context error instead of the closed-connection
+// error it caused
+func wrapContextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return ctxErr
+	}
+	return err
+}
+
+// bodyReader returns the connection to its pool once the body is consumed
+type bodyReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	release func(reusable bool)
+	cancel  context.CancelFunc // Ends Config.Timeout once the body is done
+	done    bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finish(true)
+	} else if err != nil {
+		b.finish(false)
+		err = wrapContextErr(b.ctx, err)
+	}
+	return n, err
+}
+
+// Close discards a small remainder so the connection can be reused
+func (b *bodyReader) Close() error {
+	if b.done {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, b.ReadCloser, maxDrainBytes)
+	b.finish(err == io.EOF)
+	return nil
+}
+
+func (b *bodyReader) finish(reusable bool) {
+	b.done = true
+	b.release(reusable)
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
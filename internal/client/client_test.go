@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// testServer serves connections with the same parser and writer the
+// server package uses
+type testServer struct {
+	ln       net.Listener
+	accepted atomic.Int32
+	handler  func(req *request.Request, w *response.Writer)
+}
+
+func newTestServer(t *testing.T, handler func(req *request.Request, w *response.Writer)) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testServer{ln: ln, handler: handler}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) url(path string) string {
+	return "http://" + s.ln.Addr().String() + path
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+
+		go func() {
+			defer conn.Close()
+			for {
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				w := response.NewWriter(conn)
+				s.handler(req, w)
+				if req.DrainBody(1<<20) != nil || req.WantsClose() {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func newClient() *Client {
+	config := DefaultConfig()
+	config.ExpectContinueTimeout = 2 * time.Second
+	return New(config)
+}
+
+func readAll(t *testing.T, resp *Response) string {
+	t.Helper()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestGetReusesConnection(t *testing.T) {
+	srv := newTestServer(t, func(req *request.Request, w *response.Writer) {
+		ua, _ := req.Headers.Get("user-agent")
+		w.TextResponse(response.StatusOK, req.Method+" "+req.Path+" "+ua)
+	})
+	c := newClient()
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(srv.url("/hello?x=1"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "GET /hello?x=1 http-1-client", readAll(t, resp))
+	}
+	assert.Equal(t, int32(1), srv.accepted.Load())
+}
+
+func TestRequestBodies(t *testing.T) {
+	srv := newTestServer(t, func(req *request.Request, w *response.Writer) {
+		body, _ := io.ReadAll(req.Body)
+		framing := "length"
+		if req.IsChunked() {
+			framing = "chunked"
+		}
+		w.TextResponse(response.StatusOK, framing+":"+string(body))
+	})
+	c := newClient()
+
+	resp, err := c.Post(srv.url("/"), "text/plain", strings.NewReader("sized"))
+	require.NoError(t, err)
+	assert.Equal(t, "length:sized", readAll(t, resp))
+
+	// Unknown length is sent chunked
+	resp, err = c.Post(srv.url("/"), "text/plain", io.MultiReader(strings.NewReader("stream"), strings.NewReader("ed")))
+	require.NoError(t, err)
+	assert.Equal(t, "chunked:streamed", readAll(t, resp))
+}
+
+func TestResponseFraming(t *testing.T) {
+	srv := newTestServer(t, func(req *request.Request, w *response.Writer) {
+		switch req.Path {
+		case "/chunked":
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteChunk([]byte("one "))
+			w.WriteChunk([]byte("two"))
+			w.FinishChunked()
+		case "/close":
+			h := headers.NewHeaders()
+			h.Set("Connection", "close")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte("until close"))
+			req.Headers.Set("Connection", "close") // Makes the test server hang up
+		}
+	})
+	c := newClient()
+
+	resp, err := c.Get(srv.url("/chunked"))
+	require.NoError(t, err)
+	assert.Equal(t, "one two", readAll(t, resp))
+
+	resp, err = c.Get(srv.url("/close"))
+	require.NoError(t, err)
+	assert.Equal(t, "until close", readAll(t, resp))
+	assert.Equal(t, 0, len(c.pool(hostPort(resp.Request)).idle))
+}
+
+func TestRedirects(t *testing.T) {
+	srv := newTestServer(t, func(req *request.Request, w *response.Writer) {
+		switch req.Path {
+		case "/found":
+			w.RedirectResponse(response.StatusFound, "/target")
+		case "/temporary":
+			w.RedirectResponse(response.StatusTemporaryRedirect, "/target")
+		case "/loop":
+			w.RedirectResponse(response.StatusFound, "/loop")
+		default:
+			body, _ := io.ReadAll(req.Body)
+			w.TextResponse(response.StatusOK, req.Method+" "+req.Path+" "+string(body))
+		}
+	})
+	c := newClient()
+
+	// 302 turns POST into GET without a body
+	resp, err := c.Post(srv.url("/found"), "text/plain", strings.NewReader("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "GET /target ", readAll(t, resp))
+	assert.Equal(t, "/target", resp.Request.URL.Path)
+
+	// 307 replays the method and body
+	resp, err = c.Post(srv.url("/temporary"), "text/plain", strings.NewReader("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "POST /target data", readAll(t, resp))
+
+	_, err = c.Get(srv.url("/loop"))
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+
+	config := DefaultConfig()
+	config.MaxRedirects = 0
+	resp, err = New(config).Get(srv.url("/found"))
+	require.NoError(t, err)
+	assert.Equal(t, 302, resp.StatusCode)
+}
+
+func TestTimeouts(t *testing.T) {
+	srv := newTestServer(t, func(req *request.Request, w *response.Writer) {
+		time.Sleep(300 * time.Millisecond)
+		w.TextResponse(response.StatusOK, "late")
+	})
+
+	config := DefaultConfig()
+	config.Timeout = 50 * time.Millisecond
+	_, err := New(config).Get(srv.url("/"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := NewRequestWithContext(ctx, "GET", srv.url("/"), nil)
+	require.NoError(t, err)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = newClient().Do(req)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestExpectContinue(t *testing.T) {
+	var bodySeen atomic.Bool
+	srv := newTestServer(t, func(req *request.Request, w *response.Writer) {
+		if req.Path == "/reject" {
+			w.TextResponse(response.StatusRequestEntityTooLarge, "too big")
+			req.Headers.Set("Connection", "close")
+			return
+		}
+		w.ContinueResponse()
+		body, _ := io.ReadAll(req.Body)
+		bodySeen.Store(true)
+		w.TextResponse(response.StatusOK, string(body))
+	})
+	c := newClient()
+
+	req, err := NewRequest("PUT", srv.url("/upload"), strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Headers.Set("Expect", "100-continue")
+
+	start := time.Now()
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", readAll(t, resp))
+	assert.True(t, bodySeen.Load())
+	assert.Less(t, time.Since(start), time.Second, "body should go out on 100 Continue, not the timeout")
+
+	bodySeen.Store(false)
+	req, err = NewRequest("PUT", srv.url("/reject"), strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Headers.Set("Expect", "100-continue")
+
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, 413, resp.StatusCode)
+	assert.Equal(t, "too big", readAll(t, resp))
+	assert.False(t, bodySeen.Load())
+}
+
+func TestInvalidURLs(t *testing.T) {
+	_, err := NewRequest("GET", "ftp://example.com/", nil)
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+
+	_, err = NewRequest("GET", "http:///path", nil)
+	assert.ErrorIs(t, err, ErrMissingHost)
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// persistConn is a connection that may be kept alive between requests
+type persistConn struct {
+	net.Conn
+	pool      *hostPool
+	idleSince time.Time
+	reused    bool // Came from the idle pool, so the server may have closed it
+}
+
+// hostPool keeps idle keep-alive connections to one host:port
+type hostPool struct {
+	addr        string
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mu   sync.Mutex
+	idle []*persistConn // Most recently used last
+}
+
+// get returns an idle connection, or dials a new one
+func (p *hostPool) get(ctx context.Context, dialer *net.Dialer) (*persistConn, error) {
+	if pc := p.takeIdle(); pc != nil {
+		return pc, nil
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &persistConn{Conn: conn, pool: p}, nil
+}
+
+// takeIdle pops the most recently used idle connection, closing any that
+// have been idle for too long
+func (p *hostPool) takeIdle() *persistConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if p.idleTimeout > 0 && time.Since(pc.idleSince) > p.idleTimeout {
+			pc.Close()
+			continue
+		}
+
+		pc.reused = true
+		return pc
+	}
+	return nil
+}
+
+// put returns a connection for reuse, closing it if the pool is full
+func (p *hostPool) put(pc *persistConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) >= p.maxIdle {
+		pc.Close()
+		return
+	}
+
+	pc.idleSince = time.Now()
+	p.idle = append(p.idle, pc)
+}
+
+// closeIdle closes all idle connections
+func (p *hostPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pc := range p.idle {
+		pc.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("client: unsupported URL scheme")
+	ErrMissingHost       = errors.New("client: URL has no host")
+)
+
+// Request is an outgoing HTTP request
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers *headers.Headers
+
+	// Body is sent with the request; nil means no body
+	Body io.Reader
+
+	// ContentLength is the size of Body. -1 sends the body chunked.
+	ContentLength int64
+
+	// GetBody returns a fresh copy of Body so the request can be retried
+	// or follow a 307/308 redirect. It is set for in-memory bodies.
+	GetBody func() (io.Reader, error)
+
+	ctx context.Context
+}
+
+// NewRequest creates a request with a background context
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	return NewRequestWithContext(context.Background(), method, rawURL, body)
+}
+
+// NewRequestWithContext creates a request bound to ctx. Cancelling ctx
+// aborts the request, including reading the response body.
+func NewRequestWithContext(ctx context.Context, method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateURL(u); err != nil {
+		return nil, err
+	}
+
+	req := &Request{
+		Method:        strings.ToUpper(method),
+		URL:           u,
+		Headers:       headers.NewHeaders(),
+		Body:          body,
+		ContentLength: -1,
+		ctx:           ctx,
+	}
+
+	// In-memory bodies have a known length and can be replayed
+	switch b := body.(type) {
+	case nil:
+		req.ContentLength = 0
+	case *bytes.Buffer:
+		data := b.Bytes()
+		req.ContentLength = int64(len(data))
+		req.GetBody = func() (io.Reader, error) { return bytes.NewReader(data), nil }
+	case *bytes.Reader:
+		snapshot := *b
+		req.ContentLength = int64(b.Len())
+		req.GetBody = func() (io.Reader, error) { r := snapshot; return &r, nil }
+	case *strings.Reader:
+		snapshot := *b
+		req.ContentLength = int64(b.Len())
+		req.GetBody = func() (io.Reader, error) { r := snapshot; return &r, nil }
+	}
+
+	return req, nil
+}
+
+// Context returns the request's context
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r using ctx
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// validateURL checks the URL is something this client can reach
+func validateURL(u *url.URL) error {
+	if u.Scheme != "http" {
+		return ErrUnsupportedScheme
+	}
+	if u.Host == "" {
+		return ErrMissingHost
+	}
+	return nil
+}
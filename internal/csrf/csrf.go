@@ -0,0 +1,347 @@
+// Package csrf protects state-changing requests from cross-site request
+// forgery. Unsafe requests must pass an Origin/Sec-Fetch-Site check and
+// echo a token the server handed out, either through a signed cookie
+// (double submit) or the session (synchronizer token).
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+	"github.com/Brownie44l1/http-1/internal/session"
+)
+
+var (
+	ErrInvalidKey     = errors.New("csrf: Key must be at least 32 bytes")
+	ErrNoSession      = errors.New("csrf: synchronizer tokens need the session middleware")
+	ErrOriginMismatch = errors.New("csrf: cross-origin request")
+	ErrMissingToken   = errors.New("csrf: missing token")
+	ErrInvalidToken   = errors.New("csrf: invalid token")
+)
+
+const (
+	tokenLen   = 32
+	contextKey = "csrf"
+	sessionKey = "csrf.token"
+)
+
+// Mode selects where the server keeps its copy of the token
+type Mode int
+
+const (
+	DoubleSubmit Mode = iota // Signed cookie; no server-side state
+	Synchronizer             // Session value; needs session middleware first
+)
+
+// Config configures CSRF protection
+type Config struct {
+	Mode Mode
+
+	// Key signs the double-submit cookie so a token planted from a sibling
+	// subdomain is rejected; at least 32 random bytes. Unused by
+	// Synchronizer.
+	Key []byte
+
+	// Double-submit cookie attributes. The default "__Host-" name needs
+	// Secure; pick another name for plain HTTP development.
+	CookieName string
+	Secure     bool
+	SameSite   headers.SameSite
+
+	HeaderName string // Header checked for the token, for scripts
+	FieldName  string // Form field checked for the token, for HTML forms
+
+	// TrustedOrigins may send unsafe requests besides the request's own
+	// origin, e.g. "https://app.example.com". List the origins your CORS
+	// config allows for credentialed requests here too.
+	TrustedOrigins []string
+
+	// Skip exempts requests, e.g. API routes authenticated by bearer
+	// tokens rather than cookies
+	Skip func(ctx *server.Context) bool
+
+	// ErrorHandler responds to rejected requests. Defaults to a 403, or a
+	// 500 for ErrNoSession.
+	ErrorHandler func(ctx *server.Context, err error)
+}
+
+// DefaultConfig returns a double-submit config; Key must still be set
+func DefaultConfig() Config {
+	return Config{
+		Mode:       DoubleSubmit,
+		CookieName: "__Host-csrf",
+		Secure:     true,
+		SameSite:   headers.SameSiteLax,
+		HeaderName: "X-CSRF-Token",
+		FieldName:  "csrf_token",
+	}
+}
+
+// Protector issues and checks CSRF tokens
+type Protector struct {
+	config  Config
+	trusted map[string]bool
+}
+
+// New creates a Protector. Empty names take the DefaultConfig values.
+func New(config Config) (*Protector, error) {
+	defaults := DefaultConfig()
+	if config.CookieName == "" {
+		config.CookieName = defaults.CookieName
+	}
+	if config.HeaderName == "" {
+		config.HeaderName = defaults.HeaderName
+	}
+	if config.FieldName == "" {
+		config.FieldName = defaults.FieldName
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = func(ctx *server.Context, err error) {
+			log.Printf("%v (request %s)", err, ctx.RequestID)
+			if errors.Is(err, ErrNoSession) {
+				ctx.Error(response.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			ctx.Error(response.StatusForbidden, "Forbidden")
+		}
+	}
+
+	p := &Protector{config: config, trusted: make(map[string]bool)}
+	if config.Mode == DoubleSubmit {
+		if len(config.Key) < 32 {
+			return nil, ErrInvalidKey
+		}
+		if err := p.cookie("").Valid(); err != nil {
+			return nil, fmt.Errorf("csrf cookie: %w", err)
+		}
+	}
+	for _, origin := range config.TrustedOrigins {
+		p.trusted[normalizeOrigin(origin)] = true
+	}
+	return p, nil
+}
+
+// Middleware checks unsafe requests (anything but GET, HEAD, OPTIONS and
+// TRACE) and makes Token available to handlers. Preflights are OPTIONS
+// requests, so it can sit on either side of CORSMiddleware.
+func (p *Protector) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return server.HandlerFunc(func(ctx *server.Context) {
+			st := &state{p: p, ctx: ctx}
+			if err := st.load(); err != nil {
+				p.config.ErrorHandler(ctx, err)
+				return
+			}
+			ctx.Set(contextKey, st)
+
+			if !safeMethod(ctx.Method()) && (p.config.Skip == nil || !p.config.Skip(ctx)) {
+				if err := p.check(ctx, st); err != nil {
+					p.config.ErrorHandler(ctx, err)
+					return
+				}
+			}
+
+			next.ServeHTTP(ctx)
+		})
+	}
+}
+
+// Token returns a token to embed in a form field or send in the header.
+// Each call returns a differently masked token for the same secret, so
+// compressed responses don't leak it (BREACH). It returns "" without the
+// middleware, and must be called before the response is written.
+func Token(ctx *server.Context) string {
+	v, _ := ctx.Get(contextKey)
+	st, ok := v.(*state)
+	if !ok {
+		return ""
+	}
+	secret, err := st.secretOrNew()
+	if err != nil {
+		return ""
+	}
+	return mask(secret)
+}
+
+// check verifies the origin and token of an unsafe request
+func (p *Protector) check(ctx *server.Context, st *state) error {
+	if err := p.checkOrigin(ctx); err != nil {
+		return err
+	}
+
+	sent := ctx.Header(p.config.HeaderName)
+	if sent == "" {
+		if form, err := ctx.PostForm(); err == nil {
+			sent = form.Get(p.config.FieldName)
+		}
+	}
+	if sent == "" {
+		return ErrMissingToken
+	}
+
+	token, ok := unmask(sent)
+	if !ok || st.secret == nil || subtle.ConstantTimeCompare(token, st.secret) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// checkOrigin rejects requests a browser marked as coming from another
+// site, unless their origin is trusted. Sec-Fetch-Site is checked as well
+// as Origin because browsers send it even where Origin is omitted. Origin
+// is compared with Context.Host, which follows X-Forwarded-Host from
+// trusted proxies.
+func (p *Protector) checkOrigin(ctx *server.Context) error {
+	origin := ctx.Header("origin")
+	if origin != "" && p.trusted[normalizeOrigin(origin)] {
+		return nil
+	}
+
+	switch ctx.Header("sec-fetch-site") {
+	case "cross-site", "same-site":
+		return fmt.Errorf("%w: Sec-Fetch-Site %s", ErrOriginMismatch, ctx.Header("sec-fetch-site"))
+	}
+
+	if origin == "" {
+		// Older clients; the token check still applies
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Host, ctx.Host()) {
+		return fmt.Errorf("%w: Origin %s", ErrOriginMismatch, origin)
+	}
+	return nil
+}
+
+func (p *Protector) cookie(value string) *headers.Cookie {
+	return &headers.Cookie{
+		Name:     p.config.CookieName,
+		Value:    value,
+		Path:     "/",
+		Secure:   p.config.Secure,
+		HttpOnly: true,
+		SameSite: p.config.SameSite,
+	}
+}
+
+// sign returns the double-submit cookie value for a secret
+func (p *Protector) sign(secret []byte) string {
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	mac := hmac.New(sha256.New, p.config.Key)
+	mac.Write([]byte("csrf|" + encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the secret in a double-submit cookie value
+func (p *Protector) verify(value string) ([]byte, bool) {
+	encoded, _, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(value), []byte(p.sign(decodeOrNil(encoded)))) {
+		return nil, false
+	}
+	secret := decodeOrNil(encoded)
+	return secret, len(secret) == tokenLen
+}
+
+// state is the per-request token secret
+type state struct {
+	p      *Protector
+	ctx    *server.Context
+	secret []byte
+}
+
+// load reads the existing secret, if any
+func (st *state) load() error {
+	if st.p.config.Mode == Synchronizer {
+		sess := session.FromContext(st.ctx)
+		if sess == nil {
+			return ErrNoSession
+		}
+		if secret := decodeOrNil(sess.Get(sessionKey)); len(secret) == tokenLen {
+			st.secret = secret
+		}
+		return nil
+	}
+
+	if c, err := st.ctx.Cookie(st.p.config.CookieName); err == nil {
+		if secret, ok := st.p.verify(c.Value); ok {
+			st.secret = secret
+		}
+	}
+	return nil
+}
+
+// secretOrNew returns the secret, issuing one the first time a token is
+// needed so visitors who never see a form get no cookie or session
+func (st *state) secretOrNew() ([]byte, error) {
+	if st.secret != nil {
+		return st.secret, nil
+	}
+
+	secret := make([]byte, tokenLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	if st.p.config.Mode == Synchronizer {
+		session.FromContext(st.ctx).Set(sessionKey, base64.RawURLEncoding.EncodeToString(secret))
+	} else if err := st.ctx.SetCookie(st.p.cookie(st.p.sign(secret))); err != nil {
+		return nil, err
+	}
+	st.secret = secret
+	return secret, nil
+}
+
+// mask XORs secret with a one-time pad and prepends the pad
+func mask(secret []byte) string {
+	out := make([]byte, 2*len(secret))
+	pad := out[:len(secret)]
+	rand.Read(pad)
+	for i, b := range secret {
+		out[len(secret)+i] = b ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// unmask reverses mask
+func unmask(token string) ([]byte, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 2*tokenLen {
+		return nil, false
+	}
+	secret := make([]byte, tokenLen)
+	for i := range secret {
+		secret[i] = raw[i] ^ raw[tokenLen+i]
+	}
+	return secret, true
+}
+
+func decodeOrNil(s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(origin, "/"))
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
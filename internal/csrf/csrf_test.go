@@ -0,0 +1,194 @@
+package csrf
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+	"github.com/Brownie44l1/http-1/internal/session"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+type result struct {
+	status     string
+	token      string // Token the handler issued, "" if it didn't run
+	setCookies []string
+}
+
+// serve sends a request through mws; the handler issues a token and
+// echoes it in the body. body is sent as a urlencoded form.
+func serve(mws []server.Middleware, method, body string, hdrs ...string) result {
+	h := headers.NewHeaders()
+	h.Set("Host", "app.example.com")
+	for i := 0; i+1 < len(hdrs); i += 2 {
+		h.Add(hdrs[i], hdrs[i+1])
+	}
+	req := &request.Request{Method: method, Path: "/", Headers: h, Body: request.NoBody}
+	if body != "" {
+		h.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Body = io.NopCloser(strings.NewReader(body))
+	}
+
+	var buf bytes.Buffer
+	ctx := server.NewContext(req, response.NewWriter(&buf), nil)
+
+	var handler server.Handler = server.HandlerFunc(func(ctx *server.Context) {
+		ctx.Text(response.StatusOK, "token="+Token(ctx))
+	})
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	handler.ServeHTTP(ctx)
+
+	res := result{setCookies: ctx.Response.Headers().GetAll("set-cookie")}
+	res.status, _, _ = strings.Cut(buf.String(), "\r\n")
+	if _, after, ok := strings.Cut(buf.String(), "token="); ok {
+		res.token = after
+	}
+	return res
+}
+
+func cookiePair(setCookie string) string {
+	pair, _, _ := strings.Cut(setCookie, ";")
+	return pair
+}
+
+func newProtector(t *testing.T, modify func(*Config)) server.Middleware {
+	t.Helper()
+	config := DefaultConfig()
+	config.Key = testKey
+	config.TrustedOrigins = []string{"https://admin.example.com/"}
+	if modify != nil {
+		modify(&config)
+	}
+	p, err := New(config)
+	require.NoError(t, err)
+	return p.Middleware()
+}
+
+func TestDoubleSubmit(t *testing.T) {
+	mws := []server.Middleware{newProtector(t, nil)}
+
+	page := serve(mws, "GET", "")
+	assert.Equal(t, "HTTP/1.1 200 OK", page.status)
+	require.Len(t, page.setCookies, 1)
+	assert.Contains(t, page.setCookies[0], "__Host-csrf=")
+	assert.Contains(t, page.setCookies[0], "; Path=/; Secure; HttpOnly; SameSite=Lax")
+	cookie := cookiePair(page.setCookies[0])
+
+	// The cookie is reused, with a fresh mask each time
+	again := serve(mws, "GET", "", "Cookie", cookie)
+	assert.Empty(t, again.setCookies)
+	assert.NotEqual(t, page.token, again.token)
+
+	ok := serve(mws, "POST", "", "Cookie", cookie, "X-CSRF-Token", again.token)
+	assert.Equal(t, "HTTP/1.1 200 OK", ok.status)
+
+	ok = serve(mws, "POST", "name=x&csrf_token="+page.token, "Cookie", cookie, "Origin", "https://app.example.com")
+	assert.Equal(t, "HTTP/1.1 200 OK", ok.status)
+
+	other := serve(mws, "GET", "")
+	rejected := [][]string{
+		{},                           // No cookie, no token
+		{"Cookie", cookie},           // No token
+		{"X-CSRF-Token", page.token}, // No cookie
+		{"Cookie", cookie, "X-CSRF-Token", other.token},                                // Someone else's token
+		{"Cookie", cookie, "X-CSRF-Token", "garbage"},                                  // Not a token
+		{"Cookie", cookiePair(other.setCookies[0]) + "x", "X-CSRF-Token", other.token}, // Forged cookie
+	}
+	for i, hdrs := range rejected {
+		res := serve(mws, "POST", "", hdrs...)
+		assert.Equal(t, "HTTP/1.1 403 Forbidden", res.status, i)
+		assert.Empty(t, res.token)
+	}
+}
+
+func TestOriginChecks(t *testing.T) {
+	mws := []server.Middleware{newProtector(t, nil)}
+	page := serve(mws, "GET", "")
+	valid := []string{"Cookie", cookiePair(page.setCookies[0]), "X-CSRF-Token", page.token}
+
+	tests := []struct {
+		hdrs   []string
+		status string
+	}{
+		{[]string{"Origin", "https://evil.example"}, "403 Forbidden"},
+		{[]string{"Origin", "null"}, "403 Forbidden"},
+		{[]string{"Sec-Fetch-Site", "cross-site"}, "403 Forbidden"},
+		{[]string{"Sec-Fetch-Site", "same-site", "Origin", "https://blog.example.com"}, "403 Forbidden"},
+		{[]string{"Sec-Fetch-Site", "same-origin", "Origin", "https://APP.example.com"}, "200 OK"},
+		{[]string{"Sec-Fetch-Site", "same-site", "Origin", "https://admin.example.com"}, "200 OK"},
+	}
+	for _, tt := range tests {
+		res := serve(mws, "POST", "", append(tt.hdrs, valid...)...)
+		assert.Equal(t, "HTTP/1.1 "+tt.status, res.status, tt.hdrs)
+	}
+
+	// Safe methods are never checked
+	assert.Equal(t, "HTTP/1.1 200 OK", serve(mws, "GET", "", "Sec-Fetch-Site", "cross-site").status)
+}
+
+func TestComposesWithCORS(t *testing.T) {
+	cors := server.DefaultCORSConfig()
+	cors.AllowedOrigins = []string{"https://admin.example.com"}
+	mws := []server.Middleware{newProtector(t, nil), server.CORSMiddleware(cors)}
+
+	// Preflights pass straight through to CORS
+	res := serve(mws, "OPTIONS", "",
+		"Origin", "https://admin.example.com",
+		"Access-Control-Request-Method", "POST",
+		"Access-Control-Request-Headers", "X-CSRF-Token")
+	assert.Equal(t, "HTTP/1.1 204 No Content", res.status)
+
+	// A skipped route needs no token
+	mws = []server.Middleware{newProtector(t, func(c *Config) {
+		c.Skip = func(ctx *server.Context) bool { return strings.HasPrefix(ctx.Path(), "/") }
+	})}
+	assert.Equal(t, "HTTP/1.1 200 OK", serve(mws, "POST", "").status)
+}
+
+func TestSynchronizer(t *testing.T) {
+	store := session.NewMemoryStore(0)
+	sessionConfig := session.DefaultConfig()
+	sessionConfig.HashKey = testKey
+	sessionConfig.Store = store
+	sessions, err := session.New(sessionConfig)
+	require.NoError(t, err)
+
+	mws := []server.Middleware{sessions.Middleware(), newProtector(t, func(c *Config) {
+		c.Mode = Synchronizer
+		c.Key = nil
+	})}
+
+	page := serve(mws, "GET", "")
+	require.Len(t, page.setCookies, 1)
+	assert.Contains(t, page.setCookies[0], "session=")
+	cookie := cookiePair(page.setCookies[0])
+
+	assert.Equal(t, "HTTP/1.1 200 OK", serve(mws, "POST", "csrf_token="+page.token, "Cookie", cookie).status)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden", serve(mws, "POST", "csrf_token="+page.token).status)
+
+	// Without sessions the middleware refuses to run
+	res := serve(mws[1:], "GET", "")
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", res.status)
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	_, err := New(Config{})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = New(Config{Key: testKey, Secure: false})
+	assert.ErrorIs(t, err, headers.ErrInsecureCookie)
+
+	_, err = New(Config{Key: testKey, CookieName: "csrf"})
+	assert.NoError(t, err)
+}
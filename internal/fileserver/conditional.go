@@ -0,0 +1,90 @@
+package fileserver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// checkPreconditions evaluates conditional request headers in the order
+// given by RFC 9110 section 13.2.2. It returns 0 when the request should
+// be served normally, otherwise 304 or 412.
+func checkPreconditions(ctx *server.Context, etag string, modTime time.Time) response.StatusCode {
+	if ifMatch := ctx.Header("If-Match"); ifMatch != "" {
+		if !matchETag(ifMatch, etag, false) {
+			return response.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(ctx.Header("If-Unmodified-Since")); ok && !isZeroTime(modTime) {
+		if modTime.Truncate(time.Second).After(since) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := ctx.Header("If-None-Match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, true) {
+			return response.StatusNotModified
+		}
+	} else if since, ok := parseHTTPDate(ctx.Header("If-Modified-Since")); ok && !isZeroTime(modTime) {
+		if !modTime.Truncate(time.Second).After(since) {
+			return response.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// checkIfRange reports whether a Range header should be honoured. If-Range
+// holds either a strong ETag or a date; a stale one means "send everything".
+func checkIfRange(ctx *server.Context, etag string, modTime time.Time) bool {
+	ifRange := ctx.Header("If-Range")
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return matchETag(ifRange, etag, false)
+	}
+
+	date, ok := parseHTTPDate(ifRange)
+	return ok && !isZeroTime(modTime) && modTime.Truncate(time.Second).Equal(date)
+}
+
+// matchETag checks a comma-separated list of entity tags (or "*") against
+// etag. Weak comparison ignores the W/ prefix; strong comparison never
+// matches weak tags.
+func matchETag(list, etag string, weak bool) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTTPDate parses the preferred IMF-fixdate format and the two
+// obsolete formats recipients must still accept
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,375 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/router"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// TimeFormat is the HTTP date format used by Last-Modified and friends
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// copyBufferSize matches the server's medium pooled buffer
+const copyBufferSize = 32 << 10
+
+// Config configures the file server
+type Config struct {
+	Root          string // Directory to serve (ignored when FS is set); symlinks may not lead out of it
+	FS            fs.FS  // Filesystem to serve, e.g. an embed.FS
+	IndexFile     string // Served for directory requests ("" disables)
+	Browse        bool   // Render a listing for directories without an index file
+	AllowDotfiles bool   // Serve names starting with "." (e.g. .env, .git)
+	PathParam     string // Router wildcard holding the file path
+}
+
+// DefaultConfig serves dir with index.html and no directory listings
+func DefaultConfig(dir string) Config {
+	return Config{
+		Root:      dir,
+		IndexFile: "index.html",
+		PathParam: "filepath",
+	}
+}
+
+// fileServer serves files from a filesystem
+type fileServer struct {
+	fsys   fs.FS
+	config Config
+}
+
+// New returns a router handler serving files from config.Root (or
+// config.FS). Register it on a wildcard route such as /static/*filepath.
+func New(config Config) router.Handler {
+	fsys := config.FS
+	if fsys == nil {
+		// Unlike os.DirFS, a root refuses symlinks that point outside it
+		root, err := os.OpenRoot(config.Root)
+		if err != nil {
+			fsys = failedFS{err}
+		} else {
+			fsys = root.FS()
+		}
+	}
+	if config.PathParam == "" {
+		config.PathParam = "filepath"
+	}
+
+	s := &fileServer{fsys: fsys, config: config}
+	return s.serve
+}
+
+func (s *fileServer) serve(ctx *server.Context) {
+	method := ctx.Method()
+	if method != "GET" && method != "HEAD" {
+		ctx.Response.Headers().Set("Allow", "GET, HEAD")
+		ctx.Error(response.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	target := ctx.URL()
+
+	raw, ok := ctx.Params.Get(s.config.PathParam)
+	if !ok {
+		// Not mounted on a wildcard: serve the request path itself
+		raw = target.Path
+	}
+
+	name, ok := cleanPath(raw)
+	if !ok {
+		ctx.Error(response.StatusBadRequest, "Bad Request")
+		return
+	}
+	if !s.config.AllowDotfiles && hasDotSegment(name) {
+		ctx.Error(response.StatusNotFound, "Not Found")
+		return
+	}
+
+	f, info, err := s.open(name)
+	if err != nil {
+		s.serveError(ctx, err)
+		return
+	}
+	defer f.Close()
+
+	trailingSlash := strings.HasSuffix(target.RawPath, "/")
+
+	if !info.IsDir() {
+		if trailingSlash {
+			// "/file.txt/" isn't a directory; point at the real name, one
+			// level up for each trailing slash
+			slashes := len(target.RawPath) - len(strings.TrimRight(target.RawPath, "/"))
+			redirect(ctx, strings.Repeat("../", slashes)+path.Base(target.RawPath), target.RawQuery)
+			return
+		}
+		s.serveFile(ctx, f, info)
+		return
+	}
+
+	// Relative links in directory pages need the trailing slash
+	if !trailingSlash {
+		redirect(ctx, "./"+path.Base(target.RawPath)+"/", target.RawQuery)
+		return
+	}
+
+	if s.config.IndexFile != "" {
+		index, indexInfo, err := s.open(path.Join(name, s.config.IndexFile))
+		if err == nil {
+			defer index.Close()
+			if !indexInfo.IsDir() {
+				s.serveFile(ctx, index, indexInfo)
+				return
+			}
+		}
+	}
+
+	if !s.config.Browse {
+		ctx.Error(response.StatusNotFound, "Not Found")
+		return
+	}
+	s.serveListing(ctx, name, target.Path)
+}
+
+// open opens a file and stats it
+func (s *fileServer) open(name string) (fs.File, fs.FileInfo, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// failedFS stands in for a Root that couldn't be opened, failing every
+// request the way a missing file would
+type failedFS struct{ err error }
+
+func (f failedFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: f.err}
+}
+
+// serveError maps filesystem errors without revealing details
+func (s *fileServer) serveError(ctx *server.Context, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		ctx.Error(response.StatusNotFound, "Not Found")
+	case errors.Is(err, fs.ErrPermission):
+		ctx.Error(response.StatusForbidden, "Forbidden")
+	default:
+		ctx.Error(response.StatusInternalServerError, "Internal Server Error")
+	}
+}
+
+// serveFile writes a file honouring conditional and Range requests
+func (s *fileServer) serveFile(ctx *server.Context, f fs.File, info fs.FileInfo) {
+	size := info.Size()
+	modTime := info.ModTime()
+	etag := makeETag(modTime, size)
+
+	h := headers.NewHeaders()
+	h.Set("ETag", etag)
+	if !isZeroTime(modTime) {
+		h.Set("Last-Modified", modTime.UTC().Format(TimeFormat))
+	}
+
+	switch checkPreconditions(ctx, etag, modTime) {
+	case response.StatusPreconditionFailed:
+		ctx.Error(response.StatusPreconditionFailed, "Precondition Failed")
+		return
+	case response.StatusNotModified:
+		writeHead(ctx, response.StatusNotModified, h)
+		return
+	}
+
+	// Ranges need random access; most filesystems provide it
+	rs, seekable := f.(io.ReadSeeker)
+	var body io.Reader = f
+
+	contentType := mime.TypeByExtension(path.Ext(info.Name()))
+	if contentType == "" {
+		var sniff [sniffLen]byte
+		n, _ := io.ReadFull(f, sniff[:])
+		contentType = detectContentType(sniff[:n])
+
+		if seekable {
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				ctx.Error(response.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+		} else {
+			body = io.MultiReader(strings.NewReader(string(sniff[:n])), f)
+		}
+	}
+	h.Set("Content-Type", contentType)
+
+	var ranges []byteRange
+	if seekable && size > 0 {
+		h.Set("Accept-Ranges", "bytes")
+
+		if rangeHeader := ctx.Header("Range"); rangeHeader != "" && checkIfRange(ctx, etag, modTime) {
+			var err error
+			ranges, err = parseRange(rangeHeader, size)
+			if errors.Is(err, errUnsatisfiableRange) {
+				h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				h.Set("Content-Length", "0")
+				h.Del("Content-Type")
+				writeHead(ctx, response.StatusRequestedRangeNotSatisfiable, h)
+				return
+			}
+			// Malformed ranges are ignored and the whole file is sent
+		}
+	}
+
+	head := ctx.Method() == "HEAD"
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		if !writeHead(ctx, response.StatusOK, h) || head {
+			return
+		}
+		s.copy(ctx, body, size)
+
+	case 1:
+		r := ranges[0]
+		h.Set("Content-Range", r.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(r.length, 10))
+		if !writeHead(ctx, response.StatusPartialContent, h) || head {
+			return
+		}
+		if _, err := rs.Seek(r.start, io.SeekStart); err != nil {
+			abort(ctx)
+			return
+		}
+		s.copy(ctx, rs, r.length)
+
+	default:
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		h.Set("Content-Length", strconv.FormatInt(multipartLength(boundary, contentType, ranges, size), 10))
+		if !writeHead(ctx, response.StatusPartialContent, h) || head {
+			return
+		}
+
+		for _, r := range ranges {
+			if err := ctx.Response.WriteBody([]byte(multipartHeader(boundary, contentType, r, size))); err != nil {
+				return
+			}
+			if _, err := rs.Seek(r.start, io.SeekStart); err != nil {
+				abort(ctx)
+				return
+			}
+			if !s.copy(ctx, rs, r.length) {
+				return
+			}
+		}
+		ctx.Response.WriteBody([]byte(multipartTrailer(boundary)))
+	}
+}
+
+// copy streams exactly n bytes of src to the response
+func (s *fileServer) copy(ctx *server.Context, src io.Reader, n int64) bool {
+	buf := server.GetBuffer(copyBufferSize)
+	defer server.PutBuffer(buf)
+
+	for n > 0 {
+		chunk := buf[:min(int64(len(buf)), n)]
+		read, err := io.ReadFull(src, chunk)
+		if read > 0 {
+			if werr := ctx.Response.WriteBody(chunk[:read]); werr != nil {
+				return false
+			}
+			n -= int64(read)
+		}
+		if err != nil {
+			if n > 0 {
+				// The file shrank after we sent Content-Length
+				abort(ctx)
+			}
+			return n == 0
+		}
+	}
+	return true
+}
+
+// abort marks the connection for closing after a short body. The head is
+// already on the wire, so this only affects the keep-alive decision.
+func abort(ctx *server.Context) {
+	ctx.Response.Headers().Set("Connection", "close")
+}
+
+// writeHead writes a status line and headers with no further body
+func writeHead(ctx *server.Context, code response.StatusCode, h *headers.Headers) bool {
+	if err := ctx.Response.WriteStatusLine(code); err != nil {
+		return false
+	}
+	return ctx.Response.WriteHeaders(h) == nil
+}
+
+// redirect sends a 301 to target, keeping the query string. Targets are
+// relative to the request path, as in net/http's localRedirect: an
+// absolute one built from the request could start with "//" and send the
+// client to another host.
+func redirect(ctx *server.Context, target, query string) {
+	if query != "" {
+		target += "?" + query
+	}
+	ctx.Redirect(response.StatusMovedPermanently, target)
+}
+
+// cleanPath normalises a decoded request path into an fs.FS name.
+// Dot-dot segments are resolved against "/", so they can never climb out
+// of the root.
+func cleanPath(decoded string) (string, bool) {
+	if strings.ContainsAny(decoded, "\x00\\") {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+decoded), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// hasDotSegment reports whether any path element is hidden (".git", ".env")
+func hasDotSegment(name string) bool {
+	if name == "." {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// makeETag builds a strong validator from modification time and size
+func makeETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// isZeroTime reports whether the filesystem has no useful modification time
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
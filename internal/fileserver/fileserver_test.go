@@ -0,0 +1,355 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/router"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"hello.txt":       {Data: []byte("Hello, World!"), ModTime: modTime},
+		"noext":           {Data: []byte("\x89PNG\r\n\x1a\nrest"), ModTime: modTime},
+		"docs/index.html": {Data: []byte("<h1>docs</h1>"), ModTime: modTime},
+		"files/a.txt":     {Data: []byte("a"), ModTime: modTime},
+		"files/b c.txt":   {Data: []byte("b"), ModTime: modTime},
+		"files/.hidden":   {Data: []byte("h"), ModTime: modTime},
+		".env":            {Data: []byte("SECRET=1"), ModTime: modTime},
+	}
+}
+
+// serve runs handler for a request to /static/<file> and parses the response
+func serve(t *testing.T, handler router.Handler, method, file string, extra ...string) (*http.Response, string) {
+	t.Helper()
+	return serveTarget(t, handler, method, "/static/"+file, true, extra...)
+}
+
+// serveTarget runs handler for a request to target, as if mounted on
+// /static/*filepath when wildcard is set
+func serveTarget(t *testing.T, handler router.Handler, method, target string, wildcard bool, extra ...string) (*http.Response, string) {
+	t.Helper()
+
+	raw := method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	raw += "\r\n"
+
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	ctx := server.NewContext(req, response.NewWriter(&out), nil)
+	if wildcard {
+		// As the router would for /static/*filepath
+		ctx.SetParams(server.Params{{Key: "filepath", Value: strings.TrimPrefix(req.URL.Path, "/static/")}})
+	}
+	handler(ctx)
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestServeFile(t *testing.T) {
+	handler := New(Config{FS: testFS()})
+
+	resp, body := serve(t, handler, "GET", "hello.txt")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "Hello, World!", body)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+
+	resp, body = serve(t, handler, "GET", "noext")
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "\x89PNG\r\n\x1a\nrest", body)
+
+	resp, body = serve(t, handler, "HEAD", "hello.txt")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(13), resp.ContentLength)
+	assert.Empty(t, body)
+
+	resp, _ = serve(t, handler, "POST", "hello.txt")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+}
+
+func TestPathSafety(t *testing.T) {
+	handler := New(Config{FS: testFS()})
+
+	for _, file := range []string{"../hello.txt", "files/../../hello.txt", "%2e%2e/hello.txt"} {
+		resp, body := serve(t, handler, "GET", file)
+		assert.Equal(t, 200, resp.StatusCode, file) // Resolved inside the root
+		assert.Equal(t, "Hello, World!", body, file)
+	}
+
+	resp, _ := serve(t, handler, "GET", ".env")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "files/.hidden")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "hello%00.txt")
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "missing.txt")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	handler = New(Config{FS: testFS(), AllowDotfiles: true})
+	resp, body := serve(t, handler, "GET", ".env")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "SECRET=1", body)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	handler := New(Config{FS: testFS()})
+
+	resp, _ := serve(t, handler, "GET", "hello.txt")
+	etag := resp.Header.Get("ETag")
+
+	resp, body := serve(t, handler, "GET", "hello.txt", "If-None-Match: "+etag)
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", `If-None-Match: "other", W/`+etag)
+	assert.Equal(t, 304, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", `If-None-Match: "other"`)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", "If-Modified-Since: Tue, 30 Apr 2024 12:00:00 GMT")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// If-None-Match takes precedence over If-Modified-Since
+	resp, _ = serve(t, handler, "GET", "hello.txt",
+		`If-None-Match: "other"`, "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", `If-Match: "other"`)
+	assert.Equal(t, 412, resp.StatusCode)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", "If-Unmodified-Since: Tue, 30 Apr 2024 12:00:00 GMT")
+	assert.Equal(t, 412, resp.StatusCode)
+}
+
+func TestRootSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("Hello, World!"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("SECRET"), 0o644))
+	require.NoError(t, os.Symlink("hello.txt", filepath.Join(root, "inside")))
+	require.NoError(t, os.Symlink("../secret.txt", filepath.Join(root, "leak")))
+	require.NoError(t, os.Symlink("..", filepath.Join(root, "up")))
+
+	handler := New(Config{Root: root})
+
+	resp, body := serve(t, handler, "GET", "inside")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "Hello, World!", body)
+
+	for _, file := range []string{"leak", "up/secret.txt"} {
+		resp, body := serve(t, handler, "GET", file)
+		assert.NotEqual(t, 200, resp.StatusCode, file)
+		assert.NotContains(t, body, "SECRET", file)
+	}
+
+	// A root that can't be opened serves nothing
+	handler = New(Config{Root: filepath.Join(dir, "missing")})
+	resp, _ = serve(t, handler, "GET", "hello.txt")
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestSingleRange(t *testing.T) {
+	handler := New(Config{FS: testFS()})
+
+	resp, body := serve(t, handler, "GET", "hello.txt", "Range: bytes=0-4")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "Hello", body)
+	assert.Equal(t, "bytes 0-4/13", resp.Header.Get("Content-Range"))
+
+	resp, body = serve(t, handler, "GET", "hello.txt", "Range: bytes=-6")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "World!", body)
+
+	resp, body = serve(t, handler, "GET", "hello.txt", "Range: bytes=7-")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "World!", body)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", "Range: bytes=100-")
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */13", resp.Header.Get("Content-Range"))
+
+	// A range set with no ranges in it is malformed, not unsatisfiable
+	for _, header := range []string{"Range: bytes=", "Range: bytes= , "} {
+		resp, body = serve(t, handler, "GET", "hello.txt", header)
+		assert.Equal(t, 200, resp.StatusCode, header)
+		assert.Equal(t, "Hello, World!", body, header)
+	}
+
+	// A stale If-Range means the whole file is sent
+	resp, body = serve(t, handler, "GET", "hello.txt", "Range: bytes=0-4", `If-Range: "stale"`)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "Hello, World!", body)
+
+	resp, _ = serve(t, handler, "GET", "hello.txt", "Range: bytes=0-4", "If-Range: Wed, 01 May 2024 12:00:00 GMT")
+	assert.Equal(t, 206, resp.StatusCode)
+}
+
+func TestMultipartRange(t *testing.T) {
+	handler := New(Config{FS: testFS()})
+
+	resp, body := serve(t, handler, "GET", "hello.txt", "Range: bytes=0-4, 7-11")
+	require.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts, ranges []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, string(data))
+		ranges = append(ranges, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{"Hello", "World"}, parts)
+	assert.Equal(t, []string{"bytes 0-4/13", "bytes 7-11/13"}, ranges)
+}
+
+func TestParseRange(t *testing.T) {
+	ranges, err := parseRange("bytes=0-0,-1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 1}, {9, 1}}, ranges)
+
+	ranges, err = parseRange("bytes=5-100", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{5, 5}}, ranges)
+
+	_, err = parseRange("bytes=5-1", 10)
+	assert.ErrorIs(t, err, errInvalidRange)
+
+	_, err = parseRange("bytes=abc", 10)
+	assert.ErrorIs(t, err, errInvalidRange)
+
+	_, err = parseRange("bytes=10-", 10)
+	assert.ErrorIs(t, err, errUnsatisfiableRange)
+
+	_, err = parseRange("bytes=", 10)
+	assert.ErrorIs(t, err, errInvalidRange)
+
+	// Overlapping ranges larger than the file are ignored
+	ranges, err = parseRange("bytes=0-,0-", 10)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	ranges, err = parseRange("items=0-1", 10)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+}
+
+func TestDirectories(t *testing.T) {
+	config := DefaultConfig("")
+	config.FS = testFS()
+	handler := New(config)
+
+	resp, _ := serve(t, handler, "GET", "docs?x=1")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "./docs/?x=1", resp.Header.Get("Location"))
+
+	resp, body := serve(t, handler, "GET", "docs/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>docs</h1>", body)
+
+	resp, _ = serve(t, handler, "GET", "files/")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	config.Browse = true
+	handler = New(config)
+	resp, body = serve(t, handler, "GET", "files/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, body, `<a href="./a.txt">a.txt</a>`)
+	assert.Contains(t, body, `<a href="./b%20c.txt">b c.txt</a>`)
+	assert.Contains(t, body, `<a href="../">`)
+	assert.NotContains(t, body, ".hidden")
+}
+
+func TestRedirectsStayOnHost(t *testing.T) {
+	config := DefaultConfig("")
+	config.FS = testFS()
+	handler := New(config)
+
+	tests := []struct {
+		target   string
+		wildcard bool
+		location string
+	}{
+		{"/static/hello.txt/", true, "../hello.txt"},
+		{"/static/hello.txt//?x=1", true, "../../hello.txt?x=1"},
+		// Absolute paths built from these would name the host "docs"
+		{"//docs", false, "./docs/"},
+		{"///docs?x=1", false, "./docs/?x=1"},
+		{"//hello.txt/", false, "../hello.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp, _ := serveTarget(t, handler, "GET", tt.target, tt.wildcard)
+			assert.Equal(t, 301, resp.StatusCode)
+			location := resp.Header.Get("Location")
+			assert.Equal(t, tt.location, location)
+
+			// Browsers resolve the Location against the request URL
+			base, err := url.Parse("http://localhost" + tt.target)
+			require.NoError(t, err)
+			ref, err := url.Parse(location)
+			require.NoError(t, err)
+			assert.Equal(t, "localhost", base.ResolveReference(ref).Host)
+		})
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "application/pdf", detectContentType([]byte("%PDF-1.7")))
+	assert.Equal(t, "text/html; charset=utf-8", detectContentType([]byte("  <!DOCTYPE html><html>")))
+	assert.Equal(t, "text/plain; charset=utf-8", detectContentType([]byte("plain text\n")))
+	assert.Equal(t, "application/octet-stream", detectContentType([]byte{0x00, 0x01, 0x02}))
+
+	// A sample cut in the middle of a rune is still text
+	assert.Equal(t, "text/plain; charset=utf-8", detectContentType([]byte("caf\xc3")))
+}
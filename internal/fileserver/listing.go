@@ -0,0 +1,66 @@
+package fileserver
+
+import (
+	"html"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// serveListing renders a simple HTML index of a directory
+func (s *fileServer) serveListing(ctx *server.Context, name, requestPath string) {
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		s.serveError(ctx, err)
+		return
+	}
+
+	title := html.EscapeString(requestPath)
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of ")
+	b.WriteString(title)
+	b.WriteString("</title></head>\n<body>\n<h1>Index of ")
+	b.WriteString(title)
+	b.WriteString("</h1>\n<ul>\n")
+
+	if name != "." {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+
+	// fs.ReadDir returns entries sorted by name
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if !s.config.AllowDotfiles && strings.HasPrefix(entryName, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += "/"
+		}
+
+		// The href is escaped as a path first so names like "a:b" or "#x"
+		// stay relative links
+		href := (&url.URL{Path: "./" + entryName}).String()
+		b.WriteString("<li><a href=\"")
+		b.WriteString(html.EscapeString(href))
+		b.WriteString("\">")
+		b.WriteString(html.EscapeString(entryName))
+		b.WriteString("</a></li>\n")
+	}
+
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	page := b.String()
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(page)))
+	if !writeHead(ctx, response.StatusOK, h) || ctx.Method() == "HEAD" {
+		return
+	}
+	ctx.Response.WriteBody([]byte(page))
+}
@@ -0,0 +1,131 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+// maxRanges limits multipart responses (guards against range amplification)
+const maxRanges = 32
+
+// byteRange is a resolved, inclusive-exclusive range within the file
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange formats the Content-Range value for this range
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header (RFC 9110 section 14.2) against a file
+// of the given size. A nil result with no error means "serve the whole file".
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, spec, ok := strings.Cut(header, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		// Unknown units are ignored
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	specs := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		specs++
+
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// Suffix range: last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 {
+				continue // Unsatisfiable on its own
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				continue // Unsatisfiable on its own
+			}
+
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				end = min(end, size-1)
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if specs == 0 {
+		// "bytes=" names no ranges at all, so it's malformed rather than
+		// unsatisfiable
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	if len(ranges) > maxRanges {
+		return nil, nil
+	}
+
+	// Ranges that add up to more than the file aren't worth the overhead
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// multipartHeader is the part header preceding each range in a
+// multipart/byteranges body
+func multipartHeader(boundary, contentType string, r byteRange, size int64) string {
+	return fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+		boundary, contentType, r.contentRange(size))
+}
+
+// multipartTrailer closes a multipart/byteranges body
+func multipartTrailer(boundary string) string {
+	return fmt.Sprintf("\r\n--%s--\r\n", boundary)
+}
+
+// multipartLength computes the exact body length so we can send Content-Length
+func multipartLength(boundary, contentType string, ranges []byteRange, size int64) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += int64(len(multipartHeader(boundary, contentType, r, size))) + r.length
+	}
+	return n + int64(len(multipartTrailer(boundary)))
+}
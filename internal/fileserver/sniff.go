@@ -0,0 +1,75 @@
+package fileserver
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file detectContentType looks at
+const sniffLen = 512
+
+// signature maps a magic prefix to a content type
+type signature struct {
+	prefix      []byte
+	contentType string
+}
+
+var signatures = []signature{
+	{[]byte("%PDF-"), "application/pdf"},
+	{[]byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{[]byte("GIF87a"), "image/gif"},
+	{[]byte("GIF89a"), "image/gif"},
+	{[]byte("\xff\xd8\xff"), "image/jpeg"},
+	{[]byte("PK\x03\x04"), "application/zip"},
+	{[]byte("\x1f\x8b\x08"), "application/x-gzip"},
+	{[]byte("\x00asm"), "application/wasm"},
+	{[]byte("wOFF"), "font/woff"},
+	{[]byte("wOF2"), "font/woff2"},
+}
+
+// detectContentType guesses a content type for files without a known
+// extension. It covers the common binary formats and falls back to text
+// or application/octet-stream.
+func detectContentType(data []byte) string {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, sig.prefix) {
+			return sig.contentType
+		}
+	}
+	if len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")) {
+		return "image/webp"
+	}
+
+	trimmed := bytes.TrimLeft(data, "\t\n\r ")
+	lower := bytes.ToLower(trimmed[:min(len(trimmed), 14)])
+	switch {
+	case bytes.HasPrefix(lower, []byte("<!doctype html")), bytes.HasPrefix(lower, []byte("<html")):
+		return "text/html; charset=utf-8"
+	case bytes.HasPrefix(lower, []byte("<?xml")):
+		return "text/xml; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data looks like UTF-8 text. The sample may end in
+// the middle of a multi-byte rune, so a short invalid tail is allowed.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			return true
+		}
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCookieName   = errors.New("invalid cookie name")
+	ErrInvalidCookieValue  = errors.New("invalid cookie value")
+	ErrInvalidCookieDomain = errors.New("invalid cookie domain")
+	ErrInvalidCookiePath   = errors.New("invalid cookie path")
+	ErrInsecureCookie      = errors.New("cookie attributes require Secure")
+)
+
+// cookieTimeFormat is the sane-cookie-date format (RFC 6265 section 4.1.1)
+const cookieTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// SameSite controls whether a cookie is sent with cross-site requests
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota // Attribute omitted; the browser decides
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone // Requires Secure
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is an HTTP cookie. Cookies parsed from a request only carry Name
+// and Value; the other fields are attributes for Set-Cookie.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time // Zero means no Expires attribute
+
+	// MaxAge = 0 omits the attribute, MaxAge < 0 deletes the cookie now
+	// (sent as Max-Age=0) and MaxAge > 0 is the lifetime in seconds
+	MaxAge int
+
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool // CHIPS partitioned storage; requires Secure
+}
+
+// ParseCookies parses the value of a Cookie request header into name/value
+// pairs (RFC 6265 section 5.4). Pairs with an invalid name or value are
+// skipped. Names are case-sensitive and may repeat.
+func ParseCookies(line string) []*Cookie {
+	var cookies []*Cookie
+	for line != "" {
+		var pair string
+		pair, line, _ = strings.Cut(line, ";")
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || !isToken(name) {
+			continue
+		}
+		value, ok = parseCookieValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Cookies returns the cookies sent in every Cookie header, in order
+func (h *Headers) Cookies() []*Cookie {
+	var cookies []*Cookie
+	for _, line := range h.GetAll("cookie") {
+		cookies = append(cookies, ParseCookies(line)...)
+	}
+	return cookies
+}
+
+// Cookie returns the first cookie named name
+func (h *Headers) Cookie(name string) (*Cookie, bool) {
+	for _, line := range h.GetAll("cookie") {
+		for _, c := range ParseCookies(line) {
+			if c.Name == name {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Valid checks the cookie can be sent in a Set-Cookie header. Besides the
+// RFC 6265 grammar it enforces the browser rules that would otherwise make
+// the cookie silently disappear: SameSite=None and Partitioned need Secure,
+// "__Secure-" names need Secure and "__Host-" names also need Path=/ and no
+// Domain.
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidCookieName, c.Name)
+	}
+	if _, ok := parseCookieValue(c.Value); !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidCookieValue, c.Value)
+	}
+	if c.Domain != "" && !isCookieDomain(c.Domain) {
+		return fmt.Errorf("%w: %q", ErrInvalidCookieDomain, c.Domain)
+	}
+	for i := 0; i < len(c.Path); i++ {
+		if b := c.Path[i]; b < ' ' || b == 0x7f || b == ';' {
+			return fmt.Errorf("%w: %q", ErrInvalidCookiePath, c.Path)
+		}
+	}
+
+	if !c.Secure {
+		switch {
+		case c.SameSite == SameSiteNone:
+			return fmt.Errorf("%w: SameSite=None", ErrInsecureCookie)
+		case c.Partitioned:
+			return fmt.Errorf("%w: Partitioned", ErrInsecureCookie)
+		case strings.HasPrefix(c.Name, "__Secure-"), strings.HasPrefix(c.Name, "__Host-"):
+			return fmt.Errorf("%w: %s prefix", ErrInsecureCookie, c.Name)
+		}
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (c.Domain != "" || c.Path != "/") {
+		return fmt.Errorf("%w: __Host- cookies need Path=/ and no Domain", ErrInvalidCookieDomain)
+	}
+	return nil
+}
+
+// SetCookieValue validates the cookie and serialises it as the value of a
+// Set-Cookie header
+func (c *Cookie) SetCookieValue() (string, error) {
+	if err := c.Valid(); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	sb.WriteByte('=')
+	sb.WriteString(c.Value)
+
+	if c.Path != "" {
+		sb.WriteString("; Path=")
+		sb.WriteString(c.Path)
+	}
+	if c.Domain != "" {
+		sb.WriteString("; Domain=")
+		sb.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		sb.WriteString("; Expires=")
+		sb.WriteString(c.Expires.UTC().Format(cookieTimeFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		sb.WriteString("; Max-Age=")
+		sb.WriteString(strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		sb.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		sb.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		sb.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		sb.WriteString("; SameSite=")
+		sb.WriteString(c.SameSite.String())
+	}
+	if c.Partitioned {
+		sb.WriteString("; Partitioned")
+	}
+	return sb.String(), nil
+}
+
+// String returns the Set-Cookie value, or "" if the cookie is invalid
+func (c *Cookie) String() string {
+	s, _ := c.SetCookieValue()
+	return s
+}
+
+// parseCookieValue strips optional double quotes and checks the value only
+// holds cookie-octets
+func parseCookieValue(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if !isCookieOctet(value[i]) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// isCookieOctet reports whether b may appear in a cookie value: visible
+// ASCII except DQUOTE, comma, semicolon and backslash
+func isCookieOctet(b byte) bool {
+	return b > ' ' && b < 0x7f && b != '"' && b != ',' && b != ';' && b != '\\'
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isValidHeaderChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isCookieDomain checks a Domain attribute is a host name or IP address
+func isCookieDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	if net.ParseIP(domain) != nil {
+		return true
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			b := label[i]
+			if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' || b == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
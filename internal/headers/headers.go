@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"iter"
 	"strconv"
 	"strings"
 )
@@ -21,16 +22,50 @@ var (
 	ErrHeaderTooLarge            = errors.New("header too large")
 )
 
+var crlf = []byte("\r\n")
+
 const (
 	MaxHeaderLines = 100
 	MaxHeaderSize  = 1 << 20 // 1MB
 )
 
+// Headers holds header lines in the order they were added, with lowercase
+// names. Lookups scan the lines, which for the handful of headers on a
+// typical message is faster than a map and lets the storage be reused.
 type Headers struct {
-	headers map[string][]string
+	fields []field
 
 	// Track special headers for validation
-	tracking *headerTracking
+	tracking headerTracking
+}
+
+// field is one header line. Lines read by ParseView keep name and value
+// empty and point into the parsed data instead, so reading a request costs
+// no allocations until a value is asked for.
+type field struct {
+	name, value       string
+	rawName, rawValue []byte
+}
+
+func (f *field) is(name string) bool {
+	if f.rawName != nil {
+		return string(f.rawName) == name
+	}
+	return f.name == name
+}
+
+func (f *field) getName() string {
+	if f.rawName != nil {
+		return internName(f.rawName)
+	}
+	return f.name
+}
+
+func (f *field) getValue() string {
+	if f.rawName != nil {
+		return string(f.rawValue)
+	}
+	return f.value
 }
 
 type headerTracking struct {
@@ -44,50 +79,159 @@ type headerTracking struct {
 }
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers:  make(map[string][]string),
-		tracking: &headerTracking{},
-	}
+	return &Headers{}
+}
+
+// Reset removes every header, keeping the storage for reuse
+func (h *Headers) Reset() {
+	clear(h.fields)
+	h.fields = h.fields[:0]
+	h.tracking = headerTracking{}
+}
+
+// Len returns the number of header lines
+func (h *Headers) Len() int {
+	return len(h.fields)
 }
 
 // Get returns the first value for a header
 func (h *Headers) Get(key string) (string, bool) {
-	values := h.headers[strings.ToLower(key)]
-	if len(values) == 0 {
-		return "", false
+	key = lower(key)
+	for i := range h.fields {
+		if h.fields[i].is(key) {
+			return h.fields[i].getValue(), true
+		}
+	}
+	return "", false
+}
+
+// Has reports whether a header is present
+func (h *Headers) Has(key string) bool {
+	key = lower(key)
+	for i := range h.fields {
+		if h.fields[i].is(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether the first value for a header is exactly value. Unlike
+// comparing the result of Get, it never allocates.
+func (h *Headers) Is(key, value string) bool {
+	key = lower(key)
+	for i := range h.fields {
+		f := &h.fields[i]
+		if !f.is(key) {
+			continue
+		}
+		if f.rawName != nil {
+			return string(f.rawValue) == value
+		}
+		return f.value == value
 	}
-	return values[0], true
+	return false
 }
 
 // GetAll returns all values for a header
 func (h *Headers) GetAll(key string) []string {
-	return h.headers[strings.ToLower(key)]
+	key = lower(key)
+	var values []string
+	for i := range h.fields {
+		if h.fields[i].is(key) {
+			values = append(values, h.fields[i].getValue())
+		}
+	}
+	return values
+}
+
+// All iterates over the header lines in order
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(name, value string) bool) {
+		for i := range h.fields {
+			if !yield(h.fields[i].getName(), h.fields[i].getValue()) {
+				return
+			}
+		}
+	}
 }
 
-// GetAllHeaders returns the internal map (for iteration)
+// GetAllHeaders returns a copy of the headers as a map. Prefer All for
+// iteration, which doesn't allocate.
 func (h *Headers) GetAllHeaders() map[string][]string {
-	return h.headers
+	m := make(map[string][]string)
+	for name, value := range h.All() {
+		m[name] = append(m[name], value)
+	}
+	return m
 }
 
 // Set replaces all values for a header
 func (h *Headers) Set(key, value string) {
-	h.headers[strings.ToLower(key)] = []string{value}
+	key = lower(key)
+	for i := range h.fields {
+		if h.fields[i].is(key) {
+			h.fields[i] = field{name: key, value: value}
+			h.del(key, i+1)
+			return
+		}
+	}
+	h.fields = append(h.fields, field{name: key, value: value})
 }
 
 // Add appends a value to a header (use carefully - validation bypassed)
 func (h *Headers) Add(key, value string) {
-	key = strings.ToLower(key)
-	h.headers[key] = append(h.headers[key], value)
+	h.fields = append(h.fields, field{name: lower(key), value: value})
 }
 
 // Del removes a header
 func (h *Headers) Del(key string) {
-	delete(h.headers, strings.ToLower(key))
+	h.del(lower(key), 0)
 }
 
-// IsChunked returns true if Transfer-Encoding: chunked
+// del removes the lines for key from index from on
+func (h *Headers) del(key string, from int) {
+	kept := h.fields[:from]
+	for _, f := range h.fields[from:] {
+		if !f.is(key) {
+			kept = append(kept, f)
+		}
+	}
+	clear(h.fields[len(kept):])
+	h.fields = kept
+}
+
+// Clone returns a deep copy of the headers. The copy owns its strings, so
+// it stays valid after the headers it came from are reset.
+func (h *Headers) Clone() *Headers {
+	clone := &Headers{
+		fields:   make([]field, len(h.fields)),
+		tracking: h.tracking,
+	}
+	for i := range h.fields {
+		clone.fields[i] = field{name: h.fields[i].getName(), value: h.fields[i].getValue()}
+	}
+	return clone
+}
+
+// IsChunked reports whether chunked is the final Transfer-Encoding, which
+// is what frames the body. The name and value are case-insensitive.
 func (h *Headers) IsChunked() bool {
-	return h.tracking.isChunked
+	if h.tracking.isChunked || h.tracking.seenTransferEncoding {
+		return h.tracking.isChunked
+	}
+	// Set or added rather than parsed
+	value, ok := h.Get("transfer-encoding")
+	return ok && endsInChunked(value)
+}
+
+// endsInChunked reports whether the last coding in a Transfer-Encoding
+// value is chunked
+func endsInChunked(value string) bool {
+	if idx := strings.LastIndexByte(value, ','); idx != -1 {
+		value = value[idx+1:]
+	}
+	return strings.EqualFold(strings.TrimSpace(value), "chunked")
 }
 
 // ContentLength returns the Content-Length value (-1 if not present)
@@ -98,8 +242,21 @@ func (h *Headers) ContentLength() int64 {
 	return h.tracking.contentLengthValue
 }
 
-// Parse parses headers from raw bytes with security validation
+// Parse parses headers from raw bytes with security validation. Names and
+// values are copied out of data.
 func (h *Headers) Parse(data []byte) (int, bool, error) {
+	return h.parse(data, false)
+}
+
+// ParseView is Parse without the copying: the headers keep pointing into
+// data, whose header names it lowercases in place. data must not change
+// until the headers are Reset; values are only copied when Get asks for
+// them. The request parser uses it on the connection's read buffer.
+func (h *Headers) ParseView(data []byte) (int, bool, error) {
+	return h.parse(data, true)
+}
+
+func (h *Headers) parse(data []byte, view bool) (int, bool, error) {
 	read := 0
 	done := false
 
@@ -114,7 +271,7 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 			return read, false, ErrHeaderTooLarge
 		}
 
-		idx := bytes.Index(data[read:], []byte("\r\n"))
+		idx := bytes.Index(data[read:], crlf)
 		if idx == -1 {
 			// Need more data
 			break
@@ -141,7 +298,7 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 		}
 
 		// Validate and store with security checks
-		if err := h.addWithValidation(name, value); err != nil {
+		if err := h.addWithValidation(name, value, view); err != nil {
 			return read, false, err
 		}
 
@@ -160,21 +317,26 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 }
 
 // addWithValidation adds header with security validation
-func (h *Headers) addWithValidation(name, value string) error {
-	nameLower := strings.ToLower(name)
+func (h *Headers) addWithValidation(name, value []byte, view bool) error {
+	for i, b := range name {
+		name[i] = toLower(b)
+	}
 
-	switch nameLower {
+	switch string(name) {
 	case "host":
 		if h.tracking.seenHost {
 			return ErrDuplicateHost
 		}
 		h.tracking.seenHost = true
-		h.headers[nameLower] = []string{value}
 
 	case "content-length":
-		cl, err := strconv.ParseInt(value, 10, 64)
-		if err != nil || cl < 0 {
-			return fmt.Errorf("invalid Content-Length: %w", err)
+		cl, ok := parseContentLength(value)
+		if !ok {
+			var err error
+			cl, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil || cl < 0 {
+				return fmt.Errorf("invalid Content-Length: %w", err)
+			}
 		}
 
 		if h.tracking.seenContentLength {
@@ -186,7 +348,6 @@ func (h *Headers) addWithValidation(name, value string) error {
 
 		h.tracking.seenContentLength = true
 		h.tracking.contentLengthValue = cl
-		h.headers[nameLower] = []string{value}
 
 	case "transfer-encoding":
 		if h.tracking.seenTransferEncoding {
@@ -194,54 +355,75 @@ func (h *Headers) addWithValidation(name, value string) error {
 		}
 		h.tracking.seenTransferEncoding = true
 
-		if strings.ToLower(strings.TrimSpace(value)) == "chunked" {
+		if endsInChunked(string(value)) {
 			h.tracking.isChunked = true
 		}
-		h.headers[nameLower] = []string{value}
-
-	default:
-		h.headers[nameLower] = append(h.headers[nameLower], value)
 	}
 
+	if view {
+		h.fields = append(h.fields, field{rawName: name, rawValue: value})
+	} else {
+		h.fields = append(h.fields, field{name: internName(name), value: string(value)})
+	}
 	return nil
 }
 
 func (h *Headers) validateFinal() error {
-	if h.tracking.isChunked && h.tracking.seenContentLength {
+	// Whatever the codings, a Transfer-Encoding overrides Content-Length.
+	// A peer that frames the body by the length instead would read the
+	// rest as another message.
+	if h.tracking.seenTransferEncoding && h.tracking.seenContentLength {
 		return ErrBothChunkedAndLength
 	}
 
 	return nil
 }
 
-func parseHeader(line []byte) (string, string, error) {
+// parseHeader splits and validates a header line. The name is returned as
+// sent; the value is trimmed.
+func parseHeader(line []byte) ([]byte, []byte, error) {
 	before, after, ok := bytes.Cut(line, []byte{':'})
 	if !ok {
-		return "", "", ErrMalformedHeader
+		return nil, nil, ErrMalformedHeader
 	}
 
 	name := before
 	value := after
 
-	if bytes.ContainsAny(name, " \t") {
-		return "", "", ErrMalformedHeader
-	}
-
 	if len(name) == 0 {
-		return "", "", ErrMalformedHeader
+		return nil, nil, ErrMalformedHeader
 	}
 
 	for _, b := range name {
+		if b == ' ' || b == '\t' {
+			return nil, nil, ErrMalformedHeader
+		}
 		if !isValidHeaderChar(b) {
-			return "", "", fmt.Errorf("%w: %c", ErrInvalidHeaderChar, b)
+			return nil, nil, fmt.Errorf("%w: %c", ErrInvalidHeaderChar, b)
+		}
+	}
+
+	for _, b := range value {
+		if b == 0 || b == '\r' || b == '\n' {
+			return nil, nil, fmt.Errorf("invalid characters in header value")
 		}
 	}
+	return name, bytes.TrimSpace(value), nil
+}
 
-	if bytes.ContainsAny(value, "\x00\r\n") {
-		return "", "", fmt.Errorf("invalid characters in header value")
+// parseContentLength parses a non-negative decimal without allocating
+func parseContentLength(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
 	}
-	value = bytes.TrimSpace(value)
-	return string(name), string(value), nil
+	return n, true
 }
 
 func isValidHeaderChar(b byte) bool {
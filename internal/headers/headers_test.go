@@ -2,6 +2,7 @@ package headers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -165,4 +166,168 @@ func TestHeaderParse(t *testing.T) {
 	val, ok = h.Get("x-empty")
 	assert.True(t, ok)
 	assert.Equal(t, "", val)
-}
\ No newline at end of file
+}
+func TestTransferEncoding(t *testing.T) {
+	tests := []struct {
+		value   string
+		chunked bool
+	}{
+		{"chunked", true},
+		{"Chunked", true},
+		{"gzip, CHUNKED ", true},
+		{"chunked, gzip", false},
+		{"gzip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			h := NewHeaders()
+			_, done, err := h.Parse([]byte("Transfer-Encoding: " + tt.value + "\r\n\r\n"))
+			require.NoError(t, err)
+			require.True(t, done)
+			assert.Equal(t, tt.chunked, h.IsChunked())
+
+			// Headers built rather than parsed answer the same
+			h = NewHeaders()
+			h.Set("Transfer-Encoding", tt.value)
+			assert.Equal(t, tt.chunked, h.IsChunked())
+
+			// Content-Length alongside any Transfer-Encoding is ambiguous
+			h = NewHeaders()
+			_, _, err = h.Parse([]byte("Transfer-Encoding: " + tt.value + "\r\nContent-Length: 5\r\n\r\n"))
+			assert.ErrorIs(t, err, ErrBothChunkedAndLength)
+		})
+	}
+
+	assert.False(t, NewHeaders().IsChunked())
+}
+
+func TestHeaderOrderAndSet(t *testing.T) {
+	h := NewHeaders()
+	h.Add("B", "1")
+	h.Add("a", "2")
+	h.Add("b", "3")
+	h.Add("C", "4")
+
+	// Set replaces the first field in place and drops the rest
+	h.Set("b", "5")
+	var got []string
+	for name, value := range h.All() {
+		got = append(got, name+"="+value)
+	}
+	assert.Equal(t, []string{"b=5", "a=2", "c=4"}, got)
+	assert.Equal(t, 3, h.Len())
+
+	assert.True(t, h.Has("A"))
+	assert.True(t, h.Is("B", "5"))
+	assert.False(t, h.Is("b", "1"))
+
+	h.Del("A")
+	assert.False(t, h.Has("a"))
+	h.Reset()
+	assert.Equal(t, 0, h.Len())
+}
+
+func TestParseView(t *testing.T) {
+	data := []byte("Host: example.com\r\nX-Custom: One\r\nContent-Length: 12\r\n\r\n")
+	h := NewHeaders()
+	n, done, err := h.ParseView(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, len(data), n)
+
+	// Names are lowercased in place; values are left alone
+	assert.Equal(t, "host: example.com\r\nx-custom: One\r\n", string(data[:34]))
+	v, _ := h.Get("x-custom")
+	assert.Equal(t, "One", v)
+	assert.Equal(t, int64(12), h.ContentLength())
+
+	// Views see changes to the buffer; clones don't
+	clone := h.Clone()
+	copy(data[8:], "XAMPL")
+	v, _ = h.Get("host")
+	assert.Equal(t, "exXAMPL.com", v)
+	v, _ = clone.Get("host")
+	assert.Equal(t, "example.com", v)
+}
+
+func TestParseCookies(t *testing.T) {
+	cookies := ParseCookies(`session=abc123; theme="dark"; bad name=x; empty=; novalue; lang=en; lang=fr`)
+	var pairs []string
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"session=abc123", "theme=dark", "empty=", "lang=en", "lang=fr"}, pairs)
+
+	assert.Empty(t, ParseCookies(`a=b c; d="e;`))
+
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("Cookie: a=1; b=2\r\nCookie: c=3\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Len(t, h.Cookies(), 3)
+
+	c, ok := h.Cookie("c")
+	require.True(t, ok)
+	assert.Equal(t, "3", c.Value)
+	_, ok = h.Cookie("A")
+	assert.False(t, ok)
+}
+
+func TestSetCookieValue(t *testing.T) {
+	expires := time.Date(2025, time.March, 1, 8, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		cookie Cookie
+		want   string
+	}{
+		{Cookie{Name: "id", Value: "42"}, "id=42"},
+		{
+			Cookie{Name: "sid", Value: "x", Path: "/", Domain: ".example.com", Expires: expires,
+				MaxAge: 3600, Secure: true, HttpOnly: true, SameSite: SameSiteStrict},
+			"sid=x; Path=/; Domain=example.com; Expires=Sat, 01 Mar 2025 07:30:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict",
+		},
+		{Cookie{Name: "old", MaxAge: -1}, "old=; Max-Age=0"},
+		{Cookie{Name: "embed", Value: "1", Secure: true, SameSite: SameSiteNone, Partitioned: true}, "embed=1; Secure; SameSite=None; Partitioned"},
+		{Cookie{Name: "__Host-sid", Value: "1", Path: "/", Secure: true}, "__Host-sid=1; Path=/; Secure"},
+	}
+	for _, tt := range tests {
+		got, err := tt.cookie.SetCookieValue()
+		require.NoError(t, err, tt.want)
+		assert.Equal(t, tt.want, got)
+	}
+
+	invalid := []struct {
+		cookie Cookie
+		err    error
+	}{
+		{Cookie{Name: "", Value: "x"}, ErrInvalidCookieName},
+		{Cookie{Name: "a b", Value: "x"}, ErrInvalidCookieName},
+		{Cookie{Name: "a", Value: "x;y"}, ErrInvalidCookieValue},
+		{Cookie{Name: "a", Value: "hello world"}, ErrInvalidCookieValue},
+		{Cookie{Name: "a", Domain: "exa mple.com"}, ErrInvalidCookieDomain},
+		{Cookie{Name: "a", Path: "/x;Domain=evil"}, ErrInvalidCookiePath},
+		{Cookie{Name: "a", SameSite: SameSiteNone}, ErrInsecureCookie},
+		{Cookie{Name: "a", Partitioned: true}, ErrInsecureCookie},
+		{Cookie{Name: "__Secure-a"}, ErrInsecureCookie},
+		{Cookie{Name: "__Host-a", Secure: true, Path: "/", Domain: "example.com"}, ErrInvalidCookieDomain},
+	}
+	for _, tt := range invalid {
+		_, err := tt.cookie.SetCookieValue()
+		assert.ErrorIs(t, err, tt.err, tt.cookie.Name)
+		assert.Empty(t, tt.cookie.String())
+	}
+}
+
+func BenchmarkParseView(b *testing.B) {
+	data := []byte("Host: example.com\r\nUser-Agent: bench/1.0\r\nAccept: */*\r\n" +
+		"Accept-Encoding: gzip, deflate\r\nX-Request-ID: abc123\r\nContent-Length: 42\r\n\r\n")
+	h := NewHeaders()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		h.Reset()
+		if _, _, err := h.ParseView(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package headers
+
+import "strings"
+
+// commonNames are header names common enough that their lowercase string is
+// kept, so looking them up or reading them from a request doesn't allocate
+var commonNames = []string{
+	"accept", "accept-encoding", "accept-language", "accept-ranges",
+	"access-control-allow-credentials", "access-control-allow-headers",
+	"access-control-allow-methods", "access-control-allow-origin",
+	"access-control-expose-headers", "access-control-max-age",
+	"access-control-request-headers", "access-control-request-method",
+	"age", "allow", "authorization", "cache-control", "connection",
+	"content-disposition", "content-encoding", "content-language",
+	"content-length", "content-range", "content-security-policy",
+	"content-type", "cookie", "date", "etag", "expect", "expires",
+	"forwarded", "host", "if-match", "if-modified-since", "if-none-match",
+	"if-range", "if-unmodified-since", "keep-alive", "last-modified",
+	"location", "origin", "pragma", "range", "referer", "referrer-policy",
+	"retry-after", "sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site",
+	"sec-websocket-accept", "sec-websocket-extensions", "sec-websocket-key",
+	"sec-websocket-protocol", "sec-websocket-version", "server",
+	"set-cookie", "strict-transport-security", "te", "trailer",
+	"transfer-encoding", "upgrade", "upgrade-insecure-requests",
+	"user-agent", "vary", "via", "www-authenticate", "x-content-type-options",
+	"x-csrf-token", "x-forwarded-for", "x-forwarded-host", "x-forwarded-proto",
+	"x-frame-options", "x-real-ip", "x-request-id", "x-requested-with",
+	"x-xss-protection",
+}
+
+// lowerNames maps each common name, lowercase or in the spellings used in
+// code ("Content-Type", "ETag", "X-Request-ID"), to its lowercase form
+var lowerNames = func() map[string]string {
+	m := make(map[string]string, 3*len(commonNames))
+	for _, name := range commonNames {
+		m[name] = name
+		m[canonical(name)] = name
+		m[strings.ToUpper(name)] = name
+	}
+	for _, name := range []string{"ETag", "TE", "WWW-Authenticate", "X-CSRF-Token", "X-Request-ID", "X-XSS-Protection"} {
+		m[name] = strings.ToLower(name)
+	}
+	return m
+}()
+
+// canonical capitalizes the first letter and each letter after a hyphen
+func canonical(name string) string {
+	b := []byte(name)
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// lower returns key in lowercase, without allocating for keys that already
+// are or that are common header names
+func lower(key string) string {
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; 'A' <= c && c <= 'Z' {
+			if name, ok := lowerNames[key]; ok {
+				return name
+			}
+			return strings.ToLower(key)
+		}
+	}
+	return key
+}
+
+// internName returns a lowercase name as a string, shared for common names
+func internName(name []byte) string {
+	if s, ok := lowerNames[string(name)]; ok {
+		return s
+	}
+	return string(name)
+}
+
+func toLower(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + ('a' - 'A')
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+)
+
+// hopByHopHeaders apply to a single connection and are never forwarded
+// (RFC 9110 section 7.6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHop strips hop-by-hop headers, including any the sender
+// listed in Connection
+func removeHopByHop(h *headers.Headers) {
+	for _, value := range h.GetAll("connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// addForwarded records the client hop in X-Forwarded-For, X-Forwarded-Host,
+// X-Forwarded-Proto and the standard Forwarded header (RFC 7239)
+func addForwarded(h *headers.Headers, remoteAddr, host, proto string) {
+	clientIP := remoteAddr
+	if ip, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		clientIP = ip
+	}
+
+	if clientIP != "" {
+		if prior := strings.Join(h.GetAll("x-forwarded-for"), ", "); prior != "" {
+			h.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			h.Set("X-Forwarded-For", clientIP)
+		}
+	}
+	if host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	h.Set("X-Forwarded-Proto", proto)
+
+	var elems []string
+	if clientIP != "" {
+		elems = append(elems, "for="+forwardedNode(clientIP))
+	}
+	if host != "" {
+		elems = append(elems, "host="+quoteForwarded(host))
+	}
+	elems = append(elems, "proto="+proto)
+	element := strings.Join(elems, ";")
+
+	if prior := strings.Join(h.GetAll("forwarded"), ", "); prior != "" {
+		h.Set("Forwarded", prior+", "+element)
+	} else {
+		h.Set("Forwarded", element)
+	}
+}
+
+// forwardedNode formats an IP for Forwarded; IPv6 must be bracketed and quoted
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded quotes a value unless it is a plain token
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	return c < 0x7f && c > 0x20 && !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c)
+}
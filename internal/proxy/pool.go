@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// upstreamConn is a connection to an upstream server
+type upstreamConn struct {
+	net.Conn
+	idleSince time.Time
+	reused    bool // Came from the idle pool, so the server may have closed it
+}
+
+// pool keeps idle keep-alive connections to a single upstream
+type pool struct {
+	addr        string
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*upstreamConn // Most recently used last
+	closed bool
+}
+
+func newPool(addr string, maxIdle int, idleTimeout time.Duration) *pool {
+	return &pool{
+		addr:        addr,
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// get returns an idle connection, or dials a new one
+func (p *pool) get(ctx context.Context, dialer *net.Dialer) (*upstreamConn, error) {
+	if c := p.takeIdle(); c != nil {
+		return c, nil
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &upstreamConn{Conn: conn}, nil
+}
+
+// takeIdle pops the most recently used idle connection, closing any that
+// have been idle for too long
+func (p *pool) takeIdle() *upstreamConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if p.idleTimeout > 0 && time.Since(c.idleSince) > p.idleTimeout {
+			c.Close()
+			continue
+		}
+
+		c.reused = true
+		return c
+	}
+	return nil
+}
+
+// put returns a connection for reuse, closing it if the pool is full
+func (p *pool) put(c *upstreamConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.idle) >= p.maxIdle {
+		c.Close()
+		return
+	}
+
+	c.idleSince = time.Now()
+	p.idle = append(p.idle, c)
+}
+
+// idleCount returns the number of pooled connections
+func (p *pool) idleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// close closes idle connections. With final set, later puts are refused.
+func (p *pool) close(final bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+	if final {
+		p.closed = true
+	}
+}
@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+var (
+	ErrNoUpstreams = errors.New("proxy: no upstreams configured")
+)
+
+// copyBufferSize matches the server's medium pooled buffer
+const copyBufferSize = 32 << 10
+
+// Config configures a reverse proxy
+type Config struct {
+	Upstreams              []string      // host:port (or http://host:port), used round-robin
+	DialTimeout            time.Duration // Connecting to an upstream
+	ResponseHeaderTimeout  time.Duration // Waiting for the upstream's response head (504 after)
+	IdleConnTimeout        time.Duration // Pooled connections idle longer are closed
+	MaxIdleConnsPerHost    int           // Pooled keep-alive connections per upstream
+	MaxResponseHeaderBytes int
+	PreserveHost           bool // Forward the client's Host instead of the upstream address
+}
+
+// DefaultConfig returns sensible defaults for the given upstreams
+func DefaultConfig(upstreams ...string) Config {
+	return Config{
+		Upstreams:              upstreams,
+		DialTimeout:            5 * time.Second,
+		ResponseHeaderTimeout:  30 * time.Second,
+		IdleConnTimeout:        90 * time.Second,
+		MaxIdleConnsPerHost:    32,
+		MaxResponseHeaderBytes: 1 << 20,
+	}
+}
+
+// Proxy forwards requests to upstream HTTP/1.1 servers over pooled
+// keep-alive connections. Request and response bodies are streamed.
+// Protocol upgrades (WebSocket) are not forwarded.
+type Proxy struct {
+	config Config
+	pools  []*pool
+	next   atomic.Uint64
+	dialer net.Dialer
+}
+
+// New creates a reverse proxy
+func New(config Config) *Proxy {
+	p := &Proxy{
+		config: config,
+		dialer: net.Dialer{Timeout: config.DialTimeout, KeepAlive: 30 * time.Second},
+	}
+
+	for _, upstream := range config.Upstreams {
+		addr := strings.TrimSuffix(strings.TrimPrefix(upstream, "http://"), "/")
+		p.pools = append(p.pools, newPool(addr, config.MaxIdleConnsPerHost, config.IdleConnTimeout))
+	}
+	return p
+}
+
+// ServeHTTP implements server.Handler
+func (p *Proxy) ServeHTTP(ctx *server.Context) {
+	resp, uc, pl, err := p.roundTrip(ctx)
+	if err != nil {
+		p.fail(ctx, err)
+		return
+	}
+	p.copyResponse(ctx, resp, uc, pl)
+}
+
+// CloseIdleConnections closes all pooled upstream connections
+func (p *Proxy) CloseIdleConnections() {
+	for _, pl := range p.pools {
+		pl.close(false)
+	}
+}
+
+// Close closes pooled connections and stops pooling new ones
+func (p *Proxy) Close() error {
+	for _, pl := range p.pools {
+		pl.close(true)
+	}
+	return nil
+}
+
+// outgoingRequest builds the request sent upstream
+func (p *Proxy) outgoingRequest(ctx *server.Context, host string) *request.Request {
+	in := ctx.Request
+
+	out := request.NewRequest()
+	out.Method = in.Method
+	out.Path = in.Path
+	if in.URL != nil {
+		// Absolute-form targets sent to a proxy go upstream in origin-form
+		out.Path = in.URL.RequestURI()
+	}
+	out.Version = "HTTP/1.1"
+	out.Headers = in.Headers.Clone()
+	out.Body = in.Body
+
+	removeHopByHop(out.Headers)
+	if in.IsChunked() {
+		// Re-framed by Request.Write
+		out.Headers.Set("Transfer-Encoding", "chunked")
+	}
+
+	clientHost, _ := in.Headers.Get("host")
+	proto := "http"
+	if ctx.TLS() != nil {
+		proto = "https"
+	}
+	addForwarded(out.Headers, ctx.RemoteAddr(), clientHost, proto)
+
+	if !p.config.PreserveHost {
+		out.Headers.Set("Host", host)
+	}
+	return out
+}
+
+// roundTrip sends the request to the next upstream and reads the response
+// head. Upstreams that refuse the connection are skipped; a pooled
+// connection the upstream already closed is retried when the request has
+// no body to replay.
+func (p *Proxy) roundTrip(ctx *server.Context) (*request.Response, *upstreamConn, *pool, error) {
+	if len(p.pools) == 0 {
+		return nil, nil, nil, ErrNoUpstreams
+	}
+
+	replayable := ctx.Request.Body == request.NoBody
+	start := p.next.Add(1) - 1
+
+	var lastErr error
+	for attempt := 0; attempt < len(p.pools); attempt++ {
+		pl := p.pools[(start+uint64(attempt))%uint64(len(p.pools))]
+		out := p.outgoingRequest(ctx, pl.addr)
+
+		for {
+			uc, err := pl.get(ctx.Context(), &p.dialer)
+			if err != nil {
+				// Nothing was sent, so another upstream can take it
+				lastErr = err
+				break
+			}
+
+			resp, err := p.send(ctx, uc, out)
+			if err == nil {
+				return resp, uc, pl, nil
+			}
+			uc.Close()
+
+			if uc.reused && replayable && ctx.Context().Err() == nil && !isTimeout(err) {
+				continue
+			}
+			return nil, nil, nil, err
+		}
+	}
+	return nil, nil, nil, lastErr
+}
+
+// send writes the request and waits for the response head
+func (p *Proxy) send(ctx *server.Context, uc *upstreamConn, out *request.Request) (*request.Response, error) {
+	// A client that goes away aborts the exchange
+	stop := context.AfterFunc(ctx.Context(), func() { uc.Close() })
+	defer stop()
+
+	if err := out.Write(uc); err != nil {
+		return nil, err
+	}
+
+	if p.config.ResponseHeaderTimeout > 0 {
+		uc.SetReadDeadline(time.Now().Add(p.config.ResponseHeaderTimeout))
+	}
+	resp, err := request.ReadResponse(uc, out.Method, p.config.MaxResponseHeaderBytes, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	uc.SetReadDeadline(time.Time{})
+
+	return resp, nil
+}
+
+// copyResponse streams the upstream response to the client and returns
+// the connection to the pool if it is still usable
+func (p *Proxy) copyResponse(ctx *server.Context, resp *request.Response, uc *upstreamConn, pl *pool) {
+	stop := context.AfterFunc(ctx.Context(), func() { uc.Close() })
+
+	h := resp.Headers.Clone()
+	removeHopByHop(h)
+
+	hasBody := resp.Body != request.NoBody
+	chunked := false
+	if hasBody && (resp.IsChunked() || resp.ContentLength() < 0) {
+		if ctx.Request.IsHTTP10() {
+			// HTTP/1.0 clients only understand close-delimited bodies
+			h.Set("Connection", "close")
+		} else {
+			h.Set("Transfer-Encoding", "chunked")
+			chunked = true
+		}
+	}
+
+	w := ctx.Response
+	if err := w.WriteStatusLine(response.StatusCode(resp.StatusCode)); err != nil {
+		stop()
+		uc.Close()
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		stop()
+		uc.Close()
+		return
+	}
+
+	complete := !hasBody || p.copyBody(w, resp.Body, chunked)
+
+	if !stop() || !complete || resp.WantsClose() {
+		uc.Close()
+		return
+	}
+	pl.put(uc)
+}
+
+// copyBody streams body to the client, flushing as data arrives so
+// long-polling and server-sent events work through the proxy
+func (p *Proxy) copyBody(w *response.Writer, body io.Reader, chunked bool) bool {
+	buf := server.GetBuffer(copyBufferSize)
+	defer server.PutBuffer(buf)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			var werr error
+			if chunked {
+				werr = w.WriteChunk(buf[:n])
+			} else {
+				werr = w.WriteBody(buf[:n])
+			}
+			if werr != nil {
+				return false
+			}
+			w.Flush()
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Truncated upstream body: the client must not mistake it
+			// for a complete response
+			w.Headers().Set("Connection", "close")
+			return false
+		}
+	}
+
+	if chunked {
+		return w.FinishChunked() == nil
+	}
+	return true
+}
+
+// fail maps an upstream error to 502 Bad Gateway or 504 Gateway Timeout
+func (p *Proxy) fail(ctx *server.Context, err error) {
+	if ctx.Context().Err() != nil {
+		// The client is gone; nobody is listening
+		return
+	}
+	if isTimeout(err) {
+		ctx.Error(response.StatusGatewayTimeout, "Gateway Timeout")
+		return
+	}
+	ctx.Error(response.StatusBadGateway, "Bad Gateway")
+}
+
+// isTimeout reports whether err is a deadline or dial timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
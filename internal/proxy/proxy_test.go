@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// upstream is a minimal HTTP/1.1 server built on the request parser.
+// respond returns the raw response; close drops the connection afterwards.
+type upstream struct {
+	ln       net.Listener
+	accepted atomic.Int32
+	respond  func(req *request.Request) (raw string, close bool)
+}
+
+func newUpstream(t *testing.T, respond func(req *request.Request) (string, bool)) *upstream {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	u := &upstream{ln: ln, respond: respond}
+	go u.serve()
+	return u
+}
+
+func (u *upstream) addr() string { return u.ln.Addr().String() }
+
+func (u *upstream) serve() {
+	for {
+		conn, err := u.ln.Accept()
+		if err != nil {
+			return
+		}
+		u.accepted.Add(1)
+
+		go func() {
+			defer conn.Close()
+			for {
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					return
+				}
+				raw, closeConn := u.respond(req)
+				req.DrainBody(1 << 20)
+				if _, err := io.WriteString(conn, raw); err != nil || closeConn {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// proxyRequest runs the proxy for a raw request and parses the response
+func proxyRequest(t *testing.T, p *Proxy, raw string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	p.ServeHTTP(server.NewContext(req, response.NewWriter(&out), nil))
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func okResponse(body string, extra ...string) string {
+	return "HTTP/1.1 200 OK\r\n" + strings.Join(append(extra, ""), "\r\n") +
+		fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func TestProxyForwardsAndReusesConnections(t *testing.T) {
+	var seen atomic.Pointer[headers.Headers]
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		seen.Store(req.Headers)
+		return okResponse("hello", "Connection: keep-alive", "Keep-Alive: timeout=5", "X-Upstream: yes"), false
+	})
+	p := New(DefaultConfig(up.addr()))
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, body := proxyRequest(t, p, "GET /hello?x=1 HTTP/1.1\r\nHost: example.com\r\n"+
+			"Connection: keep-alive, X-Secret\r\nX-Secret: 1\r\nX-Forwarded-For: 10.0.0.1\r\n\r\n")
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "hello", body)
+		assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+		assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	}
+
+	assert.Equal(t, int32(1), up.accepted.Load(), "keep-alive connection should be reused")
+	assert.Equal(t, 1, p.pools[0].idleCount())
+
+	h := seen.Load()
+	host, _ := h.Get("host")
+	assert.Equal(t, up.addr(), host)
+	_, ok := h.Get("x-secret")
+	assert.False(t, ok, "headers named in Connection are hop-by-hop")
+	xfh, _ := h.Get("x-forwarded-host")
+	assert.Equal(t, "example.com", xfh)
+	xff, _ := h.Get("x-forwarded-for")
+	assert.Equal(t, "10.0.0.1", xff) // No peer address in this test
+	proto, _ := h.Get("x-forwarded-proto")
+	assert.Equal(t, "http", proto)
+}
+
+func TestProxyPreserveHost(t *testing.T) {
+	var host atomic.Value
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		h, _ := req.Headers.Get("host")
+		host.Store(h)
+		return okResponse(""), false
+	})
+	config := DefaultConfig(up.addr())
+	config.PreserveHost = true
+	p := New(config)
+	defer p.Close()
+
+	proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "example.com", host.Load())
+}
+
+func TestProxyStreamsChunkedBodies(t *testing.T) {
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		body, _ := io.ReadAll(req.Body)
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(body), body), false
+	})
+	p := New(DefaultConfig(up.addr()))
+	defer p.Close()
+
+	resp, body := proxyRequest(t, p, "POST /echo HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "hello world", body)
+	assert.Equal(t, 1, p.pools[0].idleCount())
+}
+
+func TestProxyCloseDelimitedResponse(t *testing.T) {
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nuntil the end", true
+	})
+	p := New(DefaultConfig(up.addr()))
+	defer p.Close()
+
+	resp, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "until the end", body)
+	assert.Equal(t, 0, p.pools[0].idleCount())
+}
+
+func TestProxyRetriesStaleConnection(t *testing.T) {
+	// Closes every connection after responding, without saying so
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		return okResponse("fresh"), true
+	})
+	p := New(DefaultConfig(up.addr()))
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "fresh", body)
+		time.Sleep(10 * time.Millisecond) // Let the close reach us
+	}
+	assert.Equal(t, int32(2), up.accepted.Load())
+}
+
+func TestProxyFailover(t *testing.T) {
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		return okResponse("alive"), false
+	})
+
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	p := New(DefaultConfig(deadAddr, up.addr()))
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "alive", body)
+	}
+
+	p = New(DefaultConfig(deadAddr))
+	resp, _ := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+}
+
+func TestProxyGatewayTimeout(t *testing.T) {
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		time.Sleep(200 * time.Millisecond)
+		return okResponse("late"), false
+	})
+	config := DefaultConfig(up.addr())
+	config.ResponseHeaderTimeout = 20 * time.Millisecond
+	p := New(config)
+	defer p.Close()
+
+	resp, _ := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, 504, resp.StatusCode)
+}
+
+func TestProxySkipsInterimResponses(t *testing.T) {
+	up := newUpstream(t, func(req *request.Request) (string, bool) {
+		return "HTTP/1.1 100 Continue\r\n\r\n" + okResponse("final"), false
+	})
+	p := New(DefaultConfig(up.addr()))
+	defer p.Close()
+
+	resp, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "final", body)
+}
+
+func TestAddForwarded(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("Forwarded", "for=192.0.2.1")
+	addForwarded(h, "[2001:db8::1]:4711", "example.com:8080", "http")
+
+	xff, _ := h.Get("x-forwarded-for")
+	assert.Equal(t, "2001:db8::1", xff)
+	fwd, _ := h.Get("forwarded")
+	assert.Equal(t, `for=192.0.2.1, for="[2001:db8::1]";host="example.com:8080";proto=http`, fwd)
+}
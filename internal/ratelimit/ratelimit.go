@@ -0,0 +1,304 @@
+// Package ratelimit provides per-key request rate limiters: a token bucket,
+// GCRA and a sliding window log. State is kept in memory, so limits apply
+// per process.
+package ratelimit
+
+import (
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidRate  = errors.New("ratelimit: rate needs a positive limit and period")
+	ErrInvalidBurst = errors.New("ratelimit: burst must be at least 1")
+)
+
+// cleanupInterval is how often idle keys are evicted
+const cleanupInterval = time.Minute
+
+// Rate is Limit requests per Period
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// PerSecond returns a rate of n requests per second
+func PerSecond(n int) Rate { return Rate{Limit: n, Period: time.Second} }
+
+// PerMinute returns a rate of n requests per minute
+func PerMinute(n int) Rate { return Rate{Limit: n, Period: time.Minute} }
+
+// PerHour returns a rate of n requests per hour
+func PerHour(n int) Rate { return Rate{Limit: n, Period: time.Hour} }
+
+func (r Rate) valid() bool {
+	return r.Limit > 0 && r.Period > 0
+}
+
+// interval is the time between requests at a steady rate
+func (r Rate) interval() time.Duration {
+	return r.Period / time.Duration(r.Limit)
+}
+
+// Result describes the outcome of one Allow call
+type Result struct {
+	Allowed    bool
+	Limit      int           // Requests the quota allows per Window
+	Remaining  int           // Requests that would be allowed right now
+	Window     time.Duration // Time the quota applies to
+	Reset      time.Duration // Until the full quota is available again
+	RetryAfter time.Duration // Until a request can succeed; zero if Allowed
+}
+
+// Limiter decides whether a request under a key may proceed. Each call that
+// is allowed counts against the key's quota. Implementations are safe for
+// concurrent use.
+type Limiter interface {
+	Allow(key string) Result
+
+	// Close stops background cleanup; the limiter must not be used after
+	Close()
+}
+
+// table holds per-key limiter state, sharded to keep lock contention low,
+// and evicts keys whose state has returned to its initial value
+type table[T any] struct {
+	seed   maphash.Seed
+	shards [32]shard[T]
+	now    func() time.Time // Replaced in tests
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type shard[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*entry[T]
+}
+
+type entry[T any] struct {
+	state   T
+	expires time.Time // After this the state is the same as a fresh key's
+}
+
+func newTable[T any]() *table[T] {
+	t := &table[T]{
+		seed: maphash.MakeSeed(),
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	for i := range t.shards {
+		t.shards[i].entries = make(map[string]*entry[T])
+	}
+	go t.cleanup()
+	return t
+}
+
+// update runs fn on key's state under its shard lock. fresh is true when
+// the key has no live state; fn then starts from the zero value. fn
+// returns the result and when the new state expires.
+func (t *table[T]) update(key string, fn func(state *T, fresh bool, now time.Time) (Result, time.Time)) Result {
+	s := &t.shards[maphash.String(t.seed, key)%uint64(len(t.shards))]
+	now := t.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	fresh := !ok || !now.Before(e.expires)
+	if !ok {
+		e = &entry[T]{}
+		s.entries[key] = e
+	} else if fresh {
+		var zero T
+		e.state = zero
+	}
+
+	res, expires := fn(&e.state, fresh, now)
+	e.expires = expires
+	return res
+}
+
+func (t *table[T]) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := t.now()
+			for i := range t.shards {
+				s := &t.shards[i]
+				s.mu.Lock()
+				for key, e := range s.entries {
+					if !now.Before(e.expires) {
+						delete(s.entries, key)
+					}
+				}
+				s.mu.Unlock()
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *table[T]) close() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// len returns the number of tracked keys, including idle ones not yet
+// evicted
+func (t *table[T]) len() int {
+	n := 0
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		n += len(s.entries)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// TokenBucket refills a bucket of Burst tokens at Rate; each request takes
+// one. Bursts of up to Burst requests pass at once, after which requests
+// are allowed at the steady rate.
+type TokenBucket struct {
+	rate  Rate
+	burst int
+	table *table[bucketState]
+}
+
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a token bucket limiter
+func NewTokenBucket(rate Rate, burst int) (*TokenBucket, error) {
+	if !rate.valid() {
+		return nil, ErrInvalidRate
+	}
+	if burst < 1 {
+		return nil, ErrInvalidBurst
+	}
+	return &TokenBucket{rate: rate, burst: burst, table: newTable[bucketState]()}, nil
+}
+
+func (b *TokenBucket) Allow(key string) Result {
+	interval := float64(b.rate.interval())
+	capacity := float64(b.burst)
+
+	return b.table.update(key, func(s *bucketState, fresh bool, now time.Time) (Result, time.Time) {
+		if fresh {
+			s.tokens = capacity
+		} else {
+			s.tokens = min(capacity, s.tokens+float64(now.Sub(s.last))/interval)
+		}
+		s.last = now
+
+		res := Result{Limit: b.burst, Window: time.Duration(capacity * interval)}
+		if s.tokens >= 1 {
+			s.tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = time.Duration((1 - s.tokens) * interval)
+		}
+		res.Remaining = int(s.tokens)
+		res.Reset = time.Duration((capacity - s.tokens) * interval)
+		return res, now.Add(res.Reset)
+	})
+}
+
+func (b *TokenBucket) Close() { b.table.close() }
+
+// GCRA is the generic cell rate algorithm. It allows the same traffic as a
+// token bucket but stores a single timestamp per key: the theoretical
+// arrival time of the next request at the steady rate.
+type GCRA struct {
+	rate  Rate
+	burst int
+	table *table[time.Time]
+}
+
+// NewGCRA creates a GCRA limiter allowing bursts of up to burst requests
+func NewGCRA(rate Rate, burst int) (*GCRA, error) {
+	if !rate.valid() {
+		return nil, ErrInvalidRate
+	}
+	if burst < 1 {
+		return nil, ErrInvalidBurst
+	}
+	return &GCRA{rate: rate, burst: burst, table: newTable[time.Time]()}, nil
+}
+
+func (g *GCRA) Allow(key string) Result {
+	interval := g.rate.interval()
+	tolerance := interval * time.Duration(g.burst)
+
+	return g.table.update(key, func(tat *time.Time, fresh bool, now time.Time) (Result, time.Time) {
+		if fresh || tat.Before(now) {
+			*tat = now
+		}
+		next := tat.Add(interval)
+
+		res := Result{Limit: g.burst, Window: tolerance}
+		if allowAt := next.Add(-tolerance); now.Before(allowAt) {
+			res.RetryAfter = allowAt.Sub(now)
+			res.Reset = tat.Sub(now)
+			return res, *tat
+		}
+
+		*tat = next
+		res.Allowed = true
+		res.Remaining = int((tolerance - next.Sub(now)) / interval)
+		res.Reset = next.Sub(now)
+		return res, next
+	})
+}
+
+func (g *GCRA) Close() { g.table.close() }
+
+// SlidingWindowLog allows at most Rate.Limit requests in any Rate.Period.
+// It is exact, with no burst at window boundaries, but stores a timestamp
+// per allowed request, so keep Limit modest.
+type SlidingWindowLog struct {
+	rate  Rate
+	table *table[[]time.Time]
+}
+
+// NewSlidingWindowLog creates a sliding window log limiter
+func NewSlidingWindowLog(rate Rate) (*SlidingWindowLog, error) {
+	if !rate.valid() {
+		return nil, ErrInvalidRate
+	}
+	return &SlidingWindowLog{rate: rate, table: newTable[[]time.Time]()}, nil
+}
+
+func (l *SlidingWindowLog) Allow(key string) Result {
+	return l.table.update(key, func(log *[]time.Time, fresh bool, now time.Time) (Result, time.Time) {
+		// Drop requests that have left the window, keeping the backing array
+		cutoff := now.Add(-l.rate.Period)
+		expired := 0
+		for expired < len(*log) && !(*log)[expired].After(cutoff) {
+			expired++
+		}
+		*log = (*log)[:copy(*log, (*log)[expired:])]
+
+		res := Result{Limit: l.rate.Limit, Window: l.rate.Period}
+		if len(*log) < l.rate.Limit {
+			*log = append(*log, now)
+			res.Allowed = true
+		} else {
+			res.RetryAfter = (*log)[0].Add(l.rate.Period).Sub(now)
+		}
+		res.Remaining = l.rate.Limit - len(*log)
+
+		expires := (*log)[len(*log)-1].Add(l.rate.Period)
+		res.Reset = expires.Sub(now)
+		return res, expires
+	})
+}
+
+func (l *SlidingWindowLog) Close() { l.table.close() }
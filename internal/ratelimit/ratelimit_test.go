@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newClock() *clock                   { return &clock{t: time.Unix(1_700_000_000, 0)} }
+
+// allowed calls Allow n times and counts the allowed requests
+func allowed(l Limiter, key string, n int) int {
+	count := 0
+	for range n {
+		if l.Allow(key).Allowed {
+			count++
+		}
+	}
+	return count
+}
+
+// Token bucket and GCRA allow the same traffic
+func TestBurstLimiters(t *testing.T) {
+	bucket, err := NewTokenBucket(PerSecond(10), 5)
+	require.NoError(t, err)
+	gcra, err := NewGCRA(PerSecond(10), 5)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		l     Limiter
+		clock *clock
+	}{
+		"bucket": {bucket, setClock(bucket.table)},
+		"gcra":   {gcra, setClock(gcra.table)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer tc.l.Close()
+
+			res := tc.l.Allow("a")
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 5, res.Limit)
+			assert.Equal(t, 4, res.Remaining)
+			assert.Equal(t, 500*time.Millisecond, res.Window)
+			assert.Equal(t, 100*time.Millisecond, res.Reset)
+
+			assert.Equal(t, 4, allowed(tc.l, "a", 10))
+			res = tc.l.Allow("a")
+			assert.False(t, res.Allowed)
+			assert.Equal(t, 0, res.Remaining)
+			assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+			assert.Equal(t, 500*time.Millisecond, res.Reset)
+
+			// Keys are independent
+			assert.True(t, tc.l.Allow("b").Allowed)
+
+			// One token comes back every 100ms
+			tc.clock.advance(250 * time.Millisecond)
+			assert.Equal(t, 2, allowed(tc.l, "a", 5))
+
+			// After a full refill the burst is available again, no more
+			tc.clock.advance(time.Hour)
+			assert.Equal(t, 5, allowed(tc.l, "a", 10))
+		})
+	}
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	l, err := NewSlidingWindowLog(PerMinute(3))
+	require.NoError(t, err)
+	defer l.Close()
+	c := setClock(l.table)
+
+	assert.Equal(t, 2, allowed(l, "a", 2))
+	c.advance(40 * time.Second)
+	res := l.Allow("a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, time.Minute, res.Reset)
+
+	res = l.Allow("a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 20*time.Second, res.RetryAfter)
+	assert.Equal(t, time.Minute, res.Window)
+
+	// No boundary burst: the first two leave the window 60s after they came
+	c.advance(20 * time.Second)
+	assert.Equal(t, 2, allowed(l, "a", 5))
+	c.advance(40 * time.Second)
+	assert.Equal(t, 1, allowed(l, "a", 5))
+}
+
+func TestIdleKeysExpire(t *testing.T) {
+	l, err := NewSlidingWindowLog(PerSecond(2))
+	require.NoError(t, err)
+	defer l.Close()
+	c := setClock(l.table)
+
+	l.Allow("a")
+	l.Allow("b")
+	assert.Equal(t, 2, l.table.len())
+
+	// An expired key starts over rather than reusing its old log
+	c.advance(2 * time.Second)
+	res := l.Allow("a")
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 2, l.table.len())
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := NewTokenBucket(Rate{}, 1)
+	assert.ErrorIs(t, err, ErrInvalidRate)
+	_, err = NewGCRA(PerSecond(1), 0)
+	assert.ErrorIs(t, err, ErrInvalidBurst)
+	_, err = NewSlidingWindowLog(Rate{Limit: 1})
+	assert.ErrorIs(t, err, ErrInvalidRate)
+}
+
+func TestConcurrentAllow(t *testing.T) {
+	l, err := NewGCRA(PerHour(1), 100)
+	require.NoError(t, err)
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	count := 0
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := allowed(l, "shared", 50)
+			mu.Lock()
+			count += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 100, count)
+
+	// Close is idempotent
+	l.Close()
+}
+
+func setClock[T any](tbl *table[T]) *clock {
+	c := newClock()
+	tbl.now = c.now
+	return c
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
 )
 
@@ -30,6 +31,8 @@ var (
 	ErrChunkSizeLineTooLong = errors.New("chunk size line too long")
 	ErrBodyTooLarge         = errors.New("chunked body exceeds maximum size")
 	ErrInvalidChunkFormat   = errors.New("invalid chunk format")
+	ErrBodyReadAfterClose   = errors.New("read on closed request body")
+	ErrBodyNotDrained       = errors.New("unread request body exceeds drain limit")
 	crlf                    = []byte("\r\n")
 )
 
@@ -37,23 +40,264 @@ const (
 	maxChunkSize     = 10 * 1024 * 1024 // 10MB per chunk
 	maxTotalBodySize = 50 * 1024 * 1024 // 50MB total
 	maxChunkSizeLine = 1024             // 1KB for size line
+	bodyBufferSize   = 4096             // Read buffer for body framing
 )
 
-// parseChunkedIncremental parses chunked data incrementally
+// NoBody is an empty body for requests without Content-Length or chunked encoding
+var NoBody io.ReadCloser = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body streams a request body from the connection, decoding
+// Content-Length or chunked framing lazily as the handler reads.
+type body struct {
+	src io.Reader
+	buf []byte // Unconsumed bytes read from src
+	raw []byte // Backing storage for buf
+
+	chunked     bool
+	chunkParser chunkParser
+	remaining   int64 // Bytes left for Content-Length bodies
+	untilEOF    bool  // Body ends when the connection closes (responses only)
+	total       int64 // Bytes read so far from a close-delimited body
+	maxBodySize int64
+
+	done   bool
+	err    error // Sticky framing or read error
+	closed bool
+
+	scratch []byte // Discard space for drain
+}
+
+// newBody creates a body reader. A negative contentLength without chunking
+// reads until src reports EOF.
+func newBody(src io.Reader, contentLength int64, chunked bool, maxBodySize int64) *body {
+	b := &body{}
+	b.reset(src, contentLength, chunked, maxBodySize)
+	return b
+}
+
+// reset prepares b to read a new body, keeping its buffers for reuse
+func (b *body) reset(src io.Reader, contentLength int64, chunked bool, maxBodySize int64) {
+	*b = body{
+		src:         src,
+		raw:         b.raw,
+		scratch:     b.scratch,
+		chunked:     chunked,
+		remaining:   contentLength,
+		maxBodySize: maxBodySize,
+	}
+
+	if contentLength < 0 && !chunked {
+		b.untilEOF = true
+	} else if contentLength == 0 && !chunked {
+		b.done = true
+	}
+}
+
+// Read implements io.Reader
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyReadAfterClose
+	}
+	return b.read(p)
+}
+
+func (b *body) read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.chunked {
+		return b.readChunked(p)
+	}
+	if b.untilEOF {
+		return b.readUntilEOF(p)
+	}
+	return b.readFixed(p)
+}
+
+// readFixed reads a body with known Content-Length
+func (b *body) readFixed(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	var n int
+	if len(b.buf) > 0 {
+		// Serve bytes buffered by the header parser first
+		n = copy(p, b.buf)
+		b.buf = b.buf[n:]
+	} else {
+		var err error
+		n, err = b.src.Read(p)
+		if err == io.EOF {
+			if n == 0 {
+				b.err = io.ErrUnexpectedEOF
+				return 0, b.err
+			}
+		} else if err != nil {
+			b.err = err
+			return n, err
+		}
+	}
+
+	b.remaining -= int64(n)
+	if b.remaining == 0 {
+		b.done = true
+	}
+	return n, nil
+}
+
+// readUntilEOF reads a close-delimited body, enforcing maxBodySize
+func (b *body) readUntilEOF(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(b.buf) > 0 {
+		n = copy(p, b.buf)
+		b.buf = b.buf[n:]
+	} else {
+		n, err = b.src.Read(p)
+	}
+
+	b.total += int64(n)
+	if b.total > b.maxBodySize {
+		b.err = ErrBodyTooLarge
+		return n, b.err
+	}
+
+	if err == io.EOF {
+		b.done = true
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// readChunked decodes Transfer-Encoding: chunked data into p
+func (b *body) readChunked(p []byte) (int, error) {
+	for {
+		if len(b.buf) > 0 {
+			consumed, n, done, err := decodeChunked(b.buf, p, &b.chunkParser, b.maxBodySize)
+			b.buf = b.buf[consumed:]
+			if err != nil {
+				b.err = err
+				return n, err
+			}
+			if done {
+				b.done = true
+				b.release()
+				if n == 0 {
+					return 0, io.EOF
+				}
+			}
+			if n > 0 {
+				return n, nil
+			}
+			if consumed > 0 {
+				// Framing only (size line, CRLF) - keep decoding
+				continue
+			}
+		}
+
+		if err := b.fill(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+}
+
+// fill reads more data from src, compacting any unconsumed bytes first
+func (b *body) fill() error {
+	if b.raw == nil {
+		b.raw = make([]byte, bodyBufferSize)
+	}
+	n := copy(b.raw, b.buf)
+	if n == len(b.raw) {
+		return ErrInvalidChunkFormat
+	}
+
+	m, err := b.src.Read(b.raw[n:])
+	b.buf = b.raw[:n+m]
+	if m > 0 || err == nil {
+		return nil
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// release hands bytes read past the end of the body back to the
+// connection's Reader, if it has one, for the next request
+func (b *body) release() {
+	if r, ok := b.src.(*Reader); ok {
+		r.unread(b.buf)
+		b.buf = nil
+	}
+}
+
+// Close implements io.Closer. Unread data is left for the server to drain.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// drain discards the rest of the body so the connection can be reused.
+// It gives up with ErrBodyNotDrained once more than limit bytes were skipped.
+func (b *body) drain(limit int64) error {
+	if b.scratch == nil {
+		b.scratch = make([]byte, bodyBufferSize)
+	}
+	var discarded int64
+
+	for {
+		n, err := b.read(b.scratch)
+		discarded += int64(n)
+		if err == io.EOF || err == nil && b.done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if discarded > limit {
+			return ErrBodyNotDrained
+		}
+	}
+}
+
+// decodeChunked decodes chunked data from src into dst incrementally.
 // Parser state must be preserved across calls!
-func parseChunkedIncremental(data []byte, body *[]byte, parser *chunkParser, maxBodySize int64) (int, bool, error) {
+// Returns bytes consumed from src, bytes written to dst and whether the
+// terminating chunk (and trailers) has been read.
+func decodeChunked(src, dst []byte, parser *chunkParser, maxBodySize int64) (int, int, bool, error) {
 	consumed := 0
+	written := 0
 
-	for consumed < len(data) {
+	for consumed < len(src) {
 		switch parser.state {
 		case chunkStateSize:
-			n, err := parser.parseChunkSize(data[consumed:])
+			n, err := parser.parseChunkSize(src[consumed:])
 			if err != nil {
-				return consumed, false, err
+				return consumed, written, false, err
 			}
 			if n == 0 {
 				// Need more data
-				return consumed, false, nil
+				return consumed, written, false, nil
 			}
 			consumed += n
 
@@ -67,75 +311,77 @@ func parseChunkedIncremental(data []byte, body *[]byte, parser *chunkParser, max
 
 		case chunkStateData:
 			remaining := parser.chunkSize - parser.chunkRead
-			available := len(data[consumed:])
-			toRead := min(remaining, available)
+			available := len(src[consumed:])
+			toRead := min(remaining, available, len(dst)-written)
+			if toRead == 0 {
+				// Caller's buffer is full
+				return consumed, written, false, nil
+			}
 
 			// Check total body size limit
 			if parser.totalBodySize+int64(toRead) > maxBodySize {
-				return consumed, false, ErrBodyTooLarge
+				return consumed, written, false, ErrBodyTooLarge
 			}
 
-			*body = append(*body, data[consumed:consumed+toRead]...)
+			copy(dst[written:], src[consumed:consumed+toRead])
 			consumed += toRead
+			written += toRead
 			parser.chunkRead += toRead
 			parser.totalBodySize += int64(toRead)
 
 			if parser.chunkRead == parser.chunkSize {
 				parser.state = chunkStateDataCRLF
-			} else {
-				// Need more data for chunk
-				return consumed, false, nil
 			}
 
 		case chunkStateDataCRLF:
-			if len(data[consumed:]) < 2 {
+			if len(src[consumed:]) < 2 {
 				// Need more data
-				return consumed, false, nil
+				return consumed, written, false, nil
 			}
 
-			if data[consumed] != '\r' || data[consumed+1] != '\n' {
-				return consumed, false, ErrInvalidChunkFormat
+			if src[consumed] != '\r' || src[consumed+1] != '\n' {
+				return consumed, written, false, ErrInvalidChunkFormat
 			}
 
 			consumed += 2
 			parser.state = chunkStateSize // Next chunk
 
 		case chunkStateTrailer:
-			if len(data[consumed:]) < 2 {
-				return consumed, false, nil
+			if len(src[consumed:]) < 2 {
+				return consumed, written, false, nil
 			}
 
-			if data[consumed] == '\r' && data[consumed+1] == '\n' {
+			if src[consumed] == '\r' && src[consumed+1] == '\n' {
 				consumed += 2
 				parser.state = chunkStateDone
-				return consumed, true, nil
+				return consumed, written, true, nil
 			}
 
-			idx := bytes.Index(data[consumed:], []byte("\r\n\r\n"))
+			idx := bytes.Index(src[consumed:], []byte("\r\n\r\n"))
 			if idx == -1 {
 				// Check if we've buffered too much without finding end
-				if len(data[consumed:]) > maxChunkSizeLine {
-					return consumed, false, errors.New("trailer headers too large")
+				if len(src[consumed:]) > maxChunkSizeLine {
+					return consumed, written, false, errors.New("trailer headers too large")
 				}
 				// Need more data
-				return consumed, false, nil
+				return consumed, written, false, nil
 			}
 
-			trailers := data[consumed : consumed+idx]
+			trailers := src[consumed : consumed+idx]
 			if bytes.ContainsAny(trailers, "\x00") {
-				return consumed, false, errors.New("null byte in trailer headers")
+				return consumed, written, false, errors.New("null byte in trailer headers")
 			}
 
 			consumed += idx + 4 // Skip trailers + \r\n\r\n
 			parser.state = chunkStateDone
-			return consumed, true, nil
+			return consumed, written, true, nil
 
 		case chunkStateDone:
-			return consumed, true, nil
+			return consumed, written, true, nil
 		}
 	}
 
-	return consumed, false, nil
+	return consumed, written, parser.state == chunkStateDone, nil
 }
 
 // parseChunkSize parses the chunk size line: SIZE[;extensions]\r\n
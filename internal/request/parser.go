@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
 )
 
 // Size limits (Issue #3 - DoS protection)
@@ -21,91 +23,89 @@ var (
 	// ErrBodyTooLarge is removed - already declared in body.go
 	ErrTooManyHeaders      = errors.New("too many header lines")
 	ErrURITooLong          = errors.New("URI too long")
+	// ErrUnframedBody is returned for a request whose Transfer-Encoding
+	// doesn't end in chunked, so the end of its body can't be found
+	ErrUnframedBody = errors.New("transfer-encoding does not end in chunked")
 )
 
 // parserState represents the current state of the request parser
 type parserState int
 
 const (
-	stateRequestLine parserState = iota
+	stateStartLine parserState = iota // Request line or status line
 	stateHeaders
-	stateBody
 	stateDone
 )
 
-// parser handles incremental parsing of HTTP requests
+// parser handles incremental parsing of the start line and headers in a
+// Reader's buffer. The body is left on the connection and streamed by
+// Request.Body.
 type parser struct {
-	state       parserState
-	buffer      []byte       // Accumulates data between reads
-	chunkParser *chunkParser // For chunked encoding
-	
+	state parserState
+	view  bool // Headers point into the buffer instead of copying it
+
+	// Message being parsed: a request or a response
+	req     *Request
+	resp    *Response
+	headers *headers.Headers
+
 	// Size tracking (Issue #3)
-	totalBytesRead int64
-	headerLines    int
-	maxBodySize    int64
+	headerLines int
+	maxBodySize int64
 }
 
-func newParser(maxBodySize int64) *parser {
-	if maxBodySize <= 0 {
-		maxBodySize = maxBodySize // This line looks like a bug - should probably be a default value
-	}
-	
-	return &parser{
-		state:       stateRequestLine,
-		buffer:      make([]byte, 0, 4096), // Start with 4KB
-		chunkParser: &chunkParser{},
-		maxBodySize: maxBodySize,
+func newParser(bodyLimit int64) *parser {
+	p := &parser{}
+	p.reset(bodyLimit)
+	return p
+}
+
+// reset prepares the parser for a new message
+func (p *parser) reset(bodyLimit int64) {
+	if bodyLimit <= 0 {
+		bodyLimit = maxBodySize
 	}
+	*p = parser{state: stateStartLine, maxBodySize: bodyLimit}
 }
 
-// parseFromReader reads from io.Reader and parses the request
-func (p *parser) parseFromReader(reader io.Reader, req *Request, maxHeaderBytes int) error {
+// readHead reads until the start line and headers are parsed. Bytes past
+// the headers stay in r for the body or, from a pipelining client, the
+// next request.
+func (p *parser) readHead(r *Reader, maxHeaderBytes int) error {
 	if maxHeaderBytes <= 0 {
 		maxHeaderBytes = maxHeaderSize
 	}
-	
-	readBuf := make([]byte, 4096)
+
+	r.startHead()
 
 	for p.state != stateDone {
 		// Try to parse what we have in buffer first
-		if len(p.buffer) > 0 {
-			consumed, err := p.parse(p.buffer, req, maxHeaderBytes)
+		if r.Buffered() > 0 {
+			consumed, err := p.parse(r.buf[r.start:r.end])
 			if err != nil {
 				return err
 			}
 
 			// Remove consumed bytes from buffer
 			if consumed > 0 {
-				p.buffer = p.buffer[consumed:]
+				r.start += consumed
 				continue // Try parsing again before reading more
 			}
 		}
 
-		// ✅ Issue #3: Check size limits BEFORE reading more
-		if p.state != stateBody && len(p.buffer) >= maxHeaderBytes {
+		// ✅ Issue #3: Check size limits BEFORE reading more. Everything
+		// buffered since the head started belongs to it.
+		if r.end-r.head >= maxHeaderBytes {
 			return ErrHeaderTooLarge
 		}
 
 		// Need more data - read from connection
-		n, err := reader.Read(readBuf)
-		if n > 0 {
-			// ✅ Issue #3: Prevent buffer from growing unbounded
-			if p.state != stateBody {
-				// We're still reading headers
-				if len(p.buffer)+n > maxHeaderBytes {
-					return ErrHeaderTooLarge
-				}
-			}
-			
-			p.buffer = append(p.buffer, readBuf[:n]...)
-			p.totalBytesRead += int64(n)
-		}
-
+		_, err := r.fill(maxHeaderBytes)
 		if err != nil {
 			if err == io.EOF {
-				// EOF is only okay if we're done parsing
-				if p.state == stateDone {
-					return nil
+				// Clean close between requests
+				if r.end == r.head {
+					return io.EOF
 				}
 				return errors.New("unexpected EOF")
 			}
@@ -116,18 +116,32 @@ func (p *parser) parseFromReader(reader io.Reader, req *Request, maxHeaderBytes
 	return nil
 }
 
+// attachBody hands the connection to a streaming body reader
+func (p *parser) attachBody(req *Request, r *Reader) {
+	if req.IsChunked() {
+		req.bodyState.reset(r, 0, true, p.maxBodySize)
+	} else if cl := req.ContentLength(); cl > 0 {
+		req.bodyState.reset(r, cl, false, p.maxBodySize)
+	} else {
+		return
+	}
+
+	req.body = &req.bodyState
+	req.Body = req.body
+}
+
 // parse processes buffered data and advances the state machine
 // Returns number of bytes consumed
-func (p *parser) parse(data []byte, req *Request, maxHeaderBytes int) (int, error) {
+func (p *parser) parse(data []byte) (int, error) {
 	switch p.state {
-	case stateRequestLine:
-		return p.parseRequestLine(data, req)
+	case stateStartLine:
+		if p.req != nil {
+			return p.parseRequestLine(data, p.req)
+		}
+		return p.parseStatusLine(data, p.resp)
 
 	case stateHeaders:
-		return p.parseHeaders(data, req, maxHeaderBytes)
-
-	case stateBody:
-		return p.parseBody(data, req)
+		return p.parseHeaders(data)
 
 	case stateDone:
 		return 0, nil
@@ -138,17 +152,18 @@ func (p *parser) parse(data []byte, req *Request, maxHeaderBytes int) (int, erro
 }
 
 func (p *parser) parseRequestLine(data []byte, req *Request) (int, error) {
-	// ✅ Issue #3: Check request line size
-	if len(data) > maxRequestLineSize {
-		return 0, ErrRequestLineTooLarge
-	}
-	
-	method, path, version, consumed, err := parseRequestLine(data)
+	// ✅ Issue #3: Check request line size. The buffer may hold more than
+	// the line, so only look that far.
+	line := data[:min(len(data), maxRequestLineSize+len(crlf))]
+	method, path, version, consumed, err := parseRequestLine(line)
 	if err != nil {
 		return 0, err
 	}
 
 	if consumed == 0 {
+		if len(line) > maxRequestLineSize {
+			return 0, ErrRequestLineTooLarge
+		}
 		// Need more data
 		return 0, nil
 	}
@@ -158,8 +173,13 @@ func (p *parser) parseRequestLine(data []byte, req *Request) (int, error) {
 		return 0, ErrURITooLong
 	}
 
+	if err := req.url.parseTarget(method, path); err != nil {
+		return 0, err
+	}
+
 	req.Method = method
 	req.Path = path
+	req.URL = &req.url
 	req.Version = version
 
 	p.state = stateHeaders
@@ -167,8 +187,15 @@ func (p *parser) parseRequestLine(data []byte, req *Request) (int, error) {
 }
 
 // parseHeaders parses HTTP headers until empty line
-func (p *parser) parseHeaders(data []byte, req *Request, maxHeaderBytes int) (int, error) {
-	consumed, done, err := req.Headers.Parse(data)
+func (p *parser) parseHeaders(data []byte) (int, error) {
+	var consumed int
+	var done bool
+	var err error
+	if p.view {
+		consumed, done, err = p.headers.ParseView(data)
+	} else {
+		consumed, done, err = p.headers.Parse(data)
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -184,85 +211,17 @@ func (p *parser) parseHeaders(data []byte, req *Request, maxHeaderBytes int) (in
 		return consumed, nil
 	}
 
-	// Headers complete - determine what comes next
-	if req.IsChunked() {
-		// Chunked body
-		p.state = stateBody
-		return consumed, nil
+	// ✅ Issue #3: Validate body size against limit before the handler reads it
+	if cl := p.headers.ContentLength(); cl > p.maxBodySize {
+		return 0, ErrBodyTooLarge
 	}
 
-	cl := req.ContentLength()
-	if cl > 0 {
-		// ✅ Issue #3: Validate body size against limit
-		if cl > p.maxBodySize {
-			return 0, ErrBodyTooLarge
-		}
-		
-		// Fixed-length body
-		p.state = stateBody
-		return consumed, nil
+	// Only a response may run its body to the end of the connection
+	if p.req != nil && p.headers.Has("transfer-encoding") && !p.headers.IsChunked() {
+		return 0, ErrUnframedBody
 	}
 
-	// No body (GET request, or Content-Length: 0)
+	// Headers complete - the body (if any) is streamed by Request.Body
 	p.state = stateDone
 	return consumed, nil
 }
-
-// parseBody reads the request body based on Content-Length or chunked encoding
-func (p *parser) parseBody(data []byte, req *Request) (int, error) {
-	if req.IsChunked() {
-		return p.parseChunkedBody(data, req)
-	}
-	return p.parseFixedBody(data, req)
-}
-
-// parseFixedBody reads body with known Content-Length
-func (p *parser) parseFixedBody(data []byte, req *Request) (int, error) {
-	cl := req.ContentLength()
-	if cl < 0 {
-		return 0, errors.New("missing Content-Length for body")
-	}
-
-	// ✅ Issue #3: Double-check size limit
-	if cl > p.maxBodySize {
-		return 0, ErrBodyTooLarge
-	}
-
-	remaining := int(cl) - len(req.Body)
-	if remaining <= 0 {
-		p.state = stateDone
-		return 0, nil
-	}
-
-	// Read up to what we need
-	toRead := min(remaining, len(data))
-	
-	// ✅ Issue #3: Check total body size as we accumulate
-	if int64(len(req.Body)+toRead) > p.maxBodySize {
-		return 0, ErrBodyTooLarge
-	}
-	
-	req.Body = append(req.Body, data[:toRead]...)
-
-	// Check if body is complete
-	if len(req.Body) == int(cl) {
-		p.state = stateDone
-	}
-
-	return toRead, nil
-}
-
-// parseChunkedBody reads Transfer-Encoding: chunked body
-func (p *parser) parseChunkedBody(data []byte, req *Request) (int, error) {
-	// ✅ Issue #3: Use maxBodySize for chunked encoding too
-	consumed, done, err := parseChunkedIncremental(data, &req.Body, p.chunkParser, p.maxBodySize)
-	if err != nil {
-		return 0, err
-	}
-
-	if done {
-		p.state = stateDone
-	}
-
-	return consumed, nil
-}
\ No newline at end of file
@@ -0,0 +1,141 @@
+package request
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	readBufferSize    = 4096     // Initial read buffer
+	maxKeptBufferSize = 64 << 10 // Larger buffers are dropped by Reset
+)
+
+// Reader reads successive requests from one connection. It owns a read
+// buffer that is reused for every request: the parser works in place, and
+// headers point into the buffer rather than being copied. Bytes past the
+// end of one request (the start of a pipelined request) stay buffered for
+// the next. Pass the same Reader for every request on a connection.
+//
+// A request's headers are only valid until the next request is read from
+// the Reader; Clone them to keep them longer.
+type Reader struct {
+	src        io.Reader
+	buf        []byte
+	start, end int // buf[start:end] has been read from src but not consumed
+	head       int // Start of the head being parsed
+
+	parser parser
+}
+
+// NewReader creates a Reader over a connection
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src}
+}
+
+// Reset makes r read from src, discarding any buffered data but keeping
+// the buffer for reuse
+func (r *Reader) Reset(src io.Reader) {
+	r.src = src
+	r.start, r.end, r.head = 0, 0, 0
+	if cap(r.buf) > maxKeptBufferSize {
+		r.buf = nil
+	}
+	r.parser = parser{}
+}
+
+// ReadRequest reads the next request into req, which is reset first and
+// may be reused from an earlier request
+func (r *Reader) ReadRequest(req *Request, maxHeaderBytes int, maxBodySize int64) error {
+	req.Reset()
+
+	p := &r.parser
+	p.reset(maxBodySize)
+	p.req = req
+	p.headers = req.Headers
+	p.view = true
+
+	err := p.readHead(r, maxHeaderBytes)
+	if err == nil {
+		p.attachBody(req, r)
+	}
+	p.req, p.headers = nil, nil
+	return err
+}
+
+// Read implements io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.start < r.end {
+		n := copy(p, r.buf[r.start:r.end])
+		r.start += n
+		return n, nil
+	}
+	return r.src.Read(p)
+}
+
+// Buffered returns the number of bytes already read from the connection
+// but not yet consumed, e.g. because the client pipelined its requests
+func (r *Reader) Buffered() int {
+	return r.end - r.start
+}
+
+// startHead marks the start of a new head. The previous message is done
+// with, so its bytes can be overwritten; moving the unread rest to the
+// front of the buffer only pays once half of it is used up.
+func (r *Reader) startHead() {
+	if r.start == r.end {
+		r.start, r.end = 0, 0
+	} else if r.start > len(r.buf)/2 {
+		r.end = copy(r.buf, r.buf[r.start:r.end])
+		r.start = 0
+	}
+	r.head = r.start
+}
+
+// fill reads more from src into the buffer. When it is full, the head
+// being parsed moves to a new array with room to grow towards limit;
+// headers already parsed keep pointing into the old one.
+func (r *Reader) fill(limit int) (int, error) {
+	if r.end == len(r.buf) {
+		used := r.end - r.head
+		size := max(2*used, readBufferSize)
+		size = min(size, max(limit, used+1))
+		buf := make([]byte, size)
+		copy(buf, r.buf[r.head:r.end])
+		r.buf = buf
+		r.start -= r.head
+		r.end = used
+		r.head = 0
+	}
+	n, err := r.src.Read(r.buf[r.end:])
+	r.end += n
+	return n, err
+}
+
+// unread puts p, the last bytes returned by Read, back in front of the
+// unread data
+func (r *Reader) unread(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	if r.start < r.end {
+		// p was served from the buffer and is still there
+		if r.start >= len(p) && bytes.Equal(r.buf[r.start-len(p):r.start], p) {
+			r.start -= len(p)
+			return
+		}
+		pending := make([]byte, 0, len(p)+r.Buffered())
+		pending = append(append(pending, p...), r.buf[r.start:r.end]...)
+		r.buf, r.start, r.end = pending, 0, len(pending)
+		return
+	}
+
+	// Keep p after the current request's bytes, which headers may still
+	// point into
+	if len(r.buf)-r.end < len(p) {
+		r.buf = make([]byte, max(len(r.buf), len(p)))
+		r.end = 0
+	}
+	r.start = r.end
+	r.end += copy(r.buf[r.end:], p)
+}
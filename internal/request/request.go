@@ -9,35 +9,73 @@ import (
 
 // Request represents a parsed HTTP request
 type Request struct {
-	Method  string
+	Method string
+	// Path is the request-target exactly as sent, including any query
+	// string. URL holds the parsed form.
 	Path    string
+	URL     *URL
 	Version string
 	Headers *headers.Headers
-	Body    []byte
 
-	parser *parser
+	// Body streams the request body from the connection. It is never nil;
+	// requests without a body get NoBody.
+	Body io.ReadCloser
+
+	body *body // Original body, kept for draining even if Body is replaced
+
+	// Storage reused by Reader.ReadRequest
+	url       URL
+	bodyState body
 }
 
 // NewRequest creates a new Request with initialized fields
 func NewRequest() *Request {
 	return &Request{
 		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
+		Body:    NoBody,
 	}
 }
 
+// RequestFromReaderWithConfig reads a request. Use a Reader to read more
+// than one request from a connection.
 func RequestFromReaderWithConfig(reader io.Reader, maxHeaderBytes int, maxBodySize int64) (*Request, error) {
+	r, ok := reader.(*Reader)
+	if !ok {
+		r = NewReader(reader)
+	}
+
 	req := NewRequest()
-	parser := newParser(maxBodySize)
-	
-	err := parser.parseFromReader(reader, req, maxHeaderBytes)
-	if err != nil {
+	if err := r.ReadRequest(req, maxHeaderBytes, maxBodySize); err != nil {
 		return nil, err
 	}
-	
 	return req, nil
 }
 
+// Reset clears r for reuse, keeping its header storage and buffers
+func (r *Request) Reset() {
+	h := r.Headers
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	h.Reset()
+	*r = Request{
+		Headers:   h,
+		Body:      NoBody,
+		bodyState: r.bodyState,
+	}
+}
+
+// DrainBody discards whatever the handler left unread so the next request
+// on a keep-alive connection starts at a message boundary. It returns
+// ErrBodyNotDrained if more than limit bytes remain, in which case the
+// connection must not be reused.
+func (r *Request) DrainBody(limit int64) error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.drain(limit)
+}
+
 // IsHTTP10 returns true if this is an HTTP/1.0 request
 func (r *Request) IsHTTP10() bool {
 	return r.Version == "HTTP/1.0"
@@ -52,14 +90,13 @@ func (r *Request) IsHTTP11() bool {
 // HTTP/1.0: true unless "Connection: keep-alive"
 // HTTP/1.1: true only if "Connection: close"
 func (r *Request) WantsClose() bool {
-	conn, ok := r.Headers.Get("connection")
-	if !ok {
+	if !r.Headers.Has("connection") {
 		// HTTP/1.0 closes by default
 		return r.IsHTTP10()
 	}
 
 	// Explicit "Connection: close"
-	return conn == "close"
+	return r.Headers.Is("connection", "close")
 }
 
 // WantsKeepAlive returns true if the client wants persistent connection
@@ -69,6 +106,10 @@ func (r *Request) WantsKeepAlive() bool {
 
 // ContentLength returns the Content-Length header value, or -1 if not present
 func (r *Request) ContentLength() int64 {
+	if cl := r.Headers.ContentLength(); cl >= 0 {
+		return cl
+	}
+
 	cl, ok := r.Headers.Get("content-length")
 	if !ok {
 		return -1
@@ -82,13 +123,10 @@ func (r *Request) ContentLength() int64 {
 	return length
 }
 
-// IsChunked returns true if Transfer-Encoding: chunked
+// IsChunked reports whether the body is chunked: Transfer-Encoding ends in
+// chunked, in any case
 func (r *Request) IsChunked() bool {
-	te, ok := r.Headers.Get("transfer-encoding")
-	if !ok {
-		return false
-	}
-	return te == "chunked"
+	return r.Headers.IsChunked()
 }
 
 // parseInt64 parses a string to int64
@@ -7,6 +7,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
 )
 
 func TestSimpleGETRequest(t *testing.T) {
@@ -21,7 +23,7 @@ func TestSimpleGETRequest(t *testing.T) {
 	host, ok := req.Headers.Get("host")
 	assert.True(t, ok)
 	assert.Equal(t, "example.com", host)
-	assert.Len(t, req.Body, 0)
+	assert.Equal(t, NoBody, req.Body)
 }
 
 func TestPOSTWithContentLength(t *testing.T) {
@@ -37,7 +39,7 @@ func TestPOSTWithContentLength(t *testing.T) {
 	assert.Equal(t, "POST", req.Method)
 	assert.Equal(t, "/api/data", req.Path)
 	assert.Equal(t, int64(13), req.ContentLength())
-	assert.Equal(t, "Hello, World!", string(req.Body))
+	assert.Equal(t, "Hello, World!", readBody(t, req))
 }
 
 func TestChunkedTransferEncoding(t *testing.T) {
@@ -57,7 +59,31 @@ func TestChunkedTransferEncoding(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "POST", req.Method)
 	assert.True(t, req.IsChunked())
-	assert.Equal(t, "Hello, World", string(req.Body))
+	assert.Equal(t, "Hello, World", readBody(t, req))
+}
+
+func TestTransferEncodingFraming(t *testing.T) {
+	req, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: Chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, req.IsChunked())
+	assert.Equal(t, "abc", readBody(t, req))
+
+	tests := []struct {
+		name    string
+		headers string
+		err     error
+	}{
+		{"not chunked", "Transfer-Encoding: gzip\r\n", ErrUnframedBody},
+		{"chunked not last", "Transfer-Encoding: chunked, gzip\r\n", ErrUnframedBody},
+		{"chunked with length", "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n", headers.ErrBothChunkedAndLength},
+		{"not chunked with length", "Content-Length: 3\r\nTransfer-Encoding: gzip\r\n", headers.ErrBothChunkedAndLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: x\r\n" + tt.headers + "\r\nabc"))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
 }
 
 func TestHTTP10Request(t *testing.T) {
@@ -121,9 +147,9 @@ func TestInvalidPath(t *testing.T) {
 	// Path doesn't start with /
 	data := "GET invalid HTTP/1.1\r\nHost: example.com\r\n\r\n"
 	_, err := RequestFromReader(strings.NewReader(data))
+	assert.ErrorIs(t, err, ErrInvalidPath)
 
-	// Actually "invalid" is accepted as absolute-form
-	// Let's test truly invalid: empty path
+	// Empty path
 	data = "GET HTTP/1.1\r\nHost: example.com\r\n\r\n"
 	_, err = RequestFromReader(strings.NewReader(data))
 
@@ -157,7 +183,7 @@ func TestPartialBodyRead(t *testing.T) {
 	req, err := RequestFromReader(reader)
 
 	require.NoError(t, err)
-	assert.Equal(t, "12345678901234567890", string(req.Body))
+	assert.Equal(t, "12345678901234567890", readBody(t, req))
 }
 
 func TestUnexpectedEOF(t *testing.T) {
@@ -167,10 +193,13 @@ func TestUnexpectedEOF(t *testing.T) {
 		"\r\n" +
 		"0123456789"
 
-	_, err := RequestFromReader(strings.NewReader(data))
+	// Headers parse fine - the short body surfaces when the handler reads it
+	req, err := RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
 
+	_, err = io.ReadAll(req.Body)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "EOF")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
 func TestMultipleMethods(t *testing.T) {
@@ -208,10 +237,302 @@ func TestChunkedWithTrailers(t *testing.T) {
 	req, err := RequestFromReader(strings.NewReader(data))
 
 	require.NoError(t, err)
-	assert.Equal(t, "Hello", string(req.Body))
+	assert.Equal(t, "Hello", readBody(t, req))
 	// Note: We don't parse trailers yet, but it shouldn't error
 }
 
+func TestBodyIsStreamed(t *testing.T) {
+	data := "POST / HTTP/1.1\r\n" +
+		"Content-Length: 10\r\n" +
+		"\r\n" +
+		"0123456789"
+	reader := &slowReader{data: []byte(data), chunkSize: len(data) - 10}
+
+	req, err := RequestFromReader(reader)
+	require.NoError(t, err)
+
+	// Parser stops after headers; the body is still on the connection
+	assert.Equal(t, len(data)-10, reader.offset)
+	assert.Equal(t, "0123456789", readBody(t, req))
+}
+
+func TestChunkedBodySmallReads(t *testing.T) {
+	data := "POST / HTTP/1.1\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5;ext=1\r\n" +
+		"Hello\r\n" +
+		"7\r\n" +
+		", World\r\n" +
+		"0\r\n" +
+		"\r\n"
+	reader := &slowReader{data: []byte(data), chunkSize: 3}
+
+	req, err := RequestFromReader(reader)
+	require.NoError(t, err)
+
+	var got []byte
+	buf := make([]byte, 2)
+	for {
+		n, err := req.Body.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "Hello, World", string(got))
+}
+
+func TestChunkedBodyTooLarge(t *testing.T) {
+	data := "POST / HTTP/1.1\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"a\r\n" +
+		"0123456789\r\n" +
+		"0\r\n" +
+		"\r\n"
+
+	req, err := RequestFromReaderWithConfig(strings.NewReader(data), 1<<20, 5)
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(req.Body)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestContentLengthTooLarge(t *testing.T) {
+	data := "POST / HTTP/1.1\r\n" +
+		"Content-Length: 100\r\n" +
+		"\r\n"
+
+	_, err := RequestFromReaderWithConfig(strings.NewReader(data), 1<<20, 10)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestDrainBody(t *testing.T) {
+	data := "POST / HTTP/1.1\r\n" +
+		"Content-Length: 10\r\n" +
+		"\r\n" +
+		"0123456789"
+
+	req, err := RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+
+	// Handler reads a little and closes
+	buf := make([]byte, 3)
+	_, err = req.Body.Read(buf)
+	require.NoError(t, err)
+	require.NoError(t, req.Body.Close())
+
+	_, err = req.Body.Read(buf)
+	assert.ErrorIs(t, err, ErrBodyReadAfterClose)
+
+	// Server can still drain the rest
+	assert.NoError(t, req.DrainBody(1024))
+
+	// Too much left over - the connection can't be reused
+	data = "POST / HTTP/1.1\r\n" +
+		"Content-Length: 10000\r\n" +
+		"\r\n" +
+		strings.Repeat("x", 10000)
+
+	req, err = RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.ErrorIs(t, req.DrainBody(100), ErrBodyNotDrained)
+}
+
+func TestCleanEOFBetweenRequests(t *testing.T) {
+	_, err := RequestFromReader(strings.NewReader(""))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// Several requests in one write, with and without bodies
+	data := "GET /one HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"POST /two HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST /three HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"POST /four HTTP/1.1\r\nContent-Length: 4\r\n\r\nskip" +
+		"GET /five HTTP/1.1\r\n\r\n"
+
+	for _, chunkSize := range []int{len(data), 7, 1} {
+		r := NewReader(&slowReader{data: []byte(data), chunkSize: chunkSize})
+
+		req, err := RequestFromReader(r)
+		require.NoError(t, err)
+		assert.Equal(t, "/one", req.Path)
+
+		req, err = RequestFromReader(r)
+		require.NoError(t, err)
+		assert.Equal(t, "/two", req.Path)
+		assert.Equal(t, "hello", readBody(t, req))
+
+		req, err = RequestFromReader(r)
+		require.NoError(t, err)
+		assert.Equal(t, "/three", req.Path)
+		assert.Equal(t, "abc", readBody(t, req))
+
+		// An unread body is drained, as the server does, without eating
+		// into the next request
+		req, err = RequestFromReader(r)
+		require.NoError(t, err)
+		assert.Equal(t, "/four", req.Path)
+		require.NoError(t, req.DrainBody(1024))
+
+		req, err = RequestFromReader(r)
+		require.NoError(t, err)
+		assert.Equal(t, "/five", req.Path)
+
+		_, err = RequestFromReader(r)
+		assert.Equal(t, io.EOF, err, "chunk size %d", chunkSize)
+		assert.Zero(t, r.Buffered())
+	}
+}
+
+func TestReadRequestReuse(t *testing.T) {
+	// The second head is larger than the initial buffer, so it grows while
+	// earlier header lines already point into it
+	big := strings.Repeat("b", 2*readBufferSize)
+	data := "POST /one?a=1 HTTP/1.1\r\nHost: a\r\nX-One: 1\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /two HTTP/1.1\r\nHost: b\r\nX-Big: " + big + "\r\nX-Last: z\r\n\r\n"
+
+	r := NewReader(strings.NewReader(data))
+	req := NewRequest()
+
+	require.NoError(t, r.ReadRequest(req, 0, 0))
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "1", req.URL.Query().Get("a"))
+	assert.Equal(t, "abc", readBody(t, req))
+	saved := req.Headers.Clone()
+
+	require.NoError(t, r.ReadRequest(req, 0, 0))
+	assert.Equal(t, "GET", req.Method)
+	assert.Equal(t, "/two", req.URL.Path)
+	assert.Empty(t, req.URL.RawQuery)
+	assert.Equal(t, NoBody, req.Body)
+	assert.False(t, req.Headers.Has("x-one"))
+	assert.Equal(t, 3, req.Headers.Len())
+	host, _ := req.Headers.Get("Host")
+	assert.Equal(t, "b", host)
+	value, _ := req.Headers.Get("x-big")
+	assert.Equal(t, big, value)
+	assert.True(t, req.Headers.Is("x-last", "z"))
+
+	// A clone doesn't depend on the Reader's buffer
+	one, _ := saved.Get("x-one")
+	assert.Equal(t, "1", one)
+
+	assert.Equal(t, io.EOF, r.ReadRequest(req, 0, 0))
+}
+
+func TestHeadLimitWithPipelinedData(t *testing.T) {
+	// Pipelined requests after a head don't count towards its limit
+	one := "GET / HTTP/1.1\r\nHost: a\r\n\r\n"
+	r := NewReader(strings.NewReader(strings.Repeat(one, 100)))
+	req := NewRequest()
+	for range 100 {
+		require.NoError(t, r.ReadRequest(req, 64, 0))
+	}
+
+	_, err := RequestFromReaderWithConfig(strings.NewReader("GET / HTTP/1.1\r\nX-Long: "+strings.Repeat("a", 100)+"\r\n\r\n"), 64, 0)
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	data := strings.Repeat("GET /api/users/42?fields=name HTTP/1.1\r\nHost: example.com\r\n"+
+		"User-Agent: bench/1.0\r\nAccept: */*\r\nAccept-Encoding: gzip, deflate\r\n"+
+		"Connection: keep-alive\r\n\r\n", 100)
+	src := strings.NewReader(data)
+	r := NewReader(src)
+	req := NewRequest()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		src.Reset(data)
+		r.Reset(src)
+		for {
+			if err := r.ReadRequest(req, 0, 0); err != nil {
+				if err != io.EOF {
+					b.Fatal(err)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	data := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	resp, err := ReadResponse(&slowReader{data: []byte(data), chunkSize: 7}, "GET", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Reason)
+	assert.False(t, resp.WantsClose())
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestReadResponseFraming(t *testing.T) {
+	// HEAD responses have no body, whatever Content-Length says
+	resp, err := ReadResponse(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"), "HEAD", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, NoBody, resp.Body)
+
+	resp, err = ReadResponse(strings.NewReader("HTTP/1.1 204 No Content\r\n\r\n"), "GET", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, NoBody, resp.Body)
+
+	// No length: the body runs until the connection closes
+	resp, err = ReadResponse(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nall of it"), "GET", 0, 0)
+	require.NoError(t, err)
+	assert.True(t, resp.WantsClose())
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", string(body))
+
+	resp, err = ReadResponse(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"), "GET", 0, 0)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+
+	_, err = ReadResponse(strings.NewReader("HTTP/1.1 OK\r\n\r\n"), "GET", 0, 0)
+	assert.ErrorIs(t, err, ErrMalformedStatusLine)
+}
+
+func TestRequestWrite(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"
+	req, err := RequestFromReader(strings.NewReader(data))
+	require.NoError(t, err)
+
+	var out strings.Builder
+	require.NoError(t, req.Write(&out))
+
+	// Round trip through the parser
+	parsed, err := RequestFromReader(strings.NewReader(out.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "POST", parsed.Method)
+	assert.Equal(t, "/upload", parsed.Path)
+	assert.Equal(t, "hello", readBody(t, parsed))
+
+	req = NewRequest()
+	req.Method = "GET"
+	req.Path = "/"
+	req.Headers.Set("X-Injected", "a\r\nHost: evil")
+	assert.ErrorIs(t, req.Write(io.Discard), ErrInvalidHeaderValue)
+}
+
+func readBody(t *testing.T, req *Request) string {
+	t.Helper()
+	data, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
 // slowReader simulates a network connection that provides data slowly
 type slowReader struct {
 	data      []byte
@@ -236,3 +557,77 @@ func (r *slowReader) Read(p []byte) (int, error) {
 	r.offset += n
 	return n, nil
 }
+
+func TestParseRequestTarget(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           URL
+	}{
+		{"GET", "/a%20b/c%2Fd?x=1&y=2", URL{Form: OriginForm, RawPath: "/a%20b/c%2Fd", Path: "/a b/c/d", RawQuery: "x=1&y=2"}},
+		{"GET", "/", URL{Form: OriginForm, RawPath: "/", Path: "/"}},
+		{"GET", "HTTP://Example.com:8080/p?q", URL{Form: AbsoluteForm, Scheme: "http", Host: "Example.com:8080", RawPath: "/p", Path: "/p", RawQuery: "q"}},
+		{"GET", "http://example.com", URL{Form: AbsoluteForm, Scheme: "http", Host: "example.com", RawPath: "/", Path: "/"}},
+		{"GET", "https://example.com?q", URL{Form: AbsoluteForm, Scheme: "https", Host: "example.com", RawPath: "/", Path: "/", RawQuery: "q"}},
+		{"CONNECT", "example.com:443", URL{Form: AuthorityForm, Host: "example.com:443"}},
+		{"CONNECT", "[::1]:8443", URL{Form: AuthorityForm, Host: "[::1]:8443"}},
+		{"OPTIONS", "*", URL{Form: AsteriskForm, RawPath: "*", Path: "*"}},
+	}
+	for _, tt := range tests {
+		u, err := ParseRequestTarget(tt.method, tt.target)
+		require.NoError(t, err, tt.target)
+		assert.Equal(t, tt.want, *u, tt.target)
+	}
+
+	for _, bad := range []struct{ method, target string }{
+		{"GET", "/page#section"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "/caf\xc3\xa9"},
+		{"GET", "*"},
+		{"GET", "example.com:443"},
+		{"GET", "ftp://example.com/"},
+		{"GET", "http:///path"},
+		{"GET", "http://user@example.com/"},
+		{"CONNECT", "/path"},
+		{"CONNECT", "example.com"},
+		{"CONNECT", "example.com:0"},
+	} {
+		_, err := ParseRequestTarget(bad.method, bad.target)
+		assert.ErrorIs(t, err, ErrInvalidPath, bad.target)
+	}
+}
+
+func TestURLHelpers(t *testing.T) {
+	u, err := ParseRequestTarget("GET", "http://example.com/a/b%2Fc/?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b%2Fc", ""}, u.RawSegments())
+	assert.Equal(t, []string{"a", "b/c", ""}, u.Segments())
+	assert.Equal(t, "/a/b%2Fc/?q=1", u.RequestURI())
+	assert.Equal(t, "http://example.com/a/b%2Fc/?q=1", u.String())
+
+	req, err := RequestFromReader(strings.NewReader("GET /search?q=a+b&tag=x&tag=y%26z HTTP/1.1\r\nHost: h\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/search", req.URL.Path)
+	assert.Equal(t, "a b", req.URL.Query().Get("q"))
+	assert.Equal(t, []string{"x", "y&z"}, req.URL.Query()["tag"])
+}
+
+func TestParseQuery(t *testing.T) {
+	values, err := ParseQuery("a=1&a=2&&b=&c&d=%41%42&e=%zz&f=x%20y+z")
+	assert.Error(t, err)
+	assert.Equal(t, Values{
+		"a": {"1", "2"},
+		"b": {""},
+		"c": {""},
+		"d": {"AB"},
+		"f": {"x y z"},
+	}, values)
+
+	assert.True(t, values.Has("b"))
+	assert.False(t, values.Has("e"))
+	assert.Equal(t, "", values.Get("missing"))
+
+	values.Set("a", "one & two")
+	values.Add("g", "=")
+	values.Del("c")
+	assert.Equal(t, "a=one+%26+two&b=&d=AB&f=x+y+z&g=%3D", values.Encode())
+}
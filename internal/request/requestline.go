@@ -26,62 +26,49 @@ func parseRequestLine(data []byte) (string, string, string, int, error) {
 	consumed := idx + 2 // +2 for \r\n
 
 	// Split into parts: METHOD PATH VERSION
-	parts := bytes.SplitN(line, []byte(" "), 3)
-	if len(parts) != 3 {
+	methodBytes, rest, ok1 := bytes.Cut(line, []byte(" "))
+	pathBytes, versionBytes, ok2 := bytes.Cut(rest, []byte(" "))
+	if !ok1 || !ok2 {
 		return "", "", "", 0, ErrMalformedRequestLine
 	}
 
-	method := string(parts[0])
-	path := string(parts[1])
-	version := string(parts[2])
-
-	// Validate method
-	if !isValidMethod(method) {
+	// Validate method; known methods and versions come back as constants,
+	// so only the path is allocated
+	method, ok := lookupMethod(methodBytes)
+	if !ok {
 		return "", "", "", 0, ErrInvalidMethod
 	}
 
 	// Validate path
-	if !isValidPath(path) {
+	if len(pathBytes) == 0 {
 		return "", "", "", 0, ErrInvalidPath
 	}
 
 	// Validate version
-	if !isValidVersion(version) {
+	var version string
+	switch string(versionBytes) {
+	case "HTTP/1.1":
+		version = "HTTP/1.1"
+	case "HTTP/1.0":
+		version = "HTTP/1.0"
+	default:
 		return "", "", "", 0, ErrUnsupportedVersion
 	}
 
-	return method, path, version, consumed, nil
-}
-
-// isValidMethod checks if the HTTP method is supported
-func isValidMethod(method string) bool {
-	switch method {
-	case "GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS":
-		return true
-	default:
-		return false
-	}
+	return method, string(pathBytes), version, consumed, nil
 }
 
-// isValidPath checks if the request path is valid
-func isValidPath(path string) bool {
-	// Must start with / (origin-form) or be * (for OPTIONS)
-	if len(path) == 0 {
-		return false
-	}
+// methods are the supported HTTP methods
+var methods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS", "CONNECT"}
 
-	if path[0] == '/' {
-		return true
+// lookupMethod returns the supported method spelled by b
+func lookupMethod(b []byte) (string, bool) {
+	for _, method := range methods {
+		if string(b) == method {
+			return method, true
+		}
 	}
-
-	// Allow "*" for OPTIONS * HTTP/1.1
-	if path == "*" {
-		return true
-	}
-
-	// Could be absolute-form: http://example.com/path
-	// For now, we'll accept it but not fully validate
-	return true
+	return "", false
 }
 
 // isValidVersion checks if HTTP version is supported
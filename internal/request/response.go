@@ -0,0 +1,155 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+)
+
+var ErrMalformedStatusLine = errors.New("malformed status line")
+
+// Response is an HTTP response read from a connection by a client or
+// reverse proxy. It shares the request parser's header and body handling.
+type Response struct {
+	Version    string
+	StatusCode int
+	Reason     string
+	Headers    *headers.Headers
+
+	// Body streams the response body. It is never nil; responses that
+	// cannot carry a body get NoBody.
+	Body io.ReadCloser
+
+	closeDelimited bool // Body ends when the server closes the connection
+}
+
+// ReadResponse parses a response to a request made with method. Interim
+// 1xx responses (other than 101) are skipped. maxBodySize limits the body
+// as it is read; pass a very large value for proxies that stream downloads.
+func ReadResponse(reader io.Reader, method string, maxHeaderBytes int, maxBodySize int64) (*Response, error) {
+	return ReadResponseWithInterim(reader, method, maxHeaderBytes, maxBodySize, nil)
+}
+
+// ReadResponseWithInterim is ReadResponse, calling interim for each 1xx
+// response (e.g. 100 Continue) before the final one
+func ReadResponseWithInterim(reader io.Reader, method string, maxHeaderBytes int, maxBodySize int64, interim func(*Response)) (*Response, error) {
+	r, ok := reader.(*Reader)
+	if !ok {
+		r = NewReader(reader)
+	}
+	p := newParser(maxBodySize)
+
+	for {
+		// Responses outlive the buffer, so their headers are copied
+		resp := &Response{
+			Headers: headers.NewHeaders(),
+			Body:    NoBody,
+		}
+		p.resp = resp
+		p.headers = resp.Headers
+
+		if err := p.readHead(r, maxHeaderBytes); err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 200 || resp.StatusCode == 101 {
+			p.attachResponseBody(resp, r, method)
+			return resp, nil
+		}
+
+		// 100 Continue, 103 Early Hints: the final response follows,
+		// possibly already in the buffer
+		if interim != nil {
+			interim(resp)
+		}
+		p.state = stateStartLine
+		p.headerLines = 0
+	}
+}
+
+// parseStatusLine parses: VERSION CODE [REASON]\r\n
+func (p *parser) parseStatusLine(data []byte, resp *Response) (int, error) {
+	idx := bytes.Index(data[:min(len(data), maxRequestLineSize+len(crlf))], crlf)
+	if idx == -1 {
+		if len(data) > maxRequestLineSize {
+			return 0, ErrRequestLineTooLarge
+		}
+		return 0, nil
+	}
+
+	parts := strings.SplitN(string(data[:idx]), " ", 3)
+	if len(parts) < 2 {
+		return 0, ErrMalformedStatusLine
+	}
+	if !isValidVersion(parts[0]) {
+		return 0, ErrUnsupportedVersion
+	}
+
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return 0, ErrMalformedStatusLine
+	}
+
+	resp.Version = parts[0]
+	resp.StatusCode = code
+	if len(parts) == 3 {
+		resp.Reason = parts[2]
+	}
+
+	p.state = stateHeaders
+	return idx + 2, nil
+}
+
+// attachResponseBody picks the body framing (RFC 9112 section 6.3)
+func (p *parser) attachResponseBody(resp *Response, r *Reader, method string) {
+	code := resp.StatusCode
+	if method == "HEAD" || code < 200 || code == 204 || code == 304 {
+		return
+	}
+
+	switch {
+	case resp.IsChunked():
+		resp.Body = newBody(r, 0, true, p.maxBodySize)
+	case resp.ContentLength() == 0:
+		return
+	case resp.ContentLength() > 0:
+		resp.Body = newBody(r, resp.ContentLength(), false, p.maxBodySize)
+	default:
+		resp.Body = newBody(r, -1, false, p.maxBodySize)
+		resp.closeDelimited = true
+	}
+}
+
+// ContentLength returns the Content-Length header value, or -1 if not present
+func (r *Response) ContentLength() int64 {
+	return r.Headers.ContentLength()
+}
+
+// IsChunked returns true if Transfer-Encoding: chunked
+func (r *Response) IsChunked() bool {
+	return r.Headers.IsChunked()
+}
+
+// WantsClose reports whether the connection must be closed once the body
+// has been read, i.e. it cannot be reused for another request
+func (r *Response) WantsClose() bool {
+	if r.closeDelimited {
+		return true
+	}
+
+	conn, _ := r.Headers.Get("connection")
+	for _, token := range strings.Split(conn, ",") {
+		token = strings.TrimSpace(token)
+		if strings.EqualFold(token, "close") {
+			return true
+		}
+		if strings.EqualFold(token, "keep-alive") && r.Version == "HTTP/1.0" {
+			return false
+		}
+	}
+	return r.Version == "HTTP/1.0"
+}
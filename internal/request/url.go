@@ -0,0 +1,288 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TargetForm is the form of a request-target (RFC 9112 section 3.2)
+type TargetForm int
+
+const (
+	OriginForm    TargetForm = iota // /path?query
+	AbsoluteForm                    // http://host/path?query, sent to proxies
+	AuthorityForm                   // host:port, CONNECT only
+	AsteriskForm                    // *, server-wide OPTIONS only
+)
+
+// URL is a parsed request-target
+type URL struct {
+	Form TargetForm
+
+	Scheme string // Lowercase; absolute-form only
+	Host   string // host[:port]; absolute-form and authority-form
+
+	RawPath  string // Path as sent, still percent-encoded, e.g. "/a%2Fb/c"
+	Path     string // Decoded path, e.g. "/a/b/c"
+	RawQuery string // Query as sent, without the '?'
+
+	query Values // Parsed on first use
+}
+
+// ParseRequestTarget parses the request-target of a request line. The
+// allowed forms depend on method: authority-form is only valid for
+// CONNECT and asterisk-form only for OPTIONS. Fragments are rejected,
+// since clients never send them.
+func ParseRequestTarget(method, target string) (*URL, error) {
+	u := &URL{}
+	if err := u.parseTarget(method, target); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// parseTarget is ParseRequestTarget into an existing URL, which the parser
+// reuses across requests
+func (u *URL) parseTarget(method, target string) error {
+	*u = URL{}
+	if target == "" {
+		return ErrInvalidPath
+	}
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c <= ' ' || c >= 0x7f {
+			return fmt.Errorf("%w: invalid character %q", ErrInvalidPath, c)
+		}
+		if c == '#' {
+			return fmt.Errorf("%w: fragment in request target", ErrInvalidPath)
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		return u.parseAuthorityForm(target)
+	case target == "*":
+		if method != "OPTIONS" {
+			return fmt.Errorf("%w: * is only allowed for OPTIONS", ErrInvalidPath)
+		}
+		u.Form, u.RawPath, u.Path = AsteriskForm, "*", "*"
+		return nil
+	case target[0] == '/':
+		u.Form = OriginForm
+		return u.setPathAndQuery(target)
+	default:
+		return u.parseAbsoluteForm(target)
+	}
+}
+
+// parseAbsoluteForm parses scheme://authority[/path][?query]
+func (u *URL) parseAbsoluteForm(target string) error {
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, target)
+	}
+	scheme = strings.ToLower(scheme)
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidPath, scheme)
+	}
+
+	host := rest
+	pathAndQuery := "/"
+	if idx := strings.IndexAny(rest, "/?"); idx != -1 {
+		host = rest[:idx]
+		pathAndQuery = rest[idx:]
+		if pathAndQuery[0] == '?' {
+			pathAndQuery = "/" + pathAndQuery
+		}
+	}
+	if err := validateHost(host); err != nil {
+		return err
+	}
+
+	u.Form, u.Scheme, u.Host = AbsoluteForm, scheme, host
+	return u.setPathAndQuery(pathAndQuery)
+}
+
+// parseAuthorityForm parses host:port for CONNECT
+func (u *URL) parseAuthorityForm(target string) error {
+	idx := strings.LastIndexByte(target, ':')
+	if idx == -1 || strings.HasSuffix(target, "]") {
+		return fmt.Errorf("%w: CONNECT target needs a port", ErrInvalidPath)
+	}
+	if port, err := strconv.ParseUint(target[idx+1:], 10, 16); err != nil || port == 0 {
+		return fmt.Errorf("%w: invalid port in %q", ErrInvalidPath, target)
+	}
+	if err := validateHost(target); err != nil {
+		return err
+	}
+	u.Form, u.Host = AuthorityForm, target
+	return nil
+}
+
+// validateHost checks an authority has a host and no userinfo
+func validateHost(host string) error {
+	if host == "" || host[0] == ':' {
+		return fmt.Errorf("%w: missing host", ErrInvalidPath)
+	}
+	if strings.Contains(host, "@") {
+		return fmt.Errorf("%w: userinfo in request target", ErrInvalidPath)
+	}
+	if strings.HasPrefix(host, "[") && !strings.Contains(host, "]") {
+		return fmt.Errorf("%w: unterminated IPv6 literal", ErrInvalidPath)
+	}
+	return nil
+}
+
+// setPathAndQuery splits and decodes "/path?query"
+func (u *URL) setPathAndQuery(s string) error {
+	u.RawPath, u.RawQuery, _ = strings.Cut(s, "?")
+
+	path, err := url.PathUnescape(u.RawPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	u.Path = path
+	return nil
+}
+
+// RawSegments returns the path segments as sent, still percent-encoded.
+// "/a/b%2Fc/" gives ["a", "b%2Fc", ""].
+func (u *URL) RawSegments() []string {
+	if u.Form == AsteriskForm || u.Form == AuthorityForm || u.RawPath == "" {
+		return nil
+	}
+	return strings.Split(u.RawPath[1:], "/")
+}
+
+// Segments returns the path segments, each decoded on its own so an
+// encoded slash stays inside its segment. "/a/b%2Fc" gives ["a", "b/c"].
+func (u *URL) Segments() []string {
+	segments := u.RawSegments()
+	for i, s := range segments {
+		// Escapes were validated when the path was parsed
+		segments[i], _ = url.PathUnescape(s)
+	}
+	return segments
+}
+
+// Query returns the parsed query string. Malformed pairs are skipped; use
+// ParseQuery on RawQuery to see the error.
+func (u *URL) Query() Values {
+	if u.query == nil {
+		u.query, _ = ParseQuery(u.RawQuery)
+	}
+	return u.query
+}
+
+// RequestURI returns the target in origin-form (path and query) as it
+// should be sent to an origin server. Authority and asterisk forms are
+// returned unchanged.
+func (u *URL) RequestURI() string {
+	switch u.Form {
+	case AuthorityForm:
+		return u.Host
+	case AsteriskForm:
+		return "*"
+	}
+	if u.RawQuery == "" {
+		return u.RawPath
+	}
+	return u.RawPath + "?" + u.RawQuery
+}
+
+// String reassembles the request-target
+func (u *URL) String() string {
+	if u.Form == AbsoluteForm {
+		return u.Scheme + "://" + u.Host + u.RequestURI()
+	}
+	return u.RequestURI()
+}
+
+// Values maps query keys to their values, in the order they were given
+type Values map[string][]string
+
+// ParseQuery parses an application/x-www-form-urlencoded query: pairs are
+// separated by '&', '+' means space and keys and values are
+// percent-decoded. Pairs that fail to decode are skipped and the first
+// error is returned along with everything else.
+func ParseQuery(query string) (Values, error) {
+	values := make(Values)
+	var firstErr error
+
+	for query != "" {
+		var pair string
+		pair, query, _ = strings.Cut(query, "&")
+		if pair == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(key)
+		if err == nil {
+			value, err = url.QueryUnescape(value)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid query pair %q: %w", pair, err)
+			}
+			continue
+		}
+		values[key] = append(values[key], value)
+	}
+
+	return values, firstErr
+}
+
+// Get returns the first value for key, or "" if there is none
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Has reports whether key is present, even with an empty value
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// Set replaces the values for key
+func (v Values) Set(key, value string) {
+	v[key] = []string{value}
+}
+
+// Add appends a value for key
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// Del removes key
+func (v Values) Del(key string) {
+	delete(v, key)
+}
+
+// Encode returns the values in urlencoded form, sorted by key
+func (v Values) Encode() string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		for _, value := range v[k] {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(k))
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(value))
+		}
+	}
+	return sb.String()
+}
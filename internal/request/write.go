@@ -0,0 +1,112 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrInvalidHeaderValue = errors.New("header contains CR or LF")
+
+// Write sends the request on w, as a client or reverse proxy would. The
+// body is framed by the Content-Length or Transfer-Encoding header: a
+// chunked body is re-chunked from Body, a sized one must supply exactly
+// Content-Length bytes.
+func (r *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriterSize(w, bodyBufferSize)
+	if err := r.writeHead(bw); err != nil {
+		return err
+	}
+	if err := r.writeBody(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// WriteHead sends only the request line and headers. Together with
+// WriteBody it lets a client wait for 100 Continue in between.
+func (r *Request) WriteHead(w io.Writer) error {
+	bw := bufio.NewWriterSize(w, bodyBufferSize)
+	if err := r.writeHead(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// WriteBody sends the body framed as described by the headers
+func (r *Request) WriteBody(w io.Writer) error {
+	bw := bufio.NewWriterSize(w, bodyBufferSize)
+	if err := r.writeBody(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (r *Request) writeHead(bw *bufio.Writer) error {
+	version := r.Version
+	if version == "" {
+		version = "HTTP/1.1"
+	}
+	if strings.ContainsAny(r.Method+r.Path, " \r\n") {
+		return ErrMalformedRequestLine
+	}
+
+	fmt.Fprintf(bw, "%s %s %s\r\n", r.Method, r.Path, version)
+
+	for key, value := range r.Headers.All() {
+		// Header injection would let a caller smuggle a second request
+		if strings.ContainsAny(key, "\r\n: ") || strings.ContainsAny(value, "\r\n") {
+			return ErrInvalidHeaderValue
+		}
+		fmt.Fprintf(bw, "%s: %s\r\n", key, value)
+	}
+	_, err := bw.WriteString("\r\n")
+	return err
+}
+
+func (r *Request) writeBody(bw *bufio.Writer) error {
+	body := r.Body
+	if body == nil {
+		body = NoBody
+	}
+
+	switch cl := r.ContentLength(); {
+	case r.IsChunked():
+		return writeChunked(bw, body)
+	case cl > 0:
+		n, err := io.CopyN(bw, body, cl)
+		if err == io.EOF {
+			return fmt.Errorf("body ended after %d of %d bytes: %w", n, cl, io.ErrUnexpectedEOF)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeChunked streams src as chunked transfer encoding. Each chunk is
+// flushed so uploads are forwarded as they arrive.
+func writeChunked(bw *bufio.Writer, src io.Reader) error {
+	buf := make([]byte, bodyBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(buf[:n])
+			bw.WriteString("\r\n")
+			if ferr := bw.Flush(); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := bw.WriteString("0\r\n\r\n")
+	return err
+}
@@ -0,0 +1,191 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBodyNotAllowed is returned when writing a body for a status that
+// can't have one (1xx, 204 and 304)
+var ErrBodyNotAllowed = errors.New("response: status does not allow a body")
+
+// Write adds p to the response body, so handlers can treat the Writer as
+// an io.Writer. The status defaults to 200 unless WriteStatusLine chose
+// another. Up to WriterConfig.BufferSize bytes are held back so Finish can
+// send the whole response, with a Content-Length, in a single write; a
+// larger body, or one flushed early, is streamed with chunked encoding.
+//
+// After an explicit WriteHeaders, Write follows the framing those headers
+// declared.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.finished {
+		return 0, fmt.Errorf("response already finished")
+	}
+
+	switch w.state {
+	case stateStart:
+		w.statusCode = StatusOK
+	case stateStatusWritten, stateBuffering:
+	default:
+		if err := w.writeBodyPart(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if !bodyAllowed(w.statusCode) {
+		return 0, ErrBodyNotAllowed
+	}
+	w.state = stateBuffering
+	w.implicit = true
+
+	if len(w.body)+len(p) <= w.bufferSize() {
+		if w.body == nil {
+			w.body = make([]byte, 0, w.bufferSize())
+		}
+		w.body = append(w.body, p...)
+		return len(p), nil
+	}
+
+	if err := w.startStream(); err != nil {
+		return 0, err
+	}
+	if err := w.writeBodyPart(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeBodyPart sends part of a body with the framing the headers declared
+func (w *Writer) writeBodyPart(p []byte) error {
+	if w.isChunked {
+		return w.WriteChunk(p)
+	}
+	return w.WriteBody(p)
+}
+
+// startStream settles the head once a body written through Write can't be
+// held back any longer. Without a Content-Length from the handler the body
+// is chunked, or for HTTP/1.0 ended by closing the connection. The head
+// goes out with the first part of the body.
+func (w *Writer) startStream() error {
+	h := w.headers
+	if !h.Has("content-length") && !h.Has("transfer-encoding") {
+		if w.http10 {
+			h.Set("Connection", "close")
+		} else {
+			h.Set("Transfer-Encoding", "chunked")
+		}
+	}
+
+	w.state = stateStatusWritten
+	if err := w.startHead(h); err != nil {
+		return err
+	}
+
+	body := w.body
+	w.body = w.body[:0]
+	if len(body) == 0 {
+		return nil
+	}
+	return w.writeBodyPart(body)
+}
+
+// Finish completes the response once the handler is done. A body held
+// back by Write goes out with a Content-Length, a streamed one is
+// terminated, and a filtered one is flushed. A handler that wrote nothing
+// sends an empty 200. It is safe to call more than once.
+func (w *Writer) Finish() error {
+	if w.finished {
+		return nil
+	}
+	w.finished = true
+
+	if w.state < stateHeadersWritten {
+		return w.finishBuffered()
+	}
+
+	switch {
+	case w.filtering:
+		if w.headPending && !w.isChunked && w.contentLength <= 0 {
+			// Nothing was written - the empty body is complete
+			return w.writeFilteredComplete(nil)
+		}
+		return w.finishFiltered()
+	case w.implicit && w.isChunked:
+		return w.FinishChunked()
+	case w.headPending:
+		w.appendHead()
+		return w.send(nil, nil)
+	}
+	return nil
+}
+
+// finishBuffered sends a response whose head hasn't been sent, with the
+// body Write held back
+func (w *Writer) finishBuffered() error {
+	if w.state == stateStart {
+		w.statusCode = StatusOK
+	}
+
+	h := w.headers
+	if bodyAllowed(w.statusCode) && !h.Has("content-length") && !h.Has("transfer-encoding") {
+		h.Set("Content-Length", strconv.Itoa(len(w.body)))
+	}
+
+	w.state = stateStatusWritten
+	if err := w.startHead(h); err != nil {
+		return err
+	}
+
+	body := w.body
+	w.body = w.body[:0]
+	switch {
+	case w.filtering:
+		if err := w.writeFiltered(body); err != nil {
+			return err
+		}
+		if w.filtering {
+			return w.finishFiltered()
+		}
+		return nil
+	case w.isChunked:
+		if err := w.writeChunk(body, true); err != nil {
+			return err
+		}
+		w.state = stateBodyWritten
+		return nil
+	default:
+		return w.WriteBody(body)
+	}
+}
+
+// bodyAllowed reports whether a response with code may have a body
+func bodyAllowed(code StatusCode) bool {
+	return code >= 200 && code != StatusNoContent && code != StatusNotModified
+}
+
+// dateFormat is the HTTP date format (RFC 9110 section 5.6.7)
+const dateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+var dateCache atomic.Pointer[cachedDate]
+
+// httpDate returns the current time for the Date header. It only changes
+// once a second, so it is formatted once a second.
+func httpDate() string {
+	now := time.Now()
+	if d := dateCache.Load(); d != nil && d.unix == now.Unix() {
+		return d.value
+	}
+	d := &cachedDate{unix: now.Unix(), value: now.UTC().Format(dateFormat)}
+	dateCache.Store(d)
+	return d.value
+}
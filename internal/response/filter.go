@@ -0,0 +1,163 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+)
+
+// BodyFilter transforms a response body on its way to the wire (e.g. compression).
+// The Writer owns the framing: a body written in one piece with a matching
+// Content-Length gets a recomputed Content-Length, anything else is re-sent
+// with chunked encoding.
+type BodyFilter interface {
+	// Start sees the status and headers before they are sent and may edit
+	// them. Returning false sends the body untouched.
+	Start(code StatusCode, h *headers.Headers) bool
+
+	// Encode transforms a body segment and returns whatever output is ready
+	Encode(p []byte) ([]byte, error)
+
+	// Flush returns all output buffered so far (for streaming responses)
+	Flush() ([]byte, error)
+
+	// Finish returns the remaining output once the body is complete
+	Finish() ([]byte, error)
+}
+
+// SetBodyFilter installs a filter. It must be called before the status line
+// is written.
+func (w *Writer) SetBodyFilter(f BodyFilter) error {
+	if w.state != stateStart {
+		return fmt.Errorf("response already started")
+	}
+	w.filter = f
+	return nil
+}
+
+// writeFiltered encodes a body segment and frames the output
+func (w *Writer) writeFiltered(data []byte) error {
+	if w.headPending {
+		if !w.isChunked && w.contentLength == int64(len(data)) {
+			return w.writeFilteredComplete(data)
+		}
+		w.startFilteredStream()
+	}
+
+	out, err := w.filter.Encode(data)
+	if err != nil {
+		return err
+	}
+	if err := w.writeChunk(out, false); err != nil {
+		return err
+	}
+
+	w.state = stateBodyWritten
+	return nil
+}
+
+// writeFilteredComplete encodes a whole body at once and sends it with a
+// recomputed Content-Length
+func (w *Writer) writeFilteredComplete(data []byte) error {
+	body, err := w.filter.Encode(data)
+	if err != nil {
+		return err
+	}
+	tail, err := w.filter.Finish()
+	if err != nil {
+		return err
+	}
+	body = append(body, tail...)
+
+	w.filtering = false
+	w.contentLength = int64(len(body))
+	w.headers.Set("Content-Length", strconv.Itoa(len(body)))
+
+	w.appendHead()
+	if w.noBody {
+		body = nil
+	}
+	if err := w.send(body, nil); err != nil {
+		return err
+	}
+
+	w.state = stateBodyWritten
+	return nil
+}
+
+// startFilteredStream switches the held-back head to chunked encoding,
+// since the encoded length is not known up front. It goes out with the
+// first chunk.
+func (w *Writer) startFilteredStream() {
+	w.contentLength = -1
+	w.isChunked = true
+	w.headers.Del("Content-Length")
+	w.headers.Set("Transfer-Encoding", "chunked")
+}
+
+// finishFiltered flushes the filter and writes the final chunk
+func (w *Writer) finishFiltered() error {
+	if w.headPending {
+		w.startFilteredStream()
+	}
+
+	tail, err := w.filter.Finish()
+	if err != nil {
+		return err
+	}
+	w.filtering = false
+
+	if err := w.writeChunk(tail, true); err != nil {
+		return err
+	}
+
+	w.state = stateBodyWritten
+	return nil
+}
+
+// Buffer returns a Writer that records a response in memory instead of
+// sending it, e.g. so a timeout can still replace it. The new Writer takes
+// over w's body filter and OnHeaders hooks and starts with a copy of w's
+// headers.
+func (w *Writer) Buffer() *Writer {
+	b := NewWriterWithConfig(&bytes.Buffer{}, w.config)
+	b.http10 = w.http10
+	b.headRequest = w.headRequest
+	b.headers = w.headers.Clone()
+	b.filter = w.filter
+	b.onHeaders = w.onHeaders
+	w.filter = nil
+	w.onHeaders = nil
+	return b
+}
+
+// Commit sends a response recorded by Buffer. It fails if w has already
+// started a response of its own.
+func (w *Writer) Commit(b *Writer) error {
+	if w.state != stateStart {
+		return fmt.Errorf("response already started")
+	}
+
+	buf, ok := b.w.(*bytes.Buffer)
+	if !ok {
+		return fmt.Errorf("writer was not created by Buffer")
+	}
+	if err := b.Finish(); err != nil {
+		return err
+	}
+
+	w.statusCode = b.statusCode
+	w.headers = b.headers
+	w.contentLength = b.contentLength
+	w.isChunked = b.isChunked
+	w.state = b.state
+	w.finished = true
+
+	if _, err := w.write(buf.Bytes()); err != nil {
+		w.hadError = true
+		return err
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package response
 import (
 	"fmt"
 	"io"
+	"net"
+	"slices"
 	"strconv"
 
 	"github.com/Brownie44l1/http-1/internal/headers"
@@ -14,6 +16,7 @@ type StatusCode int
 const (
 	// 1xx Informational
 	StatusContinue StatusCode = 100 // ✅ Issue #11: 100-continue support
+	StatusSwitchingProtocols StatusCode = 101 // WebSocket upgrade
 	
 	// 2xx Success
 	StatusOK                  StatusCode = 200
@@ -45,6 +48,7 @@ const (
 	StatusUnsupportedMediaType StatusCode = 415
 	StatusRequestedRangeNotSatisfiable StatusCode = 416 // ✅ Issue #11: Range
 	StatusExpectationFailed   StatusCode = 417 // ✅ Issue #11: Expect
+	StatusUpgradeRequired     StatusCode = 426
 	StatusTooManyRequests     StatusCode = 429
 	
 	// 5xx Server Errors
@@ -58,6 +62,7 @@ const (
 // statusText maps status codes to reason phrases
 var statusText = map[StatusCode]string{
 	StatusContinue:            "Continue",
+	StatusSwitchingProtocols:  "Switching Protocols",
 	StatusOK:                  "OK",
 	StatusCreated:             "Created",
 	StatusAccepted:            "Accepted",
@@ -83,6 +88,7 @@ var statusText = map[StatusCode]string{
 	StatusUnsupportedMediaType: "Unsupported Media Type",
 	StatusRequestedRangeNotSatisfiable: "Requested Range Not Satisfiable",
 	StatusExpectationFailed:   "Expectation Failed",
+	StatusUpgradeRequired:     "Upgrade Required",
 	StatusTooManyRequests:     "Too Many Requests",
 	StatusInternalServerError: "Internal Server Error",
 	StatusNotImplemented:      "Not Implemented",
@@ -97,60 +103,255 @@ type writerState int
 const (
 	stateStart writerState = iota
 	stateStatusWritten
+	stateBuffering // Body written through Write is held back
 	stateHeadersWritten
 	stateBodyWritten
 )
 
+// DefaultBufferSize is how much of a body written through Write is held
+// back before the response switches to streaming
+const DefaultBufferSize = 4096
+
+// WriterConfig holds per-server settings a Writer keeps across Reset
+type WriterConfig struct {
+	BufferSize int    // Body bytes Write holds back; 0 means DefaultBufferSize
+	Date       bool   // Add a Date header to responses without one
+	Server     string // Server header for responses without one; "" omits it
+}
+
+// DefaultWriterConfig returns the settings NewWriter uses
+func DefaultWriterConfig() WriterConfig {
+	return WriterConfig{
+		BufferSize: DefaultBufferSize,
+		Date:       true,
+	}
+}
+
 // Writer writes HTTP responses to an io.Writer
 type Writer struct {
 	w             io.Writer
+	config        WriterConfig
 	state         writerState
 	statusCode    StatusCode
 	contentLength int64 // -1 means unknown
 	isChunked     bool
 	hadError      bool
 	headers       *headers.Headers // Store headers before writing
+	written       int64            // Bytes sent, including the head
+
+	own  headers.Headers // Storage behind headers, kept across Reset
+	buf  []byte          // Head and framing waiting to be sent
+	body []byte          // Body held back by Write, kept across Reset
+
+	headPending bool // Head held back so it goes out with the body
+	implicit    bool // Body framed by the Writer; Finish ends it
+	http10      bool // Client can't take chunked encoding
+	headRequest bool // Request was HEAD
+	noBody      bool // Body bytes are dropped (HEAD, 1xx, 204, 304)
+
+	// Body filtering (e.g. compression)
+	filter    BodyFilter
+	filtering bool // Filter accepted this response and is encoding the body
+	finished  bool
+
+	onHeaders []func(StatusCode, *headers.Headers)
 }
 
+// maxScratch caps the scratch buffer a Writer keeps between responses, and
+// the size up to which a body is copied in to go out with its head
+const maxScratch = 4096
+
+var (
+	crlf         = []byte("\r\n")
+	lastChunk    = []byte("0\r\n\r\n")
+	endLastChunk = []byte("\r\n0\r\n\r\n") // End of a chunk, then the last chunk
+)
+
 // NewWriter creates a new response writer
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{
-		w:             w,
+	return NewWriterWithConfig(w, DefaultWriterConfig())
+}
+
+// NewWriterWithConfig creates a response writer with custom settings
+func NewWriterWithConfig(w io.Writer, config WriterConfig) *Writer {
+	rw := &Writer{config: config}
+	rw.Reset(w)
+	return rw
+}
+
+// SetConfig changes the Writer's settings, which then apply to every
+// response until changed again
+func (w *Writer) SetConfig(config WriterConfig) {
+	w.config = config
+}
+
+// Reset prepares w for a new response to out, keeping its settings, header
+// storage and buffers, so a connection can use one Writer for every
+// response. Headers passed to WriteHeaders are never reset.
+func (w *Writer) Reset(out io.Writer) {
+	own, buf, body := w.own, w.buf, w.body
+	own.Reset()
+	if cap(buf) > maxScratch {
+		buf = nil
+	}
+	if cap(body) > w.bufferSize() {
+		body = nil
+	}
+	*w = Writer{
+		w:             out,
+		config:        w.config,
 		state:         stateStart,
 		contentLength: -1,
-		headers:       headers.NewHeaders(),
+		own:           own,
+		buf:           buf[:0],
+		body:          body[:0],
 	}
+	w.headers = &w.own
 }
 
-// WriteStatusLine writes the HTTP status line
+// SetHTTP10 marks the response as going to an HTTP/1.0 client, which
+// doesn't understand chunked encoding. A body streamed through Write is
+// then ended by closing the connection instead.
+func (w *Writer) SetHTTP10() {
+	w.http10 = true
+}
+
+// SetMethod tells the Writer the request method. Responses to HEAD get the
+// same headers as GET, Content-Length included, but the body is dropped.
+func (w *Writer) SetMethod(method string) {
+	w.headRequest = method == "HEAD"
+}
+
+func (w *Writer) bufferSize() int {
+	if w.config.BufferSize > 0 {
+		return w.config.BufferSize
+	}
+	return DefaultBufferSize
+}
+
+// write sends p to the underlying writer, counting the bytes
+func (w *Writer) write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// send writes w.buf, which holds whatever head and framing has been built
+// up, followed by data and suffix. Small data is copied in so it all goes
+// out in one write; larger data is sent with writev where the connection
+// supports it.
+func (w *Writer) send(data, suffix []byte) error {
+	if len(w.buf)+len(data)+len(suffix) == 0 {
+		return nil
+	}
+
+	var err error
+	switch {
+	case len(w.buf) == 0 && len(suffix) == 0:
+		_, err = w.write(data)
+	case len(data) == 0 || len(w.buf)+len(data)+len(suffix) <= maxScratch:
+		w.buf = append(append(w.buf, data...), suffix...)
+		_, err = w.write(w.buf)
+	default:
+		bufs := net.Buffers{w.buf, data, suffix}
+		var n int64
+		n, err = bufs.WriteTo(w.w)
+		w.written += n
+	}
+
+	w.buf = w.buf[:0]
+	if err != nil {
+		w.hadError = true
+	}
+	return err
+}
+
+// WriteStatusLine sets the response status. The status line is sent along
+// with the headers.
 func (w *Writer) WriteStatusLine(code StatusCode) error {
 	if w.state != stateStart {
 		return fmt.Errorf("status line already written")
 	}
 
+	w.statusCode = code
+	w.state = stateStatusWritten
+	return nil
+}
+
+func appendStatusLine(b []byte, code StatusCode) []byte {
 	reason, ok := statusText[code]
 	if !ok {
 		reason = "Unknown"
 	}
 
-	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, reason)
-	_, err := w.w.Write([]byte(statusLine))
-	if err != nil {
-		w.hadError = true
+	b = append(b, "HTTP/1.1 "...)
+	b = strconv.AppendInt(b, int64(code), 10)
+	b = append(b, ' ')
+	b = append(b, reason...)
+	return append(b, "\r\n"...)
+}
+
+// WriteHeaders writes the status line and all HTTP headers
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if err := w.startHead(h); err != nil {
 		return err
 	}
 
-	w.statusCode = code
-	w.state = stateStatusWritten
-	return nil
+	// With a filter, framing depends on how the body arrives - see
+	// writeFiltered
+	if w.filtering {
+		return nil
+	}
+	w.appendHead()
+	return w.send(nil, nil)
 }
 
-// WriteHeaders writes all HTTP headers
-func (w *Writer) WriteHeaders(h *headers.Headers) error {
+// startHead settles h as the response headers and holds the head back, so
+// it can go out together with the start of the body
+func (w *Writer) startHead(h *headers.Headers) error {
 	if w.state != stateStatusWritten {
 		return fmt.Errorf("must write status line before headers")
 	}
 
+	// Headers set earlier through Headers() (e.g. by middleware) fill in
+	// anything the caller didn't set explicitly. Set-Cookie lines are
+	// independent and Vary is a list, so those are kept alongside the
+	// caller's.
+	if h != w.headers {
+		var mergedBuf [8]string
+		merged := mergedBuf[:0] // Names h lacked, whose every line is added
+		for key, value := range w.headers.All() {
+			if key != "set-cookie" && key != "vary" && !slices.Contains(merged, key) {
+				if h.Has(key) {
+					continue
+				}
+				merged = append(merged, key)
+			}
+			h.Add(key, value)
+		}
+	}
+
+	// RFC 9110 sections 6.4.1 and 9.3.2: no body for HEAD, 1xx, 204 and
+	// 304, and no framing headers at all for 1xx and 204
+	w.noBody = w.headRequest || !bodyAllowed(w.statusCode)
+	if w.statusCode < 200 || w.statusCode == StatusNoContent {
+		h.Del("Content-Length")
+		h.Del("Transfer-Encoding")
+	}
+
+	if w.config.Date && !h.Has("date") {
+		h.Set("Date", httpDate())
+	}
+	if w.config.Server != "" && !h.Has("server") {
+		h.Set("Server", w.config.Server)
+	}
+
+	hooks := w.onHeaders
+	w.onHeaders = nil
+	for _, fn := range hooks {
+		fn(w.statusCode, h)
+	}
+
 	// Track important headers for connection management
 	if cl, ok := h.Get("content-length"); ok {
 		if length, err := strconv.ParseInt(cl, 10, 64); err == nil {
@@ -167,43 +368,61 @@ func (w *Writer) WriteHeaders(h *headers.Headers) error {
 	// Store headers
 	w.headers = h
 
-	// Write all headers
-	for key, values := range h.GetAllHeaders() {
-		for _, value := range values {
-			headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
-			_, err := w.w.Write([]byte(headerLine))
-			if err != nil {
-				w.hadError = true
-				return err
-			}
+	if w.filter != nil {
+		if w.filter.Start(w.statusCode, h) {
+			w.filtering = true
+		} else {
+			w.filter = nil
 		}
 	}
 
-	// Write empty line to end headers
-	_, err := w.w.Write([]byte("\r\n"))
-	if err != nil {
-		w.hadError = true
-		return err
-	}
-
+	w.headPending = true
 	w.state = stateHeadersWritten
 	return nil
 }
 
+// appendHead adds the held-back status line and headers to w.buf
+func (w *Writer) appendHead() {
+	if w.headPending {
+		w.headPending = false
+		w.buf = appendStatusLine(w.buf, w.statusCode)
+		w.buf = appendHeaderLines(w.buf, w.headers)
+	}
+}
+
+// OnHeaders registers fn to run once, just before the headers are sent and
+// after the Headers() defaults are merged in. fn may edit h, e.g. to add a
+// Set-Cookie that depends on what the handler did.
+func (w *Writer) OnHeaders(fn func(code StatusCode, h *headers.Headers)) {
+	w.onHeaders = append(w.onHeaders, fn)
+}
+
+// appendHeaderLines appends "name: value" lines and a blank line to b
+func appendHeaderLines(b []byte, h *headers.Headers) []byte {
+	for key, value := range h.All() {
+		b = append(b, key...)
+		b = append(b, ": "...)
+		b = append(b, value...)
+		b = append(b, "\r\n"...)
+	}
+	return append(b, "\r\n"...)
+}
+
 // WriteBody writes the complete response body
 func (w *Writer) WriteBody(data []byte) error {
 	if w.state != stateHeadersWritten && w.state != stateBodyWritten {
 		return fmt.Errorf("must write headers before body")
 	}
 
-	if len(data) == 0 {
-		w.state = stateBodyWritten
-		return nil
+	if w.filtering {
+		return w.writeFiltered(data)
 	}
 
-	_, err := w.w.Write(data)
-	if err != nil {
-		w.hadError = true
+	w.appendHead()
+	if w.noBody {
+		data = nil
+	}
+	if err := w.send(data, nil); err != nil {
 		return err
 	}
 
@@ -217,35 +436,70 @@ func (w *Writer) WriteChunk(data []byte) error {
 		return fmt.Errorf("must write headers before chunks")
 	}
 
-	if len(data) == 0 {
-		return nil // Don't write empty chunks (except final)
+	if w.filtering {
+		return w.writeFiltered(data)
 	}
 
-	// Write chunk size in hex
-	chunkSize := fmt.Sprintf("%x\r\n", len(data))
-	if _, err := w.w.Write([]byte(chunkSize)); err != nil {
-		w.hadError = true
+	if err := w.writeChunk(data, false); err != nil {
 		return err
 	}
 
-	// Write chunk data
-	if _, err := w.w.Write(data); err != nil {
-		w.hadError = true
-		return err
+	w.state = stateBodyWritten
+	return nil
+}
+
+// writeChunk frames data as a single chunk, after a held-back head and
+// before the last chunk if last is set
+func (w *Writer) writeChunk(data []byte, last bool) error {
+	w.appendHead()
+	if w.noBody {
+		return w.send(nil, nil)
 	}
 
-	// Write trailing CRLF
-	if _, err := w.w.Write([]byte("\r\n")); err != nil {
-		w.hadError = true
-		return err
+	// Don't write empty chunks (except final)
+	if len(data) == 0 {
+		if last {
+			w.buf = append(w.buf, lastChunk...)
+		}
+		return w.send(nil, nil)
 	}
 
-	w.state = stateBodyWritten
-	return nil
+	w.buf = strconv.AppendInt(w.buf, int64(len(data)), 16)
+	w.buf = append(w.buf, "\r\n"...)
+	if last {
+		return w.send(data, endLastChunk)
+	}
+	return w.send(data, crlf)
 }
 
-// ✅ Issue #5: Flush forces buffered data to be sent
+// ✅ Issue #5: Flush forces buffered data to be sent. A body held back by
+// Write is streamed from here on.
 func (w *Writer) Flush() error {
+	if w.state == stateBuffering {
+		if err := w.startStream(); err != nil {
+			return err
+		}
+	}
+
+	// Push out whatever the filter has buffered so streaming clients see it
+	if w.filtering {
+		if w.headPending {
+			w.startFilteredStream()
+		}
+		out, err := w.filter.Flush()
+		if err != nil {
+			return err
+		}
+		if err := w.writeChunk(out, false); err != nil {
+			return err
+		}
+	} else if w.headPending {
+		w.appendHead()
+		if err := w.send(nil, nil); err != nil {
+			return err
+		}
+	}
+
 	// Check if underlying writer supports flushing
 	if flusher, ok := w.w.(interface{ Flush() error }); ok {
 		return flusher.Flush()
@@ -260,10 +514,12 @@ func (w *Writer) FinishChunked() error {
 		return fmt.Errorf("must write headers before finishing chunks")
 	}
 
+	if w.filtering {
+		return w.finishFiltered()
+	}
+
 	// Write final chunk: 0\r\n\r\n
-	_, err := w.w.Write([]byte("0\r\n\r\n"))
-	if err != nil {
-		w.hadError = true
+	if err := w.writeChunk(nil, true); err != nil {
 		return err
 	}
 
@@ -277,70 +533,58 @@ func (w *Writer) WriteTrailers(h *headers.Headers) error {
 		return fmt.Errorf("must write body before trailers")
 	}
 
-	// Write trailers just like headers
-	for key, values := range h.GetAllHeaders() {
-		for _, value := range values {
-			line := fmt.Sprintf("%s: %s\r\n", key, value)
-			if _, err := w.w.Write([]byte(line)); err != nil {
-				w.hadError = true
-				return err
-			}
-		}
+	if w.noBody {
+		return nil
 	}
 
-	// Final CRLF
-	_, err := w.w.Write([]byte("\r\n"))
-	if err != nil {
-		w.hadError = true
-	}
-	return err
+	// Write trailers just like headers, ending with the final CRLF
+	w.buf = appendHeaderLines(w.buf, h)
+	return w.send(nil, nil)
 }
 
 // Helper methods for common responses
 
 // TextResponse sends a plain text response
 func (w *Writer) TextResponse(code StatusCode, text string) error {
-	h := headers.NewHeaders()
-	h.Set("Content-Type", "text/plain; charset=utf-8")
-	h.Set("Content-Length", strconv.Itoa(len(text)))
-
-	if err := w.WriteStatusLine(code); err != nil {
-		return err
-	}
-	if err := w.WriteHeaders(h); err != nil {
-		return err
-	}
-	return w.WriteBody([]byte(text))
+	return w.stringResponse(code, "text/plain; charset=utf-8", text)
 }
 
 // HTMLResponse sends an HTML response
 func (w *Writer) HTMLResponse(code StatusCode, html string) error {
-	h := headers.NewHeaders()
-	h.Set("Content-Type", "text/html; charset=utf-8")
-	h.Set("Content-Length", strconv.Itoa(len(html)))
-
-	if err := w.WriteStatusLine(code); err != nil {
-		return err
-	}
-	if err := w.WriteHeaders(h); err != nil {
-		return err
-	}
-	return w.WriteBody([]byte(html))
+	return w.stringResponse(code, "text/html; charset=utf-8", html)
 }
 
 // JSONResponse sends a JSON response
 func (w *Writer) JSONResponse(code StatusCode, json string) error {
-	h := headers.NewHeaders()
-	h.Set("Content-Type", "application/json; charset=utf-8")
-	h.Set("Content-Length", strconv.Itoa(len(json)))
+	return w.stringResponse(code, "application/json; charset=utf-8", json)
+}
 
+// stringResponse sends body with the given Content-Type in a single write.
+// The headers go straight into the Writer's own, so nothing is allocated
+// for them.
+func (w *Writer) stringResponse(code StatusCode, contentType, body string) error {
 	if err := w.WriteStatusLine(code); err != nil {
 		return err
 	}
-	if err := w.WriteHeaders(h); err != nil {
+	h := w.headers
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if err := w.startHead(h); err != nil {
 		return err
 	}
-	return w.WriteBody([]byte(json))
+
+	if w.filtering || len(body) > maxScratch {
+		return w.WriteBody([]byte(body))
+	}
+	w.appendHead()
+	if !w.noBody {
+		w.buf = append(w.buf, body...)
+	}
+	if err := w.send(nil, nil); err != nil {
+		return err
+	}
+	w.state = stateBodyWritten
+	return nil
 }
 
 // ErrorResponse sends an error response
@@ -350,30 +594,29 @@ func (w *Writer) ErrorResponse(code StatusCode, message string) error {
 
 // RedirectResponse sends a redirect response
 func (w *Writer) RedirectResponse(code StatusCode, location string) error {
-	h := headers.NewHeaders()
-	h.Set("Location", location)
-	h.Set("Content-Length", "0")
-
 	if err := w.WriteStatusLine(code); err != nil {
 		return err
 	}
+	h := w.headers
+	h.Set("Location", location)
+	h.Set("Content-Length", "0")
 	return w.WriteHeaders(h)
 }
 
 // NoContentResponse sends a 204 No Content response
 func (w *Writer) NoContentResponse() error {
-	h := headers.NewHeaders()
 	if err := w.WriteStatusLine(StatusNoContent); err != nil {
 		return err
 	}
-	return w.WriteHeaders(h)
+	return w.WriteHeaders(w.headers)
 }
 
+var continueResponse = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+
 // ✅ Issue #11: ContinueResponse sends 100 Continue
 func (w *Writer) ContinueResponse() error {
 	// 100 Continue is special - doesn't change state
-	statusLine := "HTTP/1.1 100 Continue\r\n\r\n"
-	_, err := w.w.Write([]byte(statusLine))
+	_, err := w.write(continueResponse)
 	if err != nil {
 		w.hadError = true
 	}
@@ -394,10 +637,16 @@ func (w *Writer) IsChunked() bool {
 	return w.isChunked
 }
 
+// BytesWritten returns the number of bytes sent so far, including the
+// status line, headers and any chunk framing
+func (w *Writer) BytesWritten() int64 {
+	return w.written
+}
+
 func (w *Writer) StatusCode() StatusCode {
 	return w.statusCode
 }
 
 func (w *Writer) Headers() *headers.Headers {
 	return w.headers
-}
\ No newline at end of file
+}
@@ -3,6 +3,7 @@ package response
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
 
@@ -55,6 +56,8 @@ func TestStatusCodes(t *testing.T) {
 
 		err := w.WriteStatusLine(tt.code)
 		require.NoError(t, err)
+		assert.Empty(t, buf.String()) // Sent along with the headers
+		require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
 
 		result := buf.String()
 		expected := fmt.Sprintf("HTTP/1.1 %d %s", tt.code, tt.reason)
@@ -261,12 +264,361 @@ func TestErrorTracking(t *testing.T) {
 	assert.False(t, w.HadError())
 
 	_ = w.WriteStatusLine(StatusOK)
+	assert.False(t, w.HadError()) // Nothing sent yet
+	_ = w.WriteHeaders(headers.NewHeaders())
 	assert.True(t, w.HadError())
 }
 
+func TestBodyFilterCompleteBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetBodyFilter(&doubleFilter{}))
+
+	require.NoError(t, w.TextResponse(StatusOK, "abc"))
+	require.NoError(t, w.Finish())
+
+	// Whole body written at once - Content-Length is recomputed
+	result := buf.String()
+	assert.True(t, strings.HasPrefix(result, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, result, "content-length: 9\r\n")
+	assert.Contains(t, result, "x-filtered: yes\r\n")
+	assert.True(t, strings.HasSuffix(result, "\r\n\r\naabbccEND"))
+	assert.False(t, w.IsChunked())
+}
+
+func TestBodyFilterStreaming(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetBodyFilter(&doubleFilter{}))
+
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "4")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteBody([]byte("ab")))
+	require.NoError(t, w.WriteBody([]byte("cd")))
+	require.NoError(t, w.Finish())
+	require.NoError(t, w.Finish()) // Idempotent
+
+	// Partial writes - switched to chunked encoding
+	result := buf.String()
+	assert.NotContains(t, result, "content-length")
+	assert.Contains(t, result, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(result, "\r\n\r\n4\r\naabb\r\n4\r\nccdd\r\n3\r\nEND\r\n0\r\n\r\n"))
+	assert.True(t, w.IsChunked())
+}
+
+func TestBodyFilterDeclined(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetBodyFilter(&doubleFilter{decline: true}))
+
+	require.NoError(t, w.TextResponse(StatusOK, "abc"))
+	require.NoError(t, w.Finish())
+
+	result := buf.String()
+	assert.Contains(t, result, "content-length: 3\r\n")
+	assert.True(t, strings.HasSuffix(result, "\r\n\r\nabc"))
+}
+
+func TestSetBodyFilterAfterStart(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Error(t, w.SetBodyFilter(&doubleFilter{}))
+}
+
+func TestBufferCommit(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Headers().Set("X-Request-ID", "abc")
+
+	b := w.Buffer()
+	require.NoError(t, b.TextResponse(StatusCreated, "done"))
+	assert.Empty(t, buf.String()) // Nothing sent yet
+
+	require.NoError(t, w.Commit(b))
+	result := buf.String()
+	assert.True(t, strings.HasPrefix(result, "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, result, "x-request-id: abc\r\n")
+	assert.True(t, strings.HasSuffix(result, "\r\n\r\ndone"))
+	assert.Equal(t, StatusCreated, w.StatusCode())
+
+	// Only one response per writer
+	assert.Error(t, w.Commit(w.Buffer()))
+}
+
+func TestPresetHeadersMerged(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Headers().Set("Connection", "close")
+	w.Headers().Set("Content-Type", "text/html")
+
+	require.NoError(t, w.TextResponse(StatusOK, "hi"))
+
+	// Explicit headers win, preset ones fill the gaps
+	result := buf.String()
+	assert.Contains(t, result, "connection: close\r\n")
+	assert.Contains(t, result, "content-type: text/plain; charset=utf-8\r\n")
+	v, _ := w.Headers().Get("connection")
+	assert.Equal(t, "close", v)
+}
+
+func TestWriterReset(t *testing.T) {
+	var first, second bytes.Buffer
+	w := NewWriter(&first)
+	w.Headers().Set("X-First", "1")
+	require.NoError(t, w.TextResponse(StatusOK, "one"))
+
+	w.Reset(&second)
+	assert.False(t, w.Headers().Has("x-first"))
+	require.NoError(t, w.TextResponse(StatusNotFound, "two"))
+	assert.True(t, strings.HasPrefix(second.String(), "HTTP/1.1 404 Not Found\r\n"))
+	assert.NotContains(t, second.String(), "x-first")
+	assert.True(t, strings.HasSuffix(first.String(), "\r\n\r\none"))
+
+	// Headers passed in belong to the caller and survive a reset
+	var third bytes.Buffer
+	own := headers.NewHeaders()
+	own.Set("X-Kept", "1")
+	w.Reset(&third)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(own))
+	w.Reset(&third)
+	assert.True(t, own.Has("x-kept"))
+}
+
+func TestWriteSetsContentLength(t *testing.T) {
+	out := &countingWriter{}
+	w := NewWriterWithConfig(out, WriterConfig{Date: true, Server: "test"})
+	w.Headers().Set("Content-Type", "text/plain")
+
+	fmt.Fprintf(w, "hello, ")
+	fmt.Fprintf(w, "%s", "world")
+	assert.Empty(t, out.String()) // Held back until the handler is done
+
+	require.NoError(t, w.Finish())
+	result := out.String()
+	assert.True(t, strings.HasPrefix(result, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, result, "content-length: 12\r\n")
+	assert.Contains(t, result, "server: test\r\n")
+	assert.Regexp(t, `date: \w{3}, \d{2} \w{3} \d{4} \d{2}:\d{2}:\d{2} GMT\r\n`, result)
+	assert.True(t, strings.HasSuffix(result, "\r\n\r\nhello, world"))
+	assert.Equal(t, 1, out.writes)
+
+	// Finish is idempotent and later writes fail
+	require.NoError(t, w.Finish())
+	_, err := w.Write([]byte("late"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, out.writes)
+}
+
+func TestWriteStreamsLargeBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterWithConfig(&buf, WriterConfig{BufferSize: 8})
+	require.NoError(t, w.WriteStatusLine(StatusCreated))
+
+	_, err := w.Write([]byte("12345"))
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+	_, err = w.Write([]byte("6789"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 201 Created\r\ntransfer-encoding: chunked\r\n\r\n"+
+		"5\r\n12345\r\n4\r\n6789\r\n0\r\n\r\n", buf.String())
+	assert.True(t, w.IsChunked())
+
+	// HTTP/1.0 clients get the body delimited by closing the connection
+	buf.Reset()
+	w.Reset(&buf)
+	w.SetHTTP10()
+	w.Write([]byte("123456789"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nconnection: close\r\n\r\n123456789", buf.String())
+}
+
+func TestFlushStartsStream(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterWithConfig(&buf, WriterConfig{})
+	w.Write([]byte("event: 1\n\n"))
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\na\r\nevent: 1\n\n\r\n"))
+
+	w.Write([]byte("event: 2\n\n"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "a\r\nevent: 2\n\n\r\n0\r\n\r\n"))
+}
+
+func TestFinishWithoutBody(t *testing.T) {
+	// Nothing written is an empty 200
+	var buf bytes.Buffer
+	w := NewWriterWithConfig(&buf, WriterConfig{})
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n", buf.String())
+
+	// Statuses without a body get no Content-Length and refuse one
+	buf.Reset()
+	w.Reset(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	_, err := w.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", buf.String())
+}
+
+func TestWriteFiltered(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterWithConfig(&buf, WriterConfig{})
+	require.NoError(t, w.SetBodyFilter(&doubleFilter{}))
+	w.Write([]byte("ab"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 7\r\nx-filtered: yes\r\n\r\naabbEND", buf.String())
+}
+
+func TestHelpersWriteOnce(t *testing.T) {
+	out := &countingWriter{}
+	w := NewWriter(out)
+	require.NoError(t, w.JSONResponse(StatusOK, `{"ok":true}`))
+	assert.Equal(t, 1, out.writes)
+	assert.Contains(t, out.String(), "date: ")
+
+	// Bodies too big to copy go out with the head in one writev where
+	// supported
+	out = &countingWriter{}
+	w = NewWriter(out)
+	require.NoError(t, w.TextResponse(StatusOK, strings.Repeat("x", 10000)))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"+strings.Repeat("x", 10000)))
+}
+
+func TestBodySuppressed(t *testing.T) {
+	// HEAD keeps the headers GET would get, Content-Length included
+	var buf bytes.Buffer
+	w := NewWriterWithConfig(&buf, WriterConfig{})
+	w.SetMethod("HEAD")
+	require.NoError(t, w.TextResponse(StatusOK, "hello"))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-type: text/plain; charset=utf-8\r\ncontent-length: 5\r\n\r\n", buf.String())
+
+	buf.Reset()
+	w.Reset(&buf)
+	w.SetMethod("HEAD")
+	fmt.Fprint(w, "hello")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\n", buf.String())
+
+	// Streamed bodies are dropped too, with no final chunk
+	buf.Reset()
+	w = NewWriterWithConfig(&buf, WriterConfig{BufferSize: 4})
+	w.SetMethod("HEAD")
+	fmt.Fprint(w, "hello")
+	require.NoError(t, w.Flush())
+	fmt.Fprint(w, "world")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n", buf.String())
+
+	// 204 drops the body and framing headers; 304 keeps Content-Length
+	buf.Reset()
+	w.Reset(&buf)
+	require.NoError(t, w.TextResponse(StatusNoContent, "ignored"))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\ncontent-type: text/plain; charset=utf-8\r\n\r\n", buf.String())
+
+	buf.Reset()
+	w.Reset(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "10")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteBody([]byte("0123456789")))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\ncontent-length: 10\r\n\r\n", buf.String())
+}
+
+func TestOnHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Headers().Add("Set-Cookie", "a=1")
+
+	calls := 0
+	w.OnHeaders(func(code StatusCode, h *headers.Headers) {
+		calls++
+		assert.Equal(t, StatusCreated, code)
+		h.Add("Set-Cookie", "b=2")
+	})
+
+	// Hooks follow the response into a buffer
+	b := w.Buffer()
+	h := headers.NewHeaders()
+	h.Add("Set-Cookie", "c=3")
+	require.NoError(t, b.WriteStatusLine(StatusCreated))
+	require.NoError(t, b.WriteHeaders(h))
+	require.NoError(t, w.Commit(b))
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"c=3", "a=1", "b=2"}, w.Headers().GetAll("set-cookie"))
+	assert.Equal(t, 3, strings.Count(buf.String(), "set-cookie: "))
+}
+
+// doubleFilter repeats every byte and appends "END" once the stream ends
+type doubleFilter struct {
+	decline  bool
+	finished bool
+}
+
+func (f *doubleFilter) Start(code StatusCode, h *headers.Headers) bool {
+	if f.decline {
+		return false
+	}
+	h.Set("X-Filtered", "yes")
+	return true
+}
+
+func (f *doubleFilter) Encode(p []byte) ([]byte, error) {
+	out := make([]byte, 0, 2*len(p))
+	for _, b := range p {
+		out = append(out, b, b)
+	}
+	return out, nil
+}
+
+func (f *doubleFilter) Flush() ([]byte, error) {
+	return nil, nil
+}
+
+func (f *doubleFilter) Finish() ([]byte, error) {
+	if f.finished {
+		return nil, nil
+	}
+	f.finished = true
+	if f.decline {
+		return nil, nil
+	}
+	return []byte("END"), nil
+}
+
 // failWriter always returns an error
+// countingWriter records how many writes a response took
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
 type failWriter struct{}
 
 func (f *failWriter) Write(p []byte) (int, error) {
 	return 0, assert.AnError
 }
+
+func BenchmarkTextResponse(b *testing.B) {
+	w := NewWriter(io.Discard)
+	b.ReportAllocs()
+	for b.Loop() {
+		w.Reset(io.Discard)
+		if err := w.TextResponse(StatusOK, "Hello, World!"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
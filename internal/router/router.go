@@ -1,61 +1,94 @@
 package router
 
 import (
-	"regexp"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/Brownie44l1/http-1/internal/response"
 	"github.com/Brownie44l1/http-1/internal/server"
 )
 
+var (
+	ErrInvalidPattern = errors.New("router: invalid route pattern")
+	ErrRouteConflict  = errors.New("router: route conflicts with an existing route")
+)
+
 // ✅ Issue #2: Use concrete type instead of interface{}
 type Handler func(ctx *server.Context)
 
 // Route represents a single route
 type Route struct {
-	Method   string
-	Pattern  string // Original pattern (e.g., "/users/:id")
-	Handler  Handler
-	Params   []string       // Parameter names (e.g., ["id", "name"])
-	Regex    *regexp.Regexp // ✅ Issue #10: Regex pattern for matching
-	IsStatic bool           // True if no parameters/wildcards
+	Method  string
+	Pattern string // Original pattern (e.g., "/users/:id")
+	Handler Handler
+	Params  []string // Parameter names (e.g., ["id", "name"])
 }
 
-// Router handles HTTP routing
+// Router handles HTTP routing. Routes live in one radix tree per method,
+// so matching costs the same with ten routes or a thousand.
 type Router struct {
 	routes           []*Route
-	notFound         Handler // 404 handler
-	methodNotAllowed Handler // 405 handler
+	trees            map[string]*node // Root node for each method
+	notFound         Handler          // 404 handler
+	methodNotAllowed Handler          // 405 handler
+	globalOptions    Handler          // OPTIONS for paths without an OPTIONS route
 }
 
 // New creates a new router
 func New() *Router {
 	return &Router{
 		routes: make([]*Route, 0),
+		trees:  make(map[string]*node),
 		notFound: func(ctx *server.Context) {
 			ctx.Error(response.StatusNotFound, "Not Found")
 		},
 		methodNotAllowed: func(ctx *server.Context) {
 			ctx.Error(response.StatusMethodNotAllowed, "Method Not Allowed")
 		},
+		globalOptions: func(ctx *server.Context) {
+			ctx.NoContent()
+		},
 	}
 }
 
-// Handle registers a new route
-func (r *Router) Handle(method, pattern string, handler Handler) {
-	// ✅ Issue #10: Parse pattern to extract params and build regex
-	params, regex, isStatic := parsePattern(pattern)
+// Handle registers a new route. It returns an error wrapping
+// ErrInvalidPattern for malformed patterns and ErrRouteConflict when the
+// route would shadow or duplicate one already registered; the router is
+// left unchanged in both cases.
+//
+// Patterns support ":name" (one segment), ":name<regex>" (one segment
+// matching regex) and "*name" (the rest of the path, last segment only).
+// When several routes match, static segments win over params, params over
+// constrained params, and those over wildcards.
+func (r *Router) Handle(method, pattern string, handler Handler) error {
+	if pattern == "" || pattern[0] != '/' {
+		return fmt.Errorf("%w: %q must start with '/'", ErrInvalidPattern, pattern)
+	}
 
 	route := &Route{
-		Method:   method,
-		Pattern:  pattern,
-		Handler:  handler,
-		Params:   params,
-		Regex:    regex,
-		IsStatic: isStatic,
+		Method:  method,
+		Pattern: pattern,
+		Handler: handler,
+		Params:  paramNames(pattern),
 	}
 
+	root := r.trees[method]
+	if root == nil {
+		root = &node{}
+	}
+
+	// insert copies the nodes it changes, so a failed registration leaves
+	// no partial nodes behind in the live tree
+	tree, err := root.insert(pattern, false, route)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, pattern, err)
+	}
+
+	r.trees[method] = tree
 	r.routes = append(r.routes, route)
+	return nil
 }
 
 // NotFound sets custom 404 handler
@@ -63,186 +96,157 @@ func (r *Router) NotFound(handler Handler) {
 	r.notFound = handler
 }
 
-// MethodNotAllowed sets custom 405 handler
+// GlobalOPTIONS sets the handler for OPTIONS requests, including
+// "OPTIONS *", to paths without an OPTIONS route. The Allow header is
+// already set when it runs. The default replies 204 No Content; nil turns
+// automatic OPTIONS off, so those requests get a 405.
+func (r *Router) GlobalOPTIONS(handler Handler) {
+	r.globalOptions = handler
+}
+
+// MethodNotAllowed sets custom 405 handler. The Allow header is already
+// set when it runs.
 func (r *Router) MethodNotAllowed(handler Handler) {
 	r.methodNotAllowed = handler
 }
 
 // GET is a shortcut for Handle("GET", ...)
-func (r *Router) GET(pattern string, handler Handler) {
-	r.Handle("GET", pattern, handler)
+func (r *Router) GET(pattern string, handler Handler) error {
+	return r.Handle("GET", pattern, handler)
 }
 
 // POST is a shortcut for Handle("POST", ...)
-func (r *Router) POST(pattern string, handler Handler) {
-	r.Handle("POST", pattern, handler)
+func (r *Router) POST(pattern string, handler Handler) error {
+	return r.Handle("POST", pattern, handler)
 }
 
 // PUT is a shortcut for Handle("PUT", ...)
-func (r *Router) PUT(pattern string, handler Handler) {
-	r.Handle("PUT", pattern, handler)
+func (r *Router) PUT(pattern string, handler Handler) error {
+	return r.Handle("PUT", pattern, handler)
 }
 
 // DELETE is a shortcut for Handle("DELETE", ...)
-func (r *Router) DELETE(pattern string, handler Handler) {
-	r.Handle("DELETE", pattern, handler)
+func (r *Router) DELETE(pattern string, handler Handler) error {
+	return r.Handle("DELETE", pattern, handler)
 }
 
 // PATCH is a shortcut for Handle("PATCH", ...)
-func (r *Router) PATCH(pattern string, handler Handler) {
-	r.Handle("PATCH", pattern, handler)
+func (r *Router) PATCH(pattern string, handler Handler) error {
+	return r.Handle("PATCH", pattern, handler)
 }
 
 // HEAD is a shortcut for Handle("HEAD", ...)
-func (r *Router) HEAD(pattern string, handler Handler) {
-	r.Handle("HEAD", pattern, handler)
+func (r *Router) HEAD(pattern string, handler Handler) error {
+	return r.Handle("HEAD", pattern, handler)
 }
 
 // OPTIONS is a shortcut for Handle("OPTIONS", ...)
-func (r *Router) OPTIONS(pattern string, handler Handler) {
-	r.Handle("OPTIONS", pattern, handler)
+func (r *Router) OPTIONS(pattern string, handler Handler) error {
+	return r.Handle("OPTIONS", pattern, handler)
 }
 
-// Match finds a route that matches the given method and path
-func (r *Router) Match(method, path string) (*Route, map[string]string) {
-	// Remove query string if present
-	if idx := strings.Index(path, "?"); idx != -1 {
-		path = path[:idx]
-	}
-
-	// ✅ Issue #10: Priority order - static first, then params, then wildcards
-	var (
-		matchedRoute  *Route
-		matchedParams map[string]string
-	)
-
-	for _, route := range r.routes {
-		// Check method
-		if route.Method != method {
-			continue
-		}
-
-		// Try to match path
-		if params := matchPath(route, path); params != nil {
-			matchedRoute = route
-			matchedParams = params
+// Match finds a route that matches the given method and path. path is
+// the escaped request path without a query string (request.URL.RawPath);
+// parameter values are returned decoded.
+func (r *Router) Match(method, path string) (*Route, server.Params) {
+	var params server.Params
+	route := r.lookup(method, path, &params)
+	return route, params
+}
 
-			// If it's a static route, return immediately (highest priority)
-			if route.IsStatic {
-				return matchedRoute, matchedParams
-			}
-		}
+// lookup matches path in method's tree, appending captured parameters to
+// params. It does not allocate when params has spare capacity.
+func (r *Router) lookup(method, path string, params *server.Params) *Route {
+	root := r.trees[method]
+	if root == nil {
+		return nil
 	}
-
-	// Return best match (or nil if none)
-	return matchedRoute, matchedParams
+	return root.lookup(path, params)
 }
 
 // ✅ Issue #2: Concrete type, no type assertions!
 func (r *Router) ServeHTTP(ctx *server.Context) {
-	route, params := r.Match(ctx.Method(), ctx.Path())
+	// Match the escaped path so an encoded slash (%2F) stays inside its
+	// segment
+	path := ctx.URL().RawPath
+	params := ctx.Params[:0]
+	route := r.lookup(ctx.Method(), path, &params)
+
+	// HEAD falls back to GET; the response writer drops the body
+	if route == nil && ctx.Method() == "HEAD" {
+		params = params[:0]
+		route = r.lookup("GET", path, &params)
+	}
 
 	if route == nil {
-		// Check if path exists with different method
-		for _, rt := range r.routes {
-			if matchPath(rt, ctx.Path()) != nil {
-				r.methodNotAllowed(ctx)
-				return
-			}
+		// Check if path exists with different methods
+		allow := r.allowed(path, params)
+		switch {
+		case allow == "":
+			r.notFound(ctx)
+		case ctx.Method() == "OPTIONS" && r.globalOptions != nil:
+			ctx.Response.Headers().Set("Allow", allow)
+			r.globalOptions(ctx)
+		default:
+			ctx.Response.Headers().Set("Allow", allow)
+			r.methodNotAllowed(ctx)
 		}
-
-		r.notFound(ctx)
 		return
 	}
 
 	// ✅ Issue #2: Direct access, no type assertion needed
 	ctx.SetParams(params)
+	ctx.SetRoute(route.Pattern)
 	route.Handler(ctx)
 }
 
-// ✅ Issue #10: Enhanced pattern parsing with wildcards and regex
-func parsePattern(pattern string) (params []string, regex *regexp.Regexp, isStatic bool) {
-	isStatic = true
-	params = make([]string, 0)
-
-	// Convert pattern to regex
-	regexStr := "^"
-	parts := strings.Split(pattern, "/")
-
-	for _, part := range parts {
-		if part == "" {
-			continue
+// allowed lists the methods path can be requested with, for the Allow
+// header, or returns "" if no route matches it. "*" (OPTIONS *) matches
+// every route. GET routes also answer HEAD, and OPTIONS is answered
+// automatically unless turned off.
+func (r *Router) allowed(path string, params server.Params) string {
+	var methods []string
+	for method, root := range r.trees {
+		params = params[:0]
+		if path == "*" || root.lookup(path, &params) != nil {
+			methods = append(methods, method)
+			if method == "GET" {
+				methods = append(methods, "HEAD")
+			}
 		}
+	}
+	if len(methods) == 0 {
+		return ""
+	}
+	if r.globalOptions != nil {
+		methods = append(methods, "OPTIONS")
+	}
 
-		regexStr += "/"
-
-		if strings.HasPrefix(part, ":") {
-			// Parameter: :id or :id<regex>
-			isStatic = false
-
-			paramName := part[1:]
-			constraint := ""
-
-			// Check for constraint: :id<[0-9]+>
-			if idx := strings.Index(paramName, "<"); idx != -1 {
-				constraint = paramName[idx+1 : len(paramName)-1]
-				paramName = paramName[:idx]
-			}
+	slices.Sort(methods)
+	return strings.Join(slices.Compact(methods), ", ")
+}
 
-			params = append(params, paramName)
+// paramNames lists the parameter and wildcard names in pattern
+func paramNames(pattern string) []string {
+	params := make([]string, 0)
 
-			if constraint != "" {
-				regexStr += "(" + constraint + ")"
-			} else {
-				regexStr += "([^/]+)" // Match anything except /
+	for _, part := range strings.Split(pattern, "/") {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			name := part[1:]
+			if idx := strings.IndexByte(name, '<'); idx != -1 {
+				name = name[:idx]
 			}
-
-		} else if part == "*" || strings.HasPrefix(part, "*") {
-			// Wildcard: * or *filepath
-			isStatic = false
-
-			paramName := "wildcard"
+			params = append(params, name)
+		case strings.HasPrefix(part, "*"):
+			name := "wildcard"
 			if len(part) > 1 {
-				paramName = part[1:]
+				name = part[1:]
 			}
-
-			params = append(params, paramName)
-			regexStr += "(.*)" // Match everything
-
-		} else {
-			// Static part
-			regexStr += regexp.QuoteMeta(part)
+			params = append(params, name)
 		}
 	}
 
-	regexStr += "$"
-	regex = regexp.MustCompile(regexStr)
-
-	return params, regex, isStatic
-}
-
-// matchPath uses regex to match path and extract parameters
-func matchPath(route *Route, path string) map[string]string {
-	// Quick static match
-	if route.IsStatic {
-		if route.Pattern == path {
-			return make(map[string]string)
-		}
-		return nil
-	}
-
-	// Regex match
-	matches := route.Regex.FindStringSubmatch(path)
-	if matches == nil {
-		return nil
-	}
-
-	// Extract parameters
-	params := make(map[string]string)
-	for i, name := range route.Params {
-		params[name] = matches[i+1] // matches[0] is full match
-	}
-
 	return params
 }
 
@@ -268,7 +272,7 @@ func (g *Group) Use(mw server.Middleware) {
 }
 
 // Handle registers a route in the group
-func (g *Group) Handle(method, pattern string, handler Handler) {
+func (g *Group) Handle(method, pattern string, handler Handler) error {
 	fullPattern := g.prefix + pattern
 
 	// Wrap handler with group middlewares
@@ -277,28 +281,28 @@ func (g *Group) Handle(method, pattern string, handler Handler) {
 		finalHandler = wrapHandlerWithMiddleware(finalHandler, g.middlewares[i])
 	}
 
-	g.router.Handle(method, fullPattern, finalHandler)
+	return g.router.Handle(method, fullPattern, finalHandler)
 }
 
 // Convenience methods for groups
-func (g *Group) GET(pattern string, handler Handler) {
-	g.Handle("GET", pattern, handler)
+func (g *Group) GET(pattern string, handler Handler) error {
+	return g.Handle("GET", pattern, handler)
 }
 
-func (g *Group) POST(pattern string, handler Handler) {
-	g.Handle("POST", pattern, handler)
+func (g *Group) POST(pattern string, handler Handler) error {
+	return g.Handle("POST", pattern, handler)
 }
 
-func (g *Group) PUT(pattern string, handler Handler) {
-	g.Handle("PUT", pattern, handler)
+func (g *Group) PUT(pattern string, handler Handler) error {
+	return g.Handle("PUT", pattern, handler)
 }
 
-func (g *Group) DELETE(pattern string, handler Handler) {
-	g.Handle("DELETE", pattern, handler)
+func (g *Group) DELETE(pattern string, handler Handler) error {
+	return g.Handle("DELETE", pattern, handler)
 }
 
-func (g *Group) PATCH(pattern string, handler Handler) {
-	g.Handle("PATCH", pattern, handler)
+func (g *Group) PATCH(pattern string, handler Handler) error {
+	return g.Handle("PATCH", pattern, handler)
 }
 
 // wrapHandlerWithMiddleware wraps a router handler with server middleware
@@ -0,0 +1,271 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+func noop(ctx *server.Context) {}
+
+func mustRouter(t *testing.T, routes ...string) *Router {
+	t.Helper()
+	r := New()
+	for _, pattern := range routes {
+		require.NoError(t, r.GET(pattern, noop), pattern)
+	}
+	return r
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	r := mustRouter(t,
+		"/",
+		"/users",
+		"/users/new",
+		"/users/:id",
+		"/users/:id/posts",
+		"/users/:name<[a-z]+>/profile",
+		"/users/:id/posts/:post",
+		"/files/*filepath",
+		"/files/readme",
+		"/api/:version<v[0-9]+>/status",
+		"/api/:section<[a-z]+>/status",
+	)
+
+	tests := []struct {
+		path    string
+		pattern string
+		params  server.Params
+	}{
+		{"/", "/", nil},
+		{"/users", "/users", nil},
+		{"/users/new", "/users/new", nil},
+		{"/users/42", "/users/:id", server.Params{{Key: "id", Value: "42"}}},
+		{"/users/42/posts", "/users/:id/posts", server.Params{{Key: "id", Value: "42"}}},
+		{"/users/a%2Fb/posts", "/users/:id/posts", server.Params{{Key: "id", Value: "a/b"}}},
+		// The unconstrained param is tried first but has no /profile child
+		{"/users/bob/profile", "/users/:name<[a-z]+>/profile", server.Params{{Key: "name", Value: "bob"}}},
+		{"/users/1/posts/9", "/users/:id/posts/:post", server.Params{{Key: "id", Value: "1"}, {Key: "post", Value: "9"}}},
+		{"/files/readme", "/files/readme", nil},
+		{"/files/css/site.css", "/files/*filepath", server.Params{{Key: "filepath", Value: "css/site.css"}}},
+		{"/files/my%20notes.txt", "/files/*filepath", server.Params{{Key: "filepath", Value: "my notes.txt"}}},
+		{"/files/", "/files/*filepath", server.Params{{Key: "filepath", Value: ""}}},
+		{"/api/v2/status", "/api/:version<v[0-9]+>/status", server.Params{{Key: "version", Value: "v2"}}},
+		{"/api/admin/status", "/api/:section<[a-z]+>/status", server.Params{{Key: "section", Value: "admin"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			route, params := r.Match("GET", tt.path)
+			require.NotNil(t, route)
+			assert.Equal(t, tt.pattern, route.Pattern)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+
+	for _, path := range []string{"/user", "/users/", "/users/1/2", "/files", "/api/V2/status", "/nope"} {
+		route, _ := r.Match("GET", path)
+		assert.Nil(t, route, path)
+	}
+
+	route, _ := r.Match("POST", "/users")
+	assert.Nil(t, route)
+}
+
+func TestParamBeforeConstrainedParam(t *testing.T) {
+	// Registration order must not matter
+	for _, routes := range [][]string{
+		{"/items/:name", "/items/:id<[0-9]+>", "/items/:id<[0-9]+>/stock"},
+		{"/items/:id<[0-9]+>/stock", "/items/:id<[0-9]+>", "/items/:name"},
+	} {
+		r := mustRouter(t, routes...)
+
+		// Both params match a whole path; the plain one wins
+		route, params := r.Match("GET", "/items/42")
+		require.NotNil(t, route)
+		assert.Equal(t, "/items/:name", route.Pattern)
+		assert.Equal(t, server.Params{{Key: "name", Value: "42"}}, params)
+
+		// Only the constrained one has the rest of the path
+		route, params = r.Match("GET", "/items/42/stock")
+		require.NotNil(t, route)
+		assert.Equal(t, "/items/:id<[0-9]+>/stock", route.Pattern)
+		assert.Equal(t, server.Params{{Key: "id", Value: "42"}}, params)
+	}
+}
+
+func TestRegistrationConflicts(t *testing.T) {
+	tests := []struct {
+		existing string
+		pattern  string
+		err      error
+	}{
+		{"/users/:id", "/users/:id", ErrRouteConflict},
+		{"/users/:id", "/users/:name", ErrRouteConflict},
+		{"/users/:id/posts", "/users/:uid/comments", ErrRouteConflict},
+		{"/users/:id<[0-9]+>", "/users/:num<[0-9]+>", ErrRouteConflict},
+		{"/static/*filepath", "/static/*path", ErrRouteConflict},
+		{"/static/*filepath", "/static/*filepath", ErrRouteConflict},
+		{"/", "/static/*filepath/more", ErrInvalidPattern},
+		{"/", "users", ErrInvalidPattern},
+		{"/", "/users/:id<[0-9+>", ErrInvalidPattern},
+		{"/", "/users/:id<(>", ErrInvalidPattern},
+		{"/", "/users/:", ErrInvalidPattern},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			r := mustRouter(t, tt.existing)
+			assert.ErrorIs(t, r.GET(tt.pattern, noop), tt.err)
+			assert.Len(t, r.routes, 1)
+		})
+	}
+
+	// Different methods and constraints can share a position
+	r := mustRouter(t, "/users/:id", "/users/:id<[0-9]+>", "/users/:slug<[a-z-]+>")
+	assert.NoError(t, r.POST("/users/:id", noop))
+
+	// A failed registration leaves no trace behind
+	r = mustRouter(t, "/a/:id")
+	require.Error(t, r.GET("/a/:other/b", noop))
+	assert.NoError(t, r.GET("/a/:id/b", noop))
+
+	// Including prefix splits made on the way to the error
+	r = mustRouter(t, "/users/:id")
+	require.Error(t, r.GET("/uploads/:file<(>", noop))
+	route, _ := r.Match("GET", "/users/1")
+	require.NotNil(t, route)
+	assert.Equal(t, "/users/:id", route.Pattern)
+	assert.Len(t, r.trees["GET"].static, 1)
+	assert.Equal(t, "/users/", r.trees["GET"].static[0].prefix)
+}
+
+// serve runs a request through r and returns the raw response
+func serve(r *Router, method, path string) string {
+	req := &request.Request{Method: method, Path: path, Headers: headers.NewHeaders(), Body: request.NoBody}
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.SetMethod(method)
+	r.ServeHTTP(server.NewContext(req, w, nil))
+	w.Finish()
+	return buf.String()
+}
+
+func TestHeadFallsBackToGet(t *testing.T) {
+	r := New()
+	require.NoError(t, r.GET("/users/:id", func(ctx *server.Context) {
+		ctx.Text(response.StatusOK, "user "+ctx.Param("id"))
+	}))
+	require.NoError(t, r.POST("/users", noop))
+	require.NoError(t, r.GET("/status", noop))
+	require.NoError(t, r.HEAD("/status", func(ctx *server.Context) {
+		ctx.Response.Headers().Set("X-Head", "1")
+	}))
+
+	get := serve(r, "GET", "/users/42")
+	head := serve(r, "HEAD", "/users/42")
+	assert.True(t, strings.HasSuffix(get, "\r\n\r\nuser 42"))
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "content-length: 7\r\n")
+	assert.True(t, strings.HasSuffix(head, "\r\n\r\n"))
+
+	// An explicit HEAD route wins, and other methods still get 405
+	assert.Contains(t, serve(r, "HEAD", "/status"), "x-head: 1\r\n")
+	assert.True(t, strings.HasPrefix(serve(r, "HEAD", "/users"), "HTTP/1.1 405 "))
+	assert.True(t, strings.HasPrefix(serve(r, "HEAD", "/nope"), "HTTP/1.1 404 "))
+}
+
+func TestAllowAndOptions(t *testing.T) {
+	r := New()
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		require.NoError(t, r.Handle(method, "/users/:id", noop))
+	}
+	require.NoError(t, r.POST("/users", noop))
+	require.NoError(t, r.OPTIONS("/custom", func(ctx *server.Context) {
+		ctx.Text(response.StatusOK, "explicit")
+	}))
+
+	res := serve(r, "POST", "/users/1")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 "))
+	assert.Contains(t, res, "allow: DELETE, GET, HEAD, OPTIONS, PUT\r\n")
+
+	res = serve(r, "OPTIONS", "/users/1")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 204 "))
+	assert.Contains(t, res, "allow: DELETE, GET, HEAD, OPTIONS, PUT\r\n")
+
+	// OPTIONS * asks about the server as a whole
+	res = serve(r, "OPTIONS", "*")
+	assert.Contains(t, res, "allow: DELETE, GET, HEAD, OPTIONS, POST, PUT\r\n")
+
+	assert.True(t, strings.HasPrefix(serve(r, "OPTIONS", "/nope"), "HTTP/1.1 404 "))
+	assert.True(t, strings.HasSuffix(serve(r, "OPTIONS", "/custom"), "explicit"))
+
+	// The global handler can be replaced or turned off
+	r.GlobalOPTIONS(func(ctx *server.Context) {
+		allow, _ := ctx.Response.Headers().Get("Allow")
+		ctx.Text(response.StatusOK, allow)
+	})
+	assert.True(t, strings.HasSuffix(serve(r, "OPTIONS", "/users"), "\r\n\r\nOPTIONS, POST"))
+
+	r.GlobalOPTIONS(nil)
+	res = serve(r, "OPTIONS", "/users")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 "))
+	assert.Contains(t, res, "allow: POST\r\n")
+}
+
+func TestMatchDoesNotAllocate(t *testing.T) {
+	r := New()
+	for i := 0; i < 200; i++ {
+		require.NoError(t, r.GET(fmt.Sprintf("/resource%d/:id/items/:item", i), noop))
+	}
+	require.NoError(t, r.GET("/files/*filepath", noop))
+
+	params := make(server.Params, 0, 4)
+	allocs := testing.AllocsPerRun(100, func() {
+		params = params[:0]
+		if r.lookup("GET", "/resource150/7/items/3", &params) == nil {
+			t.Fatal("no match")
+		}
+		params = params[:0]
+		r.lookup("GET", "/files/a/b/c.txt", &params)
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkMatch(b *testing.B) {
+	r := New()
+	for i := 0; i < 500; i++ {
+		r.GET(fmt.Sprintf("/resource%d/:id/items/:item", i), noop)
+	}
+
+	params := make(server.Params, 0, 4)
+	b.ReportAllocs()
+	for b.Loop() {
+		params = params[:0]
+		r.lookup("GET", "/resource499/7/items/3", &params)
+	}
+}
+
+func BenchmarkHandle(b *testing.B) {
+	patterns := make([]string, 1000)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("/resource%d/:id/items/:item", i)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		r := New()
+		for _, pattern := range patterns {
+			r.GET(pattern, noop)
+		}
+	}
+}
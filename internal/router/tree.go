@@ -0,0 +1,276 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// node is a vertex in a per-method radix tree. Static text is stored as
+// compressed prefixes that may span several path segments; parameters and
+// wildcards always take a whole segment and hang off the node before them.
+//
+// Lookup precedence at each node: static > param > constrained param >
+// wildcard. A lower-precedence branch is only tried when the ones before it
+// fail to match the rest of the path.
+type node struct {
+	prefix string  // Static text matched by this node
+	static []*node // Static children, each starting with a different byte
+
+	param       *node   // :name
+	constrained []*node // :name<regex>, tried in registration order
+	wildcard    *node   // *name, always a leaf
+
+	name  string         // Parameter or wildcard name
+	regex *regexp.Regexp // Constraint for constrained params
+	route *Route         // Route ending at this node, if any
+}
+
+// insert returns a copy of n with route added for the unmatched remainder
+// of its pattern. Only the nodes along the pattern's path are copied; the
+// rest are shared, and n itself is left untouched, so a failed insert has
+// nothing to undo. segStart reports whether rest begins a new path segment.
+func (n *node) insert(rest string, segStart bool, route *Route) (*node, error) {
+	c := *n
+	if rest == "" {
+		if n.route != nil {
+			return nil, fmt.Errorf("%w: duplicate of %s", ErrRouteConflict, n.route.Pattern)
+		}
+		c.route = route
+		return &c, nil
+	}
+
+	if segStart && rest[0] == '*' {
+		name := rest[1:]
+		if strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%w: wildcard must be the last segment", ErrInvalidPattern)
+		}
+		if name == "" {
+			name = "wildcard"
+		}
+		if n.wildcard != nil {
+			if n.wildcard.name != name {
+				return nil, fmt.Errorf("%w: *%s conflicts with *%s in %s", ErrRouteConflict, name, n.wildcard.name, n.wildcard.route.Pattern)
+			}
+			return nil, fmt.Errorf("%w: duplicate of %s", ErrRouteConflict, n.wildcard.route.Pattern)
+		}
+		c.wildcard = &node{name: name, route: route}
+		return &c, nil
+	}
+
+	if segStart && rest[0] == ':' {
+		segment, tail := rest[1:], ""
+		if idx := strings.IndexByte(segment, '/'); idx != -1 {
+			segment, tail = segment[:idx], segment[idx:]
+		}
+		child, err := n.paramChild(segment)
+		if err != nil {
+			return nil, err
+		}
+		if child, err = child.insert(tail, false, route); err != nil {
+			return nil, err
+		}
+		if child.regex == nil {
+			c.param = child
+		} else {
+			c.constrained = replaceChild(n.constrained, child, func(old *node) bool { return old.regex == child.regex })
+		}
+		return &c, nil
+	}
+
+	end := staticEnd(rest)
+	for _, child := range n.static {
+		if child.prefix[0] != rest[0] {
+			continue
+		}
+		first := child.prefix[0]
+		common := commonPrefix(child.prefix, rest[:end])
+		if common < len(child.prefix) {
+			child = child.split(common)
+		}
+		child, err := child.insert(rest[common:], rest[common-1] == '/', route)
+		if err != nil {
+			return nil, err
+		}
+		c.static = replaceChild(n.static, child, func(old *node) bool { return old.prefix[0] == first })
+		return &c, nil
+	}
+
+	child, err := (&node{prefix: rest[:end]}).insert(rest[end:], rest[end-1] == '/', route)
+	if err != nil {
+		return nil, err
+	}
+	c.static = replaceChild(n.static, child, func(*node) bool { return false })
+	return &c, nil
+}
+
+// paramChild finds the child for a ":name" or ":name<regex>" segment, or
+// returns a new one that isn't attached to n yet. Two params at the same
+// position must agree on their name, or one of them could never be reached
+// under the name its handler expects.
+func (n *node) paramChild(segment string) (*node, error) {
+	name, constraint := segment, ""
+	if idx := strings.IndexByte(segment, '<'); idx != -1 {
+		if !strings.HasSuffix(segment, ">") {
+			return nil, fmt.Errorf("%w: unterminated constraint in :%s", ErrInvalidPattern, segment)
+		}
+		name, constraint = segment[:idx], segment[idx+1:len(segment)-1]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w: parameter without a name", ErrInvalidPattern)
+	}
+
+	if constraint == "" {
+		if n.param == nil {
+			return &node{name: name}, nil
+		}
+		if n.param.name != name {
+			return nil, fmt.Errorf("%w: :%s conflicts with :%s", ErrRouteConflict, name, n.param.name)
+		}
+		return n.param, nil
+	}
+
+	for _, child := range n.constrained {
+		if child.regex.String() != anchor(constraint) {
+			continue
+		}
+		if child.name != name {
+			return nil, fmt.Errorf("%w: :%s<%s> conflicts with :%s<%s>", ErrRouteConflict, name, constraint, child.name, constraint)
+		}
+		return child, nil
+	}
+
+	regex, err := regexp.Compile(anchor(constraint))
+	if err != nil {
+		return nil, fmt.Errorf("%w: constraint for :%s: %v", ErrInvalidPattern, name, err)
+	}
+	return &node{name: name, regex: regex}, nil
+}
+
+// split cuts n's prefix at i, returning a new parent that holds the shared
+// part and has a shortened copy of n as its only static child
+func (n *node) split(i int) *node {
+	child := *n
+	child.prefix = n.prefix[i:]
+	return &node{prefix: n.prefix[:i], static: []*node{&child}}
+}
+
+// replaceChild returns a copy of nodes with the first node that is(old)
+// replaced by child, or with child appended if there is none. The original
+// slice may still be in use by a live tree, so it is never written to.
+func replaceChild(nodes []*node, child *node, is func(old *node) bool) []*node {
+	out := make([]*node, len(nodes), len(nodes)+1)
+	copy(out, nodes)
+	for i, old := range out {
+		if is(old) {
+			out[i] = child
+			return out
+		}
+	}
+	return append(out, child)
+}
+
+// lookup matches the remainder of the escaped path below n, appending
+// captured parameters to params. Values are substrings of path unless
+// they need decoding, so a lookup with enough spare capacity in params
+// does not allocate.
+func (n *node) lookup(path string, params *server.Params) *Route {
+	if path == "" {
+		if n.route != nil {
+			return n.route
+		}
+		if n.wildcard != nil {
+			*params = append(*params, server.Param{Key: n.wildcard.name})
+			return n.wildcard.route
+		}
+		return nil
+	}
+
+	for _, child := range n.static {
+		if child.prefix[0] != path[0] {
+			continue
+		}
+		if strings.HasPrefix(path, child.prefix) {
+			if route := child.lookup(path[len(child.prefix):], params); route != nil {
+				return route
+			}
+		}
+		break
+	}
+
+	if n.param != nil || len(n.constrained) > 0 {
+		segment := path
+		if idx := strings.IndexByte(path, '/'); idx != -1 {
+			segment = path[:idx]
+		}
+
+		if segment != "" {
+			mark := len(*params)
+			value := unescape(segment)
+			if n.param != nil {
+				*params = append(*params, server.Param{Key: n.param.name, Value: value})
+				if route := n.param.lookup(path[len(segment):], params); route != nil {
+					return route
+				}
+				*params = (*params)[:mark]
+			}
+
+			for _, child := range n.constrained {
+				if !child.regex.MatchString(value) {
+					continue
+				}
+				*params = append(*params, server.Param{Key: child.name, Value: value})
+				if route := child.lookup(path[len(segment):], params); route != nil {
+					return route
+				}
+				*params = (*params)[:mark]
+			}
+		}
+	}
+
+	if n.wildcard != nil {
+		*params = append(*params, server.Param{Key: n.wildcard.name, Value: unescape(path)})
+		return n.wildcard.route
+	}
+	return nil
+}
+
+// staticEnd returns the length of the static text at the start of pattern,
+// which runs until a ':' or '*' that begins a segment
+func staticEnd(pattern string) int {
+	for i := 1; i < len(pattern); i++ {
+		if (pattern[i] == ':' || pattern[i] == '*') && pattern[i-1] == '/' {
+			return i
+		}
+	}
+	return len(pattern)
+}
+
+// commonPrefix returns the length of the longest common prefix of a and b
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// unescape decodes a parameter value. Paths reaching the router were
+// validated by the request parser, so a bad escape is left as is.
+func unescape(s string) string {
+	if strings.IndexByte(s, '%') == -1 {
+		return s
+	}
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// anchor makes a constraint match a whole segment
+func anchor(constraint string) string {
+	return "^(?:" + constraint + ")$"
+}
@@ -68,28 +68,3 @@ func PutBuffer(buf []byte) {
 	}
 	// Else: buffer is non-standard size, let GC handle it
 }
-
-// BufferedReader can be used in parser.go to reuse buffers
-type BufferedReader struct {
-	buf []byte
-}
-
-// NewBufferedReader creates a new buffered reader with pooled buffer
-func NewBufferedReader() *BufferedReader {
-	return &BufferedReader{
-		buf: GetBuffer(4096),
-	}
-}
-
-// Close returns the buffer to the pool
-func (br *BufferedReader) Close() {
-	if br.buf != nil {
-		PutBuffer(br.buf)
-		br.buf = nil
-	}
-}
-
-// Buffer returns the internal buffer
-func (br *BufferedReader) Buffer() []byte {
-	return br.buf
-}
\ No newline at end of file
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// ✅ Issue #11: Response compression
+
+// CompressionConfig configures CompressionMiddleware
+type CompressionConfig struct {
+	Level        int      // gzip/zlib level (gzip.DefaultCompression if 0)
+	MinSize      int      // Bodies with a known length below this are sent as-is
+	ExcludeTypes []string // Content types (or "type/" prefixes) never compressed
+}
+
+// DefaultCompressionConfig skips bodies under 1KB and formats that are
+// already compressed
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Level:   gzip.DefaultCompression,
+		MinSize: 1024,
+		ExcludeTypes: []string{
+			"image/", "video/", "audio/",
+			"application/zip", "application/gzip", "application/x-gzip",
+			"application/x-bzip2", "application/x-7z-compressed",
+			"application/x-rar-compressed", "application/zstd",
+			"application/octet-stream", "font/woff", "font/woff2",
+		},
+	}
+}
+
+// compressor is the common surface of gzip.Writer and zlib.Writer
+type compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(w io.Writer)
+}
+
+// CompressionMiddleware compresses response bodies with gzip or deflate,
+// negotiated from Accept-Encoding q-values
+func CompressionMiddleware(config CompressionConfig) Middleware {
+	level := config.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, level)
+			return w
+		}},
+		"deflate": {New: func() interface{} {
+			w, _ := zlib.NewWriterLevel(nil, level)
+			return w
+		}},
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) {
+			filter := &compressFilter{
+				encoding: negotiateEncoding(ctx.Header("Accept-Encoding")),
+				config:   &config,
+				pools:    pools,
+			}
+
+			if err := ctx.Response.SetBodyFilter(filter); err != nil {
+				// Response already started by an outer middleware
+				next.ServeHTTP(ctx)
+				return
+			}
+
+			next.ServeHTTP(ctx)
+
+			if !ctx.IsHijacked() {
+				ctx.Response.Finish()
+			}
+		})
+	}
+}
+
+// compressFilter implements response.BodyFilter
+type compressFilter struct {
+	encoding string // "" when the client accepts neither gzip nor deflate
+	config   *CompressionConfig
+	pools    map[string]*sync.Pool
+
+	w   compressor
+	buf bytes.Buffer
+}
+
+// Start decides whether this response is worth compressing
+func (f *compressFilter) Start(code response.StatusCode, h *headers.Headers) bool {
+	if code < 200 || code == response.StatusNoContent || code == response.StatusNotModified {
+		return false
+	}
+
+	if _, ok := h.Get("content-encoding"); ok {
+		return false
+	}
+	contentType, _ := h.Get("content-type")
+	if f.isExcluded(contentType) {
+		return false
+	}
+
+	// Responses we could compress vary on Accept-Encoding, even when this
+	// particular client gets identity
+	addVary(h, "Accept-Encoding")
+
+	if f.encoding == "" {
+		return false
+	}
+	// Content-Range counts bytes of the identity body, so a range can't be
+	// re-encoded without breaking it
+	if code == response.StatusPartialContent || h.Has("content-range") {
+		return false
+	}
+	if cl, ok := h.Get("content-length"); ok {
+		if n, err := strconv.Atoi(cl); err == nil && n < f.config.MinSize {
+			return false
+		}
+	}
+
+	h.Set("Content-Encoding", f.encoding)
+
+	// The encoded bytes differ, so a strong validator no longer applies
+	if etag, ok := h.Get("etag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	f.w = f.pools[f.encoding].Get().(compressor)
+	f.w.Reset(&f.buf)
+	return true
+}
+
+// Encode compresses a body segment
+func (f *compressFilter) Encode(p []byte) ([]byte, error) {
+	if _, err := f.w.Write(p); err != nil {
+		return nil, err
+	}
+	return f.take(), nil
+}
+
+// Flush forces out compressed data for streaming responses
+func (f *compressFilter) Flush() ([]byte, error) {
+	if err := f.w.Flush(); err != nil {
+		return nil, err
+	}
+	return f.take(), nil
+}
+
+// Finish writes the stream trailer and returns the compressor to its pool
+func (f *compressFilter) Finish() ([]byte, error) {
+	err := f.w.Close()
+	out := f.take()
+
+	f.w.Reset(io.Discard)
+	f.pools[f.encoding].Put(f.w)
+	f.w = nil
+
+	return out, err
+}
+
+// take returns and clears the compressed output gathered so far
+func (f *compressFilter) take() []byte {
+	if f.buf.Len() == 0 {
+		return nil
+	}
+	out := bytes.Clone(f.buf.Bytes())
+	f.buf.Reset()
+	return out
+}
+
+// isExcluded reports whether a content type is already compressed
+func (f *compressFilter) isExcluded(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	// SVG is text and compresses well despite the image/ prefix
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+
+	for _, excluded := range f.config.ExcludeTypes {
+		if strings.HasSuffix(excluded, "/") {
+			if strings.HasPrefix(mediaType, excluded) {
+				return true
+			}
+		} else if mediaType == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding (RFC 9110
+// section 12.5.3). Ties prefer gzip; "" means identity.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qvalues := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		qvalues[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qvalues[coding]
+		if !ok {
+			// "*" covers codings not listed explicitly
+			q, ok = qvalues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// addVary appends a field name to Vary unless it is already listed
+func addVary(h *headers.Headers, field string) {
+	for _, value := range h.GetAll("vary") {
+		for _, existing := range strings.Split(value, ",") {
+			existing = strings.TrimSpace(existing)
+			if existing == "*" || strings.EqualFold(existing, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
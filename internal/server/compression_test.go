@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+var compressibleBody = strings.Repeat("Hello, compression! ", 200)
+
+// compressServe runs handler behind CompressionMiddleware and parses the
+// response, leaving the body encoded
+func compressServe(t *testing.T, config CompressionConfig, acceptEncoding string, handler HandlerFunc) (*http.Response, string) {
+	t.Helper()
+	var header []string
+	if acceptEncoding != "" {
+		header = []string{"Accept-Encoding", acceptEncoding}
+	}
+	ctx, out := newTestContext("GET", "/", "", header...)
+	CompressionMiddleware(config)(handler).ServeHTTP(ctx)
+
+	resp, err := http.ReadResponse(bufio.NewReader(out), &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+// sendBody writes body with the given content type
+func sendBody(contentType, body string) HandlerFunc {
+	return func(ctx *Context) {
+		ctx.Response.Headers().Set("Content-Type", contentType)
+		ctx.Write([]byte(body))
+	}
+}
+
+func decode(t *testing.T, encoding, body string) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(strings.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(strings.NewReader(body))
+	default:
+		return body
+	}
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                           "",
+		"gzip":                       "gzip",
+		"GZip":                       "gzip",
+		"deflate":                    "deflate",
+		"deflate, gzip":              "gzip", // Ties prefer gzip
+		"gzip;q=0.5, deflate;q=0.8":  "deflate",
+		"gzip;q=0, deflate":          "deflate",
+		"gzip; q=0":                  "",
+		"*":                          "gzip",
+		"*;q=0":                      "",
+		"gzip;q=0, *":                "deflate",
+		"identity":                   "",
+		"br, zstd":                   "",
+		"gzip;q=0.001":               "gzip",
+		"gzip;q=bogus, deflate;q=.5": "gzip", // Unparseable q counts as 1
+	}
+	for acceptEncoding, want := range tests {
+		assert.Equal(t, want, negotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			resp, body := compressServe(t, DefaultCompressionConfig(), encoding, sendBody("text/plain", compressibleBody))
+			assert.Equal(t, 200, resp.StatusCode)
+			assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			assert.Less(t, len(body), len(compressibleBody))
+			assert.Equal(t, compressibleBody, decode(t, encoding, body))
+		})
+	}
+}
+
+func TestCompressionIdentity(t *testing.T) {
+	for _, acceptEncoding := range []string{"", "gzip;q=0", "identity", "br"} {
+		t.Run(acceptEncoding, func(t *testing.T) {
+			resp, body := compressServe(t, DefaultCompressionConfig(), acceptEncoding, sendBody("text/plain", compressibleBody))
+			assert.Empty(t, resp.Header.Get("Content-Encoding"))
+			// Another client could get a compressed copy
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			assert.Equal(t, compressibleBody, body)
+		})
+	}
+}
+
+func TestCompressionSkips(t *testing.T) {
+	config := DefaultCompressionConfig()
+	config.MinSize = 100
+
+	tests := []struct {
+		name    string
+		handler HandlerFunc
+		vary    bool
+	}{
+		{"below MinSize", sendBody("text/plain", strings.Repeat("x", 99)), true},
+		{"excluded prefix", sendBody("image/png", compressibleBody), false},
+		{"excluded type", sendBody("application/zip", compressibleBody), false},
+		{"excluded type with parameters", sendBody("Application/Octet-Stream; foo=bar", compressibleBody), false},
+		{"already encoded", func(ctx *Context) {
+			ctx.Response.Headers().Set("Content-Encoding", "br")
+			ctx.Write([]byte(compressibleBody))
+		}, false},
+		{"no content", func(ctx *Context) { ctx.NoContent() }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := compressServe(t, config, "gzip", tt.handler)
+			assert.NotEqual(t, "gzip", resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, tt.vary, resp.Header.Get("Vary") == "Accept-Encoding")
+		})
+	}
+
+	// SVG is text despite the image/ prefix
+	resp, body := compressServe(t, config, "gzip", sendBody("image/svg+xml", compressibleBody))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, compressibleBody, decode(t, "gzip", body))
+}
+
+func TestCompressionSkipsRanges(t *testing.T) {
+	part := compressibleBody[:1500]
+	writeRange := func(code response.StatusCode) HandlerFunc {
+		return func(ctx *Context) {
+			h := headers.NewHeaders()
+			h.Set("Content-Type", "text/plain")
+			h.Set("Content-Range", "bytes 0-1499/4000")
+			h.Set("Content-Length", "1500")
+			ctx.Response.WriteStatusLine(code)
+			ctx.Response.WriteHeaders(h)
+			ctx.Response.WriteBody([]byte(part))
+		}
+	}
+
+	for _, code := range []response.StatusCode{response.StatusPartialContent, response.StatusOK} {
+		resp, body := compressServe(t, DefaultCompressionConfig(), "gzip", writeRange(code))
+		assert.Equal(t, int(code), resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "bytes 0-1499/4000", resp.Header.Get("Content-Range"))
+		assert.Equal(t, part, body)
+	}
+}
+
+func TestCompressionWeakensETag(t *testing.T) {
+	resp, _ := compressServe(t, DefaultCompressionConfig(), "gzip", func(ctx *Context) {
+		ctx.Response.Headers().Set("ETag", `"v1"`)
+		sendBody("text/html", compressibleBody)(ctx)
+	})
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+}
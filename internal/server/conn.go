@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/Brownie44l1/http-1/internal/request"
@@ -9,10 +11,32 @@ import (
 	net "github.com/Brownie44l1/socket-wrapper"
 )
 
+// maxDrainBytes caps how much unread request body the server will discard
+// to keep a connection alive. Larger leftovers close the connection instead.
+const maxDrainBytes = 256 << 10
+
 // handleConnection processes a single TCP connection
-func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *Metrics, logger Logger, shuttingDown bool) {
+// baseCtx is the server context; request contexts derive from it so
+// Shutdown cancels in-flight handlers.
+func handleConnection(baseCtx context.Context, conn net.Conn, handler Handler, config *Config, metrics *Metrics, logger Logger, shuttingDown bool) {
 	defer conn.Close()
 
+	// Reads go through cr so we can notice the client disconnecting
+	// mid-handler, and through br, which buffers them for the parser and
+	// keeps bytes of pipelined requests read along with an earlier one
+	st := connStatePool.Get().(*connState)
+	defer st.release()
+	cr, br := &st.cr, &st.reader
+	cr.reset(conn)
+	br.Reset(cr)
+
+	writerConfig := response.WriterConfig{
+		BufferSize: config.ResponseBufferSize,
+		Date:       true,
+		Server:     config.ServerName,
+	}
+	st.writer.SetConfig(writerConfig)
+
 	// ✅ Issue #4: Set initial read deadline BEFORE parsing
 	if config.ReadTimeout > 0 {
 		if err := conn.SetReadDeadline(time.Now().Add(config.ReadTimeout)); err != nil {
@@ -24,10 +48,19 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 	// Process requests in a loop (for keep-alive)
 	requestCount := 0
 	maxRequestsPerConn := 1000 // Prevent infinite keep-alive
+	pipelined := 0             // Consecutive requests that arrived before the previous response
 
 	for requestCount < maxRequestsPerConn {
 		requestCount++
 
+		// Requests are handled one at a time, so responses go out in the
+		// order the requests came in
+		if br.Buffered() > 0 {
+			pipelined++
+		} else {
+			pipelined = 0
+		}
+
 		// ✅ Issue #4: Reset deadline before each request
 		if config.ReadTimeout > 0 {
 			if err := conn.SetReadDeadline(time.Now().Add(config.ReadTimeout)); err != nil {
@@ -37,7 +70,8 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 		}
 
 		// ✅ Issue #3: Pass config for size limits
-		req, err := request.RequestFromReaderWithConfig(conn, config.MaxHeaderBytes, config.MaxRequestBodySize)
+		req := &st.req
+		err := br.ReadRequest(req, config.MaxHeaderBytes, config.MaxRequestBodySize)
 		if err != nil {
 			// EOF and connection closed errors are normal for keep-alive
 			if err == io.EOF {
@@ -56,7 +90,7 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 				err == request.ErrBodyTooLarge ||
 				err == request.ErrRequestLineTooLarge {
 				// Send 413 or 400 response
-				w := response.NewWriter(conn)
+				w := response.NewWriterWithConfig(conn, writerConfig)
 				w.ErrorResponse(response.StatusRequestEntityTooLarge, "Request too large")
 				return
 			}
@@ -67,7 +101,7 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 				Field{"request_count", requestCount},
 			)
 
-			w := response.NewWriter(conn)
+			w := response.NewWriterWithConfig(conn, writerConfig)
 			if err := w.ErrorResponse(response.StatusBadRequest, "Invalid request"); err != nil {
 				logger.Debug("failed to send error response", Field{"error", err})
 			}
@@ -75,28 +109,54 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 		}
 
 		// ✅ Issue #11: Handle Expect: 100-continue
-		if expectHeader, ok := req.Headers.Get("expect"); ok {
-			if expectHeader == "100-continue" {
-				w := response.NewWriter(conn)
-				if err := w.ContinueResponse(); err != nil {
-					logger.Error("failed to send 100-continue", Field{"error", err})
-					return
-				}
-				w.Flush()
+		if req.Headers.Is("expect", "100-continue") {
+			w := response.NewWriter(conn)
+			if err := w.ContinueResponse(); err != nil {
+				logger.Error("failed to send 100-continue", Field{"error", err})
+				return
 			}
+			w.Flush()
 		}
 
 		// Create response writer
-		w := response.NewWriter(conn)
+		w := &st.writer
+		w.Reset(conn)
+		w.SetMethod(req.Method)
+		if req.IsHTTP10() {
+			w.SetHTTP10()
+		}
 
 		// ✅ Issue #6: Create context with connection for hijacking
-		ctx := NewContext(req, w, conn)
+		ctx := &st.ctx
+		ctx.reset(req, w, conn)
+		ctx.config = config
+
+		// Cancelled when the handler returns, the client goes away or the
+		// server shuts down. It is only created if the handler asks for it.
+		st.reqCtx.reset(baseCtx)
+		ctx.ctx = nil
+		ctx.reqCtx = &st.reqCtx
+		ctx.abortRead = st.abortRead
+
+		// Watch for disconnects once the body is consumed
+		if req.Body == request.NoBody {
+			cr.startBackgroundRead(st.cancel)
+		} else {
+			st.body = bodyEOFSignal{ReadCloser: req.Body, onEOF: st.watch}
+			req.Body = &st.body
+		}
 
 		// ✅ Issue #18: Add Connection: close header if shutting down
 		if shuttingDown {
 			w.Headers().Set("Connection", "close")
 		}
 
+		// A client that keeps pipelining could hold the connection
+		// indefinitely; close it and let it retry the rest
+		if config.MaxPipelineDepth > 0 && pipelined >= config.MaxPipelineDepth {
+			w.Headers().Set("Connection", "close")
+		}
+
 		// ✅ Issue #4: Set write deadline
 		if config.WriteTimeout > 0 {
 			if err := conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout)); err != nil {
@@ -107,12 +167,34 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 
 		// Call the handler
 		start := time.Now()
+		if metrics != nil {
+			metrics.RequestStarted(req.Method)
+		}
 		handler.ServeHTTP(ctx)
+		cr.abortPendingRead()
+		st.cancel()
+		ctx.removeFormFiles()
+
+		if !ctx.IsHijacked() {
+			// Send what the handler wrote, with Content-Length if it all
+			// fit in the buffer, or end the chunked stream
+			if err := w.Finish(); err != nil {
+				logger.Debug("failed to finish response", Field{"error", err})
+			}
+		}
 		duration := time.Since(start)
 
 		// ✅ Issue #16: Record metrics
 		if metrics != nil {
-			metrics.RecordRequest(int(w.StatusCode()), duration)
+			metrics.RequestFinished(req.Method)
+			metrics.Record(RequestInfo{
+				Method:       req.Method,
+				Route:        ctx.Route(),
+				StatusCode:   int(w.StatusCode()),
+				Duration:     duration,
+				RequestSize:  requestSize(req),
+				ResponseSize: w.BytesWritten(),
+			})
 		}
 
 		// ✅ Issue #6: Check if connection was hijacked
@@ -126,6 +208,13 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 			return
 		}
 
+		// Discard any body the handler didn't read so the next request
+		// starts at a message boundary
+		if err := req.DrainBody(maxDrainBytes); err != nil {
+			logger.Debug("closing connection with unread body", Field{"error", err})
+			return
+		}
+
 		// ✅ Issue #4: Reset read deadline for next request with idle timeout
 		if config.IdleTimeout > 0 {
 			if err := conn.SetReadDeadline(time.Now().Add(config.IdleTimeout)); err != nil {
@@ -141,6 +230,94 @@ func handleConnection(conn net.Conn, handler Handler, config *Config, metrics *M
 	)
 }
 
+// connState is everything serving a connection needs. Its Request, Context
+// and Writer are reset for each request rather than allocated, and states
+// are pooled so new connections don't allocate them either.
+type connState struct {
+	cr     connReader
+	reader request.Reader
+	req    request.Request
+	writer response.Writer
+	ctx    Context
+	reqCtx requestContext
+	body   bodyEOFSignal
+
+	// Bound once, so handing them out doesn't allocate per request
+	cancel    context.CancelFunc
+	watch     func()
+	abortRead func()
+}
+
+var connStatePool = sync.Pool{
+	New: func() any {
+		st := &connState{}
+		st.cancel = st.reqCtx.cancelNow
+		st.watch = func() { st.cr.startBackgroundRead(st.cancel) }
+		st.abortRead = st.cr.abortPendingRead
+		return st
+	},
+}
+
+// release returns st to the pool once the connection is done with it. A
+// handler that hijacked the connection or outlived its timeout may still be
+// using the request, so that state is left to the garbage collector.
+func (st *connState) release() {
+	if st.ctx.hijacked || st.ctx.abandoned {
+		return
+	}
+
+	// Drop references to the connection and the last request
+	st.cr.reset(nil)
+	st.reader.Reset(nil)
+	st.req.Reset()
+	st.writer.Reset(nil)
+	clear(st.ctx.values)
+	st.ctx = Context{values: st.ctx.values}
+	st.reqCtx.reset(nil)
+	st.body = bodyEOFSignal{}
+	connStatePool.Put(st)
+}
+
+// requestContext creates a request's context.Context on first use, so
+// requests whose handlers never ask for one don't pay for it
+type requestContext struct {
+	mu       sync.Mutex
+	parent   context.Context
+	ctx      context.Context
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+func (rc *requestContext) reset(parent context.Context) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.parent = parent
+	rc.ctx, rc.cancel, rc.canceled = nil, nil, false
+}
+
+func (rc *requestContext) get() context.Context {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.ctx == nil {
+		rc.ctx, rc.cancel = context.WithCancel(rc.parent)
+		if rc.canceled {
+			rc.cancel()
+		}
+	}
+	return rc.ctx
+}
+
+// cancelNow cancels the context, or the one get will create. It is called
+// when the client disconnects and when the handler returns.
+func (rc *requestContext) cancelNow() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.canceled = true
+	if rc.cancel != nil {
+		rc.cancel()
+	}
+}
+
 // shouldKeepAlive determines if the connection should be kept alive
 func shouldKeepAlive(req *request.Request, w *response.Writer, shuttingDown bool) bool {
 	// ✅ Issue #18: Never keep alive if shutting down
@@ -154,19 +331,15 @@ func shouldKeepAlive(req *request.Request, w *response.Writer, shuttingDown bool
 	}
 
 	// Check response Connection header
-	if connHeader, ok := w.Headers().Get("connection"); ok {
-		if connHeader == "close" {
-			return false
-		}
+	if w.Headers().Is("connection", "close") {
+		return false
 	}
 
 	// HTTP/1.0 closes by default unless "Connection: keep-alive"
 	if req.IsHTTP10() {
-		conn, ok := req.Headers.Get("connection")
-		return ok && conn == "keep-alive"
+		return req.Headers.Is("connection", "keep-alive")
 	}
 
 	// HTTP/1.1 keeps alive by default unless "Connection: close"
-	conn, ok := req.Headers.Get("connection")
-	return !ok || conn != "close"
+	return !req.Headers.Is("connection", "close")
 }
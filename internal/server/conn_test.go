@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	stdnet "net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// wait fails the test if ch isn't closed or sent on in time
+func wait[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+// readAll reads from the client end until the server closes the
+// connection, failing the test if it doesn't
+func readAll(t *testing.T, client stdnet.Conn) string {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestTimeoutMiddlewareOverConn(t *testing.T) {
+	errs := make(chan error, 1)
+	handler := TimeoutMiddleware(20 * time.Millisecond)(HandlerFunc(func(c *Context) {
+		<-c.Context().Done()
+		errs <- c.Context().Err()
+		c.String(response.StatusOK, "too late")
+	}))
+
+	client, done := servePipe(context.Background(), &Config{}, handler)
+	defer client.Close()
+	_, err := io.WriteString(client, "GET /slow HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+
+	// The connection is closed after the 503, so the response ends at EOF
+	resp := readAll(t, client)
+	wait(t, done, "connection to close")
+
+	assert.Contains(t, resp, "HTTP/1.1 503 Service Unavailable\r\n")
+	assert.Contains(t, resp, "connection: close\r\n")
+	assert.Contains(t, resp, "Request timeout")
+	assert.NotContains(t, resp, "too late")
+	assert.ErrorIs(t, wait(t, errs, "handler"), context.DeadlineExceeded)
+}
+
+func TestTimeoutAbandonedStateNotPooled(t *testing.T) {
+	release := make(chan struct{})
+	seen := make(chan string, 1)
+	handler := TimeoutMiddleware(10 * time.Millisecond)(HandlerFunc(func(c *Context) {
+		<-release
+		id, _ := c.Request.Headers.Get("x-test")
+		seen <- c.Request.Path + " " + id
+	}))
+
+	client, done := servePipe(context.Background(), &Config{}, handler)
+	defer client.Close()
+	_, err := io.WriteString(client, "GET /slow HTTP/1.1\r\nHost: example.com\r\nX-Test: abc\r\n\r\n")
+	require.NoError(t, err)
+	readAll(t, client)
+
+	// The server is done with the connection while the handler still runs.
+	// Had its state gone back to the pool, the request would be reset.
+	wait(t, done, "connection to close")
+	close(release)
+	assert.Equal(t, "/slow abc", wait(t, seen, "handler"))
+}
+
+func TestContextCancelledOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{"no body", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		{"body read", "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			handler := HandlerFunc(func(c *Context) {
+				// Disconnects are only watched for once the body is read
+				io.ReadAll(c.Request.Body)
+				select {
+				case <-c.Context().Done():
+					errs <- c.Context().Err()
+				case <-time.After(2 * time.Second):
+					errs <- nil
+				}
+			})
+
+			client, done := servePipe(context.Background(), &Config{}, handler)
+			_, err := io.WriteString(client, tt.request)
+			require.NoError(t, err)
+			require.NoError(t, client.Close())
+
+			assert.ErrorIs(t, wait(t, errs, "handler"), context.Canceled)
+			wait(t, done, "connection to close")
+		})
+	}
+}
+
+func TestPipelinedRequestIsNotDisconnect(t *testing.T) {
+	started, sent := make(chan struct{}), make(chan struct{})
+	errs := make(chan error, 1)
+	handler := HandlerFunc(func(c *Context) {
+		if c.Request.Path == "/a" {
+			close(started)
+			<-sent
+			errs <- c.Context().Err()
+		}
+		c.String(response.StatusOK, "%s", c.Request.Path)
+	})
+
+	client, done := servePipe(context.Background(), &Config{}, handler)
+	defer client.Close()
+	go io.WriteString(client, "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	wait(t, started, "first request")
+
+	// The watcher reads the first byte of the next request mid-handler
+	_, err := io.WriteString(client, "G")
+	require.NoError(t, err)
+	close(sent)
+	assert.NoError(t, wait(t, errs, "first handler"))
+
+	go io.WriteString(client, "ET /b HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	raw := readAll(t, client)
+	wait(t, done, "connection to close")
+
+	assert.Regexp(t, `(?s)^HTTP/1.1 200 OK.*\r\n\r\n/aHTTP/1.1 200 OK.*\r\n\r\n/b$`, raw)
+}
+
+func TestPipelinedResponsesInOrder(t *testing.T) {
+	const depth = 3
+	handler := HandlerFunc(func(c *Context) {
+		c.String(response.StatusOK, "%s", c.Request.Path)
+	})
+
+	client, done := servePipe(context.Background(), &Config{MaxPipelineDepth: depth}, handler)
+	defer client.Close()
+
+	// One write, so every request after the first is already buffered
+	// when the one before it is answered
+	var requests strings.Builder
+	for i := 1; i <= depth+2; i++ {
+		fmt.Fprintf(&requests, "GET /%d HTTP/1.1\r\nHost: example.com\r\n\r\n", i)
+	}
+	go io.WriteString(client, requests.String())
+
+	raw := readAll(t, client)
+	wait(t, done, "connection to close")
+
+	responses := regexp.MustCompile(`(?s)HTTP/1.1 200 OK\r\n(.*?)\r\n\r\n(/[0-9]+)`).FindAllStringSubmatch(raw, -1)
+	require.Len(t, responses, depth+1, "the connection closes after request %d", depth+1)
+	for i, resp := range responses {
+		assert.Equal(t, fmt.Sprintf("/%d", i+1), resp[2])
+		assert.Equal(t, i == depth, strings.Contains(resp[1], "connection: close"), "request %d", i+1)
+	}
+}
+
+// BenchmarkServeGET measures a keep-alive GET through the pooled
+// connection path, from parsing the request to writing the response
+func BenchmarkServeGET(b *testing.B) {
+	config := &Config{ServerName: "http-1"}
+	handler := HandlerFunc(func(c *Context) {
+		c.Text(response.StatusOK, "Hello, World!")
+	})
+	req := []byte("GET /hello HTTP/1.1\r\nHost: example.com\r\nUser-Agent: bench\r\nAccept: */*\r\n\r\n")
+
+	// The server reads each request in one go, so a write returns once it
+	// is parsed. Responses are all the same length; learn it from the first.
+	client, done := servePipe(context.Background(), config, handler)
+	buf := make([]byte, 4096)
+	if _, err := client.Write(req); err != nil {
+		b.Fatal(err)
+	}
+	n := 0
+	for !bytes.HasSuffix(buf[:n], []byte("Hello, World!")) {
+		m, err := client.Read(buf[n:])
+		if err != nil {
+			b.Fatal(err)
+		}
+		n += m
+	}
+	resp := buf[:n]
+
+	served := 1
+	b.ReportAllocs()
+	for b.Loop() {
+		// The server closes a connection after 1000 requests
+		if served == 1000 {
+			client.Close()
+			<-done
+			client, done = servePipe(context.Background(), config, handler)
+			served = 0
+		}
+		served++
+
+		if _, err := client.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	client.Close()
+	<-done
+}
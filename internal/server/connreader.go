@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	net "github.com/Brownie44l1/socket-wrapper"
+)
+
+var errConcurrentRead = errors.New("concurrent read on connection")
+
+// aLongTimeAgo is a deadline in the past, used to unblock a pending read
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader wraps the connection so a background read can watch for the
+// client going away while a handler runs. A byte that arrives early (the
+// start of the next request) is kept and returned by the next Read.
+type connReader struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	inRead  bool
+	aborted bool
+	hasByte bool
+	byteBuf [1]byte
+	cancel  context.CancelFunc
+}
+
+func newConnReader(conn net.Conn) *connReader {
+	cr := &connReader{}
+	cr.reset(conn)
+	return cr
+}
+
+// reset points cr at a new connection. No background read may be running.
+func (cr *connReader) reset(conn net.Conn) {
+	if cr.cond == nil {
+		cr.cond = sync.NewCond(&cr.mu)
+	}
+	cr.conn = conn
+	cr.aborted = false
+	cr.hasByte = false
+	cr.cancel = nil
+}
+
+// Read implements io.Reader
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	if cr.inRead {
+		cr.mu.Unlock()
+		return 0, errConcurrentRead
+	}
+	if cr.hasByte && len(p) > 0 {
+		p[0] = cr.byteBuf[0]
+		cr.hasByte = false
+		cr.mu.Unlock()
+		return 1, nil
+	}
+	cr.mu.Unlock()
+
+	return cr.conn.Read(p)
+}
+
+// startBackgroundRead watches the idle connection and calls cancel if the
+// client disconnects. Only call it once the request body has been read.
+func (cr *connReader) startBackgroundRead(cancel context.CancelFunc) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if cr.inRead || cr.hasByte {
+		return
+	}
+
+	// The request is fully read; the handler may take as long as it needs
+	cr.conn.SetReadDeadline(time.Time{})
+
+	cr.inRead = true
+	cr.aborted = false
+	cr.cancel = cancel
+	go cr.backgroundRead()
+}
+
+func (cr *connReader) backgroundRead() {
+	n, err := cr.conn.Read(cr.byteBuf[:])
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if n == 1 {
+		// Pipelined data, not a disconnect
+		cr.hasByte = true
+	} else if err != nil && !cr.aborted {
+		var timeout interface{ Timeout() bool }
+		if err == io.EOF || !errors.As(err, &timeout) || !timeout.Timeout() {
+			cr.cancel()
+		}
+	}
+
+	cr.inRead = false
+	cr.cond.Broadcast()
+}
+
+// abortPendingRead stops the background read and waits for it to finish
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if !cr.inRead {
+		return
+	}
+
+	cr.aborted = true
+	cr.conn.SetReadDeadline(aLongTimeAgo)
+	for cr.inRead {
+		cr.cond.Wait()
+	}
+	cr.conn.SetReadDeadline(time.Time{})
+}
+
+// bodyEOFSignal calls onEOF once the handler has read the whole body
+type bodyEOFSignal struct {
+	io.ReadCloser
+	onEOF func()
+	fired bool
+	read  int64 // Bytes the handler has read, for request size metrics
+}
+
+func (b *bodyEOFSignal) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err == io.EOF && !b.fired {
+		b.fired = true
+		b.onEOF()
+	}
+	return n, err
+}
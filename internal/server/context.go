@@ -1,44 +1,107 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Brownie44l1/http-1/internal/headers"
 	"github.com/Brownie44l1/http-1/internal/request"
 	"github.com/Brownie44l1/http-1/internal/response"
 	net "github.com/Brownie44l1/socket-wrapper"
 )
 
+var (
+	ErrMissingQuery = errors.New("query parameter not present")
+	ErrNoCookie     = errors.New("named cookie not present")
+)
+
 // Context provides a convenient interface for handling requests and responses
 type Context struct {
 	Request   *request.Request
 	Response  *response.Writer
-	Params    map[string]string // Path parameters (e.g., /users/:id)
-	RequestID string            // ✅ Issue #8: Request ID for tracing
+	Params    Params // Path parameters (e.g., /users/:id)
+	RequestID string // ✅ Issue #8: Request ID for tracing
+
+	paramBuf [4]Param // Backing storage so routing with few params doesn't allocate
+	route    string   // Pattern of the matched route, set by the router
 
 	// ✅ Issue #6: For connection hijacking (WebSockets)
-	conn     net.Conn
-	hijacked bool
+	conn      net.Conn
+	hijacked  bool
+	abortRead func() // Stops the server's disconnect watch before hijacking
+
+	ctx    context.Context
+	reqCtx *requestContext // Creates ctx on first use in the server
+
+	body     []byte // Body read by Body(), cached for repeat calls
+	bodyRead bool
+	bodyErr  error
+
+	config        *Config // Server limits; nil for contexts built outside the server
+	postForm      request.Values
+	multipartForm *Form
+	formParsed    bool
+	formErr       error
+	multipartRead bool // Body handed to MultipartReader
+
+	values   map[string]interface{} // Per-request values set by middleware
+	cspNonce string                 // Set by SecurityHeadersMiddleware
+	origin   *origin                // Client address, scheme and host; see resolveOrigin
+
+	// A handler goroutine outlived the request (see TimeoutMiddleware), so
+	// the connection mustn't reuse it
+	abandoned bool
 }
 
 // NewContext creates a new context
 func NewContext(req *request.Request, resp *response.Writer, conn net.Conn) *Context {
+	c := &Context{}
+	c.reset(req, resp, conn)
+	return c
+}
+
+// reset prepares c for a request, keeping its storage. The server reuses
+// one Context for every request on a connection, so handlers must not keep
+// it, or the Request, after they return.
+func (c *Context) reset(req *request.Request, resp *response.Writer, conn net.Conn) {
+	values := c.values
+	clear(values)
+	*c = Context{
+		Request:  req,
+		Response: resp,
+		conn:     conn,
+		ctx:      context.Background(),
+		values:   values,
+	}
+	c.Params = c.paramBuf[:0]
+
 	// ✅ Issue #8: Extract or generate request ID
-	requestID, _ := req.Headers.Get("x-request-id")
-	if requestID == "" {
-		requestID = generateRequestID()
+	c.RequestID, _ = req.Headers.Get("x-request-id")
+	if c.RequestID == "" {
+		c.RequestID = generateRequestID()
 	}
+}
 
-	return &Context{
-		Request:   req,
-		Response:  resp,
-		Params:    make(map[string]string),
-		RequestID: requestID,
-		conn:      conn,
-		hijacked:  false,
+// Context returns the request's context.Context. It is cancelled when the
+// client disconnects, the server shuts down, a timeout expires or the
+// handler returns. Pass it to database calls and other blocking work.
+func (c *Context) Context() context.Context {
+	if c.ctx == nil {
+		c.ctx = c.reqCtx.get()
 	}
+	return c.ctx
+}
+
+// SetContext replaces the request's context.Context (e.g. to add a deadline)
+func (c *Context) SetContext(ctx context.Context) {
+	c.ctx = ctx
 }
 
 // Method returns the HTTP method
@@ -46,9 +109,22 @@ func (c *Context) Method() string {
 	return c.Request.Method
 }
 
-// Path returns the request path
+// Path returns the decoded request path, without the query string
 func (c *Context) Path() string {
-	return c.Request.Path
+	return c.URL().Path
+}
+
+// URL returns the parsed request-target. Requests built by hand rather
+// than read by the parser are parsed on first use.
+func (c *Context) URL() *request.URL {
+	if c.Request.URL == nil {
+		u, err := request.ParseRequestTarget(c.Request.Method, c.Request.Path)
+		if err != nil {
+			u = &request.URL{RawPath: c.Request.Path, Path: c.Request.Path}
+		}
+		c.Request.URL = u
+	}
+	return c.Request.URL
 }
 
 // Header gets a request header value
@@ -57,41 +133,179 @@ func (c *Context) Header(key string) string {
 	return val
 }
 
+// Set stores a value for the rest of the request, e.g. for middleware to
+// pass a session or user to handlers
+func (c *Context) Set(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+// Get returns a value stored with Set
+func (c *Context) Get(key string) (interface{}, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// Cookie returns the request cookie named name
+func (c *Context) Cookie(name string) (*headers.Cookie, error) {
+	cookie, ok := c.Request.Headers.Cookie(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoCookie, name)
+	}
+	return cookie, nil
+}
+
+// Cookies returns every cookie sent with the request
+func (c *Context) Cookies() []*headers.Cookie {
+	return c.Request.Headers.Cookies()
+}
+
+// SetCookie adds a Set-Cookie header to the response. Each call adds its
+// own header line, so several cookies can be set on one response.
+func (c *Context) SetCookie(cookie *headers.Cookie) error {
+	value, err := cookie.SetCookieValue()
+	if err != nil {
+		return err
+	}
+	c.Response.Headers().Add("Set-Cookie", value)
+	return nil
+}
+
+// Param is a single path parameter captured by the router
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params holds path parameters in pattern order
+type Params []Param
+
+// Get returns the value of the named parameter
+func (ps Params) Get(name string) (string, bool) {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
 // SetParams sets path parameters (called by router)
-func (c *Context) SetParams(params map[string]string) {
+func (c *Context) SetParams(params Params) {
 	c.Params = params
 }
 
+// SetRoute records the pattern of the matched route (called by router)
+func (c *Context) SetRoute(pattern string) {
+	c.route = pattern
+}
+
+// Route returns the pattern of the matched route (e.g. "/users/:id"), or
+// "" if no route matched
+func (c *Context) Route() string {
+	return c.route
+}
+
 // Param gets a path parameter by name
 func (c *Context) Param(name string) string {
-	return c.Params[name]
+	value, _ := c.Params.Get(name)
+	return value
 }
 
-// Query gets a query parameter (basic implementation)
+// Query returns the first value of a query parameter, decoded, or "" if
+// it is absent
 func (c *Context) Query(key string) string {
-	// Simple implementation - parse query from path
-	path := c.Request.Path
-	if idx := strings.Index(path, "?"); idx != -1 {
-		query := path[idx+1:]
-		pairs := strings.Split(query, "&")
-		for _, pair := range pairs {
-			kv := strings.SplitN(pair, "=", 2)
-			if len(kv) == 2 && kv[0] == key {
-				return kv[1]
-			}
-		}
+	return c.URL().Query().Get(key)
+}
+
+// QueryAll returns every value of a repeated query parameter
+// (?tag=a&tag=b), or nil if it is absent
+func (c *Context) QueryAll(key string) []string {
+	return c.URL().Query()[key]
+}
+
+// QueryDefault returns the first value of a query parameter, or def if it
+// is absent
+func (c *Context) QueryDefault(key, def string) string {
+	values := c.URL().Query()
+	if !values.Has(key) {
+		return def
 	}
-	return ""
+	return values.Get(key)
 }
 
-// Body returns the request body as bytes
-func (c *Context) Body() []byte {
+// QueryInt parses a query parameter as an int. It returns ErrMissingQuery
+// if the parameter is absent.
+func (c *Context) QueryInt(key string) (int, error) {
+	value, err := c.queryValue(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// QueryInt64 parses a query parameter as an int64. It returns
+// ErrMissingQuery if the parameter is absent.
+func (c *Context) QueryInt64(key string) (int64, error) {
+	value, err := c.queryValue(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// QueryBool parses a query parameter with strconv.ParseBool. A key given
+// without a value (?verbose) counts as true. It returns ErrMissingQuery if
+// the parameter is absent.
+func (c *Context) QueryBool(key string) (bool, error) {
+	value, err := c.queryValue(key)
+	if err != nil {
+		return false, err
+	}
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// queryValue returns the first value of a query parameter
+func (c *Context) queryValue(key string) (string, error) {
+	values := c.URL().Query()
+	if !values.Has(key) {
+		return "", fmt.Errorf("%w: %s", ErrMissingQuery, key)
+	}
+	return values.Get(key), nil
+}
+
+// BodyReader returns the streaming request body
+func (c *Context) BodyReader() io.ReadCloser {
 	return c.Request.Body
 }
 
+// ReadBody reads the whole request body into memory. The result is cached,
+// so it can be called more than once. Prefer BodyReader for large uploads.
+func (c *Context) ReadBody() ([]byte, error) {
+	if !c.bodyRead {
+		c.body, c.bodyErr = io.ReadAll(c.Request.Body)
+		c.bodyRead = true
+	}
+	return c.body, c.bodyErr
+}
+
+// Body returns the request body as bytes (nil if it could not be read)
+func (c *Context) Body() []byte {
+	body, err := c.ReadBody()
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
 // BodyString returns the request body as a string
 func (c *Context) BodyString() string {
-	return string(c.Request.Body)
+	return string(c.Body())
 }
 
 // Response helpers
@@ -126,11 +340,25 @@ func (c *Context) NoContent() error {
 	return c.Response.NoContentResponse()
 }
 
-// Status sends just a status code with no body
+// Status sets the status code for the response. It is sent with the
+// headers, on the first Flush or when the handler returns.
 func (c *Context) Status(code response.StatusCode) error {
 	return c.Response.WriteStatusLine(code)
 }
 
+// Write adds p to the response body, so the Context can be passed to
+// fmt.Fprintf, json.NewEncoder and the like. Small bodies get a
+// Content-Length; larger ones are streamed (see response.Writer.Write).
+func (c *Context) Write(p []byte) (int, error) {
+	return c.Response.Write(p)
+}
+
+// Flush sends what has been written so far, switching the response to
+// streaming
+func (c *Context) Flush() error {
+	return c.Response.Flush()
+}
+
 // String is a helper for formatting responses
 func (c *Context) String(code response.StatusCode, format string, values ...interface{}) error {
 	text := fmt.Sprintf(format, values...)
@@ -147,6 +375,11 @@ func (c *Context) Hijack() (net.Conn, error) {
 		return nil, errors.New("no underlying connection")
 	}
 
+	// The caller owns all reads from here on
+	if c.abortRead != nil {
+		c.abortRead()
+	}
+
 	c.hijacked = true
 	return c.conn, nil
 }
@@ -164,38 +397,44 @@ func (c *Context) IsWebSocketUpgrade() bool {
 	return upgrade == "websocket" && strings.Contains(connection, "upgrade")
 }
 
-// GetClientIP returns the client IP address
-func (c *Context) GetClientIP() string {
-	// Check X-Forwarded-For header first
-	if xff := c.Header("X-Forwarded-For"); xff != "" {
-		// Take first IP
-		if idx := strings.Index(xff, ","); idx != -1 {
-			return strings.TrimSpace(xff[:idx])
-		}
-		return strings.TrimSpace(xff)
+// TLS returns the TLS connection state, or nil if the request did not
+// arrive over TLS
+func (c *Context) TLS() *tls.ConnectionState {
+	tc, ok := c.conn.(*tlsConn)
+	if !ok {
+		return nil
 	}
+	state := tc.ConnectionState()
+	return &state
+}
 
-	// Check X-Real-IP header
-	if xri := c.Header("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
+// ClientCertificate returns the client's certificate when mutual TLS is in
+// use and the certificate was verified against Config.TLS.ClientCAs. It
+// returns nil otherwise, including for certificates that were presented
+// but not verified.
+func (c *Context) ClientCertificate() *x509.Certificate {
+	state := c.TLS()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
 	}
+	return state.VerifiedChains[0][0]
+}
 
-	// Fall back to remote address from connection
-	if c.conn != nil {
-		addr := c.conn.RemoteAddr()
-		// Strip port
-		if idx := strings.LastIndex(addr, ":"); idx != -1 {
-			return addr[:idx]
-		}
-		return addr
+// RemoteAddr returns the address of the peer (ip:port), ignoring any
+// forwarding headers. Empty if there is no underlying connection.
+func (c *Context) RemoteAddr() string {
+	if c.conn == nil {
+		return ""
 	}
-
-	return ""
+	return c.conn.RemoteAddr()
 }
 
 // generateRequestID generates a unique request ID
 func generateRequestID() string {
 	// Simple implementation - use timestamp + random
 	// For production, use UUID or similar
-	return fmt.Sprintf("req-%d", time.Now().UnixNano())
+	var buf [24]byte
+	id := append(buf[:0], "req-"...)
+	id = strconv.AppendInt(id, time.Now().UnixNano(), 10)
+	return string(id)
 }
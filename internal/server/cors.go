@@ -0,0 +1,223 @@
+package server
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures CORSMiddleware. An origin is allowed if it matches
+// any of AllowedOrigins, AllowedOriginPatterns, AllowedOriginRegexps or
+// AllowOriginFunc.
+type CORSConfig struct {
+	AllowedOrigins        []string         // Exact origins, or "*" for any origin
+	AllowedOriginPatterns []string         // Wildcards such as "https://*.example.com"
+	AllowedOriginRegexps  []*regexp.Regexp // Matched against the whole origin
+	AllowOriginFunc       func(origin string) bool
+
+	AllowedMethods []string // Methods a preflight may ask for
+	AllowedHeaders []string // Request headers a preflight may ask for; "*" allows any
+	ExposedHeaders []string // Response headers scripts may read
+
+	// AllowCredentials lets credentialed requests (cookies, Authorization)
+	// read the response. It cannot be combined with a "*" origin, which
+	// then only admits requests without credentials.
+	AllowCredentials bool
+
+	// AllowPrivateNetwork answers Private Network Access preflights, which
+	// browsers send before a public site may call a private address
+	AllowPrivateNetwork bool
+
+	MaxAge time.Duration // How long browsers may cache a preflight
+}
+
+// DefaultCORSConfig returns a permissive CORS config (for development)
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Requested-With", "X-CSRF-Token"},
+		AllowCredentials: false,
+		MaxAge:           12 * time.Hour,
+	}
+}
+
+// corsPolicy is a CORSConfig prepared for matching
+type corsPolicy struct {
+	config       CORSConfig
+	anyOrigin    bool
+	origins      map[string]bool
+	patterns     []*regexp.Regexp
+	anyHeader    bool
+	headers      map[string]bool // Lowercase
+	methods      string
+	exposed      string
+	maxAge       string
+	allowMethods map[string]bool
+}
+
+func newCORSPolicy(config CORSConfig) *corsPolicy {
+	p := &corsPolicy{
+		config:       config,
+		origins:      make(map[string]bool),
+		headers:      make(map[string]bool),
+		allowMethods: make(map[string]bool),
+		methods:      strings.Join(config.AllowedMethods, ", "),
+		exposed:      strings.Join(config.ExposedHeaders, ", "),
+	}
+
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		} else {
+			p.origins[strings.ToLower(origin)] = true
+		}
+	}
+	for _, pattern := range config.AllowedOriginPatterns {
+		quoted := strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(pattern)), `\*`, `[a-z0-9-]+(?:\.[a-z0-9-]+)*`)
+		p.patterns = append(p.patterns, regexp.MustCompile("^"+quoted+"$"))
+	}
+	for _, header := range config.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		} else {
+			p.headers[strings.ToLower(header)] = true
+		}
+	}
+	for _, method := range config.AllowedMethods {
+		p.allowMethods[method] = true
+	}
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(config.MaxAge / time.Second))
+	}
+
+	if p.anyOrigin && config.AllowCredentials {
+		log.Println("WARN: CORS: AllowCredentials only applies to origins listed explicitly, not \"*\"")
+	}
+	return p
+}
+
+// originAllowed reports whether origin may access responses
+func (p *corsPolicy) originAllowed(origin string) bool {
+	return p.anyOrigin || p.explicitlyAllowed(origin)
+}
+
+// explicitlyAllowed reports whether origin is allowed by something other
+// than "*", so it may be echoed back with credentials
+func (p *corsPolicy) explicitlyAllowed(origin string) bool {
+	lower := strings.ToLower(origin)
+	if p.origins[lower] {
+		return true
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(lower) {
+			return true
+		}
+	}
+	for _, re := range p.config.AllowedOriginRegexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return p.config.AllowOriginFunc != nil && p.config.AllowOriginFunc(origin)
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value and whether
+// credentials may be allowed with it. The spec forbids pairing "*" with
+// credentials, so a wildcard match never gets them.
+func (p *corsPolicy) allowOrigin(origin string) (string, bool) {
+	if p.anyOrigin && !(p.config.AllowCredentials && p.explicitlyAllowed(origin)) {
+		return "*", false
+	}
+	return origin, p.config.AllowCredentials
+}
+
+// headersAllowed checks an Access-Control-Request-Headers list
+func (p *corsPolicy) headersAllowed(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header != "" && !p.headers[header] {
+			return false
+		}
+	}
+	return true
+}
+
+// CORSMiddleware implements Cross-Origin Resource Sharing. Preflights (an
+// OPTIONS request with Origin and Access-Control-Request-Method) are
+// answered here with 204; every other request, including a plain OPTIONS,
+// reaches the handler with CORS headers added if its origin is allowed.
+// Responses always carry Vary: Origin so caches keep them apart.
+func CORSMiddleware(config CORSConfig) Middleware {
+	p := newCORSPolicy(config)
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) {
+			h := ctx.Response.Headers()
+			origin := ctx.Header("Origin")
+			requestMethod := ctx.Header("Access-Control-Request-Method")
+
+			if ctx.Method() == "OPTIONS" && origin != "" && requestMethod != "" {
+				p.preflight(ctx, origin, requestMethod)
+				return
+			}
+
+			addVary(h, "Origin")
+			if origin != "" && p.originAllowed(origin) {
+				value, credentials := p.allowOrigin(origin)
+				h.Set("Access-Control-Allow-Origin", value)
+				if credentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+				if p.exposed != "" {
+					h.Set("Access-Control-Expose-Headers", p.exposed)
+				}
+			}
+
+			next.ServeHTTP(ctx)
+		})
+	}
+}
+
+// preflight answers a CORS preflight. A disallowed origin, method or
+// header gets a 204 without CORS headers, which the browser treats as a
+// refusal.
+func (p *corsPolicy) preflight(ctx *Context, origin, method string) {
+	h := ctx.Response.Headers()
+	addVary(h, "Origin")
+	addVary(h, "Access-Control-Request-Method")
+	addVary(h, "Access-Control-Request-Headers")
+	if p.config.AllowPrivateNetwork {
+		addVary(h, "Access-Control-Request-Private-Network")
+	}
+
+	requestHeaders := ctx.Header("Access-Control-Request-Headers")
+	if !p.originAllowed(origin) || !p.allowMethods[method] || !p.headersAllowed(requestHeaders) {
+		ctx.NoContent()
+		return
+	}
+
+	value, credentials := p.allowOrigin(origin)
+	h.Set("Access-Control-Allow-Origin", value)
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", p.methods)
+	if requestHeaders != "" {
+		// Echo what was asked for; it has been checked against the config
+		h.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	if p.config.AllowPrivateNetwork && strings.EqualFold(ctx.Header("Access-Control-Request-Private-Network"), "true") {
+		h.Set("Access-Control-Allow-Private-Network", "true")
+	}
+
+	ctx.NoContent()
+}
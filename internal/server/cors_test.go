@@ -0,0 +1,226 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// corsServe runs a request through CORSMiddleware and reports the response
+// headers, status and whether the handler ran
+func corsServe(config CORSConfig, method string, header ...string) (*headers.Headers, response.StatusCode, bool) {
+	ctx, _ := newTestContext(method, "/api", "", append([]string{"Host", "api.example"}, header...)...)
+	called := false
+	handler := CORSMiddleware(config)(HandlerFunc(func(c *Context) {
+		called = true
+		c.String(response.StatusOK, "ok")
+	}))
+	handler.ServeHTTP(ctx)
+	return ctx.Response.Headers(), ctx.Response.StatusCode(), called
+}
+
+func vary(h *headers.Headers) string {
+	return strings.Join(h.GetAll("vary"), ", ")
+}
+
+func headerValue(h *headers.Headers, name string) string {
+	value, _ := h.Get(name)
+	return value
+}
+
+func TestCORSOriginMatching(t *testing.T) {
+	config := CORSConfig{
+		AllowedOrigins:        []string{"https://app.example"},
+		AllowedOriginPatterns: []string{"https://*.example.org"},
+		AllowedOriginRegexps:  []*regexp.Regexp{regexp.MustCompile(`^https://review-[0-9]+\.example\.net$`)},
+		AllowOriginFunc:       func(origin string) bool { return origin == "http://localhost:3000" },
+		ExposedHeaders:        []string{"X-Total", "X-Page"},
+	}
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example", true},
+		{"HTTPS://APP.EXAMPLE", true},
+		{"https://app.example:8443", false},
+		{"http://app.example", false},
+		{"https://docs.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evil.example.org.attacker.example", false},
+		{"https://review-12.example.net", true},
+		{"https://review-x.example.net", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			h, status, called := corsServe(config, "GET", "Origin", tt.origin)
+			assert.True(t, called)
+			assert.Equal(t, response.StatusOK, status)
+			assert.Equal(t, "Origin", vary(h))
+
+			if tt.allowed {
+				assert.Equal(t, tt.origin, headerValue(h, "Access-Control-Allow-Origin"))
+				assert.Equal(t, "X-Total, X-Page", headerValue(h, "Access-Control-Expose-Headers"))
+			} else {
+				assert.False(t, h.Has("Access-Control-Allow-Origin"))
+				assert.False(t, h.Has("Access-Control-Expose-Headers"))
+			}
+			assert.False(t, h.Has("Access-Control-Allow-Credentials"))
+		})
+	}
+}
+
+func TestCORSVaryOnEveryResponse(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://app.example"}, AllowedMethods: []string{"GET"}}
+
+	// Without an Origin the response must still not be reused for one
+	h, _, called := corsServe(config, "GET")
+	assert.True(t, called)
+	assert.Equal(t, "Origin", vary(h))
+	assert.False(t, h.Has("Access-Control-Allow-Origin"))
+
+	h, _, _ = corsServe(config, "GET", "Origin", "https://evil.example")
+	assert.Equal(t, "Origin", vary(h))
+
+	for _, origin := range []string{"https://app.example", "https://evil.example"} {
+		h, _, _ = corsServe(config, "OPTIONS", "Origin", origin, "Access-Control-Request-Method", "GET")
+		assert.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", vary(h), origin)
+	}
+}
+
+func TestCORSPlainOPTIONSReachesHandler(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://app.example"}, AllowedMethods: []string{"GET"}}
+
+	// No Access-Control-Request-Method: not a preflight
+	h, status, called := corsServe(config, "OPTIONS", "Origin", "https://app.example")
+	assert.True(t, called)
+	assert.Equal(t, response.StatusOK, status)
+	assert.Equal(t, "https://app.example", headerValue(h, "Access-Control-Allow-Origin"))
+	assert.False(t, h.Has("Access-Control-Allow-Methods"))
+
+	// No Origin: not a CORS request at all
+	h, _, called = corsServe(config, "OPTIONS", "Access-Control-Request-Method", "GET")
+	assert.True(t, called)
+	assert.False(t, h.Has("Access-Control-Allow-Origin"))
+	assert.False(t, h.Has("Access-Control-Allow-Methods"))
+}
+
+func TestCORSWildcardWithCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		origin      string
+		wantOrigin  string
+		credentials bool
+	}{
+		{"listed origin is echoed", []string{"*", "https://app.example"}, "https://app.example", "https://app.example", true},
+		{"unlisted origin gets *", []string{"*", "https://app.example"}, "https://other.example", "*", false},
+		{"* alone never echoes", []string{"*"}, "https://other.example", "*", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := CORSConfig{AllowedOrigins: tt.origins, AllowedMethods: []string{"GET"}, AllowCredentials: true}
+
+			for _, req := range [][]string{
+				{"Origin", tt.origin},
+				{"Origin", tt.origin, "Access-Control-Request-Method", "GET"},
+			} {
+				method := "GET"
+				if len(req) > 2 {
+					method = "OPTIONS"
+				}
+				h, _, _ := corsServe(config, method, req...)
+				assert.Equal(t, tt.wantOrigin, headerValue(h, "Access-Control-Allow-Origin"), method)
+				assert.Equal(t, tt.credentials, h.Has("Access-Control-Allow-Credentials"), method)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	config := CORSConfig{
+		AllowedOrigins: []string{"https://app.example"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "X-Custom"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	h, status, called := corsServe(config, "OPTIONS",
+		"Origin", "https://app.example",
+		"Access-Control-Request-Method", "POST",
+		"Access-Control-Request-Headers", "content-type, X-CUSTOM")
+	assert.False(t, called)
+	assert.Equal(t, response.StatusNoContent, status)
+	assert.Equal(t, "https://app.example", headerValue(h, "Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", headerValue(h, "Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, X-CUSTOM", headerValue(h, "Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", headerValue(h, "Access-Control-Max-Age"))
+
+	refusals := []struct {
+		name    string
+		request []string
+	}{
+		{"origin", []string{"Origin", "https://evil.example", "Access-Control-Request-Method", "GET"}},
+		{"method", []string{"Origin", "https://app.example", "Access-Control-Request-Method", "DELETE"}},
+		{"method case", []string{"Origin", "https://app.example", "Access-Control-Request-Method", "post"}},
+		{"header", []string{"Origin", "https://app.example", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "Content-Type, X-Other"}},
+	}
+	for _, tt := range refusals {
+		t.Run("refuses "+tt.name, func(t *testing.T) {
+			h, status, called := corsServe(config, "OPTIONS", tt.request...)
+			assert.False(t, called)
+			assert.Equal(t, response.StatusNoContent, status)
+			for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers", "Access-Control-Max-Age"} {
+				assert.False(t, h.Has(name), name)
+			}
+		})
+	}
+
+	t.Run("any header", func(t *testing.T) {
+		config := config
+		config.AllowedHeaders = []string{"*"}
+		h, _, _ := corsServe(config, "OPTIONS",
+			"Origin", "https://app.example",
+			"Access-Control-Request-Method", "GET",
+			"Access-Control-Request-Headers", "X-Anything")
+		assert.Equal(t, "X-Anything", headerValue(h, "Access-Control-Allow-Headers"))
+	})
+}
+
+func TestCORSPrivateNetwork(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://app.example"}, AllowedMethods: []string{"GET"}}
+	preflight := []string{
+		"Origin", "https://app.example",
+		"Access-Control-Request-Method", "GET",
+		"Access-Control-Request-Private-Network", "true",
+	}
+
+	h, _, _ := corsServe(config, "OPTIONS", preflight...)
+	assert.False(t, h.Has("Access-Control-Allow-Private-Network"))
+
+	config.AllowPrivateNetwork = true
+	h, _, _ = corsServe(config, "OPTIONS", preflight...)
+	assert.Equal(t, "true", headerValue(h, "Access-Control-Allow-Private-Network"))
+	assert.Contains(t, vary(h), "Access-Control-Request-Private-Network")
+
+	// Only answered when asked
+	h, _, _ = corsServe(config, "OPTIONS", preflight[:4]...)
+	assert.False(t, h.Has("Access-Control-Allow-Private-Network"))
+	assert.Equal(t, "https://app.example", headerValue(h, "Access-Control-Allow-Origin"))
+
+	// Or when the rest of the preflight is allowed
+	h, _, _ = corsServe(config, "OPTIONS", append([]string{"Origin", "https://evil.example"}, preflight[2:]...)...)
+	assert.False(t, h.Has("Access-Control-Allow-Private-Network"))
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+
+	"github.com/Brownie44l1/http-1/internal/request"
+)
+
+var (
+	ErrNotMultipart = errors.New("request is not multipart/form-data")
+	ErrFormTooLarge = errors.New("form exceeds size limit")
+	ErrMissingFile  = errors.New("no such file in form")
+	ErrBodyConsumed = errors.New("request body already read by MultipartReader")
+)
+
+// Form is a parsed multipart/form-data body
+type Form struct {
+	Value request.Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file. Small files are held in memory;
+// larger ones are spilled to a temporary file that is removed when the
+// request finishes.
+type FileHeader struct {
+	Filename string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	content []byte
+	tmpFile string
+}
+
+// File is an uploaded file opened with FileHeader.Open
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open opens the uploaded file for reading
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+	return memoryFile{bytes.NewReader(fh.content)}, nil
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// RemoveAll deletes any temporary files backing the form. The server calls
+// it when the handler returns; code that parses forms on a Context made
+// with NewContext must call it itself.
+func (f *Form) RemoveAll() error {
+	var firstErr error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpFile); err != nil && !errors.Is(err, os.ErrNotExist) && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// formLimits are the parsing limits taken from the server Config
+type formLimits struct {
+	maxMemory   int64
+	maxFileSize int64
+	maxParts    int
+	tempDir     string
+}
+
+func (c *Context) formLimits() formLimits {
+	config := c.config
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	limits := formLimits{
+		maxMemory:   config.MaxFormMemory,
+		maxFileSize: config.MaxFormFileSize,
+		maxParts:    config.MaxFormParts,
+		tempDir:     config.FormTempDir,
+	}
+	// Hand-built configs may leave the form limits unset
+	defaults := DefaultConfig()
+	if limits.maxMemory <= 0 {
+		limits.maxMemory = defaults.MaxFormMemory
+	}
+	if limits.maxParts <= 0 {
+		limits.maxParts = defaults.MaxFormParts
+	}
+	if limits.maxFileSize <= 0 {
+		limits.maxFileSize = config.MaxRequestBodySize
+	}
+	if limits.maxFileSize <= 0 {
+		limits.maxFileSize = defaults.MaxRequestBodySize
+	}
+	return limits
+}
+
+// removeFormFiles deletes the temporary files of a parsed multipart form
+func (c *Context) removeFormFiles() {
+	if c.multipartForm != nil {
+		c.multipartForm.RemoveAll()
+	}
+}
+
+// FormValue returns the first value for key from the body (urlencoded or
+// multipart) or, failing that, the query string. Parse errors are ignored;
+// call ParseForm to see them.
+func (c *Context) FormValue(key string) string {
+	c.ParseForm()
+	if values := c.postForm[key]; len(values) > 0 {
+		return values[0]
+	}
+	return c.URL().Query().Get(key)
+}
+
+// PostForm returns the fields sent in the body of a POST, PUT or PATCH
+// request, without the query string. File fields are not included.
+func (c *Context) PostForm() (request.Values, error) {
+	err := c.ParseForm()
+	return c.postForm, err
+}
+
+// MultipartForm returns the parsed multipart/form-data body
+func (c *Context) MultipartForm() (*Form, error) {
+	if err := c.ParseForm(); err != nil {
+		return nil, err
+	}
+	if c.multipartForm == nil {
+		return nil, ErrNotMultipart
+	}
+	return c.multipartForm, nil
+}
+
+// FormFile opens the first file uploaded under key
+func (c *Context) FormFile(key string) (File, *FileHeader, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := form.File[key]
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrMissingFile, key)
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, files[0], nil
+}
+
+// MultipartReader streams a multipart/form-data body part by part, for
+// uploads too large to buffer. It cannot be combined with the Form
+// methods, which read the same body.
+func (c *Context) MultipartReader() (*multipart.Reader, error) {
+	if c.multipartRead || c.formParsed {
+		return nil, ErrBodyConsumed
+	}
+
+	boundary, err := c.multipartBoundary()
+	if err != nil {
+		return nil, err
+	}
+	c.multipartRead = true
+	return multipart.NewReader(c.formBody(), boundary), nil
+}
+
+// ParseForm reads a urlencoded or multipart body of a POST, PUT or PATCH
+// request. It runs once; later calls return the first result. Limits come
+// from Config.MaxFormMemory, MaxFormFileSize and MaxFormParts, on top of
+// the MaxRequestBodySize cap on the whole body.
+func (c *Context) ParseForm() error {
+	if c.formParsed {
+		return c.formErr
+	}
+	c.formParsed = true
+	c.postForm = make(request.Values)
+
+	switch c.Method() {
+	case "POST", "PUT", "PATCH":
+	default:
+		return nil
+	}
+	if c.multipartRead {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Header("content-type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		c.formErr = c.parseURLEncoded()
+	case "multipart/form-data":
+		c.formErr = c.parseMultipart()
+	}
+	return c.formErr
+}
+
+// parseURLEncoded reads an application/x-www-form-urlencoded body
+func (c *Context) parseURLEncoded() error {
+	limit := c.formLimits().maxMemory
+	data, err := io.ReadAll(io.LimitReader(c.formBody(), limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > limit {
+		return ErrFormTooLarge
+	}
+
+	values, err := request.ParseQuery(string(data))
+	c.postForm = values
+	return err
+}
+
+// parseMultipart reads a multipart/form-data body. Field values and files
+// share the memory budget; once it is used up, files go to disk.
+func (c *Context) parseMultipart() error {
+	boundary, err := c.multipartBoundary()
+	if err != nil {
+		return err
+	}
+
+	limits := c.formLimits()
+	form := &Form{Value: c.postForm, File: make(map[string][]*FileHeader)}
+	c.multipartForm = form
+
+	reader := multipart.NewReader(c.formBody(), boundary)
+	memLeft := limits.maxMemory
+
+	for parts := 0; ; parts++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if parts >= limits.maxParts {
+			return fmt.Errorf("%w: more than %d parts", ErrFormTooLarge, limits.maxParts)
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, part, memLeft+1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if n > memLeft {
+				return fmt.Errorf("%w: field %q", ErrFormTooLarge, name)
+			}
+			memLeft -= n
+			form.Value.Add(name, value.String())
+			continue
+		}
+
+		fh, err := readFilePart(part, limits, &memLeft)
+		if err != nil {
+			return err
+		}
+		form.File[name] = append(form.File[name], fh)
+	}
+}
+
+// readFilePart stores one uploaded file in memory if it fits in the
+// remaining budget, or in a temporary file otherwise
+func readFilePart(part *multipart.Part, limits formLimits, memLeft *int64) (*FileHeader, error) {
+	fh := &FileHeader{Filename: part.FileName(), Header: part.Header}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, min(*memLeft, limits.maxFileSize)+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n > limits.maxFileSize {
+		return nil, fmt.Errorf("%w: file %q", ErrFormTooLarge, fh.Filename)
+	}
+
+	if n <= *memLeft {
+		*memLeft -= n
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return fh, nil
+	}
+
+	tmp, err := os.CreateTemp(limits.tempDir, "multipart-")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	fh.tmpFile = tmp.Name()
+
+	rest, err := io.CopyN(tmp, io.MultiReader(&buf, part), limits.maxFileSize+1)
+	if err != nil && err != io.EOF {
+		os.Remove(fh.tmpFile)
+		return nil, err
+	}
+	if rest > limits.maxFileSize {
+		os.Remove(fh.tmpFile)
+		return nil, fmt.Errorf("%w: file %q", ErrFormTooLarge, fh.Filename)
+	}
+	fh.Size = rest
+	return fh, nil
+}
+
+// multipartBoundary returns the boundary of a multipart/form-data body
+func (c *Context) multipartBoundary() (string, error) {
+	mediaType, params, err := mime.ParseMediaType(c.Header("content-type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return "", fmt.Errorf("%w: missing boundary", ErrNotMultipart)
+	}
+	return boundary, nil
+}
+
+// formBody returns the body to parse a form from, reusing it if Body()
+// already read it into memory
+func (c *Context) formBody() io.Reader {
+	if c.bodyRead {
+		return bytes.NewReader(c.body)
+	}
+	return c.Request.Body
+}
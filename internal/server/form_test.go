@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartBody builds a multipart/form-data body from name, value pairs;
+// names starting with "@" are files
+func multipartBody(t *testing.T, fields ...string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, value := fields[i], fields[i+1]
+		if file, ok := strings.CutPrefix(name, "@"); ok {
+			w, err := mw.CreateFormFile(file, file+".txt")
+			require.NoError(t, err)
+			_, err = io.WriteString(w, value)
+			require.NoError(t, err)
+			continue
+		}
+		require.NoError(t, mw.WriteField(name, value))
+	}
+	require.NoError(t, mw.Close())
+	return buf.String(), mw.FormDataContentType()
+}
+
+func multipartContext(t *testing.T, config *Config, fields ...string) *Context {
+	t.Helper()
+	body, contentType := multipartBody(t, fields...)
+	ctx, _ := newTestContext("POST", "/upload", body, "Content-Type", contentType)
+	ctx.config = config
+	return ctx
+}
+
+func readFile(t *testing.T, fh *FileHeader) string {
+	t.Helper()
+	f, err := fh.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseFormURLEncoded(t *testing.T) {
+	ctx, _ := newTestContext("POST", "/submit?q=search&a=query", "a=1&b=two+words&a=3",
+		"Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	form, err := ctx.PostForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "3"}, form["a"])
+	assert.Equal(t, "two words", form.Get("b"))
+	assert.False(t, form.Has("q"))
+
+	// The body wins over the query string, which fills the gaps
+	assert.Equal(t, "1", ctx.FormValue("a"))
+	assert.Equal(t, "search", ctx.FormValue("q"))
+	assert.Equal(t, "", ctx.FormValue("missing"))
+}
+
+func TestParseFormIgnoresBodyForGET(t *testing.T) {
+	ctx, _ := newTestContext("GET", "/?a=query", "a=body",
+		"Content-Type", "application/x-www-form-urlencoded")
+
+	require.NoError(t, ctx.ParseForm())
+	assert.Equal(t, "query", ctx.FormValue("a"))
+}
+
+func TestFormLimitsFallBackToDefaults(t *testing.T) {
+	defaults := DefaultConfig()
+	ctx, _ := newTestContext("POST", "/", "")
+	ctx.config = &Config{}
+
+	limits := ctx.formLimits()
+	assert.Equal(t, defaults.MaxFormMemory, limits.maxMemory)
+	assert.Equal(t, defaults.MaxFormParts, limits.maxParts)
+	assert.Equal(t, defaults.MaxRequestBodySize, limits.maxFileSize)
+
+	ctx.config = &Config{MaxRequestBodySize: 1234}
+	assert.Equal(t, int64(1234), ctx.formLimits().maxFileSize)
+}
+
+func TestMultipartSpillsToTempDir(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxFormMemory = 16
+	config.FormTempDir = t.TempDir()
+
+	big := strings.Repeat("x", 100)
+	ctx := multipartContext(t, config, "name", "gopher", "@small", "tiny", "@big", big)
+
+	form, err := ctx.MultipartForm()
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	assert.Equal(t, "gopher", form.Value.Get("name"))
+
+	small := form.File["small"][0]
+	assert.Empty(t, small.tmpFile, "fits in memory")
+	assert.Equal(t, int64(4), small.Size)
+	assert.Equal(t, "tiny", readFile(t, small))
+
+	f, fh, err := ctx.FormFile("big")
+	require.NoError(t, err)
+	f.Close()
+	assert.Equal(t, "big.txt", fh.Filename)
+	assert.Equal(t, int64(100), fh.Size)
+	assert.Equal(t, config.FormTempDir, filepath.Dir(fh.tmpFile))
+	assert.Equal(t, big, readFile(t, fh))
+
+	require.NoError(t, form.RemoveAll())
+	entries, err := os.ReadDir(config.FormTempDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, _, err = ctx.FormFile("missing")
+	assert.ErrorIs(t, err, ErrMissingFile)
+}
+
+func TestFormLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*Config)
+		fields []string
+	}{
+		{
+			name:   "file larger than MaxFormFileSize",
+			config: func(c *Config) { c.MaxFormFileSize = 10 },
+			fields: []string{"@upload", strings.Repeat("x", 11)},
+		},
+		{
+			name: "spilled file larger than MaxFormFileSize",
+			config: func(c *Config) {
+				c.MaxFormMemory = 4
+				c.MaxFormFileSize = 10
+			},
+			fields: []string{"@upload", strings.Repeat("x", 11)},
+		},
+		{
+			name:   "fields larger than MaxFormMemory",
+			config: func(c *Config) { c.MaxFormMemory = 10 },
+			fields: []string{"a", "123456", "b", "123456"},
+		},
+		{
+			name:   "more parts than MaxFormParts",
+			config: func(c *Config) { c.MaxFormParts = 2 },
+			fields: []string{"a", "1", "b", "2", "c", "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.FormTempDir = t.TempDir()
+			tt.config(config)
+
+			ctx := multipartContext(t, config, tt.fields...)
+			err := ctx.ParseForm()
+			assert.ErrorIs(t, err, ErrFormTooLarge)
+			assert.Equal(t, err, ctx.ParseForm(), "later calls return the first result")
+
+			// Files written before the limit was hit are still removed
+			ctx.removeFormFiles()
+			entries, err := os.ReadDir(config.FormTempDir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+
+	t.Run("urlencoded body larger than MaxFormMemory", func(t *testing.T) {
+		config := DefaultConfig()
+		config.MaxFormMemory = 8
+		ctx, _ := newTestContext("POST", "/", "a=123456789",
+			"Content-Type", "application/x-www-form-urlencoded")
+		ctx.config = config
+		assert.ErrorIs(t, ctx.ParseForm(), ErrFormTooLarge)
+	})
+}
+
+func TestMultipartReaderConsumesBody(t *testing.T) {
+	ctx := multipartContext(t, DefaultConfig(), "a", "1")
+
+	reader, err := ctx.MultipartReader()
+	require.NoError(t, err)
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "a", part.FormName())
+
+	_, err = ctx.MultipartReader()
+	assert.ErrorIs(t, err, ErrBodyConsumed)
+}
+
+func TestFormFilesRemovedWhenRequestEnds(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxFormMemory = 16
+	config.FormTempDir = t.TempDir()
+
+	body, contentType := multipartBody(t, "@upload", strings.Repeat("x", 100))
+	input := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"+
+		"Content-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+
+	var spilled string
+	out := serveConn(config, func(ctx *Context) {
+		_, fh, err := ctx.FormFile("upload")
+		require.NoError(t, err)
+		spilled = fh.tmpFile
+		assert.FileExists(t, spilled)
+		ctx.String(200, "ok")
+	}, input)
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	require.NotEmpty(t, spilled)
+	assert.NoFileExists(t, spilled)
+}
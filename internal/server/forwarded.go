@@ -0,0 +1,270 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ForwardedHeader selects the header trusted proxies report the original
+// client in
+type ForwardedHeader int
+
+const (
+	ForwardedHeaderXForwardedFor ForwardedHeader = iota // X-Forwarded-For, with X-Forwarded-Proto/Host
+	ForwardedHeaderForwarded                            // Forwarded (RFC 7239)
+	ForwardedHeaderXRealIP                              // X-Real-IP; scheme and host come from the connection
+)
+
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8" for
+// Config.TrustedProxies. A bare address trusts that single host.
+func ParseTrustedProxies(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if strings.Contains(cidr, "/") {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// origin is where a request came from, as resolved through trusted proxies
+type origin struct {
+	clientIP string
+	scheme   string
+	host     string
+}
+
+// hop is one proxy hop: who sent the request and how the proxy received it
+type hop struct {
+	node  string // Address or identifier of the sender
+	proto string
+	host  string
+}
+
+// GetClientIP returns the IP address of the client. The header named by
+// Config.ForwardedHeader is only believed when the connection comes from
+// one of Config.TrustedProxies; its hops are then walked right to left,
+// skipping trusted ones, so a client can't pick its own address by adding
+// entries.
+func (c *Context) GetClientIP() string {
+	return c.resolveOrigin().clientIP
+}
+
+// Scheme returns "https" or "http" for the request as the client sent it,
+// taking X-Forwarded-Proto or Forwarded from trusted proxies into account
+func (c *Context) Scheme() string {
+	return c.resolveOrigin().scheme
+}
+
+// Host returns the host the client addressed, taking X-Forwarded-Host or
+// Forwarded from trusted proxies into account
+func (c *Context) Host() string {
+	return c.resolveOrigin().host
+}
+
+// resolveOrigin works out the request's origin once per request
+func (c *Context) resolveOrigin() *origin {
+	if c.origin != nil {
+		return c.origin
+	}
+
+	peer := c.RemoteAddr()
+	if addrPort, err := netip.ParseAddrPort(peer); err == nil {
+		peer = addrPort.Addr().Unmap().String()
+	}
+	o := &origin{clientIP: peer, scheme: "http", host: c.Header("host")}
+	if c.TLS() != nil {
+		o.scheme = "https"
+	}
+	c.origin = o
+
+	if c.config == nil || !c.trusted(peer) {
+		return o
+	}
+
+	var hops []hop
+	switch c.config.ForwardedHeader {
+	case ForwardedHeaderForwarded:
+		if forwarded := c.Request.Headers.GetAll("forwarded"); len(forwarded) > 0 {
+			hops = parseForwarded(strings.Join(forwarded, ","))
+		}
+	case ForwardedHeaderXRealIP:
+		if ip, ok := parseNode(c.Header("x-real-ip")); ok {
+			o.clientIP = ip.String()
+		}
+		return o
+	default:
+		hops = c.xForwardedHops()
+	}
+	if len(hops) == 0 {
+		return o
+	}
+
+	// The rightmost hop was added by our peer; stop at the first sender
+	// that isn't a trusted proxy. If they all are, the leftmost is the
+	// client.
+	i := len(hops) - 1
+	for i > 0 && c.trusted(hops[i].node) {
+		i--
+	}
+	client := hops[i]
+
+	if ip, ok := parseNode(client.node); ok {
+		o.clientIP = ip.String()
+	} else if client.node != "" {
+		// "unknown" or an obfuscated identifier (RFC 7239 section 6)
+		o.clientIP = client.node
+	}
+	if proto := strings.ToLower(client.proto); proto == "http" || proto == "https" {
+		o.scheme = proto
+	}
+	if client.host != "" && !strings.ContainsAny(client.host, " \t/\\") {
+		o.host = client.host
+	}
+	return o
+}
+
+// trusted reports whether node is an address in Config.TrustedProxies
+func (c *Context) trusted(node string) bool {
+	ip, ok := parseNode(node)
+	if !ok {
+		return false
+	}
+	for _, prefix := range c.config.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// xForwardedHops returns the hops in X-Forwarded-For, with
+// X-Forwarded-Proto/Host
+func (c *Context) xForwardedHops() []hop {
+	nodes := splitList(c.Request.Headers.GetAll("x-forwarded-for"))
+	if len(nodes) == 0 {
+		return nil
+	}
+	hops := make([]hop, len(nodes))
+	for i, node := range nodes {
+		hops[i].node = node
+	}
+
+	// Proxies either append to these like X-Forwarded-For or overwrite
+	// them. If the lists line up, each entry belongs to its hop; otherwise
+	// the last value, from the nearest proxy, applies to all of them.
+	for _, field := range []struct {
+		name string
+		set  func(*hop, string)
+	}{
+		{"x-forwarded-proto", func(h *hop, v string) { h.proto = v }},
+		{"x-forwarded-host", func(h *hop, v string) { h.host = v }},
+	} {
+		values := splitList(c.Request.Headers.GetAll(field.name))
+		if len(values) == 0 {
+			continue
+		}
+		for i := range hops {
+			if len(values) == len(hops) {
+				field.set(&hops[i], values[i])
+			} else {
+				field.set(&hops[i], values[len(values)-1])
+			}
+		}
+	}
+	return hops
+}
+
+// parseForwarded parses a Forwarded header (RFC 7239). Quoted strings may
+// contain commas and semicolons.
+func parseForwarded(value string) []hop {
+	var hops []hop
+	var cur hop
+	var param, text strings.Builder
+	inValue, quoted, escaped := false, false, false
+
+	finishPair := func() {
+		v := text.String()
+		switch strings.ToLower(strings.TrimSpace(param.String())) {
+		case "for":
+			cur.node = v
+		case "proto":
+			cur.proto = v
+		case "host":
+			cur.host = v
+		}
+		param.Reset()
+		text.Reset()
+		inValue = false
+	}
+
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case escaped:
+			text.WriteByte(ch)
+			escaped = false
+		case quoted && ch == '\\':
+			escaped = true
+		case ch == '"' && inValue:
+			quoted = !quoted
+		case quoted:
+			text.WriteByte(ch)
+		case ch == '=' && !inValue:
+			inValue = true
+		case ch == ';':
+			finishPair()
+		case ch == ',':
+			finishPair()
+			hops = append(hops, cur)
+			cur = hop{}
+		case ch == ' ' || ch == '\t':
+		case inValue:
+			text.WriteByte(ch)
+		default:
+			param.WriteByte(ch)
+		}
+	}
+	finishPair()
+	return append(hops, cur)
+}
+
+// parseNode parses an address from X-Forwarded-For or a Forwarded "for"
+// parameter: an IP, optionally with a port, IPv6 in brackets
+func parseNode(node string) (netip.Addr, bool) {
+	node = strings.TrimSpace(node)
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	ip, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// splitList splits comma-separated header values, dropping empty entries
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
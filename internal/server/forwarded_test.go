@@ -0,0 +1,186 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    ForwardedHeader
+		peer    string
+		headers []string
+		want    origin
+	}{
+		{
+			name:    "untrusted peer's headers are ignored",
+			peer:    "203.0.113.9:5000",
+			headers: []string{"X-Forwarded-For", "198.51.100.7", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "evil.example", "X-Real-IP", "198.51.100.8"},
+			want:    origin{clientIP: "203.0.113.9", scheme: "http", host: "example.com"},
+		},
+		{
+			name: "trusted peer without forwarding headers",
+			peer: "10.0.0.1:5000",
+			want: origin{clientIP: "10.0.0.1", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "walk skips trusted hops",
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Forwarded-For", "198.51.100.7, 10.0.0.2, 10.0.0.3"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "spoofed leftmost entry",
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Forwarded-For", "6.6.6.6, 198.51.100.7, 10.0.0.2"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "spoofed entry on its own line",
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Forwarded-For", "6.6.6.6", "X-Forwarded-For", "198.51.100.7"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "all hops trusted",
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Forwarded-For", "10.0.0.5, 10.0.0.6"},
+			want:    origin{clientIP: "10.0.0.5", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "mapped IPv4 peer",
+			peer:    "[::ffff:10.0.0.1]:5000",
+			headers: []string{"X-Forwarded-For", "198.51.100.7"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name: "proto and host lists line up with hops",
+			peer: "10.0.0.1:5000",
+			headers: []string{
+				"X-Forwarded-For", "198.51.100.7, 10.0.0.2",
+				"X-Forwarded-Proto", "https, http",
+				"X-Forwarded-Host", "public.example, internal.example",
+			},
+			want: origin{clientIP: "198.51.100.7", scheme: "https", host: "public.example"},
+		},
+		{
+			name: "proto and host lists don't line up",
+			peer: "10.0.0.1:5000",
+			headers: []string{
+				"X-Forwarded-For", "6.6.6.6, 198.51.100.7, 10.0.0.2",
+				"X-Forwarded-Proto", "HTTPS",
+				"X-Forwarded-Host", "spoofed.example, public.example",
+			},
+			want: origin{clientIP: "198.51.100.7", scheme: "https", host: "public.example"},
+		},
+		{
+			name:    "invalid forwarded host and proto",
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Forwarded-For", "198.51.100.7", "X-Forwarded-Proto", "gopher", "X-Forwarded-Host", "evil.example/path"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "client's Forwarded ignored when proxies set X-Forwarded-For",
+			peer:    "10.0.0.5:5000",
+			headers: []string{"X-Forwarded-For", "203.0.113.9", "Forwarded", "for=1.1.1.1;proto=https"},
+			want:    origin{clientIP: "203.0.113.9", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "client's X-Real-IP ignored when proxies set X-Forwarded-For",
+			peer:    "10.0.0.5:5000",
+			headers: []string{"X-Real-IP", "1.1.1.1"},
+			want:    origin{clientIP: "10.0.0.5", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "Forwarded with quoted IPv6 and escapes",
+			mode:    ForwardedHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"Forwarded", `for="[::1]:80";proto=https;host="ex\ample.com", for=10.0.0.2`},
+			want:    origin{clientIP: "::1", scheme: "https", host: "example.com"},
+		},
+		{
+			name:    "Forwarded spoofed leftmost element",
+			mode:    ForwardedHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"Forwarded", `for=6.6.6.6;host="a,b", For="198.51.100.7:443"`, "Forwarded", "for=10.0.0.2"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "Forwarded obfuscated identifier",
+			mode:    ForwardedHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"Forwarded", "for=_hidden;proto=https"},
+			want:    origin{clientIP: "_hidden", scheme: "https", host: "example.com"},
+		},
+		{
+			name:    "client's X-Forwarded-* ignored when proxies set Forwarded",
+			mode:    ForwardedHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"Forwarded", "for=198.51.100.7", "X-Forwarded-For", "1.1.1.1", "X-Forwarded-Proto", "https"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "no Forwarded header",
+			mode:    ForwardedHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Forwarded-For", "1.1.1.1"},
+			want:    origin{clientIP: "10.0.0.1", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "X-Real-IP",
+			mode:    ForwardedHeaderXRealIP,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Real-IP", "198.51.100.7", "X-Forwarded-For", "1.1.1.1", "X-Forwarded-Proto", "https"},
+			want:    origin{clientIP: "198.51.100.7", scheme: "http", host: "example.com"},
+		},
+		{
+			name:    "invalid X-Real-IP",
+			mode:    ForwardedHeaderXRealIP,
+			peer:    "10.0.0.1:5000",
+			headers: []string{"X-Real-IP", "not-an-ip"},
+			want:    origin{clientIP: "10.0.0.1", scheme: "http", host: "example.com"},
+		},
+	}
+
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := newTestContext("GET", "/", "", append([]string{"Host", "example.com"}, tt.headers...)...)
+			ctx.conn = newFakeConn("", tt.peer)
+			ctx.config = &Config{TrustedProxies: trusted, ForwardedHeader: tt.mode}
+
+			assert.Equal(t, tt.want.clientIP, ctx.GetClientIP())
+			assert.Equal(t, tt.want.scheme, ctx.Scheme())
+			assert.Equal(t, tt.want.host, ctx.Host())
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies("10.1.2.3/8", "192.0.2.1", "::ffff:192.0.2.2", "2001:db8::/32")
+	require.NoError(t, err)
+
+	var got []string
+	for _, prefix := range prefixes {
+		got = append(got, prefix.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1/32", "192.0.2.2/32", "2001:db8::/32"}, got)
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies("proxy.internal")
+	assert.Error(t, err)
+}
+
+func TestParseForwarded(t *testing.T) {
+	hops := parseForwarded(`for="198.51.100.7;x, y";proto=https, by=10.0.0.1;for=10.0.0.2 ;host=api.example`)
+	assert.Equal(t, []hop{
+		{node: "198.51.100.7;x, y", proto: "https"},
+		{node: "10.0.0.2", host: "api.example"},
+	}, hops)
+}
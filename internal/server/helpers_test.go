@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	stdnet "net"
+	"strings"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	net "github.com/Brownie44l1/socket-wrapper"
+)
+
+// fakeConn is a connection that reads a fixed input and records what is
+// written. Methods it doesn't override fall through to the nil net.Conn.
+type fakeConn struct {
+	net.Conn
+	in     io.Reader
+	out    bytes.Buffer
+	remote string
+}
+
+func newFakeConn(input, remote string) *fakeConn {
+	return &fakeConn{in: strings.NewReader(input), remote: remote}
+}
+
+func (c *fakeConn) Read(p []byte) (int, error)       { return c.in.Read(p) }
+func (c *fakeConn) Write(p []byte) (int, error)      { return c.out.Write(p) }
+func (c *fakeConn) Close() error                     { return nil }
+func (c *fakeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *fakeConn) RemoteAddr() string               { return c.remote }
+
+// pipeConn is the server end of an in-memory connection. Unlike fakeConn
+// it blocks, honors deadlines and sees the client hang up.
+type pipeConn struct {
+	net.Conn
+	pipe stdnet.Conn
+}
+
+// newPipeConn returns the server end of a connection and the client end
+func newPipeConn() (*pipeConn, stdnet.Conn) {
+	server, client := stdnet.Pipe()
+	return &pipeConn{pipe: server}, client
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)         { return c.pipe.Read(p) }
+func (c *pipeConn) Write(p []byte) (int, error)        { return c.pipe.Write(p) }
+func (c *pipeConn) Close() error                       { return c.pipe.Close() }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return c.pipe.SetReadDeadline(t) }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return c.pipe.SetWriteDeadline(t) }
+func (c *pipeConn) RemoteAddr() string                 { return "192.0.2.1:1234" }
+
+// servePipe serves handler over a pipe in the background. It returns the
+// client end and a channel closed when the server is done with the
+// connection.
+func servePipe(baseCtx context.Context, config *Config, handler Handler) (stdnet.Conn, <-chan struct{}) {
+	conn, client := newPipeConn()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(baseCtx, conn, handler, config, nil, &NullLogger{}, false)
+	}()
+	return client, done
+}
+
+// serveConn runs handler on the requests in input and returns the raw
+// responses
+func serveConn(config *Config, handler HandlerFunc, input string) string {
+	conn := newFakeConn(input, "192.0.2.1:1234")
+	handleConnection(context.Background(), conn, handler, config, nil, &NullLogger{}, false)
+	return conn.out.String()
+}
+
+// newTestContext builds a Context for a request that isn't read from a
+// connection. Header pairs are name, value; a repeated name adds a line.
+func newTestContext(method, path, body string, header ...string) (*Context, *bytes.Buffer) {
+	req := request.NewRequest()
+	req.Method = method
+	req.Path = path
+	req.Version = "HTTP/1.1"
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Headers.Add(header[i], header[i+1])
+	}
+	if body != "" {
+		req.Body = io.NopCloser(strings.NewReader(body))
+	}
+
+	var buf bytes.Buffer
+	return NewContext(req, response.NewWriter(&buf), nil), &buf
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// histogram counts observations into fixed buckets. It is safe for
+// concurrent use and never locks.
+type histogram struct {
+	bounds []float64       // Upper bounds, ascending
+	counts []atomic.Uint64 // Per bucket, not cumulative; the last is +Inf
+	sum    atomic.Uint64   // float64 bits
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// observe records one value
+func (h *histogram) observe(v float64) {
+	// First bucket whose upper bound is >= v (bounds are inclusive)
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i].Add(1)
+
+	for {
+		old := h.sum.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if h.sum.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// histogramSnapshot is a point-in-time copy of a histogram with
+// cumulative bucket counts, as exposed to Prometheus
+type histogramSnapshot struct {
+	bounds     []float64
+	cumulative []uint64 // Same length as bounds; +Inf is count
+	count      uint64
+	sum        float64
+}
+
+func (h *histogram) snapshot() histogramSnapshot {
+	s := histogramSnapshot{
+		bounds:     h.bounds,
+		cumulative: make([]uint64, len(h.bounds)),
+	}
+
+	var running uint64
+	for i := range h.bounds {
+		running += h.counts[i].Load()
+		s.cumulative[i] = running
+	}
+	// Concurrent observations may land between loads; keep +Inf consistent
+	// with the buckets so the exposition is always monotonic
+	s.count = running + h.counts[len(h.bounds)].Load()
+	s.sum = math.Float64frombits(h.sum.Load())
+	return s
+}
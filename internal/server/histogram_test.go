@@ -0,0 +1,51 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramCumulativeBuckets(t *testing.T) {
+	h := newHistogram([]float64{1, 5, 10})
+	for _, v := range []float64{0, 1, 2, 5, 7, 10, 11, 100} {
+		h.observe(v)
+	}
+
+	s := h.snapshot()
+	assert.Equal(t, []float64{1, 5, 10}, s.bounds)
+	// Upper bounds are inclusive
+	assert.Equal(t, []uint64{2, 4, 6}, s.cumulative)
+	assert.Equal(t, uint64(8), s.count, "+Inf counts every observation")
+	assert.Equal(t, 136.0, s.sum)
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	s := newHistogram([]float64{0.5}).snapshot()
+	assert.Equal(t, []uint64{0}, s.cumulative)
+	assert.Zero(t, s.count)
+	assert.Zero(t, s.sum)
+}
+
+func TestHistogramConcurrentObserve(t *testing.T) {
+	h := newHistogram([]float64{1})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				h.observe(0.5)
+				h.observe(2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := h.snapshot()
+	assert.Equal(t, []uint64{8000}, s.cumulative)
+	assert.Equal(t, uint64(16000), s.count)
+	assert.Equal(t, 20000.0, s.sum)
+}
@@ -1,12 +1,67 @@
 package server
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/Brownie44l1/http-1/internal/request"
 )
 
 // ✅ Issue #16: Metrics and Observability
 
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request
+// duration histogram
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the request and
+// response size histograms
+var DefaultSizeBuckets = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000}
+
+// MetricsConfig configures histogram buckets and metric names
+type MetricsConfig struct {
+	Namespace      string    // Prefix for metric names, e.g. "http"
+	LatencyBuckets []float64 // Seconds, ascending
+	SizeBuckets    []float64 // Bytes, ascending
+}
+
+// DefaultMetricsConfig returns the default metrics configuration
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Namespace:      "http",
+		LatencyBuckets: DefaultLatencyBuckets,
+		SizeBuckets:    DefaultSizeBuckets,
+	}
+}
+
+// RequestInfo describes a completed request for Metrics.Record
+type RequestInfo struct {
+	Method string
+	// Route is the matched route pattern (e.g. "/users/:id"), never the raw
+	// path, so label cardinality stays bounded. Empty if no route matched.
+	Route        string
+	StatusCode   int
+	Duration     time.Duration
+	RequestSize  int64 // Headers are not included
+	ResponseSize int64 // Bytes on the wire, including the head
+}
+
+// routeKey identifies the labelled series for one method and route
+type routeKey struct {
+	method string
+	route  string
+}
+
+// routeMetrics holds the per-method, per-route series
+type routeMetrics struct {
+	duration     *histogram
+	requestSize  *histogram
+	responseSize *histogram
+
+	mu    sync.Mutex
+	codes map[int]*atomic.Int64 // Requests by status code
+}
+
 // Metrics holds server runtime metrics
 type Metrics struct {
 	RequestsTotal     atomic.Int64
@@ -17,11 +72,134 @@ type Metrics struct {
 	
 	// Latency tracking (simplified - use histogram in production)
 	TotalLatencyNs atomic.Int64
+
+	config   MetricsConfig
+	inFlight [len(knownMethods) + 1]atomic.Int64 // Indexed by methodIndex
+
+	mu     sync.RWMutex
+	routes map[routeKey]*routeMetrics
 }
 
 // NewMetrics creates a new metrics instance
 func NewMetrics() *Metrics {
-	return &Metrics{}
+	return NewMetricsWithConfig(DefaultMetricsConfig())
+}
+
+// NewMetricsWithConfig creates a metrics instance with custom buckets
+func NewMetricsWithConfig(config MetricsConfig) *Metrics {
+	if config.LatencyBuckets == nil {
+		config.LatencyBuckets = DefaultLatencyBuckets
+	}
+	if config.SizeBuckets == nil {
+		config.SizeBuckets = DefaultSizeBuckets
+	}
+	return &Metrics{
+		config: config,
+		routes: make(map[routeKey]*routeMetrics),
+	}
+}
+
+// Record records a completed request in the counters and the labelled
+// histograms
+func (m *Metrics) Record(info RequestInfo) {
+	m.RecordRequest(info.StatusCode, info.Duration)
+
+	rm := m.route(routeKey{method: normalizeMethod(info.Method), route: info.Route})
+	rm.duration.observe(info.Duration.Seconds())
+	rm.requestSize.observe(float64(info.RequestSize))
+	rm.responseSize.observe(float64(info.ResponseSize))
+	rm.code(info.StatusCode).Add(1)
+}
+
+// RequestStarted marks a request as in flight until RequestFinished is
+// called with the same method
+func (m *Metrics) RequestStarted(method string) {
+	m.inFlight[methodIndex(method)].Add(1)
+}
+
+// RequestFinished ends a request begun with RequestStarted
+func (m *Metrics) RequestFinished(method string) {
+	m.inFlight[methodIndex(method)].Add(-1)
+}
+
+// InFlight returns the number of requests currently being handled
+func (m *Metrics) InFlight() int64 {
+	var total int64
+	for i := range m.inFlight {
+		total += m.inFlight[i].Load()
+	}
+	return total
+}
+
+// route returns the series for key, creating them on first use
+func (m *Metrics) route(key routeKey) *routeMetrics {
+	m.mu.RLock()
+	rm := m.routes[key]
+	m.mu.RUnlock()
+	if rm != nil {
+		return rm
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.routes == nil {
+		m.routes = make(map[routeKey]*routeMetrics) // Zero Metrics value
+	}
+	if rm = m.routes[key]; rm == nil {
+		rm = &routeMetrics{
+			duration:     newHistogram(m.config.LatencyBuckets),
+			requestSize:  newHistogram(m.config.SizeBuckets),
+			responseSize: newHistogram(m.config.SizeBuckets),
+			codes:        make(map[int]*atomic.Int64),
+		}
+		m.routes[key] = rm
+	}
+	return rm
+}
+
+// code returns the request counter for a status code
+func (rm *routeMetrics) code(statusCode int) *atomic.Int64 {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	counter := rm.codes[statusCode]
+	if counter == nil {
+		counter = &atomic.Int64{}
+		rm.codes[statusCode] = counter
+	}
+	return counter
+}
+
+// requestSize returns the request body size: the declared Content-Length,
+// or for chunked bodies the bytes the handler has read so far
+func requestSize(req *request.Request) int64 {
+	if n := req.ContentLength(); n >= 0 {
+		return n
+	}
+	if b, ok := req.Body.(*bodyEOFSignal); ok {
+		return b.read
+	}
+	return 0
+}
+
+// knownMethods get their own method label; anything else is "OTHER" so a
+// client cannot create unbounded series
+var knownMethods = [...]string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS", "CONNECT", "TRACE"}
+
+func methodIndex(method string) int {
+	for i, known := range knownMethods {
+		if method == known {
+			return i
+		}
+	}
+	return len(knownMethods)
+}
+
+func normalizeMethod(method string) string {
+	if i := methodIndex(method); i < len(knownMethods) {
+		return knownMethods[i]
+	}
+	return "OTHER"
 }
 
 // RecordRequest records a completed request
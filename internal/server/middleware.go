@@ -1,10 +1,8 @@
 package server
 
 import (
-	"fmt"
+	"context"
 	"runtime/debug"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/Brownie44l1/http-1/internal/response"
@@ -59,190 +57,73 @@ func RecoveryMiddleware(logger Logger) Middleware {
 	}
 }
 
-// RateLimiter implements a simple token bucket rate limiter per IP
-type RateLimiter struct {
-	mu       sync.RWMutex
-	buckets  map[string]*bucket
-	rate     int           // requests per window
-	window   time.Duration // time window
-	cleanupInterval time.Duration
-}
-
-type bucket struct {
-	tokens    int
-	lastReset time.Time
-}
-
-// NewRateLimiter creates a new rate limiter
-// rate: number of requests allowed per window
-// window: time window (e.g., 1 minute)
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		buckets:         make(map[string]*bucket),
-		rate:            rate,
-		window:          window,
-		cleanupInterval: window * 2,
-	}
-
-	// Start cleanup goroutine to remove old entries
-	go rl.cleanup()
-
-	return rl
-}
-
-// Allow checks if a request from the given IP should be allowed
-func (rl *RateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-
-	b, exists := rl.buckets[ip]
-	if !exists {
-		rl.buckets[ip] = &bucket{
-			tokens:    rl.rate - 1,
-			lastReset: now,
-		}
-		return true
-	}
-
-	// Reset bucket if window has passed
-	if now.Sub(b.lastReset) >= rl.window {
-		b.tokens = rl.rate - 1
-		b.lastReset = now
-		return true
-	}
-
-	// Check if tokens available
-	if b.tokens > 0 {
-		b.tokens--
-		return true
-	}
-
-	return false
-}
-
-// cleanup removes old bucket entries periodically
-func (rl *RateLimiter) cleanup() {
-	ticker := time.NewTicker(rl.cleanupInterval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		rl.mu.Lock()
-		now := time.Now()
-		for ip, b := range rl.buckets {
-			if now.Sub(b.lastReset) > rl.window*2 {
-				delete(rl.buckets, ip)
-			}
-		}
-		rl.mu.Unlock()
-	}
-}
-
-// ✅ Issue #20: Rate Limiting Middleware
-func RateLimitMiddleware(limiter *RateLimiter) Middleware {
-	return func(next Handler) Handler {
-		return HandlerFunc(func(ctx *Context) {
-			ip := ctx.GetClientIP()
-
-			if !limiter.Allow(ip) {
-				ctx.Error(response.StatusTooManyRequests, "Rate limit exceeded")
-				return
-			}
-
-			next.ServeHTTP(ctx)
-		})
-	}
-}
-
-// ✅ Issue #21: CORS Middleware
-func CORSMiddleware(config CORSConfig) Middleware {
+// TimeoutMiddleware enforces a timeout on request handling. The handler
+// writes into a buffered response and gets a context that is cancelled on
+// timeout; exactly one of its response or a 503 reaches the client.
+func TimeoutMiddleware(timeout time.Duration) Middleware {
 	return func(next Handler) Handler {
 		return HandlerFunc(func(ctx *Context) {
-			origin := ctx.Header("Origin")
-
-			// Check if origin is allowed
-			if isAllowedOrigin(origin, config.AllowedOrigins) {
-				// Set CORS headers
-				ctx.Response.Headers().Set("Access-Control-Allow-Origin", origin)
-				ctx.Response.Headers().Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
-				ctx.Response.Headers().Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
-
-				if config.AllowCredentials {
-					ctx.Response.Headers().Set("Access-Control-Allow-Credentials", "true")
-				}
-
-				if config.MaxAge > 0 {
-					ctx.Response.Headers().Set("Access-Control-Max-Age", fmt.Sprintf("%d", int(config.MaxAge.Seconds())))
-				}
-			}
-
-			// Handle preflight request
-			if ctx.Method() == "OPTIONS" {
-				ctx.NoContent()
-				return
-			}
+			timeoutCtx, cancel := context.WithTimeout(ctx.Context(), timeout)
+			defer cancel()
 
-			next.ServeHTTP(ctx)
-		})
-	}
-}
-
-// CORSConfig configures CORS middleware
-type CORSConfig struct {
-	AllowedOrigins   []string
-	AllowedMethods   []string
-	AllowedHeaders   []string
-	AllowCredentials bool
-	MaxAge           time.Duration
-}
-
-// DefaultCORSConfig returns a permissive CORS config (for development)
-func DefaultCORSConfig() CORSConfig {
-	return CORSConfig{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-Requested-With"},
-		AllowCredentials: false,
-		MaxAge:           12 * time.Hour,
-	}
-}
-
-func isAllowedOrigin(origin string, allowed []string) bool {
-	if len(allowed) == 0 {
-		return false
-	}
-
-	for _, allowedOrigin := range allowed {
-		if allowedOrigin == "*" {
-			return true
-		}
-		if allowedOrigin == origin {
-			return true
-		}
-	}
+			// The handler may outlive us, so it gets its own copy of the
+			// context and response writer
+			inner := *ctx
+			inner.ctx = timeoutCtx
+			inner.Response = ctx.Response.Buffer()
 
-	return false
-}
-
-// TimeoutMiddleware enforces a timeout on request handling
-func TimeoutMiddleware(timeout time.Duration) Middleware {
-	return func(next Handler) Handler {
-		return HandlerFunc(func(ctx *Context) {
 			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			returned := make(chan struct{}) // Closed however the handler ends
 
 			go func() {
-				next.ServeHTTP(ctx)
+				defer close(returned)
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(&inner)
 				close(done)
 			}()
 
 			select {
 			case <-done:
-				// Request completed normally
-			case <-time.After(timeout):
-				// Timeout - but we can't stop the handler
-				// Just log it
-				ctx.Error(response.StatusRequestTimeout, "Request timeout")
+				ctx.Params = inner.Params
+				ctx.route = inner.route
+				ctx.values = inner.values
+				// The body is consumed, so keep what the handler parsed from
+				// it; the server removes any spilled files
+				ctx.postForm = inner.postForm
+				ctx.multipartForm = inner.multipartForm
+				ctx.formParsed = inner.formParsed
+				ctx.formErr = inner.formErr
+				ctx.multipartRead = inner.multipartRead
+				if inner.IsHijacked() {
+					ctx.hijacked = true
+					return
+				}
+				ctx.Response.Commit(inner.Response)
+
+			case p := <-panicked:
+				ctx.multipartForm = inner.multipartForm
+				// Re-raise on this goroutine so RecoveryMiddleware sees it
+				panic(p)
+
+			case <-timeoutCtx.Done():
+				// The handler may still be reading the body - don't reuse the
+				// connection, or the request it is still using
+				ctx.abandoned = true
+				ctx.Response.Headers().Set("Connection", "close")
+				go func() {
+					<-returned
+					inner.removeFormFiles()
+				}()
+				if timeoutCtx.Err() == context.DeadlineExceeded {
+					ctx.Error(response.StatusServiceUnavailable, "Request timeout")
+				} else {
+					ctx.Error(response.StatusServiceUnavailable, "Service unavailable")
+				}
 			}
 		})
 	}
@@ -266,31 +147,20 @@ func MetricsMiddleware(metrics *Metrics) Middleware {
 	return func(next Handler) Handler {
 		return HandlerFunc(func(ctx *Context) {
 			start := time.Now()
+			method := ctx.Method()
+			metrics.RequestStarted(method)
+			defer metrics.RequestFinished(method)
 
 			next.ServeHTTP(ctx)
 
-			duration := time.Since(start)
-			statusCode := int(ctx.Response.StatusCode())
-
-			metrics.RecordRequest(statusCode, duration)
+			metrics.Record(RequestInfo{
+				Method:       method,
+				Route:        ctx.Route(),
+				StatusCode:   int(ctx.Response.StatusCode()),
+				Duration:     time.Since(start),
+				RequestSize:  requestSize(ctx.Request),
+				ResponseSize: ctx.Response.BytesWritten(),
+			})
 		})
 	}
 }
-
-// CompressionMiddleware adds gzip compression (placeholder)
-// ✅ Issue #11: Compression support (simplified)
-func CompressionMiddleware() Middleware {
-	return func(next Handler) Handler {
-		return HandlerFunc(func(ctx *Context) {
-			acceptEncoding := ctx.Header("Accept-Encoding")
-
-			// Check if client accepts gzip
-			if strings.Contains(acceptEncoding, "gzip") {
-				// TODO: Wrap response writer with gzip writer
-				// For now, just pass through
-			}
-
-			next.ServeHTTP(ctx)
-		})
-	}
-}
\ No newline at end of file
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// PrometheusContentType is the media type of the text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns a handler that serves the metrics in the Prometheus text
+// exposition format, for mounting on e.g. GET /metrics
+func (m *Metrics) Handler() Handler {
+	return HandlerFunc(func(ctx *Context) {
+		var buf bytes.Buffer
+		if err := m.WritePrometheus(&buf); err != nil {
+			ctx.Error(response.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		h := headers.NewHeaders()
+		h.Set("Content-Type", PrometheusContentType)
+		h.Set("Content-Length", strconv.Itoa(buf.Len()))
+
+		if err := ctx.Response.WriteStatusLine(response.StatusOK); err != nil {
+			return
+		}
+		if err := ctx.Response.WriteHeaders(h); err != nil {
+			return
+		}
+		if ctx.Method() != "HEAD" {
+			ctx.Response.WriteBody(buf.Bytes())
+		}
+	})
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition
+// format, version 0.0.4. Series are sorted so output is stable.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	e := &expositionWriter{w: bw, namespace: m.config.Namespace}
+
+	type series struct {
+		key routeKey
+		rm  *routeMetrics
+	}
+	m.mu.RLock()
+	all := make([]series, 0, len(m.routes))
+	for key, rm := range m.routes {
+		all = append(all, series{key, rm})
+	}
+	m.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].key.route != all[j].key.route {
+			return all[i].key.route < all[j].key.route
+		}
+		return all[i].key.method < all[j].key.method
+	})
+
+	e.header("requests_total", "counter", "Total HTTP requests by method, route and status code.")
+	for _, s := range all {
+		s.rm.mu.Lock()
+		codes := make([]int, 0, len(s.rm.codes))
+		for code := range s.rm.codes {
+			codes = append(codes, code)
+		}
+		s.rm.mu.Unlock()
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			e.sample("requests_total", float64(s.rm.code(code).Load()),
+				"method", s.key.method, "route", s.key.route, "code", strconv.Itoa(code))
+		}
+	}
+
+	histograms := []struct {
+		name, help string
+		get        func(*routeMetrics) *histogram
+	}{
+		{"request_duration_seconds", "HTTP request latency in seconds.", func(rm *routeMetrics) *histogram { return rm.duration }},
+		{"request_size_bytes", "HTTP request body size in bytes.", func(rm *routeMetrics) *histogram { return rm.requestSize }},
+		{"response_size_bytes", "HTTP response size in bytes, including the status line and headers.", func(rm *routeMetrics) *histogram { return rm.responseSize }},
+	}
+	for _, h := range histograms {
+		e.header(h.name, "histogram", h.help)
+		for _, s := range all {
+			e.histogram(h.name, h.get(s.rm).snapshot(), "method", s.key.method, "route", s.key.route)
+		}
+	}
+
+	e.header("requests_in_flight", "gauge", "HTTP requests currently being handled.")
+	for i := range m.inFlight {
+		method := "OTHER"
+		if i < len(knownMethods) {
+			method = knownMethods[i]
+		}
+		e.sample("requests_in_flight", float64(m.inFlight[i].Load()), "method", method)
+	}
+
+	e.header("connections_active", "gauge", "Open client connections.")
+	e.sample("connections_active", float64(m.ActiveConnections.Load()))
+
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// expositionWriter writes metric lines, remembering the first error
+type expositionWriter struct {
+	w         *bufio.Writer
+	namespace string
+	err       error
+}
+
+func (e *expositionWriter) name(name string) string {
+	if e.namespace == "" {
+		return name
+	}
+	return e.namespace + "_" + name
+}
+
+func (e *expositionWriter) header(name, kind, help string) {
+	e.write("# HELP ", e.name(name), " ", help, "\n")
+	e.write("# TYPE ", e.name(name), " ", kind, "\n")
+}
+
+// sample writes one line; labels are name, value pairs
+func (e *expositionWriter) sample(name string, value float64, labels ...string) {
+	var line strings.Builder
+	line.WriteString(e.name(name))
+	if len(labels) > 0 {
+		line.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(labels[i])
+			line.WriteString(`="`)
+			line.WriteString(escapeLabelValue(labels[i+1]))
+			line.WriteByte('"')
+		}
+		line.WriteByte('}')
+	}
+	line.WriteByte(' ')
+	line.WriteString(formatFloat(value))
+	line.WriteByte('\n')
+	e.write(line.String())
+}
+
+func (e *expositionWriter) histogram(name string, h histogramSnapshot, labels ...string) {
+	withLE := make([]string, len(labels)+2)
+	copy(withLE, labels)
+	withLE[len(labels)] = "le"
+
+	for i, bound := range h.bounds {
+		withLE[len(labels)+1] = formatFloat(bound)
+		e.sample(name+"_bucket", float64(h.cumulative[i]), withLE...)
+	}
+	withLE[len(labels)+1] = "+Inf"
+	e.sample(name+"_bucket", float64(h.count), withLE...)
+	e.sample(name+"_sum", h.sum, labels...)
+	e.sample(name+"_count", float64(h.count), labels...)
+}
+
+func (e *expositionWriter) write(parts ...string) {
+	for _, part := range parts {
+		if e.err != nil {
+			return
+		}
+		_, e.err = e.w.WriteString(part)
+	}
+}
+
+// escapeLabelValue escapes backslash, double quote and newline
+func escapeLabelValue(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetrics() *Metrics {
+	m := NewMetricsWithConfig(MetricsConfig{
+		Namespace:      "app",
+		LatencyBuckets: []float64{0.1, 1},
+		SizeBuckets:    []float64{100},
+	})
+	m.Record(RequestInfo{Method: "GET", Route: "/users/:id", StatusCode: 200, Duration: 50 * time.Millisecond, ResponseSize: 80})
+	m.Record(RequestInfo{Method: "GET", Route: "/users/:id", StatusCode: 404, Duration: time.Second, RequestSize: 150, ResponseSize: 120})
+	return m
+}
+
+func TestWritePrometheusHistograms(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testMetrics().WritePrometheus(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, `# HELP app_requests_total Total HTTP requests by method, route and status code.
+# TYPE app_requests_total counter
+app_requests_total{method="GET",route="/users/:id",code="200"} 1
+app_requests_total{method="GET",route="/users/:id",code="404"} 1
+`)
+	assert.Contains(t, out, `# TYPE app_request_duration_seconds histogram
+app_request_duration_seconds_bucket{method="GET",route="/users/:id",le="0.1"} 1
+app_request_duration_seconds_bucket{method="GET",route="/users/:id",le="1"} 2
+app_request_duration_seconds_bucket{method="GET",route="/users/:id",le="+Inf"} 2
+app_request_duration_seconds_sum{method="GET",route="/users/:id"} 1.05
+app_request_duration_seconds_count{method="GET",route="/users/:id"} 2
+`)
+	assert.Contains(t, out, `# TYPE app_request_size_bytes histogram
+app_request_size_bytes_bucket{method="GET",route="/users/:id",le="100"} 1
+app_request_size_bytes_bucket{method="GET",route="/users/:id",le="+Inf"} 2
+app_request_size_bytes_sum{method="GET",route="/users/:id"} 150
+app_request_size_bytes_count{method="GET",route="/users/:id"} 2
+`)
+	assert.Contains(t, out, `app_response_size_bytes_sum{method="GET",route="/users/:id"} 200
+`)
+	assert.Contains(t, out, "app_requests_in_flight{method=\"OTHER\"} 0\n")
+	assert.True(t, strings.HasSuffix(out, "app_connections_active 0\n"))
+}
+
+func TestWritePrometheusSortsAndNormalizes(t *testing.T) {
+	m := NewMetricsWithConfig(MetricsConfig{LatencyBuckets: []float64{1}, SizeBuckets: []float64{1}})
+	m.Record(RequestInfo{Method: "POST", Route: "/b", StatusCode: 201})
+	m.Record(RequestInfo{Method: "BREW", Route: "/a", StatusCode: 500})
+	m.Record(RequestInfo{Method: "GET", Route: "/a", StatusCode: 200})
+	m.RequestStarted("PURGE")
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WritePrometheus(&buf))
+	out := buf.String()
+
+	// No namespace prefix; unknown methods share one label value
+	assert.Contains(t, out, `requests_total{method="GET",route="/a",code="200"} 1
+requests_total{method="OTHER",route="/a",code="500"} 1
+requests_total{method="POST",route="/b",code="201"} 1
+`)
+	assert.Contains(t, out, "requests_in_flight{method=\"OTHER\"} 1\n")
+}
+
+func TestPrometheusLabelEscaping(t *testing.T) {
+	m := NewMetricsWithConfig(MetricsConfig{LatencyBuckets: []float64{1}, SizeBuckets: []float64{1}})
+	m.Record(RequestInfo{Method: "GET", Route: "/a\"b\\c\nd", StatusCode: 200})
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), `requests_total{method="GET",route="/a\"b\\c\nd",code="200"} 1`+"\n")
+
+	tests := map[string]string{
+		"plain":      "plain",
+		`quote"`:     `quote\"`,
+		`back\slash`: `back\\slash`,
+		"new\nline":  `new\nline`,
+		`\"` + "\n":  `\\\"\n`,
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, escapeLabelValue(in), in)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := testMetrics()
+
+	ctx, out := newTestContext("GET", "/metrics", "")
+	m.Handler().ServeHTTP(ctx)
+	require.NoError(t, ctx.Response.Finish())
+
+	head, body, ok := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, ok)
+	assert.Contains(t, head, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, head, "\r\ncontent-type: "+PrometheusContentType+"\r\n")
+
+	var want bytes.Buffer
+	require.NoError(t, m.WritePrometheus(&want))
+	assert.Equal(t, want.String(), body)
+
+	ctx, out = newTestContext("HEAD", "/metrics", "")
+	m.Handler().ServeHTTP(ctx)
+	require.NoError(t, ctx.Response.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+}
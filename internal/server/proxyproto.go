@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	net "github.com/Brownie44l1/socket-wrapper"
+)
+
+var (
+	ErrNoProxySources      = errors.New("server: PROXY protocol enabled without ProxyProtocolSources")
+	ErrProxyHeaderMissing  = errors.New("server: PROXY protocol header required")
+	ErrInvalidProxyHeader  = errors.New("server: invalid PROXY protocol header")
+	ErrUntrustedProxyPeer  = errors.New("server: PROXY protocol peer not in ProxyProtocolSources")
+	errProxyHeaderTooShort = fmt.Errorf("%w: truncated", ErrInvalidProxyHeader)
+)
+
+// ProxyProtocolMode controls whether connections start with a PROXY
+// protocol header (HAProxy, AWS NLB and other TCP load balancers)
+type ProxyProtocolMode int
+
+const (
+	ProxyProtocolOff      ProxyProtocolMode = iota
+	ProxyProtocolOptional                   // Parse a header if one is sent
+	ProxyProtocolRequired                   // Close connections without one
+)
+
+// PROXY protocol v2 TLV types
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02 // Server name the client asked for (SNI)
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+const (
+	proxyV1Prefix  = "PROXY "
+	proxyV1MaxLen  = 107 // Longest v1 line, including CRLF
+	proxyV2HeadLen = 16
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyHeader is a parsed PROXY protocol header
+type ProxyHeader struct {
+	Version int // 1 or 2
+
+	// Local is set for v2 LOCAL commands and v1 UNKNOWN, typically health
+	// checks from the balancer itself; Source and Destination are then
+	// not set and the connection's own address is used
+	Local bool
+
+	Source      netip.AddrPort // Original client
+	Destination netip.AddrPort // Address the client connected to
+
+	TLVs []ProxyTLV // v2 only
+}
+
+// ProxyTLV is a v2 type-length-value extension
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first TLV of type typ
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyHeader returns the connection's PROXY protocol header, or nil if it
+// had none
+func (c *Context) ProxyHeader() *ProxyHeader {
+	conn := c.conn
+	if tc, ok := conn.(*tlsConn); ok {
+		conn = tc.inner()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.header
+	}
+	return nil
+}
+
+// proxyConn is a connection whose PROXY header has been read. Bytes read
+// past the header are replayed before the rest of the stream.
+type proxyConn struct {
+	net.Conn
+	header *ProxyHeader
+	rest   []byte
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if len(c.rest) > 0 {
+		n := copy(p, c.rest)
+		c.rest = c.rest[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the original client address from the header
+func (c *proxyConn) RemoteAddr() string {
+	if c.header != nil && c.header.Source.IsValid() {
+		return c.header.Source.String()
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// acceptProxyHeader reads the PROXY header at the start of conn according
+// to config. Peers outside ProxyProtocolSources may not send one.
+func acceptProxyHeader(conn net.Conn, config *Config) (net.Conn, error) {
+	if !proxySourceTrusted(conn.RemoteAddr(), config.ProxyProtocolSources) {
+		if config.ProxyProtocol == ProxyProtocolRequired {
+			return nil, ErrUntrustedProxyPeer
+		}
+		return conn, nil
+	}
+
+	if config.ReadTimeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(config.ReadTimeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	pr := &proxyReader{conn: conn}
+	header, err := pr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if header == nil && config.ProxyProtocol == ProxyProtocolRequired {
+		return nil, ErrProxyHeaderMissing
+	}
+	return &proxyConn{Conn: conn, header: header, rest: pr.buf[pr.off:]}, nil
+}
+
+func proxySourceTrusted(addr string, sources []netip.Prefix) bool {
+	ip, ok := parseNode(addr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range sources {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyReader reads just enough of a connection to parse its header
+type proxyReader struct {
+	conn net.Conn
+	buf  []byte
+	off  int // Start of the bytes after the header
+}
+
+// fill reads until at least n bytes are buffered
+func (r *proxyReader) fill(n int) error {
+	for len(r.buf) < n {
+		chunk := make([]byte, max(n-len(r.buf), 128))
+		m, err := r.conn.Read(chunk)
+		r.buf = append(r.buf, chunk[:m]...)
+		if err != nil && len(r.buf) < n {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHeader parses a v1 or v2 header. It returns nil without error if
+// the stream doesn't start with one, having read no further than needed to
+// tell.
+func (r *proxyReader) readHeader() (*ProxyHeader, error) {
+	// Only wait for more input while what has arrived could still be a
+	// header, so a short request without one isn't held up
+	for i := 1; i <= len(proxyV2Signature); i++ {
+		if err := r.fill(i); err != nil {
+			return nil, err
+		}
+		v1 := i <= len(proxyV1Prefix) && string(r.buf[:i]) == proxyV1Prefix[:i]
+		v2 := bytes.Equal(r.buf[:i], proxyV2Signature[:i])
+		switch {
+		case v1 && i == len(proxyV1Prefix):
+			return r.readV1()
+		case !v1 && !v2:
+			return nil, nil
+		}
+	}
+	return r.readV2()
+}
+
+func (r *proxyReader) readV1() (*ProxyHeader, error) {
+	var end int
+	for {
+		end = bytes.Index(r.buf, []byte("\r\n"))
+		if end >= 0 && end+2 <= proxyV1MaxLen {
+			break
+		}
+		if len(r.buf) >= proxyV1MaxLen {
+			return nil, fmt.Errorf("%w: v1 line too long", ErrInvalidProxyHeader)
+		}
+		if err := r.fill(len(r.buf) + 1); err != nil {
+			return nil, err
+		}
+	}
+	r.off = end + 2
+
+	fields := strings.Split(string(r.buf[len(proxyV1Prefix):end]), " ")
+	header := &ProxyHeader{Version: 1}
+	if fields[0] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("%w: v1 %q", ErrInvalidProxyHeader, r.buf[:end])
+	}
+
+	src, err1 := parseV1Addr(fields[1], fields[3], fields[0] == "TCP6")
+	dst, err2 := parseV1Addr(fields[2], fields[4], fields[0] == "TCP6")
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, fmt.Errorf("%w: v1: %v", ErrInvalidProxyHeader, err)
+	}
+	header.Source, header.Destination = src, dst
+	return header, nil
+}
+
+func parseV1Addr(ip, port string, v6 bool) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if addr.Is6() != v6 {
+		return netip.AddrPort{}, fmt.Errorf("address %s does not match protocol", ip)
+	}
+	// Ports are decimal without leading zeros
+	if port == "" || (len(port) > 1 && port[0] == '0') {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", port)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+func (r *proxyReader) readV2() (*ProxyHeader, error) {
+	if err := r.fill(proxyV2HeadLen); err != nil {
+		return nil, err
+	}
+	verCmd, family := r.buf[12], r.buf[13]
+	length := int(binary.BigEndian.Uint16(r.buf[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 version %d", ErrInvalidProxyHeader, verCmd>>4)
+	}
+	if err := r.fill(proxyV2HeadLen + length); err != nil {
+		return nil, err
+	}
+	raw := r.buf[:proxyV2HeadLen+length]
+	r.off = len(raw)
+	body := raw[proxyV2HeadLen:]
+
+	header := &ProxyHeader{Version: 2}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		header.Local = true
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: v2 command %d", ErrInvalidProxyHeader, verCmd&0x0f)
+	}
+
+	var addrLen int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		addrLen = 12
+	case 0x2: // AF_INET6
+		addrLen = 36
+	case 0x3: // AF_UNIX; no IP addresses to report
+		addrLen = 216
+	case 0x0: // AF_UNSPEC
+	default:
+		return nil, fmt.Errorf("%w: v2 address family %d", ErrInvalidProxyHeader, family>>4)
+	}
+	if len(body) < addrLen {
+		return nil, errProxyHeaderTooShort
+	}
+
+	if !header.Local {
+		switch family >> 4 {
+		case 0x1:
+			header.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[0:4])), binary.BigEndian.Uint16(body[8:10]))
+			header.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[4:8])), binary.BigEndian.Uint16(body[10:12]))
+		case 0x2:
+			header.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[0:16])), binary.BigEndian.Uint16(body[32:34]))
+			header.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[16:32])), binary.BigEndian.Uint16(body[34:36]))
+		default:
+			header.Local = true
+		}
+	}
+
+	crcOffset := -1
+	for pos := proxyV2HeadLen + addrLen; pos < len(raw); {
+		if len(raw)-pos < 3 {
+			return nil, errProxyHeaderTooShort
+		}
+		typ, n := raw[pos], int(binary.BigEndian.Uint16(raw[pos+1:pos+3]))
+		pos += 3
+		if len(raw)-pos < n {
+			return nil, errProxyHeaderTooShort
+		}
+		if typ == ProxyTLVCRC32C && crcOffset < 0 {
+			if n != 4 {
+				return nil, fmt.Errorf("%w: CRC32C length %d", ErrInvalidProxyHeader, n)
+			}
+			crcOffset = pos
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: typ, Value: raw[pos : pos+n : pos+n]})
+		pos += n
+	}
+
+	if crcOffset >= 0 && !validProxyCRC(raw, crcOffset) {
+		return nil, fmt.Errorf("%w: CRC32C mismatch", ErrInvalidProxyHeader)
+	}
+	return header, nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// validProxyCRC checks the CRC32C TLV at offset, which covers the whole
+// header with the checksum itself zeroed
+func validProxyCRC(raw []byte, offset int) bool {
+	want := binary.BigEndian.Uint32(raw[offset : offset+4])
+	crc := crc32.Update(0, castagnoli, raw[:offset])
+	crc = crc32.Update(crc, castagnoli, make([]byte, 4))
+	crc = crc32.Update(crc, castagnoli, raw[offset+4:])
+	return crc == want
+}
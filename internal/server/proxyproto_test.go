@@ -0,0 +1,341 @@
+package server
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const proxyTestRequest = "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+
+// proxyV2 builds a v2 header for cmd (0 LOCAL, 1 PROXY) and family
+func proxyV2(cmd, family byte, addrs []byte, tlvs ...ProxyTLV) []byte {
+	body := append([]byte(nil), addrs...)
+	for _, tlv := range tlvs {
+		body = append(body, tlv.Type)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(tlv.Value)))
+		body = append(body, tlv.Value...)
+	}
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|cmd, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+// proxyV2WithCRC builds a PROXY header ending in a CRC32C TLV
+func proxyV2WithCRC(family byte, addrs []byte) []byte {
+	raw := proxyV2(0x1, family, addrs, ProxyTLV{Type: ProxyTLVCRC32C, Value: make([]byte, 4)})
+	binary.BigEndian.PutUint32(raw[len(raw)-4:], crc32.Checksum(raw, castagnoli))
+	return raw
+}
+
+var (
+	proxyV2Inet = []byte{
+		198, 51, 100, 7, // Source
+		10, 0, 0, 1, // Destination
+		0xdc, 0x04, // 56324
+		0x01, 0xbb, // 443
+	}
+	proxyV2Inet6 = append(append(append(
+		netip.MustParseAddr("2001:db8::1").AsSlice(),
+		netip.MustParseAddr("2001:db8::2").AsSlice()...),
+		0xdc, 0x04), 0x01, 0xbb)
+)
+
+func proxyConfig(mode ProxyProtocolMode) *Config {
+	sources, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		panic(err)
+	}
+	return &Config{ProxyProtocol: mode, ProxyProtocolSources: sources}
+}
+
+// acceptProxy runs acceptProxyHeader on input from a trusted peer and
+// returns the header and the bytes left for the HTTP parser
+func acceptProxy(t *testing.T, mode ProxyProtocolMode, input string) (*ProxyHeader, string, error) {
+	t.Helper()
+	conn, err := acceptProxyHeader(newFakeConn(input, "10.0.0.5:4000"), proxyConfig(mode))
+	if err != nil {
+		return nil, "", err
+	}
+	pc, ok := conn.(*proxyConn)
+	require.True(t, ok)
+	rest, err := io.ReadAll(pc)
+	require.NoError(t, err)
+	return pc.header, string(rest), nil
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   *ProxyHeader
+		remote string
+	}{
+		{
+			name: "TCP4",
+			line: "PROXY TCP4 198.51.100.7 10.0.0.1 56324 443\r\n",
+			want: &ProxyHeader{
+				Version:     1,
+				Source:      netip.MustParseAddrPort("198.51.100.7:56324"),
+				Destination: netip.MustParseAddrPort("10.0.0.1:443"),
+			},
+			remote: "198.51.100.7:56324",
+		},
+		{
+			name: "TCP6",
+			line: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+			want: &ProxyHeader{
+				Version:     1,
+				Source:      netip.MustParseAddrPort("[2001:db8::1]:56324"),
+				Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+			},
+			remote: "[2001:db8::1]:56324",
+		},
+		{
+			name:   "UNKNOWN",
+			line:   "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n",
+			want:   &ProxyHeader{Version: 1, Local: true},
+			remote: "10.0.0.5:4000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := acceptProxyHeader(newFakeConn(tt.line+proxyTestRequest, "10.0.0.5:4000"), proxyConfig(ProxyProtocolRequired))
+			require.NoError(t, err)
+			assert.Equal(t, tt.remote, conn.RemoteAddr())
+
+			pc := conn.(*proxyConn)
+			assert.Equal(t, tt.want, pc.header)
+			rest, err := io.ReadAll(pc)
+			require.NoError(t, err)
+			assert.Equal(t, proxyTestRequest, string(rest))
+		})
+	}
+}
+
+func TestProxyProtocolV1Invalid(t *testing.T) {
+	for name, line := range map[string]string{
+		"too long":            "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		"missing port":        "PROXY TCP4 198.51.100.7 10.0.0.1 56324\r\n",
+		"unknown protocol":    "PROXY TCP5 198.51.100.7 10.0.0.1 56324 443\r\n",
+		"family mismatch":     "PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n",
+		"leading zero port":   "PROXY TCP4 198.51.100.7 10.0.0.1 056324 443\r\n",
+		"port out of range":   "PROXY TCP4 198.51.100.7 10.0.0.1 65536 443\r\n",
+		"double space":        "PROXY TCP4  198.51.100.7 10.0.0.1 56324 443\r\n",
+		"invalid source addr": "PROXY TCP4 198.51.100 10.0.0.1 56324 443\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := acceptProxy(t, ProxyProtocolRequired, line+proxyTestRequest)
+			assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+		})
+	}
+
+	// A line cut off by the connection closing
+	_, _, err := acceptProxy(t, ProxyProtocolRequired, "PROXY TCP4 198.51.100.7")
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	unix := make([]byte, 216)
+	copy(unix, "/var/run/client.sock")
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   *ProxyHeader
+	}{
+		{
+			name:   "PROXY over AF_INET",
+			header: proxyV2(0x1, 0x11, proxyV2Inet),
+			want: &ProxyHeader{
+				Version:     2,
+				Source:      netip.MustParseAddrPort("198.51.100.7:56324"),
+				Destination: netip.MustParseAddrPort("10.0.0.1:443"),
+			},
+		},
+		{
+			name:   "PROXY over AF_INET6",
+			header: proxyV2(0x1, 0x21, proxyV2Inet6),
+			want: &ProxyHeader{
+				Version:     2,
+				Source:      netip.MustParseAddrPort("[2001:db8::1]:56324"),
+				Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+			},
+		},
+		{
+			name:   "PROXY over AF_UNIX",
+			header: proxyV2(0x1, 0x31, unix),
+			want:   &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name:   "LOCAL over AF_INET",
+			header: proxyV2(0x0, 0x11, proxyV2Inet),
+			want:   &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name:   "LOCAL over AF_INET6",
+			header: proxyV2(0x0, 0x21, proxyV2Inet6),
+			want:   &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name:   "LOCAL over AF_UNSPEC",
+			header: proxyV2(0x0, 0x00, nil),
+			want:   &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name: "TLVs",
+			header: proxyV2(0x1, 0x11, proxyV2Inet,
+				ProxyTLV{Type: ProxyTLVALPN, Value: []byte("h2")},
+				ProxyTLV{Type: ProxyTLVNoop, Value: []byte{}},
+				ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.com")},
+			),
+			want: &ProxyHeader{
+				Version:     2,
+				Source:      netip.MustParseAddrPort("198.51.100.7:56324"),
+				Destination: netip.MustParseAddrPort("10.0.0.1:443"),
+				TLVs: []ProxyTLV{
+					{Type: ProxyTLVALPN, Value: []byte("h2")},
+					{Type: ProxyTLVNoop, Value: []byte{}},
+					{Type: ProxyTLVAuthority, Value: []byte("example.com")},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, rest, err := acceptProxy(t, ProxyProtocolRequired, string(tt.header)+proxyTestRequest)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, header)
+			assert.Equal(t, proxyTestRequest, rest)
+		})
+	}
+}
+
+func TestProxyProtocolV2TLVLookup(t *testing.T) {
+	raw := proxyV2(0x1, 0x11, proxyV2Inet,
+		ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.com")},
+		ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("second.example")},
+	)
+	header, _, err := acceptProxy(t, ProxyProtocolRequired, string(raw))
+	require.NoError(t, err)
+
+	value, ok := header.TLV(ProxyTLVAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(value))
+
+	_, ok = header.TLV(ProxyTLVUniqueID)
+	assert.False(t, ok)
+}
+
+func TestProxyProtocolV2CRC32C(t *testing.T) {
+	raw := proxyV2WithCRC(0x11, proxyV2Inet)
+	header, rest, err := acceptProxy(t, ProxyProtocolRequired, string(raw)+proxyTestRequest)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("198.51.100.7:56324"), header.Source)
+	assert.Equal(t, proxyTestRequest, rest)
+
+	// Flip a bit in the source address
+	raw[proxyV2HeadLen] ^= 1
+	_, _, err = acceptProxy(t, ProxyProtocolRequired, string(raw)+proxyTestRequest)
+	assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+}
+
+func TestProxyProtocolV2Invalid(t *testing.T) {
+	truncatedTLV := proxyV2(0x1, 0x11, proxyV2Inet, ProxyTLV{Type: ProxyTLVALPN, Value: []byte("h2")})
+	binary.BigEndian.PutUint16(truncatedTLV[len(truncatedTLV)-4:], 10) // TLV claims 10 bytes
+
+	partialTLV := proxyV2(0x1, 0x11, append(append([]byte(nil), proxyV2Inet...), ProxyTLVALPN, 0))
+
+	badVersion := proxyV2(0x1, 0x11, proxyV2Inet)
+	badVersion[12] = 0x31
+
+	badCommand := proxyV2(0x2, 0x11, proxyV2Inet)
+	badFamily := proxyV2(0x1, 0x41, proxyV2Inet)
+	shortAddrs := proxyV2(0x1, 0x21, proxyV2Inet)
+
+	badCRCLength := proxyV2(0x1, 0x11, proxyV2Inet, ProxyTLV{Type: ProxyTLVCRC32C, Value: []byte{1, 2}})
+
+	for name, raw := range map[string][]byte{
+		"truncated TLV":    truncatedTLV,
+		"partial TLV head": partialTLV,
+		"version":          badVersion,
+		"command":          badCommand,
+		"address family":   badFamily,
+		"short addresses":  shortAddrs,
+		"CRC32C length":    badCRCLength,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := acceptProxy(t, ProxyProtocolRequired, string(raw)+proxyTestRequest)
+			assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+		})
+	}
+
+	// The connection closes before the announced length arrives
+	raw := proxyV2(0x1, 0x11, proxyV2Inet)
+	_, _, err := acceptProxy(t, ProxyProtocolRequired, string(raw[:len(raw)-3]))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProxyProtocolOptional(t *testing.T) {
+	// No header: everything read while looking for one is replayed
+	header, rest, err := acceptProxy(t, ProxyProtocolOptional, proxyTestRequest)
+	require.NoError(t, err)
+	assert.Nil(t, header)
+	assert.Equal(t, proxyTestRequest, rest)
+
+	// A request that happens to start like a v2 signature
+	input := "\r\nGET / HTTP/1.1\r\n\r\n"
+	header, rest, err = acceptProxy(t, ProxyProtocolOptional, input)
+	require.NoError(t, err)
+	assert.Nil(t, header)
+	assert.Equal(t, input, rest)
+
+	// Headers are still parsed when sent
+	header, rest, err = acceptProxy(t, ProxyProtocolOptional, "PROXY TCP4 198.51.100.7 10.0.0.1 56324 443\r\n"+proxyTestRequest)
+	require.NoError(t, err)
+	require.NotNil(t, header)
+	assert.Equal(t, netip.MustParseAddrPort("198.51.100.7:56324"), header.Source)
+	assert.Equal(t, proxyTestRequest, rest)
+}
+
+func TestProxyProtocolRequired(t *testing.T) {
+	_, _, err := acceptProxy(t, ProxyProtocolRequired, proxyTestRequest)
+	assert.ErrorIs(t, err, ErrProxyHeaderMissing)
+}
+
+func TestProxyProtocolUntrustedPeer(t *testing.T) {
+	input := "PROXY TCP4 198.51.100.7 10.0.0.1 56324 443\r\n" + proxyTestRequest
+
+	_, err := acceptProxyHeader(newFakeConn(input, "203.0.113.9:4000"), proxyConfig(ProxyProtocolRequired))
+	assert.ErrorIs(t, err, ErrUntrustedProxyPeer)
+
+	// Optional mode passes the connection through untouched, so the header
+	// reaches the HTTP parser instead of being believed
+	raw := newFakeConn(input, "203.0.113.9:4000")
+	conn, err := acceptProxyHeader(raw, proxyConfig(ProxyProtocolOptional))
+	require.NoError(t, err)
+	assert.Same(t, raw, conn)
+	assert.Equal(t, "203.0.113.9:4000", conn.RemoteAddr())
+}
+
+func TestContextProxyHeader(t *testing.T) {
+	conn, err := acceptProxyHeader(newFakeConn("PROXY TCP4 198.51.100.7 10.0.0.1 56324 443\r\n", "10.0.0.5:4000"), proxyConfig(ProxyProtocolRequired))
+	require.NoError(t, err)
+
+	ctx, _ := newTestContext("GET", "/", "")
+	ctx.conn = conn
+	require.NotNil(t, ctx.ProxyHeader())
+	assert.Equal(t, "198.51.100.7", ctx.GetClientIP())
+
+	ctx.conn = newFakeConn("", "10.0.0.5:4000")
+	assert.Nil(t, ctx.ProxyHeader())
+}
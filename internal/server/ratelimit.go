@@ -0,0 +1,110 @@
+package server
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/Brownie44l1/http-1/internal/ratelimit"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// KeyFunc derives the rate limit key for a request. Returning "" exempts
+// the request.
+type KeyFunc func(ctx *Context) string
+
+// KeyByIP keys requests by client IP (see GetClientIP), so forwarding
+// headers only count when they come from Config.TrustedProxies
+func KeyByIP(ctx *Context) string {
+	return ctx.GetClientIP()
+}
+
+// KeyByHeader keys requests by a header value, such as an API key.
+// Requests without the header are exempt, so put authentication first.
+func KeyByHeader(name string) KeyFunc {
+	return func(ctx *Context) string {
+		return ctx.Header(name)
+	}
+}
+
+// KeyByRoute scopes key to the matched route, giving each route its own
+// quota. The route is only known inside the router, so use it on a router
+// group.
+func KeyByRoute(key KeyFunc) KeyFunc {
+	return func(ctx *Context) string {
+		k := key(ctx)
+		if k == "" {
+			return ""
+		}
+		return ctx.Method() + " " + ctx.Route() + "|" + k
+	}
+}
+
+// RateLimitConfig configures RateLimitMiddleware
+type RateLimitConfig struct {
+	Limiter ratelimit.Limiter
+	Key     KeyFunc // Defaults to KeyByIP
+
+	// OnLimited responds to rejected requests. The RateLimit and
+	// Retry-After headers are already set. Defaults to a 429.
+	OnLimited func(ctx *Context, res ratelimit.Result)
+}
+
+// RateLimitMiddleware rejects requests over the limiter's quota. Every
+// response carries RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers; rejected ones also get Retry-After. The
+// middleware can be stacked, e.g. globally and on a router group with a
+// tighter limit, in which case the headers describe whichever quota has
+// fewer requests remaining.
+func RateLimitMiddleware(config RateLimitConfig) Middleware {
+	if config.Key == nil {
+		config.Key = KeyByIP
+	}
+	if config.OnLimited == nil {
+		config.OnLimited = func(ctx *Context, res ratelimit.Result) {
+			ctx.Error(response.StatusTooManyRequests, "Rate limit exceeded")
+		}
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx *Context) {
+			key := config.Key(ctx)
+			if key == "" {
+				next.ServeHTTP(ctx)
+				return
+			}
+
+			res := config.Limiter.Allow(key)
+			setRateLimitHeaders(ctx, res)
+			if !res.Allowed {
+				config.OnLimited(ctx, res)
+				return
+			}
+
+			next.ServeHTTP(ctx)
+		})
+	}
+}
+
+// setRateLimitHeaders writes the headers from the IETF RateLimit header
+// fields draft, unless an outer limiter already reported a tighter quota
+func setRateLimitHeaders(ctx *Context, res ratelimit.Result) {
+	h := ctx.Response.Headers()
+	if prev, ok := h.Get("RateLimit-Remaining"); ok {
+		if n, err := strconv.Atoi(prev); err == nil && n < res.Remaining {
+			return
+		}
+	}
+
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset.Seconds()), 10))
+	h.Set("RateLimit-Policy", strconv.Itoa(res.Limit)+";w="+strconv.FormatInt(ceilSeconds(res.Window.Seconds()), 10))
+	if !res.Allowed {
+		// Rounded up so a client that waits exactly this long succeeds
+		h.Set("Retry-After", strconv.FormatInt(max(1, ceilSeconds(res.RetryAfter.Seconds())), 10))
+	}
+}
+
+func ceilSeconds(s float64) int64 {
+	return int64(math.Ceil(s))
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/ratelimit"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// fixedLimiter answers every request with result and records the keys
+type fixedLimiter struct {
+	result ratelimit.Result
+	keys   []string
+}
+
+func (l *fixedLimiter) Allow(key string) ratelimit.Result {
+	l.keys = append(l.keys, key)
+	return l.result
+}
+
+func (l *fixedLimiter) Close() {}
+
+// rateLimitServe runs a request from peer through mw and reports the
+// response headers, status and whether the handler ran
+func rateLimitServe(t *testing.T, mw Middleware, peer string, header ...string) (*headers.Headers, response.StatusCode, bool) {
+	t.Helper()
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	ctx, _ := newTestContext("GET", "/", "", append([]string{"Host", "example.com"}, header...)...)
+	ctx.conn = newFakeConn("", peer)
+	ctx.config = &Config{TrustedProxies: trusted}
+
+	called := false
+	mw(HandlerFunc(func(c *Context) {
+		called = true
+		c.String(response.StatusOK, "ok")
+	})).ServeHTTP(ctx)
+	return ctx.Response.Headers(), ctx.Response.StatusCode(), called
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter, err := ratelimit.NewSlidingWindowLog(ratelimit.PerMinute(2))
+	require.NoError(t, err)
+	defer limiter.Close()
+	mw := RateLimitMiddleware(RateLimitConfig{Limiter: limiter})
+
+	for i := 1; i <= 2; i++ {
+		h, status, called := rateLimitServe(t, mw, "203.0.113.9:5000")
+		assert.True(t, called)
+		assert.Equal(t, response.StatusOK, status)
+		assert.Equal(t, "2", headerValue(h, "RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(2-i), headerValue(h, "RateLimit-Remaining"))
+		assert.Equal(t, "2;w=60", headerValue(h, "RateLimit-Policy"))
+		assert.False(t, h.Has("Retry-After"))
+	}
+
+	h, status, called := rateLimitServe(t, mw, "203.0.113.9:5000")
+	assert.False(t, called)
+	assert.Equal(t, response.StatusTooManyRequests, status)
+	assert.Equal(t, "0", headerValue(h, "RateLimit-Remaining"))
+	retry, err := strconv.Atoi(headerValue(h, "Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 60, retry, 1)
+
+	// Other clients have their own quota
+	_, status, _ = rateLimitServe(t, mw, "203.0.113.10:5000")
+	assert.Equal(t, response.StatusOK, status)
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	limiter := &fixedLimiter{result: ratelimit.Result{
+		Limit:      10,
+		Remaining:  0,
+		Window:     time.Minute,
+		Reset:      1500 * time.Millisecond,
+		RetryAfter: 200 * time.Millisecond,
+	}}
+	h, status, _ := rateLimitServe(t, RateLimitMiddleware(RateLimitConfig{Limiter: limiter}), "203.0.113.9:5000")
+
+	assert.Equal(t, response.StatusTooManyRequests, status)
+	assert.Equal(t, "10", headerValue(h, "RateLimit-Limit"))
+	assert.Equal(t, "0", headerValue(h, "RateLimit-Remaining"))
+	assert.Equal(t, "2", headerValue(h, "RateLimit-Reset")) // Rounded up
+	assert.Equal(t, "10;w=60", headerValue(h, "RateLimit-Policy"))
+	assert.Equal(t, "1", headerValue(h, "Retry-After")) // Never 0
+
+	// A custom response still gets the headers
+	var seen ratelimit.Result
+	mw := RateLimitMiddleware(RateLimitConfig{Limiter: limiter, OnLimited: func(ctx *Context, res ratelimit.Result) {
+		seen = res
+		ctx.Error(response.StatusServiceUnavailable, "busy")
+	}})
+	h, status, _ = rateLimitServe(t, mw, "203.0.113.9:5000")
+	assert.Equal(t, response.StatusServiceUnavailable, status)
+	assert.Equal(t, limiter.result, seen)
+	assert.Equal(t, "1", headerValue(h, "Retry-After"))
+
+	// Stacked limiters report the tighter quota
+	outer := &fixedLimiter{result: ratelimit.Result{Allowed: true, Limit: 100, Remaining: 50, Window: time.Hour}}
+	inner := &fixedLimiter{result: ratelimit.Result{Allowed: true, Limit: 10, Remaining: 9, Window: time.Minute}}
+	stacked := func(next Handler) Handler {
+		return RateLimitMiddleware(RateLimitConfig{Limiter: outer})(RateLimitMiddleware(RateLimitConfig{Limiter: inner})(next))
+	}
+	h, _, called := rateLimitServe(t, stacked, "203.0.113.9:5000")
+	assert.True(t, called)
+	assert.Equal(t, "9", headerValue(h, "RateLimit-Remaining"))
+	assert.Equal(t, "10;w=60", headerValue(h, "RateLimit-Policy"))
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	limiter := &fixedLimiter{result: ratelimit.Result{Allowed: true, Limit: 1, Remaining: 1}}
+	byIP := RateLimitMiddleware(RateLimitConfig{Limiter: limiter})
+
+	// Forwarding headers only count from a trusted proxy
+	rateLimitServe(t, byIP, "203.0.113.9:5000", "X-Forwarded-For", "198.51.100.7")
+	rateLimitServe(t, byIP, "10.0.0.1:5000", "X-Forwarded-For", "6.6.6.6, 198.51.100.7")
+	rateLimitServe(t, byIP, "[::ffff:203.0.113.9]:5000")
+	assert.Equal(t, []string{"203.0.113.9", "198.51.100.7", "203.0.113.9"}, limiter.keys)
+
+	// Requests without a key are exempt and get no headers
+	limiter.keys = nil
+	byHeader := RateLimitMiddleware(RateLimitConfig{Limiter: limiter, Key: KeyByHeader("X-API-Key")})
+	h, _, called := rateLimitServe(t, byHeader, "203.0.113.9:5000")
+	assert.True(t, called)
+	assert.False(t, h.Has("RateLimit-Limit"))
+	rateLimitServe(t, byHeader, "203.0.113.9:5000", "X-API-Key", "k1")
+	assert.Equal(t, []string{"k1"}, limiter.keys)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+// securityServe runs a request from peer through SecurityHeadersMiddleware
+// and returns the response headers and the nonce the handler saw
+func securityServe(t *testing.T, config SecurityHeadersConfig, handler HandlerFunc, peer string, header ...string) (*headers.Headers, string) {
+	t.Helper()
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	ctx, _ := newTestContext("GET", "/", "", append([]string{"Host", "example.com"}, header...)...)
+	ctx.conn = newFakeConn("", peer)
+	ctx.config = &Config{TrustedProxies: trusted}
+
+	var nonce string
+	SecurityHeadersMiddleware(config)(HandlerFunc(func(c *Context) {
+		nonce = c.CSPNonce()
+		if handler != nil {
+			handler(c)
+		}
+		c.String(response.StatusOK, "ok")
+	})).ServeHTTP(ctx)
+	return ctx.Response.Headers(), nonce
+}
+
+func TestSecurityHeadersDefaults(t *testing.T) {
+	h, nonce := securityServe(t, DefaultSecurityHeadersConfig(), nil, "203.0.113.9:5000")
+
+	assert.Equal(t, "nosniff", headerValue(h, "X-Content-Type-Options"))
+	assert.Equal(t, "DENY", headerValue(h, "X-Frame-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", headerValue(h, "Referrer-Policy"))
+	assert.Equal(t, "camera=(), microphone=(), geolocation=()", headerValue(h, "Permissions-Policy"))
+	assert.Contains(t, headerValue(h, "Content-Security-Policy"), "default-src 'self'")
+	assert.NotEmpty(t, nonce)
+
+	// Plain HTTP from the client itself: no HSTS
+	assert.False(t, h.Has("Strict-Transport-Security"))
+
+	// Empty fields leave their header out
+	h, _ = securityServe(t, SecurityHeadersConfig{ContentTypeNosniff: true}, nil, "203.0.113.9:5000")
+	assert.Equal(t, "nosniff", headerValue(h, "X-Content-Type-Options"))
+	for _, name := range []string{"Strict-Transport-Security", "Content-Security-Policy", "X-Frame-Options", "Referrer-Policy", "Permissions-Policy"} {
+		assert.False(t, h.Has(name), name)
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	config := SecurityHeadersConfig{HSTSMaxAge: 365 * 24 * time.Hour, HSTSIncludeSubdomains: true, HSTSPreload: true}
+	const want = "max-age=31536000; includeSubDomains; preload"
+
+	tests := []struct {
+		name   string
+		force  bool
+		peer   string
+		header []string
+		sent   bool
+	}{
+		{"plain HTTP", false, "203.0.113.9:5000", nil, false},
+		{"trusted proxy terminated TLS", false, "10.0.0.1:5000", []string{"X-Forwarded-For", "198.51.100.7", "X-Forwarded-Proto", "https"}, true},
+		{"trusted proxy over HTTP", false, "10.0.0.1:5000", []string{"X-Forwarded-For", "198.51.100.7", "X-Forwarded-Proto", "http"}, false},
+		{"client claims HTTPS", false, "203.0.113.9:5000", []string{"X-Forwarded-For", "198.51.100.7", "X-Forwarded-Proto", "https"}, false},
+		{"forced", true, "203.0.113.9:5000", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := config
+			config.ForceHSTS = tt.force
+			h, _ := securityServe(t, config, nil, tt.peer, tt.header...)
+			if tt.sent {
+				assert.Equal(t, want, headerValue(h, "Strict-Transport-Security"))
+			} else {
+				assert.False(t, h.Has("Strict-Transport-Security"))
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersHandlerOverrides(t *testing.T) {
+	h, _ := securityServe(t, DefaultSecurityHeadersConfig(), func(c *Context) {
+		c.Response.Headers().Set("X-Frame-Options", "SAMEORIGIN")
+		c.Response.Headers().Set("Content-Security-Policy", "frame-ancestors https://partner.example")
+	}, "203.0.113.9:5000")
+
+	assert.Equal(t, []string{"SAMEORIGIN"}, h.GetAll("X-Frame-Options"))
+	assert.Equal(t, []string{"frame-ancestors https://partner.example"}, h.GetAll("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", headerValue(h, "X-Content-Type-Options"))
+}
+
+func TestSecurityHeadersCSPNonce(t *testing.T) {
+	config := SecurityHeadersConfig{ContentSecurityPolicy: "script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'"}
+
+	h, nonce := securityServe(t, config, nil, "203.0.113.9:5000")
+	require.Regexp(t, `^[A-Za-z0-9+/]{22}==$`, nonce) // 128 bits
+	assert.Equal(t, "script-src 'nonce-"+nonce+"'; style-src 'nonce-"+nonce+"'", headerValue(h, "Content-Security-Policy"))
+
+	// Fresh for every request
+	_, again := securityServe(t, config, nil, "203.0.113.9:5000")
+	assert.NotEqual(t, nonce, again)
+
+	// Report-only policies get one too
+	config.CSPReportOnly = true
+	h, nonce = securityServe(t, config, nil, "203.0.113.9:5000")
+	assert.False(t, h.Has("Content-Security-Policy"))
+	assert.Regexp(t, regexp.QuoteMeta("'nonce-"+nonce+"'"), headerValue(h, "Content-Security-Policy-Report-Only"))
+
+	// And a policy without the placeholder none
+	h, nonce = securityServe(t, SecurityHeadersConfig{ContentSecurityPolicy: "default-src 'self'"}, nil, "203.0.113.9:5000")
+	assert.Empty(t, nonce)
+	assert.Equal(t, "default-src 'self'", headerValue(h, "Content-Security-Policy"))
+}
@@ -5,11 +5,14 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net/netip"
 	"sync"
 	"time"
 
+	"github.com/Brownie44l1/http-1/internal/response"
 	net "github.com/Brownie44l1/socket-wrapper"
 )
 
@@ -30,6 +33,41 @@ type Config struct {
 	// Request limits (DoS protection)
 	MaxRequestsPerConn int           // Max requests per connection
 	RequestTimeout     time.Duration // Total time for request including body
+
+	// MaxPipelineDepth caps how many pipelined requests (sent before the
+	// previous response) are answered in a row; the last one gets
+	// Connection: close and the client retries the rest. 0 means no limit.
+	MaxPipelineDepth int
+
+	// Responses
+	ResponseBufferSize int    // Body bytes Context.Write holds back to set Content-Length before chunking
+	ServerName         string // Server header; "" omits it
+
+	// Form parsing (see Context.ParseForm)
+	MaxFormMemory   int64  // Form bytes held in memory; larger uploads spill to disk
+	MaxFormFileSize int64  // Max size of one uploaded file; 0 means MaxRequestBodySize
+	MaxFormParts    int    // Max parts in a multipart body
+	FormTempDir     string // Directory for spilled uploads; "" means os.TempDir
+
+	// TLS, when set, makes ListenAndServe terminate TLS on every accepted
+	// connection. Set ClientAuth and ClientCAs for mutual TLS; the verified
+	// client certificate is available from Context.ClientCertificate.
+	TLS *tls.Config
+
+	// TrustedProxies lists the load balancers and reverse proxies whose
+	// forwarding headers are believed (see ParseTrustedProxies). Leave it
+	// empty when clients connect directly, or anyone can forge their address.
+	TrustedProxies []netip.Prefix
+
+	// ForwardedHeader names the one header TrustedProxies set. Only that
+	// header is read; proxies pass the others through from the client.
+	ForwardedHeader ForwardedHeader
+
+	// ProxyProtocol reads a PROXY protocol v1 or v2 header from TCP load
+	// balancers, making Context.RemoteAddr the original client. Headers
+	// are only accepted from ProxyProtocolSources, which must be set.
+	ProxyProtocol        ProxyProtocolMode
+	ProxyProtocolSources []netip.Prefix
 }
 
 // DefaultConfig returns sensible defaults
@@ -46,6 +84,11 @@ func DefaultConfig() *Config {
 		DeferAccept:        1 * time.Second, // Optimize for HTTP
 		MaxRequestsPerConn: 1000,             // Prevent infinite keep-alive
 		RequestTimeout:     30 * time.Second,
+		MaxPipelineDepth:   16,
+		ResponseBufferSize: response.DefaultBufferSize,
+		ServerName:         "http-1",
+		MaxFormMemory:      1 << 20, // 1MB
+		MaxFormParts:       1000,
 	}
 }
 
@@ -53,6 +96,7 @@ type Server struct {
 	config   *Config
 	handler  Handler
 	listener net.Listener
+	tlsConfig *tls.Config // Non-nil when serving HTTPS
 	metrics *Metrics
 	logger  Logger
 	mu       sync.RWMutex
@@ -134,8 +178,50 @@ func (s *Server) buildHandler() Handler {
 	return handler
 }
 
-// ListenAndServe starts the server using custom net library
+// ListenAndServe starts the server using custom net library. If
+// Config.TLS is set, connections are served over TLS.
 func (s *Server) ListenAndServe() error {
+	if s.config.TLS != nil {
+		tlsConfig, err := newTLSConfig(s.config.TLS, nil)
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = tlsConfig
+	}
+	return s.listenAndServe()
+}
+
+// ListenAndServeTLS starts an HTTPS server with the certificate and key
+// in the given PEM files. The files are reloaded when they change or the
+// process receives SIGHUP. They may be empty if Config.TLS already
+// provides certificates.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	var certs *CertReloader
+	if certFile != "" || keyFile != "" {
+		certs = NewCertReloader()
+		if err := certs.Add(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(s.config.TLS, certs)
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = tlsConfig
+
+	if certs != nil {
+		go certs.Watch(s.ctx, certCheckInterval)
+	}
+	return s.listenAndServe()
+}
+
+// listenAndServe creates the listener and runs the accept loop
+func (s *Server) listenAndServe() error {
+	if s.config.ProxyProtocol != ProxyProtocolOff && len(s.config.ProxyProtocolSources) == 0 {
+		return ErrNoProxySources
+	}
+
 	// Create network configuration using fluent API
 	netConfig := net.DefaultConfig().
 		WithPort(s.config.Port).
@@ -150,7 +236,11 @@ func (s *Server) ListenAndServe() error {
 	}
 
 	s.listener = listener
-	log.Printf("HTTP server listening on %s", listener.Addr())
+	if s.tlsConfig != nil {
+		log.Printf("HTTPS server listening on %s", listener.Addr())
+	} else {
+		log.Printf("HTTP server listening on %s", listener.Addr())
+	}
 
 	return s.serve()
 }
@@ -258,7 +348,29 @@ func (s *Server) handleConn(conn net.Conn, handler Handler) {
 	shuttingDown := s.shutdown
 	s.mu.RUnlock()
 
-	handleConnection(conn, handler, s.config, s.metrics, s.logger, shuttingDown)
+	// The PROXY header and TLS handshake are read here rather than in the
+	// accept loop so a slow client cannot hold up other connections
+	if s.config.ProxyProtocol != ProxyProtocolOff {
+		pc, err := acceptProxyHeader(conn, s.config)
+		if err != nil {
+			s.logger.Debug("PROXY protocol header rejected", Field{"error", err}, Field{"remote_addr", conn.RemoteAddr()})
+			conn.Close()
+			return
+		}
+		conn = pc
+	}
+
+	if s.tlsConfig != nil {
+		tc := newTLSConn(conn, s.tlsConfig)
+		if err := tc.handshake(s.ctx, s.config.ReadTimeout); err != nil {
+			s.logger.Debug("TLS handshake failed", Field{"error", err}, Field{"remote_addr", conn.RemoteAddr()})
+			conn.Close()
+			return
+		}
+		conn = tc
+	}
+
+	handleConnection(s.ctx, conn, handler, s.config, s.metrics, s.logger, shuttingDown)
 }
 
 // Metrics returns server metrics
@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/response"
+)
+
+func TestShutdownCancelsHandlers(t *testing.T) {
+	started := make(chan struct{})
+	errs := make(chan error, 1)
+	s := New(&Config{}, nil)
+	handler := HandlerFunc(func(c *Context) {
+		close(started)
+		<-c.Context().Done()
+		errs <- c.Context().Err()
+		c.String(response.StatusOK, "bye")
+	})
+
+	conn, client := newPipeConn()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.handleConn(conn, handler)
+	}()
+
+	_, err := io.WriteString(client, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+	wait(t, started, "handler")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.ErrorIs(t, wait(t, errs, "handler"), context.Canceled)
+
+	// The handler still gets to respond
+	status, err := bufio.NewReader(client).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+
+	client.Close()
+	wait(t, done, "connection to close")
+}
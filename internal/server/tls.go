@@ -0,0 +1,269 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	stdnet "net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	net "github.com/Brownie44l1/socket-wrapper"
+)
+
+// certCheckInterval is how often ListenAndServeTLS checks the certificate
+// files for changes
+const certCheckInterval = 30 * time.Second
+
+var ErrNoCertificates = errors.New("server: TLS enabled without certificates")
+
+// newTLSConfig prepares the TLS settings the server listens with. certs is
+// used when base has no certificates of its own.
+func newTLSConfig(base *tls.Config, certs *CertReloader) (*tls.Config, error) {
+	var config *tls.Config
+	if base != nil {
+		config = base.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+
+	if certs != nil && len(config.Certificates) == 0 && config.GetCertificate == nil {
+		config.GetCertificate = certs.GetCertificate
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, ErrNoCertificates
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	// We only speak HTTP/1.1, so that is all ALPN may negotiate
+	config.NextProtos = []string{"http/1.1"}
+	return config, nil
+}
+
+// tlsConn is a server-side TLS connection over a socket-wrapper connection
+type tlsConn struct {
+	*tls.Conn
+	remoteAddr string
+}
+
+func newTLSConn(conn net.Conn, config *tls.Config) *tlsConn {
+	return &tlsConn{
+		Conn:       tls.Server(stdConn{conn}, config),
+		remoteAddr: conn.RemoteAddr(),
+	}
+}
+
+// RemoteAddr returns the peer address (ip:port)
+func (c *tlsConn) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// inner returns the connection TLS runs over
+func (c *tlsConn) inner() net.Conn {
+	return c.NetConn().(stdConn).Conn
+}
+
+// handshake runs the TLS handshake, bounded by timeout if it is positive
+func (c *tlsConn) handshake(ctx context.Context, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return c.HandshakeContext(ctx)
+}
+
+// stdConn adapts a socket-wrapper connection to the standard library's
+// net.Conn, which crypto/tls requires
+type stdConn struct {
+	net.Conn
+}
+
+func (c stdConn) LocalAddr() stdnet.Addr { return tcpAddr("") }
+
+func (c stdConn) RemoteAddr() stdnet.Addr { return tcpAddr(c.Conn.RemoteAddr()) }
+
+func (c stdConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// tcpAddr is an address string reported as a net.Addr
+type tcpAddr string
+
+func (a tcpAddr) Network() string { return "tcp" }
+func (a tcpAddr) String() string  { return string(a) }
+
+// CertReloader holds certificates loaded from disk. It picks a certificate
+// by SNI server name and can reload the files without a restart, so
+// renewed certificates are picked up by new connections.
+type CertReloader struct {
+	mu      sync.RWMutex
+	files   []certFiles
+	certs   []*tls.Certificate
+	byName  map[string]*tls.Certificate // Lowercase DNS names, "*.example.com" for wildcards
+	modTime time.Time                   // Newest file modification time at the last load
+}
+
+type certFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// NewCertReloader creates an empty reloader; add certificates with Add
+func NewCertReloader() *CertReloader {
+	return &CertReloader{byName: make(map[string]*tls.Certificate)}
+}
+
+// Add loads a certificate and key pair. The first pair added is served to
+// clients that send no SNI name or one that no certificate covers.
+func (r *CertReloader) Add(certFile, keyFile string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := append(append([]certFiles(nil), r.files...), certFiles{certFile, keyFile})
+	return r.loadLocked(files)
+}
+
+// Reload reads every certificate again. On error the previous
+// certificates are kept.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked(r.files)
+}
+
+// loadLocked replaces the loaded certificates with files, or changes
+// nothing if any of them fails to load
+func (r *CertReloader) loadLocked(files []certFiles) error {
+	certs := make([]*tls.Certificate, 0, len(files))
+	byName := make(map[string]*tls.Certificate)
+	var newest time.Time
+
+	for _, f := range files {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate %s: %w", f.certFile, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("parse certificate %s: %w", f.certFile, err)
+			}
+		}
+
+		certs = append(certs, &cert)
+		for _, name := range certNames(cert.Leaf) {
+			if _, taken := byName[name]; !taken {
+				byName[name] = &cert
+			}
+		}
+
+		for _, file := range []string{f.certFile, f.keyFile} {
+			if info, err := os.Stat(file); err == nil && info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+		}
+	}
+
+	r.files = files
+	r.certs = certs
+	r.byName = byName
+	r.modTime = newest
+	return nil
+}
+
+// certNames returns the lowercase DNS names a certificate covers
+func certNames(leaf *x509.Certificate) []string {
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = strings.ToLower(name)
+	}
+	return out
+}
+
+// GetCertificate selects a certificate for a handshake; use it as
+// tls.Config.GetCertificate. An exact name match wins over a wildcard.
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.certs) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := r.byName[name]; ok {
+		return cert, nil
+	}
+	if idx := strings.IndexByte(name, '.'); idx > 0 {
+		if cert, ok := r.byName["*"+name[idx:]]; ok {
+			return cert, nil
+		}
+	}
+	return r.certs[0], nil
+}
+
+// changed reports whether any certificate file was modified since the last
+// load
+func (r *CertReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, f := range r.files {
+		for _, file := range []string{f.certFile, f.keyFile} {
+			if info, err := os.Stat(file); err == nil && info.ModTime().After(r.modTime) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Watch reloads the certificates on SIGHUP and whenever the files change,
+// checking every interval, until ctx is done. Failed reloads are logged
+// and the old certificates stay in use.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("TLS certificate reload failed: %v", err)
+		} else {
+			log.Println("TLS certificates reloaded")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for names to dir, identified
+// by its common name, and returns the certificate and key paths
+func writeCert(t *testing.T, dir, commonName string, names ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, commonName+".crt")
+	keyFile := filepath.Join(dir, commonName+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// replaceCert overwrites certFile and keyFile with a new certificate and
+// dates them age from now
+func replaceCert(t *testing.T, certFile, keyFile, commonName string, age time.Duration) {
+	t.Helper()
+	dir := t.TempDir()
+	newCert, newKey := writeCert(t, dir, commonName, commonName)
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		require.NoError(t, os.Rename(src, dst))
+		mtime := time.Now().Add(age)
+		require.NoError(t, os.Chtimes(dst, mtime, mtime))
+	}
+}
+
+func servedName(t *testing.T, r *CertReloader, serverName string) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	require.NoError(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+// serves reports whether r serves the certificate named commonName for it.
+// Unlike servedName it is safe to call from an Eventually condition.
+func serves(r *CertReloader, commonName string) bool {
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: commonName})
+	return err == nil && cert.Leaf.Subject.CommonName == commonName
+}
+
+func TestCertReloaderSNI(t *testing.T) {
+	dir := t.TempDir()
+	r := NewCertReloader()
+	_, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example"})
+	assert.ErrorIs(t, err, ErrNoCertificates)
+
+	for _, cert := range [][]string{
+		{"default", "a.example", "www.a.example"},
+		{"wildcard-b", "*.b.example"},
+		{"wildcard-c", "*.c.example"},
+		{"api-c", "api.c.example"},
+		{"cn-only"},
+	} {
+		require.NoError(t, r.Add(writeCert(t, dir, cert[0], cert[1:]...)))
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"a.example", "default"},
+		{"WWW.A.Example.", "default"},
+		{"x.b.example", "wildcard-b"},
+		{"b.example", "default"},     // A wildcard doesn't cover the bare domain
+		{"y.x.b.example", "default"}, // Or more than one label
+		{"api.c.example", "api-c"},   // Exact names win over wildcards
+		{"web.c.example", "wildcard-c"},
+		{"cn-only", "cn-only"}, // Common name used when there are no SANs
+		{"unknown.example", "default"},
+		{"", "default"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, servedName(t, r, tt.serverName), tt.serverName)
+	}
+
+	// A pair that fails to load leaves the others in place
+	assert.Error(t, r.Add(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key")))
+	assert.Equal(t, "api-c", servedName(t, r, "api.c.example"))
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "v1", "v1")
+	r := NewCertReloader()
+	require.NoError(t, r.Add(certFile, keyFile))
+	assert.False(t, r.changed())
+
+	replaceCert(t, certFile, keyFile, "v2", time.Minute)
+	assert.True(t, r.changed())
+	assert.Equal(t, "v1", servedName(t, r, "v1"))
+
+	require.NoError(t, r.Reload())
+	assert.False(t, r.changed())
+	assert.Equal(t, "v2", servedName(t, r, "v2"))
+
+	// A broken file is reported and the loaded certificate kept
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, "v2", servedName(t, r, "v2"))
+}
+
+func TestCertReloaderWatchModTime(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "v1", "v1")
+	r := NewCertReloader()
+	require.NoError(t, r.Add(certFile, keyFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	replaceCert(t, certFile, keyFile, "v2", time.Minute)
+	assert.Eventually(t, func() bool { return serves(r, "v2") }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestCertReloaderWatchSIGHUP(t *testing.T) {
+	// Keep SIGHUP from terminating the test binary before Watch handles it
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	certFile, keyFile := writeCert(t, t.TempDir(), "v1", "v1")
+	r := NewCertReloader()
+	require.NoError(t, r.Add(certFile, keyFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, time.Hour)
+
+	// Files dated in the past don't look changed, so only SIGHUP reloads
+	replaceCert(t, certFile, keyFile, "v2", -time.Hour)
+	require.False(t, r.changed())
+
+	assert.Eventually(t, func() bool {
+		// Watch may not be listening yet; keep signalling until it is
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		return serves(r, "v2")
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestSecurityHeadersHSTSOverTLS(t *testing.T) {
+	ctx, _ := newTestContext("GET", "/", "", "Host", "example.com")
+	ctx.conn = newTLSConn(newFakeConn("", "203.0.113.9:5000"), &tls.Config{})
+	ctx.config = &Config{}
+
+	SecurityHeadersMiddleware(DefaultSecurityHeadersConfig())(HandlerFunc(func(*Context) {})).ServeHTTP(ctx)
+	assert.Equal(t, "max-age=31536000; includeSubDomains", headerValue(ctx.Response.Headers(), "Strict-Transport-Security"))
+}
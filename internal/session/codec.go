@@ -0,0 +1,108 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// codec signs and optionally encrypts the session ID carried in the cookie.
+// The signature covers the cookie name, so a value cannot be replayed
+// under another cookie.
+type codec struct {
+	hashKey []byte
+	aead    cipher.AEAD // nil when encryption is off
+}
+
+func newCodec(hashKey, blockKey []byte) (*codec, error) {
+	if len(hashKey) < 32 {
+		return nil, fmt.Errorf("%w: HashKey must be at least 32 bytes", ErrInvalidKey)
+	}
+	c := &codec{hashKey: hashKey}
+	if len(blockKey) == 0 {
+		return c, nil
+	}
+
+	block, err := aes.NewCipher(blockKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: BlockKey: %v", ErrInvalidKey, err)
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, fmt.Errorf("%w: BlockKey: %v", ErrInvalidKey, err)
+	}
+	return c, nil
+}
+
+// encode turns a session ID into a cookie value: payload "." signature,
+// where payload is the ID or, with encryption, nonce+ciphertext
+func (c *codec) encode(name, id string) (string, error) {
+	payload := id
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := c.aead.Seal(nonce, nonce, []byte(id), []byte(name))
+		payload = base64.RawURLEncoding.EncodeToString(sealed)
+	}
+	return payload + "." + c.sign(name, payload), nil
+}
+
+// decode verifies a cookie value and returns the session ID in it
+func (c *codec) decode(name, value string) (string, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(c.sign(name, payload))) {
+		return "", ErrInvalidCookie
+	}
+	if c.aead == nil {
+		return payload, nil
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrInvalidCookie
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	id, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	return string(id), nil
+}
+
+func (c *codec) sign(name, payload string) string {
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newID returns a random session ID
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validID reports whether id looks like one made by newID, which keeps
+// stores from seeing arbitrary strings (e.g. as file names)
+func validID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' || b == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,364 @@
+// Package session provides server-side sessions. The cookie only carries
+// a signed (and optionally encrypted) random session ID; the data lives in
+// a Store.
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+var (
+	ErrNotFound      = errors.New("session: not found")
+	ErrInvalidCookie = errors.New("session: invalid cookie")
+	ErrInvalidKey    = errors.New("session: invalid key")
+)
+
+// contextKey is where the middleware stores the Session on server.Context
+const contextKey = "session"
+
+// Config configures session handling
+type Config struct {
+	CookieName string
+
+	// HashKey signs the cookie with HMAC-SHA256; at least 32 random bytes.
+	// BlockKey, if set, also encrypts it with AES-GCM and must be 16, 24
+	// or 32 bytes.
+	HashKey  []byte
+	BlockKey []byte
+
+	Store Store // Defaults to a MemoryStore
+
+	IdleTimeout     time.Duration // Expire after this long without a request
+	AbsoluteTimeout time.Duration // Expire this long after creation regardless
+
+	// Cookie attributes
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite headers.SameSite
+
+	// ErrorHandler is told about store failures. Defaults to logging.
+	ErrorHandler func(ctx *server.Context, err error)
+}
+
+// DefaultConfig returns secure defaults; HashKey must still be set
+func DefaultConfig() Config {
+	return Config{
+		CookieName:      "session",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+		Path:            "/",
+		Secure:          true,
+		HttpOnly:        true,
+		SameSite:        headers.SameSiteLax,
+	}
+}
+
+// Manager loads and saves sessions for requests
+type Manager struct {
+	config Config
+	codec  *codec
+}
+
+// New creates a Manager. Zero timeouts and an empty cookie name take the
+// DefaultConfig values.
+func New(config Config) (*Manager, error) {
+	defaults := DefaultConfig()
+	if config.CookieName == "" {
+		config.CookieName = defaults.CookieName
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaults.IdleTimeout
+	}
+	if config.AbsoluteTimeout <= 0 {
+		config.AbsoluteTimeout = defaults.AbsoluteTimeout
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore(time.Minute)
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = func(ctx *server.Context, err error) {
+			log.Printf("session: %v (request %s)", err, ctx.RequestID)
+		}
+	}
+
+	codec, err := newCodec(config.HashKey, config.BlockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{config: config, codec: codec}
+	if err := m.cookie("", 0).Valid(); err != nil {
+		return nil, fmt.Errorf("session cookie: %w", err)
+	}
+	return m, nil
+}
+
+// Middleware loads the request's session and saves it just before the
+// response headers are written, setting the cookie when the ID changes.
+// Handlers get it with FromContext. A session is only created once
+// something is stored in it.
+func (m *Manager) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return server.HandlerFunc(func(ctx *server.Context) {
+			sess := m.load(ctx)
+			ctx.Set(contextKey, sess)
+
+			ctx.Response.OnHeaders(func(code response.StatusCode, h *headers.Headers) {
+				if err := m.save(sess, h); err != nil {
+					m.config.ErrorHandler(ctx, err)
+				}
+			})
+
+			next.ServeHTTP(ctx)
+		})
+	}
+}
+
+// FromContext returns the request's session, or nil if the middleware is
+// not installed
+func FromContext(ctx *server.Context) *Session {
+	v, _ := ctx.Get(contextKey)
+	sess, _ := v.(*Session)
+	return sess
+}
+
+// load finds the session named by the request cookie, or starts a new one
+func (m *Manager) load(ctx *server.Context) *Session {
+	now := time.Now()
+	fresh := &Session{rec: newRecord(now), isNew: true}
+
+	cookie, err := ctx.Cookie(m.config.CookieName)
+	if err != nil {
+		return fresh
+	}
+	id, err := m.codec.decode(m.config.CookieName, cookie.Value)
+	if err != nil {
+		return fresh
+	}
+
+	rec, err := m.config.Store.Load(id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			m.config.ErrorHandler(ctx, err)
+		}
+		return fresh
+	}
+	if m.expired(rec, now) {
+		if err := m.config.Store.Delete(id); err != nil {
+			m.config.ErrorHandler(ctx, err)
+		}
+		return fresh
+	}
+	return &Session{id: id, rec: rec}
+}
+
+// save stores the session and adds a Set-Cookie to h if the client needs a
+// new cookie
+func (m *Manager) save(s *Session, h *headers.Headers) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := m.config.Store
+	oldID := s.id
+
+	if s.destroyed {
+		if oldID != "" {
+			if err := store.Delete(oldID); err != nil {
+				return err
+			}
+			s.id = ""
+		}
+		if !s.modified {
+			return m.setCookie(h, "", -1)
+		}
+	} else if s.isNew && !s.modified {
+		// Nothing worth keeping; don't hand out a session
+		return nil
+	}
+
+	if s.id == "" || s.rotate {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		s.id = id
+	}
+
+	now := time.Now()
+	s.rec.LastActive = now
+	ttl := min(m.config.IdleTimeout, s.rec.Created.Add(m.config.AbsoluteTimeout).Sub(now))
+	if err := store.Save(s.id, s.rec, ttl); err != nil {
+		return err
+	}
+
+	if oldID != "" && oldID != s.id && !s.destroyed {
+		if err := store.Delete(oldID); err != nil {
+			return err
+		}
+	}
+	if s.id == oldID {
+		return nil
+	}
+
+	value, err := m.codec.encode(m.config.CookieName, s.id)
+	if err != nil {
+		return err
+	}
+	// The cookie outlives idle periods; the store enforces those
+	maxAge := int(s.rec.Created.Add(m.config.AbsoluteTimeout).Sub(now).Round(time.Second) / time.Second)
+	return m.setCookie(h, value, max(maxAge, 1))
+}
+
+func (m *Manager) setCookie(h *headers.Headers, value string, maxAge int) error {
+	v, err := m.cookie(value, maxAge).SetCookieValue()
+	if err != nil {
+		return err
+	}
+	h.Add("Set-Cookie", v)
+	return nil
+}
+
+func (m *Manager) cookie(value string, maxAge int) *headers.Cookie {
+	return &headers.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Path:     m.config.Path,
+		Domain:   m.config.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.config.Secure,
+		HttpOnly: m.config.HttpOnly,
+		SameSite: m.config.SameSite,
+	}
+}
+
+// expired checks the idle and absolute timeouts
+func (m *Manager) expired(rec *Record, now time.Time) bool {
+	return now.Sub(rec.LastActive) > m.config.IdleTimeout ||
+		now.Sub(rec.Created) > m.config.AbsoluteTimeout
+}
+
+func newRecord(now time.Time) *Record {
+	return &Record{Values: make(map[string]string), Created: now, LastActive: now}
+}
+
+// Session is one client's session data for the current request. Changes
+// are saved when the response headers are written, so make them before
+// writing the response.
+type Session struct {
+	mu        sync.Mutex
+	id        string // Empty until the session is first saved
+	rec       *Record
+	isNew     bool
+	modified  bool
+	rotate    bool
+	destroyed bool
+}
+
+// ID returns the session ID, or "" for a new session that is not saved yet
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the request arrived without a valid session
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Created returns when the session was started
+func (s *Session) Created() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.Created
+}
+
+// Get returns the value for key, or "" if there is none
+func (s *Session) Get(key string) string {
+	v, _ := s.Lookup(key)
+	return v
+}
+
+// Lookup returns the value for key and whether it is set
+func (s *Session) Lookup(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.rec.Values[key]
+	return v, ok
+}
+
+// Set stores a value
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Values == nil {
+		s.rec.Values = make(map[string]string)
+	}
+	s.rec.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes a value
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.modified = true
+	}
+}
+
+// AddFlash queues a message for a later request, e.g. a notice to show
+// after a redirect
+func (s *Session) AddFlash(key, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Flashes == nil {
+		s.rec.Flashes = make(map[string][]string)
+	}
+	s.rec.Flashes[key] = append(s.rec.Flashes[key], message)
+	s.modified = true
+}
+
+// Flashes returns and removes the messages queued under key
+func (s *Session) Flashes(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := s.rec.Flashes[key]
+	if len(messages) > 0 {
+		delete(s.rec.Flashes, key)
+		s.modified = true
+	}
+	return messages
+}
+
+// Rotate gives the session a new ID when it is saved, keeping its data.
+// Call it whenever privileges change (login, logout, sudo) so an ID
+// leaked or planted before the change is useless afterwards.
+func (s *Session) Rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate = true
+}
+
+// Destroy deletes the session and clears the cookie. Values set afterwards
+// go into a new session with a new ID.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec = newRecord(time.Now())
+	s.destroyed = true
+	s.modified = false
+}
@@ -0,0 +1,251 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newManager(t *testing.T, modify func(*Config)) (*Manager, *MemoryStore) {
+	t.Helper()
+	store := NewMemoryStore(0)
+	config := DefaultConfig()
+	config.HashKey = testKey
+	config.Store = store
+	if modify != nil {
+		modify(&config)
+	}
+	m, err := New(config)
+	require.NoError(t, err)
+	return m, store
+}
+
+// serve runs handler behind the middleware with the given Cookie header
+// and returns the Set-Cookie values sent back
+func serve(m *Manager, cookie string, handler func(*Session)) []string {
+	h := headers.NewHeaders()
+	if cookie != "" {
+		h.Set("Cookie", cookie)
+	}
+	req := &request.Request{Method: "GET", Path: "/", Headers: h}
+	ctx := server.NewContext(req, response.NewWriter(&bytes.Buffer{}), nil)
+
+	m.Middleware()(server.HandlerFunc(func(ctx *server.Context) {
+		handler(FromContext(ctx))
+		ctx.Text(response.StatusOK, "ok")
+	})).ServeHTTP(ctx)
+	return ctx.Response.Headers().GetAll("set-cookie")
+}
+
+// cookiePair turns a Set-Cookie value into a Cookie request header
+func cookiePair(setCookie string) string {
+	pair, _, _ := strings.Cut(setCookie, ";")
+	return pair
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	m, store := newManager(t, nil)
+
+	// Nothing stored, no session handed out
+	assert.Empty(t, serve(m, "", func(s *Session) { assert.True(t, s.IsNew()) }))
+	assert.Zero(t, store.Len())
+
+	var id string
+	set := serve(m, "", func(s *Session) {
+		s.Set("user", "alice")
+	})
+	require.Len(t, set, 1)
+	assert.Contains(t, set[0], "; Path=/; Max-Age=86400; Secure; HttpOnly; SameSite=Lax")
+	cookie := cookiePair(set[0])
+
+	// The cookie brings the data back and is not re-sent
+	set = serve(m, cookie+"; other=1", func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "alice", s.Get("user"))
+		id = s.ID()
+	})
+	assert.Empty(t, set)
+	assert.Len(t, id, 43)
+
+	// A tampered cookie starts over
+	tampered := strings.Replace(cookie, id[:4], "AAAA", 1)
+	serve(m, tampered, func(s *Session) {
+		assert.True(t, s.IsNew())
+		assert.Empty(t, s.Get("user"))
+	})
+}
+
+func TestSessionRotate(t *testing.T) {
+	m, store := newManager(t, nil)
+
+	cookie := cookiePair(serve(m, "", func(s *Session) { s.Set("role", "guest") })[0])
+
+	var oldID, newID string
+	set := serve(m, cookie, func(s *Session) {
+		oldID = s.ID()
+		s.Set("role", "admin")
+		s.Rotate()
+	})
+	require.Len(t, set, 1)
+
+	serve(m, cookiePair(set[0]), func(s *Session) {
+		newID = s.ID()
+		assert.Equal(t, "admin", s.Get("role"))
+	})
+	assert.NotEqual(t, oldID, newID)
+	assert.Equal(t, 1, store.Len())
+
+	// The old cookie is dead
+	serve(m, cookie, func(s *Session) { assert.True(t, s.IsNew()) })
+}
+
+func TestSessionDestroyAndFlash(t *testing.T) {
+	m, store := newManager(t, nil)
+	cookie := cookiePair(serve(m, "", func(s *Session) { s.Set("user", "bob") })[0])
+
+	set := serve(m, cookie, func(s *Session) { s.Destroy() })
+	require.Len(t, set, 1)
+	assert.Contains(t, set[0], "Max-Age=0")
+	assert.Zero(t, store.Len())
+
+	// Logging out and leaving a message starts a new session
+	cookie = cookiePair(serve(m, "", func(s *Session) { s.Set("user", "bob") })[0])
+	set = serve(m, cookie, func(s *Session) {
+		s.Destroy()
+		s.AddFlash("notice", "Signed out")
+	})
+	require.Len(t, set, 1)
+	assert.NotContains(t, set[0], "Max-Age=0")
+	cookie = cookiePair(set[0])
+
+	serve(m, cookie, func(s *Session) {
+		assert.Empty(t, s.Get("user"))
+		assert.Equal(t, []string{"Signed out"}, s.Flashes("notice"))
+	})
+	serve(m, cookie, func(s *Session) {
+		assert.Empty(t, s.Flashes("notice"))
+	})
+}
+
+func TestSessionExpiry(t *testing.T) {
+	m, store := newManager(t, func(c *Config) {
+		c.IdleTimeout = time.Minute
+		c.AbsoluteTimeout = time.Hour
+	})
+
+	var id string
+	cookie := cookiePair(serve(m, "", func(s *Session) { s.Set("k", "v") })[0])
+	serve(m, cookie, func(s *Session) { id = s.ID() })
+
+	age := func(idle, total time.Duration) {
+		rec, err := store.Load(id)
+		require.NoError(t, err)
+		rec.LastActive = time.Now().Add(-idle)
+		rec.Created = time.Now().Add(-total)
+		require.NoError(t, store.Save(id, rec, time.Hour))
+	}
+
+	age(30*time.Second, 50*time.Minute)
+	serve(m, cookie, func(s *Session) { assert.Equal(t, "v", s.Get("k")) })
+
+	age(2*time.Minute, 2*time.Minute)
+	serve(m, cookie, func(s *Session) { assert.True(t, s.IsNew()) })
+
+	cookie = cookiePair(serve(m, "", func(s *Session) { s.Set("k", "v") })[0])
+	serve(m, cookie, func(s *Session) { id = s.ID() })
+	age(time.Second, 2*time.Hour)
+	serve(m, cookie, func(s *Session) { assert.True(t, s.IsNew()) })
+}
+
+func TestEncryptedCookie(t *testing.T) {
+	m, _ := newManager(t, func(c *Config) { c.BlockKey = testKey[:16] })
+
+	var id string
+	cookie := cookiePair(serve(m, "", func(s *Session) { s.Set("k", "v") })[0])
+	serve(m, cookie, func(s *Session) {
+		id = s.ID()
+		assert.Equal(t, "v", s.Get("k"))
+	})
+	assert.NotContains(t, cookie, id)
+
+	// Values are bound to the cookie name
+	value := strings.TrimPrefix(cookie, "session=")
+	_, err := m.codec.decode("other", value)
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	_, err := New(Config{HashKey: []byte("short")})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = New(Config{HashKey: testKey, BlockKey: []byte("not-aes")})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = New(Config{HashKey: testKey, SameSite: headers.SameSiteNone})
+	assert.ErrorIs(t, err, headers.ErrInsecureCookie)
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	id, err := newID()
+	require.NoError(t, err)
+	rec := newRecord(time.Now())
+	rec.Values["user"] = "carol"
+	rec.Flashes = map[string][]string{"notice": {"hi"}}
+
+	require.NoError(t, store.Save(id, rec, time.Hour))
+	loaded, err := store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "carol", loaded.Values["user"])
+	assert.Equal(t, []string{"hi"}, loaded.Flashes["notice"])
+
+	require.NoError(t, store.Delete(id))
+	_, err = store.Load(id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, store.Delete(id))
+
+	// IDs that aren't ours never reach the file system
+	_, err = store.Load("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Error(t, store.Save("../x", rec, time.Hour))
+
+	expired, _ := newID()
+	require.NoError(t, store.Save(id, rec, time.Hour))
+	require.NoError(t, store.Save(expired, rec, -time.Second))
+	removed, err := store.Cleanup()
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, err = store.Load(id)
+	assert.NoError(t, err)
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	store := NewMemoryStore(10 * time.Millisecond)
+	defer store.Close()
+
+	rec := newRecord(time.Now())
+	require.NoError(t, store.Save("a", rec, time.Millisecond))
+	require.NoError(t, store.Save("b", rec, time.Hour))
+
+	// Stored records are copies
+	rec.Values["x"] = "y"
+	loaded, err := store.Load("b")
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Values)
+
+	assert.Eventually(t, func() bool { return store.Len() == 1 }, time.Second, 5*time.Millisecond)
+}
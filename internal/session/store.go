@@ -0,0 +1,274 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists session records between requests. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Load returns the record saved under id, or ErrNotFound if there is
+	// none or it has expired
+	Load(id string) (*Record, error)
+
+	// Save stores rec under id, to expire after ttl
+	Save(id string, rec *Record, ttl time.Duration) error
+
+	// Delete removes the record under id; deleting a missing one is not an
+	// error
+	Delete(id string) error
+}
+
+// Record is the data kept for one session
+type Record struct {
+	Values     map[string]string   `json:"values,omitempty"`
+	Flashes    map[string][]string `json:"flashes,omitempty"`
+	Created    time.Time           `json:"created"`
+	LastActive time.Time           `json:"last_active"`
+}
+
+// clone deep-copies r so stores never share maps with a live Session
+func (r *Record) clone() *Record {
+	c := &Record{Created: r.Created, LastActive: r.LastActive}
+	if r.Values != nil {
+		c.Values = make(map[string]string, len(r.Values))
+		for k, v := range r.Values {
+			c.Values[k] = v
+		}
+	}
+	if r.Flashes != nil {
+		c.Flashes = make(map[string][]string, len(r.Flashes))
+		for k, v := range r.Flashes {
+			c.Flashes[k] = append([]string(nil), v...)
+		}
+	}
+	return c
+}
+
+// MemoryStore keeps sessions in memory. Sessions are lost on restart and
+// are not shared between processes.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	stop    chan struct{}
+	once    sync.Once
+}
+
+type memoryEntry struct {
+	rec     *Record
+	expires time.Time
+}
+
+// NewMemoryStore creates a memory store that evicts expired sessions every
+// cleanupInterval. With an interval <= 0 they are only dropped when loaded.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		stop:    make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go s.cleanup(cleanupInterval)
+	}
+	return s
+}
+
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(entry.expires) {
+		delete(s.entries, id)
+		return nil, ErrNotFound
+	}
+	return entry.rec.clone(), nil
+}
+
+func (s *MemoryStore) Save(id string, rec *Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[id] = memoryEntry{rec: rec.clone(), expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+// Len returns the number of stored sessions, including expired ones not
+// yet evicted
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Close stops the eviction goroutine
+func (s *MemoryStore) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// cleanup evicts expired sessions periodically
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		now := time.Now()
+		for id, entry := range s.entries {
+			if now.After(entry.expires) {
+				delete(s.entries, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// FileStore keeps each session in a JSON file in a directory, so sessions
+// survive restarts. Expired files are removed when loaded or by Cleanup.
+type FileStore struct {
+	dir string
+}
+
+type fileEntry struct {
+	Expires time.Time `json:"expires"`
+	Record  *Record   `json:"record"`
+}
+
+// NewFileStore creates a file store in dir, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file for id. IDs are checked so a forged one can never
+// name a file outside dir.
+func (s *FileStore) path(id string) (string, bool) {
+	if !validID(id) {
+		return "", false
+	}
+	return filepath.Join(s.dir, id+".json"), true
+}
+
+func (s *FileStore) Load(id string) (*Record, error) {
+	path, ok := s.path(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry fileEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Record == nil {
+		return nil, fmt.Errorf("session file %s: corrupt", path)
+	}
+	if time.Now().After(entry.Expires) {
+		os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return entry.Record, nil
+}
+
+// Save writes the session to a temporary file and renames it into place,
+// so a concurrent Load never sees a partial file
+func (s *FileStore) Save(id string, rec *Record, ttl time.Duration) error {
+	path, ok := s.path(id)
+	if !ok {
+		return fmt.Errorf("invalid session ID")
+	}
+
+	data, err := json.Marshal(fileEntry{Expires: time.Now().Add(ttl), Record: rec})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".session-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, ok := s.path(id)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes expired and unreadable session files, returning how many
+// were removed
+func (s *FileStore) Cleanup() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	now := time.Now()
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		path := filepath.Join(s.dir, e.Name())
+
+		var entry fileEntry
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if json.Unmarshal(data, &entry) == nil && now.Before(entry.Expires) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// permessage-deflate (RFC 7692) without context takeover: every message is
+// an independent deflate stream with the trailing empty block removed.
+
+// deflateTail is the empty stored block stripped from each compressed message
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// finalBlock terminates the stream so the inflater reports io.EOF
+var finalBlock = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compress deflates a message payload
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fw := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(fw)
+	fw.Reset(&buf)
+
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+
+	out := buf.Bytes()
+	return bytes.TrimSuffix(out, deflateTail), nil
+}
+
+// decompress inflates a message payload, refusing output beyond limit
+func decompress(data []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(
+		bytes.NewReader(data),
+		bytes.NewReader(deflateTail),
+		bytes.NewReader(finalBlock),
+	))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrReadLimit
+	}
+	return out, nil
+}
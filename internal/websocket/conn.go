@@ -0,0 +1,509 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types (RFC 6455 section 5.2 opcodes)
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// Close codes (RFC 6455 section 7.4.1)
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const (
+	finalBit = 1 << 7
+	rsv1Bit  = 1 << 6
+	rsv2Bit  = 1 << 5
+	rsv3Bit  = 1 << 4
+	maskBit  = 1 << 7
+
+	maxControlPayload = 125
+)
+
+var (
+	ErrCloseSent       = errors.New("websocket: close sent")
+	ErrReadLimit       = errors.New("websocket: message exceeds read limit")
+	ErrProtocol        = errors.New("websocket: protocol error")
+	ErrInvalidUTF8     = errors.New("websocket: invalid UTF-8 in text message")
+	ErrInvalidType     = errors.New("websocket: invalid message type")
+	ErrControlTooLarge = errors.New("websocket: control frame payload too large")
+)
+
+// CloseError is returned by ReadMessage when the peer sends a close frame
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. One goroutine may read and one may write
+// concurrently; control replies sent by the reader are serialized with writes.
+type Conn struct {
+	rwc      io.ReadWriteCloser
+	br       *bufio.Reader
+	isServer bool
+
+	subprotocol    string
+	compress       bool
+	readLimit      int64
+	writeFrameSize int
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	readErr error // Sticky: once reading fails it keeps failing
+
+	pingHandler func(appData string) error
+	pongHandler func(appData string) error
+}
+
+// newConn wraps an established connection. Servers expect masked frames
+// from the peer and send unmasked ones; clients do the opposite.
+func newConn(rwc io.ReadWriteCloser, isServer bool) *Conn {
+	c := &Conn{
+		rwc:       rwc,
+		br:        bufio.NewReaderSize(rwc, 4096),
+		isServer:  isServer,
+		readLimit: defaultReadLimit,
+	}
+	c.pingHandler = func(appData string) error {
+		return c.WriteControl(PongMessage, []byte(appData))
+	}
+	c.pongHandler = func(string) error { return nil }
+	return c
+}
+
+// Subprotocol returns the negotiated subprotocol ("" if none)
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetReadLimit sets the maximum message size in bytes
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPingHandler overrides the default ping handler, which replies with a pong
+func (c *Conn) SetPingHandler(h func(appData string) error) {
+	c.pingHandler = h
+}
+
+// SetPongHandler sets a handler for pong frames (e.g. to extend deadlines)
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	c.pongHandler = h
+}
+
+// SetReadDeadline sets the deadline on the underlying connection
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the deadline on the underlying connection
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// Close closes the underlying connection without a close handshake
+func (c *Conn) Close() error {
+	return c.rwc.Close()
+}
+
+// frameHeader is a decoded frame header
+type frameHeader struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	length  int64
+	masked  bool
+	maskKey [4]byte
+}
+
+// readFrameHeader reads and validates a frame header
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var h frameHeader
+
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = b[0]&finalBit != 0
+	h.rsv1 = b[0]&rsv1Bit != 0
+	h.opcode = int(b[0] & 0x0f)
+	h.masked = b[1]&maskBit != 0
+	h.length = int64(b[1] & 0x7f)
+
+	if b[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return h, c.fail(CloseProtocolError, "reserved bits set")
+	}
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		length := binary.BigEndian.Uint64(b[:8])
+		if length > 1<<63-1 {
+			return h, c.fail(CloseProtocolError, "invalid payload length")
+		}
+		h.length = int64(length)
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(c.br, h.maskKey[:]); err != nil {
+			return h, err
+		}
+	}
+
+	// RFC 6455 section 5.1: clients must mask, servers must not
+	if h.masked != c.isServer {
+		return h, c.fail(CloseProtocolError, "incorrect masking")
+	}
+
+	switch h.opcode {
+	case continuationFrame, TextMessage, BinaryMessage:
+		if h.rsv1 && (!c.compress || h.opcode == continuationFrame) {
+			return h, c.fail(CloseProtocolError, "unexpected RSV1")
+		}
+	case CloseMessage, PingMessage, PongMessage:
+		if !h.fin {
+			return h, c.fail(CloseProtocolError, "fragmented control frame")
+		}
+		if h.length > maxControlPayload {
+			return h, c.fail(CloseProtocolError, "control frame too large")
+		}
+		if h.rsv1 {
+			return h, c.fail(CloseProtocolError, "unexpected RSV1")
+		}
+	default:
+		return h, c.fail(CloseProtocolError, "unknown opcode")
+	}
+
+	return h, nil
+}
+
+// readPayload reads and unmasks a frame payload
+func (c *Conn) readPayload(h frameHeader, dst []byte) ([]byte, error) {
+	start := len(dst)
+	dst = append(dst, make([]byte, h.length)...)
+	if _, err := io.ReadFull(c.br, dst[start:]); err != nil {
+		return dst, err
+	}
+	if h.masked {
+		maskBytes(h.maskKey, dst[start:])
+	}
+	return dst, nil
+}
+
+// ReadMessage reads the next complete data message, reassembling fragments
+// and answering control frames along the way. A peer close is reported as
+// *CloseError after the close frame has been echoed.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	messageType, p, err = c.readMessage()
+	if err != nil {
+		c.readErr = err
+	}
+	return messageType, p, err
+}
+
+func (c *Conn) readMessage() (int, []byte, error) {
+	var (
+		messageType int
+		compressed  bool
+		payload     []byte
+	)
+
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.opcode >= CloseMessage {
+			control, err := c.readPayload(h, nil)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.opcode, control); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		// Fragmentation rules (RFC 6455 section 5.4)
+		if h.opcode == continuationFrame && messageType == 0 {
+			return 0, nil, c.fail(CloseProtocolError, "continuation without start")
+		}
+		if h.opcode != continuationFrame && messageType != 0 {
+			return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+		}
+		if h.opcode != continuationFrame {
+			messageType = h.opcode
+			compressed = h.rsv1
+		}
+
+		if int64(len(payload))+h.length > c.readLimit {
+			return 0, nil, c.failErr(CloseMessageTooBig, ErrReadLimit)
+		}
+
+		payload, err = c.readPayload(h, payload)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.fin {
+			break
+		}
+	}
+
+	if compressed {
+		inflated, err := decompress(payload, c.readLimit)
+		if err == ErrReadLimit {
+			return 0, nil, c.failErr(CloseMessageTooBig, err)
+		}
+		if err != nil {
+			return 0, nil, c.fail(CloseInvalidFramePayloadData, "invalid compressed data")
+		}
+		payload = inflated
+	}
+
+	if messageType == TextMessage && !utf8.Valid(payload) {
+		return 0, nil, c.failErr(CloseInvalidFramePayloadData, ErrInvalidUTF8)
+	}
+
+	return messageType, payload, nil
+}
+
+// handleControl processes ping, pong and close frames
+func (c *Conn) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case PingMessage:
+		if err := c.pingHandler(string(payload)); err != nil && err != ErrCloseSent {
+			return err
+		}
+	case PongMessage:
+		return c.pongHandler(string(payload))
+	case CloseMessage:
+		code := CloseNoStatusReceived
+		text := ""
+
+		switch {
+		case len(payload) == 1:
+			return c.fail(CloseProtocolError, "invalid close payload")
+		case len(payload) >= 2:
+			code = int(binary.BigEndian.Uint16(payload))
+			text = string(payload[2:])
+			if !isValidCloseCode(code) {
+				return c.fail(CloseProtocolError, "invalid close code")
+			}
+			if !utf8.ValidString(text) {
+				return c.failErr(CloseInvalidFramePayloadData, ErrInvalidUTF8)
+			}
+		}
+
+		// Echo the close and report it to the caller
+		reply := code
+		if code == CloseNoStatusReceived {
+			reply = CloseNormalClosure
+		}
+		c.WriteClose(reply, "")
+		return &CloseError{Code: code, Text: text}
+	}
+	return nil
+}
+
+// fail sends a close frame with the given code and returns a protocol error
+func (c *Conn) fail(code int, reason string) error {
+	return c.failErr(code, fmt.Errorf("%w: %s", ErrProtocol, reason))
+}
+
+func (c *Conn) failErr(code int, err error) error {
+	c.WriteClose(code, "")
+	return err
+}
+
+// WriteMessage writes a data message, fragmenting it if WriteFrameSize is set
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		if messageType >= CloseMessage {
+			return c.WriteControl(messageType, data)
+		}
+		return ErrInvalidType
+	}
+
+	compressed := false
+	if c.compress {
+		deflated, err := compress(data)
+		if err != nil {
+			return err
+		}
+		data = deflated
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	opcode := messageType
+	for {
+		frame := data
+		if c.writeFrameSize > 0 && len(frame) > c.writeFrameSize {
+			frame = frame[:c.writeFrameSize]
+		}
+		data = data[len(frame):]
+
+		// RSV1 marks a compressed message on its first frame only
+		if err := c.writeFrame(opcode, frame, len(data) == 0, compressed && opcode != continuationFrame); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		opcode = continuationFrame
+	}
+}
+
+// WriteControl writes a ping, pong or close frame
+func (c *Conn) WriteControl(messageType int, data []byte) error {
+	if messageType < CloseMessage || messageType > PongMessage {
+		return ErrInvalidType
+	}
+	if len(data) > maxControlPayload {
+		return ErrControlTooLarge
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if messageType == CloseMessage {
+		c.closeSent = true
+	}
+	return c.writeFrame(messageType, data, true, false)
+}
+
+// WriteClose starts (or completes) the closing handshake
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.WriteControl(CloseMessage, payload)
+}
+
+// writeFrame writes a single frame in one write call. Caller holds writeMu.
+func (c *Conn) writeFrame(opcode int, payload []byte, fin, rsv1 bool) error {
+	header := make([]byte, 0, 14+len(payload))
+
+	b0 := byte(opcode)
+	if fin {
+		b0 |= finalBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	header = append(header, b0)
+
+	var b1 byte
+	if !c.isServer {
+		b1 = maskBit
+	}
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header = append(header, b1|byte(length))
+	case length <= 0xffff:
+		header = append(header, b1|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, b1|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	frame := header
+	if c.isServer {
+		frame = append(frame, payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(key, frame[start:])
+	}
+
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// maskBytes XORs data with the masking key in place
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i&3]
+	}
+}
+
+// isValidCloseCode reports whether a code may appear in a close frame
+func isValidCloseCode(code int) bool {
+	switch code {
+	case CloseNormalClosure, CloseGoingAway, CloseProtocolError, CloseUnsupportedData,
+		CloseInvalidFramePayloadData, ClosePolicyViolation, CloseMessageTooBig,
+		CloseMandatoryExtension, CloseInternalServerErr:
+		return true
+	}
+	// 3000-3999 registered, 4000-4999 private use
+	return code >= 3000 && code <= 4999
+}
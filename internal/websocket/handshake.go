@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Brownie44l1/http-1/internal/headers"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+)
+
+// RFC 6455 section 1.3: appended to the client key before hashing
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const defaultReadLimit = 32 << 20 // 32MB per message
+
+var (
+	ErrNotWebSocket     = errors.New("websocket: not a websocket upgrade request")
+	ErrBadMethod        = errors.New("websocket: upgrade requires GET")
+	ErrBadVersion       = errors.New("websocket: unsupported Sec-WebSocket-Version")
+	ErrBadKey           = errors.New("websocket: invalid Sec-WebSocket-Key")
+	ErrOriginNotAllowed = errors.New("websocket: origin not allowed")
+)
+
+// Upgrader performs the server side of the opening handshake
+type Upgrader struct {
+	// Subprotocols lists supported subprotocols in order of preference.
+	// The first one the client also offers is selected.
+	Subprotocols []string
+
+	// CheckOrigin decides whether the request Origin is acceptable.
+	// Nil means same-origin only (or no Origin header at all).
+	CheckOrigin func(ctx *server.Context) bool
+
+	// EnableCompression negotiates permessage-deflate (RFC 7692) when
+	// the client offers it. Context takeover is disabled both ways.
+	EnableCompression bool
+
+	// ReadLimit is the maximum message size in bytes (0 = 32MB)
+	ReadLimit int64
+
+	// WriteFrameSize splits outgoing messages into fragments of at most
+	// this many bytes (0 = one frame per message)
+	WriteFrameSize int
+}
+
+// Upgrade validates the handshake, hijacks the connection and writes the
+// 101 response. On failure an error response is sent and the error returned.
+func (u *Upgrader) Upgrade(ctx *server.Context) (*Conn, error) {
+	if ctx.Method() != "GET" {
+		ctx.Error(response.StatusMethodNotAllowed, "Method Not Allowed")
+		return nil, ErrBadMethod
+	}
+
+	if !ctx.IsWebSocketUpgrade() {
+		ctx.Error(response.StatusBadRequest, "Not a WebSocket request")
+		return nil, ErrNotWebSocket
+	}
+
+	if ctx.Header("Sec-WebSocket-Version") != "13" {
+		// RFC 6455 section 4.4: advertise the version we speak
+		h := headers.NewHeaders()
+		h.Set("Sec-WebSocket-Version", "13")
+		h.Set("Content-Length", "0")
+		if err := ctx.Response.WriteStatusLine(response.StatusUpgradeRequired); err == nil {
+			ctx.Response.WriteHeaders(h)
+		}
+		return nil, ErrBadVersion
+	}
+
+	key := ctx.Header("Sec-WebSocket-Key")
+	if !isValidKey(key) {
+		ctx.Error(response.StatusBadRequest, "Invalid Sec-WebSocket-Key")
+		return nil, ErrBadKey
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(ctx) {
+		ctx.Error(response.StatusForbidden, "Origin not allowed")
+		return nil, ErrOriginNotAllowed
+	}
+
+	subprotocol := selectSubprotocol(u.Subprotocols, ctx.Request.Headers.GetAll("sec-websocket-protocol"))
+
+	extensions := ""
+	if u.EnableCompression {
+		extensions = negotiateDeflate(ctx.Request.Headers.GetAll("sec-websocket-extensions"))
+	}
+
+	netConn, err := ctx.Hijack()
+	if err != nil {
+		ctx.Error(response.StatusInternalServerError, "Failed to hijack connection")
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", computeAcceptKey(key))
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if extensions != "" {
+		h.Set("Sec-WebSocket-Extensions", extensions)
+	}
+
+	w := response.NewWriter(netConn)
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	c := newConn(netConn, true)
+	c.subprotocol = subprotocol
+	c.compress = extensions != ""
+	c.readLimit = u.ReadLimit
+	if c.readLimit <= 0 {
+		c.readLimit = defaultReadLimit
+	}
+	c.writeFrameSize = u.WriteFrameSize
+
+	return c, nil
+}
+
+// computeAcceptKey derives Sec-WebSocket-Accept from Sec-WebSocket-Key
+func computeAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// isValidKey checks the key is base64 of a 16-byte nonce
+func isValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 16
+}
+
+// sameOrigin accepts requests without Origin or whose Origin host matches Host
+func sameOrigin(ctx *server.Context) bool {
+	origin := ctx.Header("Origin")
+	if origin == "" {
+		return true
+	}
+
+	// Strip scheme: "https://example.com" -> "example.com"
+	if idx := strings.Index(origin, "://"); idx != -1 {
+		origin = origin[idx+3:]
+	}
+	return strings.EqualFold(origin, ctx.Header("Host"))
+}
+
+// selectSubprotocol picks the first server protocol the client offered
+func selectSubprotocol(supported []string, offered []string) string {
+	clientProtocols := splitTokens(offered)
+	for _, proto := range supported {
+		for _, offer := range clientProtocols {
+			if proto == offer {
+				return proto
+			}
+		}
+	}
+	return ""
+}
+
+// negotiateDeflate returns the Sec-WebSocket-Extensions response to the
+// first permessage-deflate offer with parameters we can accept, or "" if
+// there is none
+func negotiateDeflate(offered []string) string {
+	for _, offer := range splitTokens(offered) {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		ok, windowBits := true, false
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover":
+			case "client_max_window_bits":
+				// We always use the default window, which any client accepts
+			case "server_max_window_bits":
+				// We can only honour a request for the full 15-bit window
+				bits, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+				if err != nil || bits != 15 {
+					ok = false
+				}
+				windowBits = true
+			default:
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		response := "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+		if windowBits {
+			// RFC 7692 section 7.1.2.1: the parameter must be echoed
+			response += "; server_max_window_bits=15"
+		}
+		return response
+	}
+	return ""
+}
+
+// splitTokens flattens comma-separated header values
+func splitTokens(values []string) []string {
+	var tokens []string
+	for _, v := range values {
+		for _, token := range strings.Split(v, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,250 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	stdnet "net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Brownie44l1/http-1/internal/request"
+	"github.com/Brownie44l1/http-1/internal/response"
+	"github.com/Brownie44l1/http-1/internal/server"
+	net "github.com/Brownie44l1/socket-wrapper"
+)
+
+// newPair returns a connected server/client pair over an in-memory pipe
+func newPair() (*Conn, *Conn) {
+	a, b := stdnet.Pipe()
+	return newConn(a, true), newConn(b, false)
+}
+
+func TestComputeAcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", computeAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestIsValidKey(t *testing.T) {
+	assert.True(t, isValidKey("dGhlIHNhbXBsZSBub25jZQ=="))
+	assert.False(t, isValidKey(""))
+	assert.False(t, isValidKey("not base64!"))
+	assert.False(t, isValidKey("c2hvcnQ=")) // decodes to 5 bytes
+}
+
+func TestNegotiation(t *testing.T) {
+	assert.Equal(t, "chat", selectSubprotocol([]string{"chat", "superchat"}, []string{"superchat, chat"}))
+	assert.Equal(t, "", selectSubprotocol([]string{"chat"}, []string{"mqtt"}))
+
+	const deflate = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	assert.Equal(t, deflate, negotiateDeflate([]string{"permessage-deflate; client_max_window_bits"}))
+	assert.Equal(t, deflate, negotiateDeflate([]string{"x-webkit-deflate-frame, permessage-deflate"}))
+	assert.Equal(t, "", negotiateDeflate([]string{"permessage-deflate; server_max_window_bits=10"}))
+	assert.Equal(t, "", negotiateDeflate([]string{"permessage-deflate; x-unknown; server_max_window_bits=15"}))
+	assert.Equal(t, "", negotiateDeflate(nil))
+}
+
+// recordConn is a hijackable connection that records what is written
+type recordConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *recordConn) Close() error                { return nil }
+func (c *recordConn) RemoteAddr() string          { return "192.0.2.1:1234" }
+
+// upgrade runs Upgrade for a request offering extensions and returns the
+// handshake response
+func upgrade(t *testing.T, u Upgrader, extensions string) (*http.Response, *Conn) {
+	t.Helper()
+	raw := "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if extensions != "" {
+		raw += "Sec-WebSocket-Extensions: " + extensions + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	conn := &recordConn{}
+	ctx := server.NewContext(req, response.NewWriter(&conn.out), conn)
+	c, err := u.Upgrade(ctx)
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(&conn.out), nil)
+	require.NoError(t, err)
+	return resp, c
+}
+
+func TestUpgradeNegotiatesDeflate(t *testing.T) {
+	tests := []struct {
+		offer string
+		want  string
+	}{
+		{"permessage-deflate", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=15", "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=15"},
+		{`permessage-deflate; server_max_window_bits="15"; client_max_window_bits`, "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=15"},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=10", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.offer, func(t *testing.T) {
+			resp, c := upgrade(t, Upgrader{EnableCompression: true}, tt.offer)
+			assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+			assert.Equal(t, tt.want, resp.Header.Get("Sec-WebSocket-Extensions"))
+			assert.Equal(t, tt.want != "", c.compress)
+		})
+	}
+
+	// Not negotiated unless enabled
+	resp, c := upgrade(t, Upgrader{}, "permessage-deflate; server_max_window_bits=15")
+	assert.Empty(t, resp.Header.Values("Sec-WebSocket-Extensions"))
+	assert.False(t, c.compress)
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	server, client := newPair()
+
+	go client.WriteMessage(TextMessage, []byte("hello"))
+
+	mt, p, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, "hello", string(p))
+
+	go server.WriteMessage(BinaryMessage, []byte{1, 2, 3})
+
+	mt, p, err = client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, mt)
+	assert.Equal(t, []byte{1, 2, 3}, p)
+}
+
+func TestLargeMessage(t *testing.T) {
+	server, client := newPair()
+	payload := bytes.Repeat([]byte("x"), 70000) // Needs 64-bit length
+
+	go client.WriteMessage(BinaryMessage, payload)
+
+	_, p, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, payload, p)
+}
+
+func TestFragmentedMessageWithInterleavedPing(t *testing.T) {
+	server, client := newPair()
+
+	go func() {
+		client.writeMu.Lock()
+		client.writeFrame(TextMessage, []byte("Hel"), false, false)
+		client.writeFrame(PingMessage, []byte("p"), true, false)
+		client.writeFrame(continuationFrame, []byte("lo"), true, false)
+		client.writeMu.Unlock()
+	}()
+
+	// The server answers the ping while reassembling
+	pong := make(chan string, 1)
+	go func() {
+		client.SetPongHandler(func(appData string) error {
+			pong <- appData
+			return nil
+		})
+		client.ReadMessage()
+	}()
+
+	mt, p, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, "Hello", string(p))
+	assert.Equal(t, "p", <-pong)
+}
+
+func TestWriteFrameSize(t *testing.T) {
+	server, client := newPair()
+	client.writeFrameSize = 4
+
+	go client.WriteMessage(TextMessage, []byte("fragmented message"))
+
+	_, p, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "fragmented message", string(p))
+}
+
+func TestCloseHandshake(t *testing.T) {
+	server, client := newPair()
+
+	go client.WriteClose(CloseGoingAway, "bye")
+
+	// Read the echoed close on the client side
+	echoed := make(chan error, 1)
+	go func() {
+		_, _, err := client.ReadMessage()
+		echoed <- err
+	}()
+
+	_, _, err := server.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Text)
+
+	err = <-echoed
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+
+	assert.ErrorIs(t, server.WriteMessage(TextMessage, []byte("late")), ErrCloseSent)
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	server, client := newPair()
+
+	go client.WriteMessage(TextMessage, []byte{0xff, 0xfe})
+	go client.ReadMessage() // Drain the close frame
+
+	_, _, err := server.ReadMessage()
+	assert.ErrorIs(t, err, ErrInvalidUTF8)
+}
+
+func TestUnmaskedClientFrameRejected(t *testing.T) {
+	server, client := newPair()
+	client.isServer = true // Sends unmasked frames like a server would
+
+	go client.WriteMessage(TextMessage, []byte("hi"))
+	go client.ReadMessage()
+
+	_, _, err := server.ReadMessage()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrProtocol)
+	assert.True(t, strings.Contains(err.Error(), "masking"))
+}
+
+func TestReadLimit(t *testing.T) {
+	server, client := newPair()
+	server.SetReadLimit(4)
+
+	go client.WriteMessage(BinaryMessage, []byte("too long"))
+	go client.ReadMessage()
+
+	_, _, err := server.ReadMessage()
+	assert.ErrorIs(t, err, ErrReadLimit)
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	server, client := newPair()
+	server.compress = true
+	client.compress = true
+
+	payload := strings.Repeat("compress me ", 100)
+	go client.WriteMessage(TextMessage, []byte(payload))
+
+	mt, p, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, payload, string(p))
+}